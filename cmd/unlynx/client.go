@@ -7,29 +7,94 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/BurntSushi/toml"
 	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/predicate"
+	"github.com/ldsec/unlynx/lib/statistics"
 	"github.com/ldsec/unlynx/services"
 	"github.com/urfave/cli"
+	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/app"
 	"go.dedis.ch/onet/v3/log"
 )
 
 // BEGIN CLIENT: QUERIER ----------
-func startQuery(el *onet.Roster, proofs bool, sum []string, count bool, whereQueryValues []libunlynx.WhereQueryAttribute, predicate string, groupBy []string) error {
-	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
 
-	nbrDPs := make(map[string]int64)
-	//how many data providers for each server
-	for _, server := range el.List {
-		nbrDPs[server.String()] = 1 // 1 DP for each server
+// queryOptions are the parameters of a query run by the client that are not sent with the survey creation
+type queryOptions struct {
+	keys       *key.Pair
+	histograms []libunlynxstatistics.HistogramAggregate
+	statistics []libunlynxstatistics.Statistic
+	limit      int64 // decoding range of the results
+}
+
+func startQuery(query servicesunlynx.SurveyCreationQuery, options queryOptions) error {
+	entryPoint := query.Roster.List[0]
+	client := servicesunlynx.NewUnLynxClient(entryPoint, strconv.Itoa(0))
+	if options.keys != nil {
+		client = servicesunlynx.NewUnLynxClientWithKeys(entryPoint, strconv.Itoa(0), options.keys)
 	}
 
-	surveyID, err := client.SendSurveyCreationQuery(el, servicesunlynx.SurveyID(""), nil, nbrDPs, proofs, true, sum, count, whereQueryValues, predicate, groupBy)
+	surveyID, err := client.SendSurveyCreation(query)
 	if err != nil {
 		return err
 	}
 
+	if len(options.statistics) > 0 {
+		grp, results, err := client.SendSurveyStatisticsResultsQuery(*surveyID, options.statistics, options.limit)
+		if err != nil {
+			return fmt.Errorf("service could not output the results: %v", err)
+		}
+
+		// Print Output
+		log.Lvl1("Service output:")
+		for i := range *grp {
+			for _, result := range (*results)[i] {
+				if result.Statistic.Function == libunlynxstatistics.Regression {
+					log.Lvl1(i, ")", (*grp)[i], "->", result.Statistic, "=", result.Coefficients, "( n =", result.Count, ")")
+				} else {
+					log.Lvl1(i, ")", (*grp)[i], "->", result.Statistic, "=", result.Value, "( n =", result.Count, ")")
+				}
+			}
+		}
+		return nil
+	}
+
+	if len(options.histograms) > 0 {
+		grp, results, err := client.SendSurveyHistogramResultsQuery(*surveyID, options.histograms)
+		if err != nil {
+			return fmt.Errorf("service could not output the results: %v", err)
+		}
+
+		// Print Output
+		log.Lvl1("Service output:")
+		for i := range *grp {
+			for _, result := range (*results)[i] {
+				if result.Empty {
+					log.Lvl1(i, ")", (*grp)[i], "->", result.Aggregate, "= (no value)")
+				} else {
+					log.Lvl1(i, ")", (*grp)[i], "->", result.Aggregate, "=", result.Value)
+				}
+			}
+		}
+		return nil
+	}
+
+	if len(query.FixedPoint) > 0 {
+		grp, aggr, err := client.SendSurveyResultsQueryFixedPoint(*surveyID, options.limit)
+		if err != nil {
+			return fmt.Errorf("service could not output the results: %v", err)
+		}
+
+		// Print Output
+		log.Lvl1("Service output:")
+		for i := range *grp {
+			log.Lvl1(i, ")", (*grp)[i], "->", (*aggr)[i])
+		}
+		return nil
+	}
+
 	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
 	if err != nil {
 		return fmt.Errorf("service could not output the results: %v", err)
@@ -56,16 +121,132 @@ func runUnLynx(c *cli.Context) {
 	whereQueryValues := c.String("where")
 	predicate := c.String("predicate")
 	groupBy := c.String("groupBy")
+	fixedPoint := c.String("fixedPoint")
+	schemaFile := c.String("schema")
+	histograms := c.String("histograms")
+	statistics := c.String("statistics")
+	memoryBudget := c.Int64("memoryBudget")
+	keyFile := c.String("key")
+	epsilon := c.Float64("epsilon")
+	minCellSize := c.Int64("minCellSize")
+	mergeSmallCells := c.Bool("mergeSmallCells")
+
+	limit := c.Int64("range")
+
+	if table := c.String("table"); table != "" {
+		dt, err := libunlynx.ReadDecryptionTable(table)
+		log.ErrFatal(err, "Could not read the decryption table.")
+		libunlynx.SetDecryptionTable(dt)
+	}
 
 	el, err := openGroupToml(tomlFileName)
 	log.ErrFatal(err, "Could not open group toml.")
 
 	sumFinal, countFinal, whereFinal, predicateFinal, groupByFinal, err := parseQuery(el, sum, count, whereQueryValues, predicate, groupBy)
+	log.ErrFatal(err)
+
+	fixedPointFinal, err := parseFixedPoint(fixedPoint, sumFinal)
+	log.ErrFatal(err)
+
+	if minCellSize > 0 && !countFinal {
+		// the minimum cell size is tested on the count of the groups
+		sumFinal = append(sumFinal, libunlynx.CountAttribute)
+		countFinal = true
+	}
+
+	var schema *libunlynx.Schema
+	var histogramsFinal []libunlynxstatistics.HistogramAggregate
+	var statisticsFinal []libunlynxstatistics.Statistic
+	if schemaFile != "" {
+		schema, err = readSchema(schemaFile)
+		log.ErrFatal(err, "Could not read the schema.")
+		if histograms != "" {
+			// the histogram bins are summed along with the sum attributes
+			histogramsFinal, err = libunlynxstatistics.ParseHistogramAggregates(histograms)
+			log.ErrFatal(err, "Could not parse the histogram aggregates.")
+			bins, err := libunlynxstatistics.HistogramSum(schema, histogramsFinal)
+			log.ErrFatal(err, "The histogram aggregates do not match the schema.")
+			sumFinal = append(sumFinal, bins...)
+		}
+		if statistics != "" {
+			// the count, the attributes and their moments are summed along with the sum attributes
+			statisticsFinal, err = libunlynxstatistics.ParseStatistics(statistics)
+			log.ErrFatal(err, "Could not parse the statistics.")
+			moments, err := libunlynxstatistics.MomentSum(schema, statisticsFinal)
+			log.ErrFatal(err, "The statistics do not match the schema.")
+			summed := make(map[string]bool, len(sumFinal))
+			for _, name := range sumFinal {
+				summed[name] = true
+			}
+			for _, name := range moments {
+				if !summed[name] {
+					sumFinal = append(sumFinal, name)
+				}
+			}
+			countFinal = true
+		}
+		if len(fixedPointFinal) == 0 {
+			fixedPointFinal = schema.FixedPoint(sumFinal)
+		}
+		err = schema.ValidateQuery(sumFinal, countFinal, whereFinal, groupByFinal, fixedPointFinal)
+		log.ErrFatal(err, "The query does not match the schema.")
+	} else if histograms != "" || statistics != "" {
+		log.Fatal("The histogram aggregates and statistics need a schema declaring their attributes.")
+	}
+
+	var keys *key.Pair
+	if keyFile != "" {
+		keys, err = readQuerierKey(keyFile)
+		log.ErrFatal(err, "Could not read the querier key.")
+	}
+
+	nbrDPs := make(map[string]int64)
+	//how many data providers for each server
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1 // 1 DP for each server
+	}
+
+	query := servicesunlynx.SurveyCreationQuery{
+		SurveyID: servicesunlynx.SurveyID(""),
+		Roster:   *el,
+		MapDPs:   nbrDPs,
+		Proofs:   proofs,
+		AppFlag:  true,
 
-	err = startQuery(el, proofs, sumFinal, countFinal, whereFinal, predicateFinal, groupByFinal)
+		Sum:        sumFinal,
+		Count:      countFinal,
+		Where:      whereFinal,
+		Predicate:  predicateFinal,
+		GroupBy:    groupByFinal,
+		FixedPoint: fixedPointFinal,
+		Schema:     schema,
+		// the servers require the ranges of the histogram bins
+		Ranges: libunlynxstatistics.HistogramRanges(sumFinal),
+
+		MemoryBudget: memoryBudget,
+		Epsilon:      epsilon,
+
+		MinCellSize:     minCellSize,
+		MergeSmallCells: mergeSmallCells,
+	}
+	err = startQuery(query, queryOptions{keys: keys, histograms: histogramsFinal, statistics: statisticsFinal, limit: limit})
 	log.ErrFatal(err)
 }
 
+func generateDecryptionTable(c *cli.Context) error {
+	table := c.String("table")
+	if table == "" {
+		return fmt.Errorf("no output file for the decryption table")
+	}
+
+	limit := c.Int64("range")
+	if err := libunlynx.GenerateDecryptionTable(table, limit); err != nil {
+		return fmt.Errorf("could not generate the decryption table: %v", err)
+	}
+	log.Lvl1("Decryption table for [", -limit, ",", limit, "] written to", table)
+	return nil
+}
+
 func openGroupToml(tomlFileName string) (*onet.Roster, error) {
 	f, err := os.Open(tomlFileName)
 	if err != nil {
@@ -83,6 +264,44 @@ func openGroupToml(tomlFileName string) (*onet.Roster, error) {
 	return el.Roster, nil
 }
 
+// readSchema reads and validates a schema (TOML file with an [[Attributes]] table per attribute)
+func readSchema(schemaFileName string) (*libunlynx.Schema, error) {
+	schema := &libunlynx.Schema{}
+	if _, err := toml.DecodeFile(schemaFileName, schema); err != nil {
+		return nil, err
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// querierKey is the content of a querier key file
+type querierKey struct {
+	Public  string
+	Private string
+}
+
+// readQuerierKey reads a querier key pair (TOML file with the base64 encoded Public and Private keys)
+func readQuerierKey(keyFileName string) (*key.Pair, error) {
+	qk := querierKey{}
+	if _, err := toml.DecodeFile(keyFileName, &qk); err != nil {
+		return nil, err
+	}
+	public, err := libunlynx.DeserializePoint(qk.Public)
+	if err != nil {
+		return nil, err
+	}
+	private, err := libunlynx.DeserializeScalar(qk.Private)
+	if err != nil {
+		return nil, err
+	}
+	if !libunlynx.SuiTe.Point().Mul(private, nil).Equal(public) {
+		return nil, fmt.Errorf("the public key does not match the private key")
+	}
+	return &key.Pair{Public: public, Private: private}, nil
+}
+
 func checkRegex(input, expression string) bool {
 	var aux = regexp.MustCompile(expression)
 	return aux.MatchString(input)
@@ -94,9 +313,12 @@ func parseQuery(el *onet.Roster, sum string, count bool, where, predicate, group
 		return nil, false, nil, "", nil, fmt.Errorf("wrong query! please check the sum, where and the predicate parameters")
 	}
 
-	sumRegex := "{s[0-9]+(,\\s*s[0-9]+)*}"
-	whereRegex := "{(w[0-9]+(,\\s*[0-9]+))*(,\\s*w[0-9]+(,\\s*[0-9]+))*}"
-	groupByRegex := "{g[0-9]+(,\\s*g[0-9]+)*}"
+	name := libunlynx.AttributeNamePattern
+	sumRegex := "{" + name + "(,\\s*" + name + ")*}"
+	// the where attributes can be prefix tags (e.g. age/3)
+	whereName := name + "(" + libunlynx.PrefixTagSeparator + "[0-9]+)?"
+	whereRegex := "{(" + whereName + "(,\\s*[0-9]+))*(,\\s*" + whereName + "(,\\s*[0-9]+))*}"
+	groupByRegex := "{" + name + "(,\\s*" + name + ")*}"
 
 	if !checkRegex(sum, sumRegex) {
 		return nil, false, nil, "", nil, fmt.Errorf("error parsing the sum parameter(s)")
@@ -131,7 +353,7 @@ func parseQuery(el *onet.Roster, sum string, count bool, where, predicate, group
 
 	var variable string
 	for i := range whereTokens {
-		// if is a variable (w1, w2...)
+		// if is a variable (w1, smoker...)
 		if i%2 == 0 {
 			variable = whereTokens[i]
 		} else { // if it is a value
@@ -144,6 +366,16 @@ func parseQuery(el *onet.Roster, sum string, count bool, where, predicate, group
 		}
 	}
 
+	if predicate != "" {
+		names := make([]string, len(whereFinal))
+		for i, w := range whereFinal {
+			names[i] = w.Name
+		}
+		if _, err := libunlynxpredicate.Parse(predicate, names); err != nil {
+			return nil, false, nil, "", nil, fmt.Errorf("error parsing the predicate: %v", err)
+		}
+	}
+
 	if !checkRegex(groupBy, groupByRegex) {
 		return nil, false, nil, "", nil, fmt.Errorf("error parsing the groupBy parameter(s)")
 	}
@@ -155,4 +387,40 @@ func parseQuery(el *onet.Roster, sum string, count bool, where, predicate, group
 	return sumFinal, count, whereFinal, predicate, groupByFinal, nil
 }
 
+func parseFixedPoint(fixedPoint string, sum []string) (libunlynx.FixedPointScales, error) {
+	fixedPointFinal := libunlynx.FixedPointScales{}
+	if fixedPoint == "" {
+		return fixedPointFinal, nil
+	}
+
+	name := libunlynx.AttributeNamePattern
+	fixedPointRegex := "{" + name + "(,\\s*[0-9]+)(,\\s*" + name + "(,\\s*[0-9]+))*}"
+	if !checkRegex(fixedPoint, fixedPointRegex) {
+		return nil, fmt.Errorf("error parsing the fixedPoint parameter(s)")
+	}
+	fixedPoint = strings.Replace(fixedPoint, " ", "", -1)
+	fixedPoint = strings.Replace(fixedPoint, "{", "", -1)
+	fixedPoint = strings.Replace(fixedPoint, "}", "", -1)
+	fixedPointTokens := strings.Split(fixedPoint, ",")
+
+	for i := 0; i < len(fixedPointTokens); i = i + 2 {
+		decimals, err := strconv.ParseInt(fixedPointTokens[i+1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		check := false
+		for _, el := range sum {
+			if el == fixedPointTokens[i] {
+				check = true
+			}
+		}
+		if !check {
+			return nil, fmt.Errorf("fixed-point attribute %s is not in the sum variables", fixedPointTokens[i])
+		}
+		fixedPointFinal[fixedPointTokens[i]] = decimals
+	}
+	return fixedPointFinal, fixedPointFinal.Validate()
+}
+
 // CLIENT END: QUERIER ----------
@@ -21,6 +21,8 @@ const (
 	// definition
 	DefaultGroupFile = "group.toml"
 
+	optionSuite = "suite"
+
 	optionConfig      = "config"
 	optionConfigShort = "c"
 
@@ -45,6 +47,34 @@ const (
 
 	optionGroupBy      = "groupBy"
 	optionGroupByShort = "g"
+
+	optionFixedPoint      = "fixedPoint"
+	optionFixedPointShort = "x"
+
+	optionSchema = "schema"
+
+	optionHistograms = "histograms"
+
+	optionStatistics = "statistics"
+
+	optionMemoryBudget = "memoryBudget"
+
+	optionQuerierKey = "key"
+
+	optionEpsilon = "epsilon"
+
+	optionMinCellSize     = "minCellSize"
+	optionMergeSmallCells = "mergeSmallCells"
+
+	optionPolicy = "policy"
+
+	// decryption table flags
+
+	optionDecryptionTable      = "table"
+	optionDecryptionTableShort = "t"
+
+	optionDecryptionRange      = "range"
+	optionDecryptionRangeShort = "r"
 )
 
 func main() {
@@ -59,6 +89,11 @@ func main() {
 			Value: 0,
 			Usage: "debug-level: 1 for terse, 5 for maximal",
 		},
+		cli.StringFlag{
+			Name:  optionSuite,
+			Value: libunlynx.DefaultSuite,
+			Usage: "Cryptographic suite (e.g. Ed25519, P256), must be the same for all servers and clients",
+		},
 	}
 
 	querierFlags := []cli.Flag{
@@ -88,12 +123,69 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:  optionPredicate + ", " + optionPredicateShort,
-			Usage: "WHERE x AND y OR z (predicate) -> (v0 == v1 || v2 == v3) && v4 == v5",
+			Usage: "WHERE x AND y OR z (predicate, $i is the value of the i-th where attribute) -> (w1 == $0 OR w2 IN ($1, $2)) AND NOT w3 == $3",
 		},
 		cli.StringFlag{
 			Name:  optionGroupBy + ", " + optionGroupByShort,
 			Usage: "GROUP BY g1, g2, g3 -> {g1, g2, g3}",
 		},
+		cli.StringFlag{
+			Name:  optionFixedPoint + ", " + optionFixedPointShort,
+			Usage: "SUM attributes encoded as fixed-point values (attribute, number of decimals) -> {s1, 2, s2, 1}",
+		},
+		cli.StringFlag{
+			Name:  optionSchema,
+			Usage: "Schema file (TOML) declaring the name, role, sensitivity, domain and encoding of each attribute",
+		},
+		cli.StringFlag{
+			Name:  optionHistograms,
+			Usage: "MIN, MAX, MEDIAN and PERCENTILE of attributes with a histogram in the schema -> {MEDIAN(age), PERCENTILE(age, 90)}",
+		},
+		cli.StringFlag{
+			Name:  optionStatistics,
+			Usage: "MEAN, VARIANCE, COVARIANCE and REGRESSION (OLS) of attributes with moments in the schema -> {MEAN(s1), COVARIANCE(s1, s2), REGRESSION(y, x1, x2)}",
+		},
+		cli.Int64Flag{
+			Name:  optionMemoryBudget,
+			Usage: "Maximum size in bytes of the responses each server keeps in memory (0 for no limit), the others are spilled to disk",
+		},
+		cli.StringFlag{
+			Name:  optionQuerierKey,
+			Usage: "Key file (TOML with the base64 Public and Private keys) of the querier signing the query, a new key is used if empty",
+		},
+		cli.Float64Flag{
+			Name:  optionEpsilon,
+			Usage: "Privacy budget (epsilon) consumed by the query, charged by each server to the querier",
+		},
+		cli.Int64Flag{
+			Name:  optionMinCellSize,
+			Usage: "Minimum number of responses of a group of the results (0 for no minimum), the smaller groups are suppressed",
+		},
+		cli.BoolFlag{
+			Name:  optionMergeSmallCells,
+			Usage: "Merge the groups below the minimum cell size in an \"other\" group (without group by values) instead of suppressing them",
+		},
+		cli.StringFlag{
+			Name:  optionDecryptionTable + ", " + optionDecryptionTableShort,
+			Usage: "Decryption table file used to decode the results",
+		},
+		cli.Int64Flag{
+			Name:  optionDecryptionRange + ", " + optionDecryptionRangeShort,
+			Value: libunlynx.MaxHomomorphicInt,
+			Usage: "The (fixed-point encoded) results, including the sums of squares and products of the statistics, are decoded in [-range, range]",
+		},
+	}
+
+	tableFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:  optionDecryptionTable + ", " + optionDecryptionTableShort,
+			Usage: "Output decryption table file",
+		},
+		cli.Int64Flag{
+			Name:  optionDecryptionRange + ", " + optionDecryptionRangeShort,
+			Value: libunlynx.MaxHomomorphicInt,
+			Usage: "The table decodes all integers in [-range, range]",
+		},
 	}
 
 	serverFlags := []cli.Flag{
@@ -101,6 +193,10 @@ func main() {
 			Name:  optionConfig + ", " + optionConfigShort,
 			Usage: "Configuration file of the server",
 		},
+		cli.StringFlag{
+			Name:  optionPolicy,
+			Usage: "Query policy file (TOML) listing the allowed queriers and attributes, all the queries are accepted if empty",
+		},
 	}
 	cliApp.Commands = []cli.Command{
 		// BEGIN CLIENT: DATA PROVIDER ----------
@@ -115,6 +211,13 @@ func main() {
 			Action:  runUnLynx,
 			Flags:   querierFlags,
 		},
+		{
+			Name:    "table",
+			Aliases: []string{"t"},
+			Usage:   "Generate a decryption table file",
+			Action:  generateDecryptionTable,
+			Flags:   tableFlags,
+		},
 		// CLIENT END: QUERIER ----------
 
 		// BEGIN SERVER --------
@@ -152,7 +255,7 @@ func main() {
 	cliApp.Flags = binaryFlags
 	cliApp.Before = func(c *cli.Context) error {
 		log.SetDebugVisible(c.GlobalInt("debug"))
-		return nil
+		return libunlynx.SetSuite(c.GlobalString(optionSuite))
 	}
 	err := cliApp.Run(os.Args)
 	log.ErrFatal(err)
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/services"
 	"github.com/urfave/cli"
 	"go.dedis.ch/onet/v3/app"
 
@@ -12,6 +16,27 @@ import (
 func runServer(ctx *cli.Context) error {
 	// first check the options
 	config := ctx.String("config")
+
+	// the suite is the one the server keys were generated in
+	conf, err := app.LoadCothority(config)
+	if err != nil {
+		return err
+	}
+	if ctx.GlobalIsSet(optionSuite) && ctx.GlobalString(optionSuite) != conf.Suite {
+		return fmt.Errorf("suite %s differs from the suite %s of the configuration file", ctx.GlobalString(optionSuite), conf.Suite)
+	}
+	if err := libunlynx.SetSuite(conf.Suite); err != nil {
+		return err
+	}
+
+	// the policy is checked before the server starts
+	if policy := ctx.String(optionPolicy); policy != "" {
+		if _, err := servicesunlynx.LoadQueryPolicy(policy); err != nil {
+			return fmt.Errorf("invalid query policy: %v", err)
+		}
+		servicesunlynx.PolicyFile = policy
+	}
+
 	app.RunServer(config)
 	return nil
 }
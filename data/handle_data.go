@@ -216,6 +216,14 @@ func WriteDataToFile(filename string, testData map[string][]libunlynx.DpClearRes
 
 // ReadDataFromFile reads the testData from 'filename'.txt
 func ReadDataFromFile(filename string) (map[string][]libunlynx.DpClearResponse, error) {
+	return ReadDataFromFileWithSchema(filename, nil)
+}
+
+// ReadDataFromFileWithSchema reads the testData from 'filename'.txt and names its attributes after the schema: the
+// values of each line of an entry are the ones of the schema attributes with the line's role and sensitivity, in their
+// declaration order. Without schema, the attributes are named g0, g1... (grouping), w0... (where) and s0...
+// (aggregating).
+func ReadDataFromFileWithSchema(filename string, schema *libunlynx.Schema) (map[string][]libunlynx.DpClearResponse, error) {
 	testData := make(map[string][]libunlynx.DpClearResponse)
 
 	fileHandle, err := os.Open(filename)
@@ -263,6 +271,15 @@ func ReadDataFromFile(filename string) (map[string][]libunlynx.DpClearResponse,
 			scanner.Scan()
 			aggrEnc := libunlynxtools.StringToInt64Array(scanner.Text()[:int(math.Max(float64(0), float64(len(scanner.Text())-1)))])
 
+			if schema != nil {
+				dcr, err := schemaDpClearResponse(schema, [][]int64{grpClear, grpEnc, whereClear, whereEnc, aggrClear, aggrEnc})
+				if err != nil {
+					return nil, fmt.Errorf("data provider %s: %v", id, err)
+				}
+				container = append(container, dcr)
+				continue
+			}
+
 			container = append(container, libunlynx.DpClearResponse{
 				GroupByClear:               libunlynxtools.ConvertDataToMap(grpClear, "g", 0),
 				GroupByEnc:                 libunlynxtools.ConvertDataToMap(grpEnc, "g", len(grpClear)),
@@ -281,6 +298,26 @@ func ReadDataFromFile(filename string) (map[string][]libunlynx.DpClearResponse,
 	return testData, nil
 }
 
+// schemaDpClearResponse names the values of the lines of a data file entry (grouping, where and aggregating attributes,
+// in clear and encrypted) after the attributes of the schema
+func schemaDpClearResponse(schema *libunlynx.Schema, lines [][]int64) (libunlynx.DpClearResponse, error) {
+	roles := []libunlynx.AttributeRole{libunlynx.RoleGroupBy, libunlynx.RoleWhere, libunlynx.RoleAggregate}
+	sensitivities := []libunlynx.Sensitivity{libunlynx.SensitivityClear, libunlynx.SensitivityEncrypted}
+
+	values := make(map[string]int64)
+	for i, line := range lines {
+		role, sensitivity := roles[i/2], sensitivities[i%2]
+		names := schema.Names(role, sensitivity)
+		if len(names) != len(line) {
+			return libunlynx.DpClearResponse{}, fmt.Errorf("%d %s %s values for %d attributes in the schema", len(line), sensitivity, role, len(names))
+		}
+		for j, name := range names {
+			values[name] = line[j]
+		}
+	}
+	return schema.NewDpClearResponse(values)
+}
+
 // joinMaps concatenates two similar maps of type map[string]int64
 func joinMaps(a, b map[string]int64) map[string]int64 {
 	concat := make(map[string]int64)
@@ -314,6 +351,23 @@ func ClearExpectedResult(expectedResult []libunlynx.DpClearResponse) []libunlynx
 	return clearExpectedResult
 }
 
+// FilterData keeps the responses of the test data that satisfy a predicate evaluated in clear and removes their where
+// attributes, so that ComputeExpectedResult gives the expected result of a survey filtering them
+func FilterData(testData map[string][]libunlynx.DpClearResponse, keep func(libunlynx.DpClearResponse) bool) map[string][]libunlynx.DpClearResponse {
+	filtered := make(map[string][]libunlynx.DpClearResponse, len(testData))
+	for dp, responses := range testData {
+		filtered[dp] = make([]libunlynx.DpClearResponse, 0)
+		for _, elem := range responses {
+			if keep(elem) {
+				elem.WhereClear = map[string]int64{}
+				elem.WhereEnc = map[string]int64{}
+				filtered[dp] = append(filtered[dp], elem)
+			}
+		}
+	}
+	return filtered
+}
+
 // ComputeExpectedResult computes the expected results from the testData (we can then compare with the result obtained by service UnLynx)
 func ComputeExpectedResult(testData map[string][]libunlynx.DpClearResponse, dataRepetitions int, clear bool) []libunlynx.DpClearResponse {
 	allData := make([]libunlynx.DpClearResponse, 0)
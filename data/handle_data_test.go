@@ -52,6 +52,31 @@ func TestReadDataFromFile(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestReadDataFromFileWithSchema(t *testing.T) {
+	schema := libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "sex", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 0, Max: numType[0] - 1}},
+		{Name: "age_range", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 0, Max: numType[1] - 1}},
+		{Name: "smoker", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityEncrypted, Encoding: libunlynx.EncodingBoolean},
+		{Name: "diabetic", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityEncrypted, Encoding: libunlynx.EncodingBoolean},
+		{Name: "visits", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted},
+		{Name: "stays", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted},
+	}}
+	data, err := dataunlynx.ReadDataFromFileWithSchema(filename, &schema)
+	assert.NoError(t, err)
+	for dp, responses := range data {
+		for i, dcr := range responses {
+			assert.Equal(t, testData[dp][i].GroupByEnc["g0"], dcr.GroupByEnc["sex"])
+			assert.Equal(t, testData[dp][i].WhereEnc["w1"], dcr.WhereEnc["diabetic"])
+			assert.Equal(t, testData[dp][i].AggregatingAttributesEnc["s1"], dcr.AggregatingAttributesEnc["stays"])
+		}
+	}
+
+	// the schema must declare as many attributes as there are values in the file
+	schema.Attributes = schema.Attributes[1:]
+	_, err = dataunlynx.ReadDataFromFileWithSchema(filename, &schema)
+	assert.Error(t, err)
+}
+
 func TestCompareClearResponses(t *testing.T) {
 	data, err := dataunlynx.ReadDataFromFile(filename)
 	assert.NoError(t, err)
@@ -64,3 +89,15 @@ func TestComputeExpectedResult(t *testing.T) {
 	assert.Equal(t, dataunlynx.CompareClearResponses(dataunlynx.ComputeExpectedResult(testData, 1, false), dataunlynx.ComputeExpectedResult(data, 1, false)), true, "Result should be the same")
 	assert.Equal(t, dataunlynx.CompareClearResponses(dataunlynx.ComputeExpectedResult(testData, 1, true), dataunlynx.ComputeExpectedResult(data, 1, true)), true, "Result should be the same")
 }
+
+func TestFilterData(t *testing.T) {
+	data := map[string][]libunlynx.DpClearResponse{"0": {
+		{WhereEnc: map[string]int64{"w0": 3}, GroupByClear: map[string]int64{"g0": 1}, AggregatingAttributesEnc: map[string]int64{"s0": 2}},
+		{WhereEnc: map[string]int64{"w0": 5}, GroupByClear: map[string]int64{"g0": 1}, AggregatingAttributesEnc: map[string]int64{"s0": 4}},
+		{WhereEnc: map[string]int64{"w0": 4}, GroupByClear: map[string]int64{"g0": 1}, AggregatingAttributesEnc: map[string]int64{"s0": 8}},
+	}}
+	filtered := dataunlynx.FilterData(data, func(dcr libunlynx.DpClearResponse) bool { return dcr.WhereEnc["w0"] >= 4 })
+	assert.Equal(t, 2, len(filtered["0"]))
+	assert.Equal(t, []libunlynx.DpClearResponse{{WhereClear: map[string]int64{}, WhereEnc: map[string]int64{}, GroupByClear: map[string]int64{"g0": 1}, GroupByEnc: map[string]int64{}, AggregatingAttributesClear: map[string]int64{"s0": 12}, AggregatingAttributesEnc: map[string]int64{}}},
+		dataunlynx.ComputeExpectedResult(filtered, 1, true))
+}
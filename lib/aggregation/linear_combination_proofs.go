@@ -0,0 +1,206 @@
+package libunlynxaggr
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/ldsec/unlynx/lib"
+)
+
+// PublishedLinearCombinationProof contains all the information for one linear combination proof (weighted sum of
+// ciphervectors or dot product with clear coefficients)
+type PublishedLinearCombinationProof struct {
+	Data    []libunlynx.CipherVector
+	Weights []int64
+	Result  libunlynx.CipherVector
+}
+
+// PublishedLinearCombinationProofBytes is the 'bytes' equivalent of PublishedLinearCombinationProof
+type PublishedLinearCombinationProofBytes struct {
+	Data      []byte
+	DataLen   int64
+	VectorLen int64
+	Weights   []int64
+	Result    []byte
+}
+
+// PublishedLinearCombinationListProof contains a list of linear combination proofs
+type PublishedLinearCombinationListProof struct {
+	List []PublishedLinearCombinationProof
+}
+
+// PublishedLinearCombinationListProofBytes is the 'bytes' equivalent of PublishedLinearCombinationListProof
+type PublishedLinearCombinationListProofBytes struct {
+	List []PublishedLinearCombinationProofBytes
+}
+
+// LINEAR COMBINATION proofs
+//______________________________________________________________________________________________________________________
+
+// LinearCombinationProofCreation creates a proof for a weighted sum of ciphervectors (see CipherVector.WeightedSum)
+func LinearCombinationProofCreation(data []libunlynx.CipherVector, weights []int64, result libunlynx.CipherVector) PublishedLinearCombinationProof {
+	return PublishedLinearCombinationProof{Data: data, Weights: weights, Result: result}
+}
+
+// DotClearProofCreation creates a proof for a dot product with clear coefficients (see CipherVector.DotClear)
+func DotClearProofCreation(data libunlynx.CipherVector, coefficients []int64, result libunlynx.CipherText) PublishedLinearCombinationProof {
+	// the dot product is the weighted sum of the ciphertexts (ciphervectors of length 1)
+	vectors := make([]libunlynx.CipherVector, len(data))
+	for i, v := range data {
+		vectors[i] = libunlynx.CipherVector{v}
+	}
+	return LinearCombinationProofCreation(vectors, coefficients, libunlynx.CipherVector{result})
+}
+
+// LinearCombinationListProofCreation creates multiple proofs for linear combinations
+func LinearCombinationListProofCreation(data [][]libunlynx.CipherVector, weights [][]int64, results []libunlynx.CipherVector) PublishedLinearCombinationListProof {
+	plclp := PublishedLinearCombinationListProof{}
+	plclp.List = make([]PublishedLinearCombinationProof, len(data))
+
+	var wg sync.WaitGroup
+	for i := 0; i < len(data); i += libunlynx.VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < libunlynx.VPARALLELIZE && (i+j) < len(data); j++ {
+				plclp.List[i+j] = LinearCombinationProofCreation(data[i+j], weights[i+j], results[i+j])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return plclp
+}
+
+// LinearCombinationProofVerification verifies a linear combination proof
+func LinearCombinationProofVerification(plcp PublishedLinearCombinationProof) bool {
+	expected := make(libunlynx.CipherVector, len(plcp.Result))
+	if err := expected.WeightedSum(plcp.Data, plcp.Weights); err != nil {
+		return false
+	}
+	return expected.Equal(&plcp.Result)
+}
+
+// LinearCombinationListProofVerification verifies multiple linear combination proofs
+func LinearCombinationListProofVerification(plclp PublishedLinearCombinationListProof, percent float64) bool {
+	nbrProofsToVerify := int(math.Ceil(percent * float64(len(plclp.List))))
+	results := make([]bool, nbrProofsToVerify)
+
+	var wg sync.WaitGroup
+	for i := 0; i < nbrProofsToVerify; i += libunlynx.VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < libunlynx.VPARALLELIZE && (i+j) < nbrProofsToVerify; j++ {
+				results[i+j] = LinearCombinationProofVerification(plclp.List[i+j])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	finalResult := true
+	for _, v := range results {
+		finalResult = finalResult && v
+	}
+	return finalResult
+}
+
+// Marshal
+//______________________________________________________________________________________________________________________
+
+// ToBytes converts PublishedLinearCombinationProof to bytes
+func (plcp *PublishedLinearCombinationProof) ToBytes() (PublishedLinearCombinationProofBytes, error) {
+	plcpb := PublishedLinearCombinationProofBytes{Weights: plcp.Weights, DataLen: int64(len(plcp.Data)), VectorLen: int64(len(plcp.Result))}
+	plcpb.Data = make([]byte, 0)
+	for _, v := range plcp.Data {
+		if len(v) != len(plcp.Result) {
+			return PublishedLinearCombinationProofBytes{}, fmt.Errorf("ciphervector of length %d instead of %d", len(v), len(plcp.Result))
+		}
+		data, _, err := v.ToBytes()
+		if err != nil {
+			return PublishedLinearCombinationProofBytes{}, err
+		}
+		plcpb.Data = append(plcpb.Data, data...)
+	}
+
+	var err error
+	plcpb.Result, _, err = plcp.Result.ToBytes()
+	if err != nil {
+		return PublishedLinearCombinationProofBytes{}, err
+	}
+
+	return plcpb, nil
+}
+
+// FromBytes converts back bytes to PublishedLinearCombinationProof
+func (plcp *PublishedLinearCombinationProof) FromBytes(plcpb PublishedLinearCombinationProofBytes) error {
+	vectorByteSize := int(plcpb.VectorLen) * libunlynx.CipherTextByteSize()
+	if len(plcpb.Data) != int(plcpb.DataLen)*vectorByteSize || len(plcpb.Result) != vectorByteSize {
+		return fmt.Errorf("wrong linear combination proof length")
+	}
+
+	plcp.Data = make([]libunlynx.CipherVector, plcpb.DataLen)
+	for i := range plcp.Data {
+		if err := plcp.Data[i].FromBytes(plcpb.Data[i*vectorByteSize:(i+1)*vectorByteSize], int(plcpb.VectorLen)); err != nil {
+			return err
+		}
+	}
+	plcp.Weights = plcpb.Weights
+	return plcp.Result.FromBytes(plcpb.Result, int(plcpb.VectorLen))
+}
+
+// ToBytes converts PublishedLinearCombinationListProof to bytes
+func (plclp *PublishedLinearCombinationListProof) ToBytes() (PublishedLinearCombinationListProofBytes, error) {
+	plclpb := PublishedLinearCombinationListProofBytes{}
+	plclpb.List = make([]PublishedLinearCombinationProofBytes, len(plclp.List))
+
+	var err error
+	mutex := sync.Mutex{}
+	wg := libunlynx.StartParallelize(len(plclpb.List))
+	for i, plcp := range plclp.List {
+		go func(index int, plcp PublishedLinearCombinationProof) {
+			defer wg.Done()
+			var tmpErr error
+			plclpb.List[index], tmpErr = plcp.ToBytes()
+			if tmpErr != nil {
+				mutex.Lock()
+				err = tmpErr
+				mutex.Unlock()
+				return
+			}
+		}(i, plcp)
+	}
+	libunlynx.EndParallelize(wg)
+
+	if err != nil {
+		return PublishedLinearCombinationListProofBytes{}, err
+	}
+	return plclpb, nil
+}
+
+// FromBytes converts bytes back to PublishedLinearCombinationListProof
+func (plclp *PublishedLinearCombinationListProof) FromBytes(plclpb PublishedLinearCombinationListProofBytes) error {
+	plclp.List = make([]PublishedLinearCombinationProof, len(plclpb.List))
+
+	var err error
+	mutex := sync.Mutex{}
+	wg := libunlynx.StartParallelize(len(plclpb.List))
+	for i, plcpb := range plclpb.List {
+		go func(index int, plcpb PublishedLinearCombinationProofBytes) {
+			defer wg.Done()
+			plcp := PublishedLinearCombinationProof{}
+			tmpErr := plcp.FromBytes(plcpb)
+			if tmpErr != nil {
+				mutex.Lock()
+				err = tmpErr
+				mutex.Unlock()
+				return
+			}
+			plclp.List[index] = plcp
+		}(i, plcpb)
+	}
+	libunlynx.EndParallelize(wg)
+
+	return err
+}
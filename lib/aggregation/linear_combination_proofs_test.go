@@ -0,0 +1,44 @@
+package libunlynxaggr_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/aggregation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinearCombinationProof(t *testing.T) {
+	_, pubKey := libunlynx.GenKey()
+
+	cv1 := *libunlynx.EncryptIntVector(pubKey, []int64{1, 2, 3, 6})
+	cv2 := *libunlynx.EncryptIntVector(pubKey, []int64{4, 0, 1, 2})
+	weights := []int64{3, -2}
+
+	result := make(libunlynx.CipherVector, len(cv1))
+	require.NoError(t, result.WeightedSum([]libunlynx.CipherVector{cv1, cv2}, weights))
+	proof := libunlynxaggr.LinearCombinationProofCreation([]libunlynx.CipherVector{cv1, cv2}, weights, result)
+	assert.True(t, libunlynxaggr.LinearCombinationProofVerification(proof))
+
+	proof = libunlynxaggr.LinearCombinationProofCreation([]libunlynx.CipherVector{cv1, cv2}, []int64{3, 2}, result)
+	assert.False(t, libunlynxaggr.LinearCombinationProofVerification(proof))
+
+	dot, err := cv1.DotClear([]int64{1, 0, -1, 2})
+	require.NoError(t, err)
+	dotProof := libunlynxaggr.DotClearProofCreation(cv1, []int64{1, 0, -1, 2}, dot)
+	assert.True(t, libunlynxaggr.LinearCombinationProofVerification(dotProof))
+	dotProof = libunlynxaggr.DotClearProofCreation(cv1, []int64{1, 0, -1, 2}, cv1[0])
+	assert.False(t, libunlynxaggr.LinearCombinationProofVerification(dotProof))
+
+	listProof := libunlynxaggr.LinearCombinationListProofCreation([][]libunlynx.CipherVector{{cv1, cv2}, dotProof.Data}, [][]int64{weights, dotProof.Weights}, []libunlynx.CipherVector{result, {dot}})
+	assert.True(t, libunlynxaggr.LinearCombinationListProofVerification(listProof, 1.0))
+
+	// marshal
+	listProofBytes, err := listProof.ToBytes()
+	require.NoError(t, err)
+	newListProof := libunlynxaggr.PublishedLinearCombinationListProof{}
+	require.NoError(t, newListProof.FromBytes(listProofBytes))
+	assert.True(t, libunlynxaggr.LinearCombinationListProofVerification(newListProof, 1.0))
+	assert.True(t, newListProof.List[0].Result.Equal(&result))
+}
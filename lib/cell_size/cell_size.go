@@ -0,0 +1,74 @@
+package libunlynxcellsize
+
+import (
+	"github.com/ldsec/unlynx/lib"
+	"go.dedis.ch/kyber/v3"
+)
+
+// MaxMinCellSize bounds the minimum cell size of a survey: the count of each group of the results is compared to each
+// value below the minimum
+const MaxMinCellSize = 1024
+
+// ThresholdTests returns the ciphertexts testing whether an encrypted count is below the minimum cell size k: the
+// encryptions of count-v for v in [0, k), one of which encrypts 0 if and only if the (non-negative) count is below k.
+func ThresholdTests(count libunlynx.CipherText, k int64) libunlynx.CipherVector {
+	tests := make(libunlynx.CipherVector, k)
+	for v := int64(0); v < k; v++ {
+		tests[v].K = count.K.Clone()
+		tests[v].C = libunlynx.SuiTe.Point().Sub(count.C, libunlynx.IntToPoint(v))
+	}
+	return tests
+}
+
+// BlindSequence blinds the threshold tests of each group and removes the contribution of a server to the collective
+// key (secretKey). Each ciphertext is multiplied by a fresh random scalar, so that it only reveals whether it encrypts
+// 0, and the tests of each group are permuted, so that the position of 0 does not reveal the count. Once all the
+// servers removed their contribution, the ciphertexts contain the blinded plaintexts (see BelowThreshold).
+func BlindSequence(tests []libunlynx.CipherVector, secretKey kyber.Scalar) []libunlynx.CipherVector {
+	blinded := make([]libunlynx.CipherVector, len(tests))
+
+	wg := libunlynx.StartParallelize(len(tests))
+	for i := range tests {
+		go func(i int) {
+			defer wg.Done()
+			blinded[i] = Blind(tests[i], secretKey)
+		}(i)
+	}
+	libunlynx.EndParallelize(wg)
+
+	return blinded
+}
+
+// Blind blinds and permutes the threshold tests of a group and removes the contribution of a server to the collective
+// key (see BlindSequence)
+func Blind(tests libunlynx.CipherVector, secretKey kyber.Scalar) libunlynx.CipherVector {
+	pi := libunlynx.RandomPermutation(len(tests))
+	rs := libunlynx.RandomScalarSlice(len(tests))
+
+	blinded := make(libunlynx.CipherVector, len(tests))
+	for i, ct := range tests {
+		blinded[pi[i]] = blindCipherText(ct, rs[i], secretKey)
+	}
+	return blinded
+}
+
+// blindCipherText multiplies a ciphertext by the blinding factor r and removes the contribution of a server to the
+// collective key (secretKey)
+func blindCipherText(ct libunlynx.CipherText, r, secretKey kyber.Scalar) libunlynx.CipherText {
+	b := libunlynx.NewCipherText()
+	b.MulCipherTextbyScalar(ct, r)
+	b.C.Sub(b.C, libunlynx.SuiTe.Point().Mul(secretKey, b.K))
+	return *b
+}
+
+// BelowThreshold returns whether the threshold tests of a group, blinded by all the servers, reveal that its count is
+// below the minimum cell size, i.e. whether one of them contains 0
+func BelowThreshold(tests libunlynx.CipherVector) bool {
+	zero := libunlynx.SuiTe.Point().Null()
+	for _, ct := range tests {
+		if ct.C.Equal(zero) {
+			return true
+		}
+	}
+	return false
+}
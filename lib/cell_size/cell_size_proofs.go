@@ -0,0 +1,301 @@
+package libunlynxcellsize
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/shuffle"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/proof"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// Structs
+//______________________________________________________________________________________________________________________
+
+// PublishedBlindingProof proves that a server correctly blinded the threshold tests of a group (see BlindWithProof).
+// Shuffle proves that the tests were permuted and re-randomized under the key left to remove, then each shuffled test
+// was multiplied by a secret scalar and the contribution Public = x*B of the server to the key was removed (Blinded).
+type PublishedBlindingProof struct {
+	Shuffle libunlynxshuffle.PublishedShufflingProof
+	Public  kyber.Point
+	Blinded libunlynx.CipherVector
+	Proof   []byte
+}
+
+// PublishedBlindingProofBytes is the 'bytes' equivalent of PublishedBlindingProof
+type PublishedBlindingProofBytes struct {
+	Shuffle libunlynxshuffle.PublishedShufflingProofBytes
+	Public  []byte
+	Blinded []byte
+	Proof   []byte
+}
+
+// PublishedBlindingListProof contains the blinding proofs of one server, one for each group
+type PublishedBlindingListProof struct {
+	List []PublishedBlindingProof
+}
+
+// PublishedBlindingListProofBytes is the 'bytes' equivalent of PublishedBlindingListProof
+type PublishedBlindingListProofBytes struct {
+	List []PublishedBlindingProofBytes
+}
+
+// BLINDING proofs
+//______________________________________________________________________________________________________________________
+
+func createPredicateBlinding(nbrTests int) (predicate proof.Predicate) {
+	// the same secret (nx = -x) removes the contribution of the server from all the tests
+	preds := []proof.Predicate{proof.Rep("NX", "nx", "B")}
+	for i := 0; i < nbrTests; i++ {
+		index := strconv.Itoa(i)
+		preds = append(preds, proof.Rep("Kb"+index, "r"+index, "Ks"+index))
+		preds = append(preds, proof.Rep("Cb"+index, "r"+index, "Cs"+index, "nx", "Kb"+index))
+	}
+	predicate = proof.And(preds...)
+	return
+}
+
+func blindingPoints(pbp PublishedBlindingProof) map[string]kyber.Point {
+	pval := map[string]kyber.Point{"NX": libunlynx.SuiTe.Point().Neg(pbp.Public), "B": libunlynx.SuiTe.Point().Base()}
+	for i, row := range pbp.Shuffle.ShuffledList {
+		index := strconv.Itoa(i)
+		pval["Ks"+index] = row[0].K
+		pval["Cs"+index] = row[0].C
+		pval["Kb"+index] = pbp.Blinded[i].K
+		pval["Cb"+index] = pbp.Blinded[i].C
+	}
+	return pval
+}
+
+// BlindWithProof blinds the threshold tests of a group like Blind and proves it. The tests are encrypted under the key
+// h left to remove (the sum of the contributions of the servers which did not blind them yet): they are shuffled and
+// re-randomized under it instead of only being permuted, so that the permutation can be proven.
+func BlindWithProof(tests libunlynx.CipherVector, secretKey kyber.Scalar, h kyber.Point) (libunlynx.CipherVector, PublishedBlindingProof, error) {
+	if len(tests) == 0 {
+		return nil, PublishedBlindingProof{}, fmt.Errorf("no threshold test to blind")
+	}
+	// each test is a line of the shuffle
+	lines := make([]libunlynx.CipherVector, len(tests))
+	for i, ct := range tests {
+		lines[i] = libunlynx.CipherVector{ct}
+	}
+	base := libunlynx.SuiTe.Point().Base()
+	// a single test is not shuffled (there is no permutation to hide)
+	shuffled, psp := lines, libunlynxshuffle.PublishedShufflingProof{OriginalList: lines, ShuffledList: lines, G: base, H: h}
+	if len(lines) > 1 {
+		var pi []int
+		var beta [][]kyber.Scalar
+		shuffled, pi, beta = libunlynxshuffle.ShuffleSequence(lines, base, h, nil)
+		var err error
+		if psp, err = libunlynxshuffle.ShuffleProofCreation(lines, shuffled, base, h, beta, pi); err != nil {
+			return nil, PublishedBlindingProof{}, err
+		}
+	}
+
+	rs := libunlynx.RandomScalarSlice(len(tests))
+	blinded := make(libunlynx.CipherVector, len(tests))
+	for i, line := range shuffled {
+		blinded[i] = blindCipherText(line[0], rs[i], secretKey)
+	}
+	pbp := PublishedBlindingProof{Shuffle: psp, Public: libunlynx.SuiTe.Point().Mul(secretKey, nil), Blinded: blinded}
+
+	sval := map[string]kyber.Scalar{"nx": libunlynx.SuiTe.Scalar().Neg(secretKey)}
+	for i, r := range rs {
+		sval["r"+strconv.Itoa(i)] = r
+	}
+	prover := createPredicateBlinding(len(tests)).Prover(libunlynx.SuiTe, sval, blindingPoints(pbp), nil)
+	var err error
+	if pbp.Proof, err = proof.HashProve(libunlynx.SuiTe, "blindingProof", prover); err != nil {
+		return nil, PublishedBlindingProof{}, fmt.Errorf("---------prover: %v", err)
+	}
+	return blinded, pbp, nil
+}
+
+// BlindSequenceWithProof blinds the threshold tests of each group like BlindSequence and proves it (see BlindWithProof)
+func BlindSequenceWithProof(tests []libunlynx.CipherVector, secretKey kyber.Scalar, h kyber.Point) ([]libunlynx.CipherVector, PublishedBlindingListProof, error) {
+	blinded := make([]libunlynx.CipherVector, len(tests))
+	pblp := PublishedBlindingListProof{List: make([]PublishedBlindingProof, len(tests))}
+
+	errs := make([]error, len(tests))
+	wg := libunlynx.StartParallelize(len(tests))
+	for i := range tests {
+		go func(i int) {
+			defer wg.Done()
+			blinded[i], pblp.List[i], errs[i] = BlindWithProof(tests[i], secretKey, h)
+		}(i)
+	}
+	libunlynx.EndParallelize(wg)
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, PublishedBlindingListProof{}, err
+		}
+	}
+	return blinded, pblp, nil
+}
+
+// BlindingProofVerification verifies that the blinded tests of a group were computed from the given tests, encrypted
+// under the key h left to remove, by the server with the public contribution public
+func BlindingProofVerification(pbp PublishedBlindingProof, tests libunlynx.CipherVector, h, public kyber.Point) bool {
+	psp := pbp.Shuffle
+	if len(psp.OriginalList) != len(tests) || len(psp.ShuffledList) != len(tests) || len(pbp.Blinded) != len(tests) {
+		log.Error("blinding proof for ", len(psp.OriginalList), " tests instead of ", len(tests))
+		return false
+	}
+	for i, ct := range tests {
+		if len(psp.OriginalList[i]) != 1 || len(psp.ShuffledList[i]) != 1 || !equalCipherTexts(psp.OriginalList[i][0], ct) {
+			log.Error("blinding proof for other tests")
+			return false
+		}
+	}
+	if !psp.G.Equal(libunlynx.SuiTe.Point().Base()) || !psp.H.Equal(h) || !pbp.Public.Equal(public) {
+		log.Error("blinding proof with the wrong keys")
+		return false
+	}
+	// a null blinding factor would turn any test into an encryption of 0
+	null := libunlynx.SuiTe.Point().Null()
+	for _, ct := range pbp.Blinded {
+		if ct.K.Equal(null) {
+			log.Error("null blinding factor")
+			return false
+		}
+	}
+
+	if len(tests) == 1 {
+		if !equalCipherTexts(psp.ShuffledList[0][0], tests[0]) {
+			log.Error("single test shuffled")
+			return false
+		}
+	} else if !libunlynxshuffle.ShuffleProofVerification(psp, h) {
+		return false
+	}
+	verifier := createPredicateBlinding(len(tests)).Verifier(libunlynx.SuiTe, blindingPoints(pbp))
+	if err := proof.HashVerify(libunlynx.SuiTe, "blindingProof", verifier, pbp.Proof); err != nil {
+		log.Error("---------Verifier:", err.Error())
+		return false
+	}
+	return true
+}
+
+// BlindingListProofVerification verifies the blinding proofs of all the servers, in the order in which they blinded the
+// threshold tests: the first one blinded the tests, each next one the tests blinded by the previous one and the last
+// one output the blinded tests. keys are the keys left to remove when each server blinded the tests and publics their
+// public contributions.
+func BlindingListProofVerification(pblps []PublishedBlindingListProof, tests, blinded []libunlynx.CipherVector, keys, publics []kyber.Point) bool {
+	if len(pblps) != len(keys) || len(pblps) != len(publics) {
+		log.Error("got ", len(pblps), " blinding proofs for ", len(publics), " servers")
+		return false
+	}
+	for _, pblp := range pblps {
+		if len(pblp.List) != len(tests) {
+			log.Error("blinding proof for ", len(pblp.List), " groups instead of ", len(tests))
+			return false
+		}
+	}
+	if len(blinded) != len(tests) {
+		log.Error(len(blinded), " blinded groups instead of ", len(tests))
+		return false
+	}
+
+	results := make([]bool, len(tests))
+	wg := libunlynx.StartParallelize(len(tests))
+	for g := range tests {
+		go func(g int) {
+			defer wg.Done()
+			input := tests[g]
+			for i, pblp := range pblps {
+				if !BlindingProofVerification(pblp.List[g], input, keys[i], publics[i]) {
+					return
+				}
+				input = pblp.List[g].Blinded
+			}
+			results[g] = equalCipherVectors(input, blinded[g])
+		}(g)
+	}
+	libunlynx.EndParallelize(wg)
+
+	finalResult := true
+	for _, v := range results {
+		finalResult = finalResult && v
+	}
+	return finalResult
+}
+
+// equalCipherTexts checks whether two ciphertexts are the same
+func equalCipherTexts(a, b libunlynx.CipherText) bool {
+	return a.K.Equal(b.K) && a.C.Equal(b.C)
+}
+
+// equalCipherVectors checks whether two cipher vectors are the same
+func equalCipherVectors(a, b libunlynx.CipherVector) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !equalCipherTexts(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Marshal
+//______________________________________________________________________________________________________________________
+
+// ToBytes converts PublishedBlindingProof to bytes
+func (pbp *PublishedBlindingProof) ToBytes() (PublishedBlindingProofBytes, error) {
+	pbpb := PublishedBlindingProofBytes{Proof: pbp.Proof}
+
+	var err error
+	if pbpb.Shuffle, err = pbp.Shuffle.ToBytes(); err != nil {
+		return PublishedBlindingProofBytes{}, err
+	}
+	if pbpb.Public, err = pbp.Public.MarshalBinary(); err != nil {
+		return PublishedBlindingProofBytes{}, err
+	}
+	if pbpb.Blinded, _, err = pbp.Blinded.ToBytes(); err != nil {
+		return PublishedBlindingProofBytes{}, err
+	}
+	return pbpb, nil
+}
+
+// FromBytes converts back bytes to PublishedBlindingProof
+func (pbp *PublishedBlindingProof) FromBytes(pbpb PublishedBlindingProofBytes) error {
+	pbp.Proof = pbpb.Proof
+	if pbpb.Shuffle.OriginalList == nil || pbpb.Shuffle.ShuffledList == nil || pbpb.Shuffle.G == nil || pbpb.Shuffle.H == nil {
+		return fmt.Errorf("incomplete shuffle proof")
+	}
+	if err := pbp.Shuffle.FromBytes(pbpb.Shuffle); err != nil {
+		return err
+	}
+	pbp.Public = libunlynx.SuiTe.Point()
+	if err := pbp.Public.UnmarshalBinary(pbpb.Public); err != nil {
+		return err
+	}
+	return pbp.Blinded.FromBytes(pbpb.Blinded, len(pbpb.Blinded)/libunlynx.CipherTextByteSize())
+}
+
+// ToBytes converts PublishedBlindingListProof to bytes
+func (pblp *PublishedBlindingListProof) ToBytes() (PublishedBlindingListProofBytes, error) {
+	pblpb := PublishedBlindingListProofBytes{List: make([]PublishedBlindingProofBytes, len(pblp.List))}
+	for i, pbp := range pblp.List {
+		var err error
+		if pblpb.List[i], err = pbp.ToBytes(); err != nil {
+			return PublishedBlindingListProofBytes{}, err
+		}
+	}
+	return pblpb, nil
+}
+
+// FromBytes converts bytes back to PublishedBlindingListProof
+func (pblp *PublishedBlindingListProof) FromBytes(pblpb PublishedBlindingListProofBytes) error {
+	pblp.List = make([]PublishedBlindingProof, len(pblpb.List))
+	for i, pbpb := range pblpb.List {
+		if err := pblp.List[i].FromBytes(pbpb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
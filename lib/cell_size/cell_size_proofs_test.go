@@ -0,0 +1,82 @@
+package libunlynxcellsize_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/cell_size"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/key"
+)
+
+// TestBlindingProof tests the creation and verification of the proofs of the blinding of threshold tests
+func TestBlindingProof(t *testing.T) {
+	keys := []*key.Pair{key.NewKeyPair(libunlynx.SuiTe), key.NewKeyPair(libunlynx.SuiTe), key.NewKeyPair(libunlynx.SuiTe)}
+	publics := make([]kyber.Point, len(keys))
+	collectiveKey := libunlynx.SuiTe.Point().Null()
+	for i, kp := range keys {
+		publics[i] = kp.Public
+		collectiveKey.Add(collectiveKey, kp.Public)
+	}
+
+	k := int64(3)
+	counts := []int64{0, 2, 3, 7}
+	tests := make([]libunlynx.CipherVector, len(counts))
+	for i, c := range counts {
+		tests[i] = libunlynxcellsize.ThresholdTests(*libunlynx.EncryptInt(collectiveKey, c), k)
+	}
+
+	// each server blinds the tests under the key left to remove
+	remaining := make([]kyber.Point, len(keys))
+	h := collectiveKey.Clone()
+	blinded := tests
+	pblps := make([]libunlynxcellsize.PublishedBlindingListProof, len(keys))
+	for i, kp := range keys {
+		remaining[i] = h.Clone()
+		var err error
+		blinded, pblps[i], err = libunlynxcellsize.BlindSequenceWithProof(blinded, kp.Private, remaining[i])
+		require.NoError(t, err)
+		h.Sub(h, kp.Public)
+	}
+	for i, c := range counts {
+		assert.Equal(t, c < k, libunlynxcellsize.BelowThreshold(blinded[i]), "count %d", c)
+	}
+	assert.True(t, libunlynxcellsize.BlindingListProofVerification(pblps, tests, blinded, remaining, publics))
+
+	// marshal and unmarshal
+	pblpb, err := pblps[1].ToBytes()
+	require.NoError(t, err)
+	pblpCopy := libunlynxcellsize.PublishedBlindingListProof{}
+	require.NoError(t, pblpCopy.FromBytes(pblpb))
+	pblps[1] = pblpCopy
+	assert.True(t, libunlynxcellsize.BlindingListProofVerification(pblps, tests, blinded, remaining, publics))
+
+	// the servers in another order
+	assert.False(t, libunlynxcellsize.BlindingListProofVerification([]libunlynxcellsize.PublishedBlindingListProof{pblps[1], pblps[0], pblps[2]}, tests, blinded, remaining, publics))
+	// other tests
+	assert.False(t, libunlynxcellsize.BlindingListProofVerification(pblps, blinded, blinded, remaining, publics))
+
+	// a test replaced by an encryption of 0 (forcing the suppression of the group)
+	forged := append(libunlynx.CipherVector{}, pblps[2].List[3].Blinded...)
+	forged[0] = libunlynx.CipherText{K: forged[0].K, C: libunlynx.SuiTe.Point().Null()}
+	pbp := pblps[2].List[3]
+	pbp.Blinded = forged
+	assert.False(t, libunlynxcellsize.BlindingProofVerification(pbp, pblps[1].List[3].Blinded, remaining[2], publics[2]))
+
+	// a null blinding factor
+	_, pbp, err = libunlynxcellsize.BlindWithProof(tests[0], keys[0].Private, collectiveKey)
+	require.NoError(t, err)
+	assert.True(t, libunlynxcellsize.BlindingProofVerification(pbp, tests[0], collectiveKey, publics[0]))
+	for i := range pbp.Blinded {
+		pbp.Blinded[i] = libunlynx.CipherText{K: libunlynx.SuiTe.Point().Null(), C: libunlynx.SuiTe.Point().Null()}
+	}
+	assert.False(t, libunlynxcellsize.BlindingProofVerification(pbp, tests[0], collectiveKey, publics[0]))
+
+	// a single test
+	single := libunlynxcellsize.ThresholdTests(*libunlynx.EncryptInt(collectiveKey, 0), 1)
+	_, pbp, err = libunlynxcellsize.BlindWithProof(single, keys[0].Private, collectiveKey)
+	require.NoError(t, err)
+	assert.True(t, libunlynxcellsize.BlindingProofVerification(pbp, single, collectiveKey, publics[0]))
+}
@@ -0,0 +1,42 @@
+package libunlynxcellsize_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/cell_size"
+	"github.com/stretchr/testify/assert"
+	"go.dedis.ch/kyber/v3/util/key"
+)
+
+// TestCellSize tests the threshold tests of encrypted counts blinded by several servers
+func TestCellSize(t *testing.T) {
+	keys := []*key.Pair{key.NewKeyPair(libunlynx.SuiTe), key.NewKeyPair(libunlynx.SuiTe), key.NewKeyPair(libunlynx.SuiTe)}
+	collectiveKey := libunlynx.SuiTe.Point().Null()
+	for _, kp := range keys {
+		collectiveKey.Add(collectiveKey, kp.Public)
+	}
+
+	k := int64(5)
+	counts := []int64{0, 1, 4, 5, 6, 100}
+	tests := make([]libunlynx.CipherVector, len(counts))
+	for i, c := range counts {
+		tests[i] = libunlynxcellsize.ThresholdTests(*libunlynx.EncryptInt(collectiveKey, c), k)
+		assert.Equal(t, int(k), len(tests[i]))
+	}
+
+	for _, kp := range keys {
+		tests = libunlynxcellsize.BlindSequence(tests, kp.Private)
+	}
+	for i, c := range counts {
+		assert.Equal(t, c < k, libunlynxcellsize.BelowThreshold(tests[i]), "count %d", c)
+	}
+
+	// the tests are not decrypted until all the servers removed their contribution
+	test := libunlynxcellsize.ThresholdTests(*libunlynx.EncryptInt(collectiveKey, 2), k)
+	test = libunlynxcellsize.Blind(test, keys[0].Private)
+	test = libunlynxcellsize.Blind(test, keys[1].Private)
+	assert.False(t, libunlynxcellsize.BelowThreshold(test))
+	test = libunlynxcellsize.Blind(test, keys[2].Private)
+	assert.True(t, libunlynxcellsize.BelowThreshold(test))
+}
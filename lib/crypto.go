@@ -4,6 +4,7 @@ import (
 	"encoding"
 	"encoding/base64"
 	"fmt"
+	"math"
 	"math/big"
 	"strings"
 	"sync"
@@ -16,13 +17,20 @@ import (
 	"go.dedis.ch/onet/v3/log"
 )
 
-// MaxHomomorphicInt is upper bound for integers used in messages, a failed decryption will return this value.
+// MaxHomomorphicInt is the default decoding range of DecryptInt and DecryptIntWithNeg, a failed decryption returns 0.
 const MaxHomomorphicInt int64 = 100000
 
-// PointToInt creates a map between EC points and integers.
+// PointToInt caches the already decoded points (EC point -> integer).
 var PointToInt = concurrent.NewConcurrentMap()
-var currentGreatestM kyber.Point
-var currentGreatestInt int64
+
+// maxBabySteps bounds the size of a baby-step table, hence the decoding range: [0, maxBabySteps^2) (about 2.8e14).
+const maxBabySteps int64 = 1 << 24
+
+// maxBabyStepsTables is the number of baby-step tables kept in the cache.
+const maxBabyStepsTables = 4
+
+// babyStepsTables contains the baby-step tables used by the baby-step giant-step decoding, indexed by their size.
+var babyStepsTables = make(map[int64]*babyStepsTable)
 var mutex = sync.Mutex{}
 
 // PublishedSimpleAdditionProof contains the two added ciphervectors and the resulting ciphervector
@@ -262,25 +270,50 @@ func decryptPoint(prikey kyber.Scalar, c CipherText) kyber.Point {
 }
 
 // DecryptInt decrypts an integer from an ElGamal cipher text where integer are encoded in the exponent.
+// The integer must lie in [0, MaxHomomorphicInt], otherwise 0 is returned.
 func DecryptInt(prikey kyber.Scalar, cipher CipherText) int64 {
-	M := decryptPoint(prikey, cipher)
-	v, err := discreteLog(M, false)
+	v, err := DecryptIntRange(prikey, cipher, MaxHomomorphicInt)
 	if err != nil {
+		log.Error(err)
 		return 0
 	}
 	return v
 }
 
 // DecryptIntWithNeg decrypts an integer from an ElGamal cipher text where integer are encoded in the exponent.
+// The integer must lie in [-MaxHomomorphicInt, MaxHomomorphicInt], otherwise 0 is returned.
 func DecryptIntWithNeg(prikey kyber.Scalar, cipher CipherText) int64 {
-	M := decryptPoint(prikey, cipher)
-	v, err := discreteLog(M, true)
+	v, err := DecryptIntWithNegRange(prikey, cipher, MaxHomomorphicInt)
 	if err != nil {
+		log.Error(err)
 		return 0
 	}
 	return v
 }
 
+// DecryptIntRange decrypts an integer in [0, limit] from an ElGamal cipher text where integer are encoded in the exponent.
+func DecryptIntRange(prikey kyber.Scalar, cipher CipherText, limit int64) (int64, error) {
+	M := decryptPoint(prikey, cipher)
+	return discreteLog(M, false, limit)
+}
+
+// DecryptIntWithNegRange decrypts an integer in [-limit, limit] from an ElGamal cipher text where integer are encoded
+// in the exponent.
+func DecryptIntWithNegRange(prikey kyber.Scalar, cipher CipherText, limit int64) (int64, error) {
+	M := decryptPoint(prikey, cipher)
+	return discreteLog(M, true, limit)
+}
+
+// DecodeIntRange decodes an integer in [0, limit] from a plaintext point (e.g. the result of a collective decryption).
+func DecodeIntRange(P kyber.Point, limit int64) (int64, error) {
+	return discreteLog(P, false, limit)
+}
+
+// DecodeIntWithNegRange decodes an integer in [-limit, limit] from a plaintext point.
+func DecodeIntWithNegRange(P kyber.Point, limit int64) (int64, error) {
+	return discreteLog(P, true, limit)
+}
+
 // DecryptIntVector decrypts a cipherVector.
 func DecryptIntVector(prikey kyber.Scalar, cipherVector *CipherVector) []int64 {
 	result := make([]int64, len(*cipherVector))
@@ -299,6 +332,32 @@ func DecryptIntVectorWithNeg(prikey kyber.Scalar, cipherVector *CipherVector) []
 	return result
 }
 
+// DecryptIntVectorRange decrypts a cipherVector whose integers lie in [0, limit].
+func DecryptIntVectorRange(prikey kyber.Scalar, cipherVector *CipherVector, limit int64) ([]int64, error) {
+	result := make([]int64, len(*cipherVector))
+	for i, c := range *cipherVector {
+		v, err := DecryptIntRange(prikey, c, limit)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+// DecryptIntVectorWithNegRange decrypts a cipherVector whose integers lie in [-limit, limit].
+func DecryptIntVectorWithNegRange(prikey kyber.Scalar, cipherVector *CipherVector, limit int64) ([]int64, error) {
+	result := make([]int64, len(*cipherVector))
+	for i, c := range *cipherVector {
+		v, err := DecryptIntWithNegRange(prikey, c, limit)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
 // DecryptCheckZero check if the encrypted value is a 0. Does not do the complete decryption
 func DecryptCheckZero(prikey kyber.Scalar, cipher CipherText) int64 {
 	M := decryptPoint(prikey, cipher)
@@ -318,76 +377,140 @@ func DecryptCheckZeroVector(prikey kyber.Scalar, cipherVector *CipherVector) []i
 	return result
 }
 
-// Brute-Forces the discrete log for integer decoding.
-func discreteLog(P kyber.Point, checkNeg bool) (int64, error) {
+// babyStepsTable contains the baby steps {jB, 0 <= j < m} and the giant step -mB of the baby-step giant-step algorithm.
+type babyStepsTable struct {
+	m         int64
+	steps     map[string]int64
+	giantStep kyber.Point
+}
+
+// newBabyStepsTable computes the baby steps for a giant step of size m.
+func newBabyStepsTable(m int64) (*babyStepsTable, error) {
 	B := SuiTe.Point().Base()
+	table := &babyStepsTable{m: m, steps: make(map[string]int64, m)}
+
+	step := SuiTe.Point().Null()
+	for j := int64(0); j < m; j++ {
+		key, err := step.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		table.steps[string(key)] = j
+		step = SuiTe.Point().Add(step, B)
+	}
+	// step is now equal to mB
+	table.giantStep = SuiTe.Point().Neg(step)
+	return table, nil
+}
+
+// getBabyStepsTable returns the (cached) baby-step table able to decode all integers in [0, limit]. The cache keeps at
+// most maxBabyStepsTables tables, the smallest one is evicted first.
+func getBabyStepsTable(limit int64) (*babyStepsTable, error) {
+	m := int64(math.Ceil(math.Sqrt(float64(limit) + 1)))
+	if m > maxBabySteps {
+		return nil, fmt.Errorf("decoding range too large: %d (at most %d)", limit, maxBabySteps*maxBabySteps-1)
+	}
 
-	//check if the point is already in the table
 	mutex.Lock()
-	decrypted, ok := PointToInt.Get(P.String())
-	if ok == nil && decrypted != nil {
-		mutex.Unlock()
-		return decrypted.(int64), nil
-	}
-
-	//otherwise, we complete/create the table while decrypting
-	//initialise
-	if currentGreatestInt == 0 {
-		currentGreatestM = SuiTe.Point().Null()
-	}
-	foundPos := false
-	foundNeg := false
-	guess := currentGreatestM
-	guessInt := currentGreatestInt
-	guessNeg := SuiTe.Point().Null()
-	guessIntMinus := int64(0)
-
-	start := true
-	for i := guessInt; i < MaxHomomorphicInt && !foundPos && !foundNeg; i = i + 1 {
-		// check for 0 first
-		if !start {
-			guess = SuiTe.Point().Add(guess, B)
+	defer mutex.Unlock()
+	if table, ok := babyStepsTables[m]; ok {
+		return table, nil
+	}
+	table, err := newBabyStepsTable(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(babyStepsTables) >= maxBabyStepsTables {
+		smallest := int64(math.MaxInt64)
+		for size := range babyStepsTables {
+			if size < smallest {
+				smallest = size
+			}
 		}
-		start = false
+		delete(babyStepsTables, smallest)
+	}
+	babyStepsTables[m] = table
+	return table, nil
+}
 
-		guessInt = i
-		if _, err := PointToInt.Put(guess.String(), guessInt); err != nil {
-			return -1, err
+// search looks for x in [0, limit] such that P = xB by walking at most limit/m giant steps.
+func (t *babyStepsTable) search(P kyber.Point, limit int64) (int64, bool, error) {
+	guess := P.Clone()
+	for i := int64(0); i <= limit/t.m; i++ {
+		key, err := guess.MarshalBinary()
+		if err != nil {
+			return 0, false, err
 		}
-		if checkNeg {
-			guessIntMinus = -guessInt
-			guessNeg = SuiTe.Point().Mul(SuiTe.Scalar().SetInt64(guessIntMinus), B)
-			if _, err := PointToInt.Put(guessNeg.String(), guessIntMinus); err != nil {
-				return -1, err
+		if j, ok := t.steps[string(key)]; ok {
+			if x := i*t.m + j; x <= limit {
+				return x, true, nil
 			}
+			return 0, false, nil
+		}
+		guess = SuiTe.Point().Add(guess, t.giantStep)
+	}
+	return 0, false, nil
+}
 
-			if guessNeg.Equal(P) {
-				foundNeg = true
-			}
+// discreteLog uses the baby-step giant-step algorithm to decode the integer encoded in P. It looks in [0, limit] or,
+// if checkNeg is set, in [-limit, limit].
+func discreteLog(P kyber.Point, checkNeg bool, limit int64) (int64, error) {
+	if limit < 0 {
+		return 0, fmt.Errorf("invalid decoding range: %d", limit)
+	}
+
+	//check if the point is in the loaded decryption table
+	if dt := getDecryptionTable(); dt != nil {
+		if v, ok := dt.Lookup(P); ok && (v >= 0 || checkNeg) && v <= limit && v >= -limit {
+			return v, nil
 		}
-		if !foundNeg && guess.Equal(P) {
-			foundPos = true
+	}
+
+	//check if the point has already been decoded
+	decrypted, err := PointToInt.Get(P.String())
+	if err == nil && decrypted != nil {
+		v := decrypted.(int64)
+		if (v >= 0 || checkNeg) && v <= limit && v >= -limit {
+			return v, nil
 		}
 	}
-	currentGreatestM = guess
-	currentGreatestInt = guessInt
-	mutex.Unlock()
 
-	if !foundPos && !foundNeg {
-		log.Error("out of bound encryption, bound is ", MaxHomomorphicInt)
-		return 0, nil
+	table, err := getBabyStepsTable(limit)
+	if err != nil {
+		return 0, err
+	}
+
+	v, found, err := table.search(P, limit)
+	if err != nil {
+		return 0, err
+	}
+	if !found && checkNeg {
+		v, found, err = table.search(SuiTe.Point().Neg(P), limit)
+		if err != nil {
+			return 0, err
+		}
+		v = -v
 	}
 
-	if foundNeg {
-		return guessIntMinus, nil
+	if !found {
+		if checkNeg {
+			return 0, fmt.Errorf("out of bound encryption, bound is [%d, %d]", -limit, limit)
+		}
+		return 0, fmt.Errorf("out of bound encryption, bound is [0, %d]", limit)
 	}
-	return guessInt, nil
+
+	if _, err := PointToInt.Put(P.String(), v); err != nil {
+		return 0, err
+	}
+	return v, nil
 }
 
-// CreateDecryptionTable generated the lookup table for decryption of all the integers in [-limit, limit]
+// CreateDecryptionTable generates the lookup table for decryption of all the integers in [-limit, limit]
 func CreateDecryptionTable(limit int64, pubKey kyber.Point, secKey kyber.Scalar) {
 	dummy := EncryptInt(pubKey, int64(limit))
-	DecryptIntWithNeg(secKey, *dummy)
+	if _, err := DecryptIntWithNegRange(secKey, *dummy, limit); err != nil {
+		log.Error(err)
+	}
 }
 
 // Homomorphic Operations
@@ -411,6 +534,12 @@ func (c *CipherText) Sub(c1, c2 CipherText) {
 	c.K = SuiTe.Point().Sub(c1.K, c2.K)
 }
 
+// Neg negates a ciphertext (it then encrypts the opposite of the plaintext) and stores result in receiver.
+func (c *CipherText) Neg(c1 CipherText) {
+	c.C = SuiTe.Point().Neg(c1.C)
+	c.K = SuiTe.Point().Neg(c1.K)
+}
+
 // Equal checks equality between ciphertexts.
 func (c *CipherText) Equal(c2 *CipherText) bool {
 	return c2.K.Equal(c.K) && c2.C.Equal(c.C)
@@ -440,6 +569,104 @@ func (cv *CipherVector) Sub(cv1, cv2 CipherVector) {
 	}
 }
 
+// Neg negates all elements of a ciphervector and stores result in receiver.
+func (cv *CipherVector) Neg(cv1 CipherVector) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < len(cv1); i = i + VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < VPARALLELIZE && (j+i < len(cv1)); j++ {
+				(*cv)[i+j].Neg(cv1[i+j])
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// ScalarMul multiplies all elements of a ciphervector by the same scalar and stores result in receiver.
+func (cv *CipherVector) ScalarMul(cv1 CipherVector, a kyber.Scalar) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < len(cv1); i = i + VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < VPARALLELIZE && (j+i < len(cv1)); j++ {
+				(*cv)[i+j].MulCipherTextbyScalar(cv1[i+j], a)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// DotClear computes the dot product of a ciphervector with a vector of clear coefficients: sum_i coefficients[i]*cv[i].
+func (cv *CipherVector) DotClear(coefficients []int64) (CipherText, error) {
+	if len(coefficients) != len(*cv) {
+		return CipherText{}, fmt.Errorf("%d coefficients for %d ciphertexts", len(coefficients), len(*cv))
+	}
+
+	// each chunk of VPARALLELIZE elements is summed separately
+	partials := make(CipherVector, (len(*cv)+VPARALLELIZE-1)/VPARALLELIZE)
+	var wg sync.WaitGroup
+	for i := 0; i < len(*cv); i = i + VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			partial := *NewCipherText()
+			for j := 0; j < VPARALLELIZE && (j+i < len(*cv)); j++ {
+				tmp := CipherText{}
+				tmp.MulCipherTextbyScalar((*cv)[i+j], SuiTe.Scalar().SetInt64(coefficients[i+j]))
+				partial.Add(partial, tmp)
+			}
+			partials[i/VPARALLELIZE] = partial
+		}(i)
+	}
+	wg.Wait()
+
+	result := *NewCipherText()
+	for _, v := range partials {
+		result.Add(result, v)
+	}
+	return result, nil
+}
+
+// WeightedSum computes the weighted sum of ciphervectors: sum_k weights[k]*cvs[k], and stores result in receiver.
+func (cv *CipherVector) WeightedSum(cvs []CipherVector, weights []int64) error {
+	if len(weights) != len(cvs) {
+		return fmt.Errorf("%d weights for %d ciphervectors", len(weights), len(cvs))
+	}
+	for _, v := range cvs {
+		if len(v) != len(*cv) {
+			return fmt.Errorf("ciphervector of length %d instead of %d", len(v), len(*cv))
+		}
+	}
+	scalars := make([]kyber.Scalar, len(weights))
+	for k, w := range weights {
+		scalars[k] = SuiTe.Scalar().SetInt64(w)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < len(*cv); i = i + VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < VPARALLELIZE && (j+i < len(*cv)); j++ {
+				sum := *NewCipherText()
+				for k := range cvs {
+					tmp := CipherText{}
+					tmp.MulCipherTextbyScalar(cvs[k][i+j], scalars[k])
+					sum.Add(sum, tmp)
+				}
+				(*cv)[i+j] = sum
+			}
+		}(i)
+	}
+	wg.Wait()
+	return nil
+}
+
 // Equal checks equality between ciphervector.
 func (cv *CipherVector) Equal(cv2 *CipherVector) bool {
 	if cv == nil || cv2 == nil {
@@ -581,7 +808,7 @@ func (cv *CipherVector) ToBytes() ([]byte, int, error) {
 // FromBytes converts a byte array to a CipherVector. Note that you need to create the (empty) object beforehand.
 func (cv *CipherVector) FromBytes(data []byte, length int) error {
 	*cv = make(CipherVector, length)
-	cipherLength := 2 * SuiTe.PointLen()
+	cipherLength := CipherTextByteSize()
 	for i, pos := 0, 0; i < length*cipherLength; i, pos = i+cipherLength, pos+1 {
 		ct := CipherText{}
 		if err := ct.FromBytes(data[i : i+cipherLength]); err != nil {
@@ -592,7 +819,7 @@ func (cv *CipherVector) FromBytes(data []byte, length int) error {
 	return nil
 }
 
-// ToBytes converts a CipherText to a byte array
+// ToBytes converts a CipherText to a byte array (prefixed by the identifier of the suite)
 func (c *CipherText) ToBytes() ([]byte, error) {
 	k, errK := (*c).K.MarshalBinary()
 	if errK != nil {
@@ -602,13 +829,21 @@ func (c *CipherText) ToBytes() ([]byte, error) {
 	if errC != nil {
 		return nil, errC
 	}
-	b := append(k, cP...)
+	b := AddSuitePrefix(append(k, cP...))
 
 	return b, nil
 }
 
 // FromBytes converts a byte array to a CipherText. Note that you need to create the (empty) object beforehand.
 func (c *CipherText) FromBytes(data []byte) error {
+	if len(data) != CipherTextByteSize() {
+		return fmt.Errorf("wrong ciphertext length: %d bytes instead of %d", len(data), CipherTextByteSize())
+	}
+	data, err := RemoveSuitePrefix(data)
+	if err != nil {
+		return err
+	}
+
 	(*c).K = SuiTe.Point()
 	(*c).C = SuiTe.Point()
 	pointLength := SuiTe.PointLen()
@@ -811,5 +1046,5 @@ func FromBytesToArrayCipherVector(data []byte, cvLengthsByte []byte) ([]CipherVe
 
 // CipherTextByteSize return the length of one CipherText element transform into []byte
 func CipherTextByteSize() int {
-	return 2 * SuiTe.PointLen()
+	return 1 + 2*SuiTe.PointLen()
 }
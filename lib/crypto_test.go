@@ -3,9 +3,11 @@ package libunlynx_test
 import (
 	"github.com/ldsec/unlynx/lib"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/onet/v3/log"
+	"math"
 	"reflect"
 	"strings"
 	"testing"
@@ -158,6 +160,46 @@ func TestHomomorphicOpp(t *testing.T) {
 	assert.Equal(t, targetMul, pMul)
 }
 
+// TestHomomorphicLinearCombinations tests the negation, scalar multiplication, dot product and weighted sum of
+// ciphervectors.
+func TestHomomorphicLinearCombinations(t *testing.T) {
+	secKey, pubKey := libunlynx.GenKey()
+
+	// longer than VPARALLELIZE to test the chunking
+	values1 := make([]int64, 2*libunlynx.VPARALLELIZE+3)
+	values2 := make([]int64, len(values1))
+	coefficients := make([]int64, len(values1))
+	dot := int64(0)
+	for i := range values1 {
+		values1[i], values2[i], coefficients[i] = int64(i), int64(2*i+1), int64(i%3-1)
+		dot += coefficients[i] * values1[i]
+	}
+	cv1 := libunlynx.EncryptIntVector(pubKey, values1)
+	cv2 := libunlynx.EncryptIntVector(pubKey, values2)
+
+	neg := libunlynx.NewCipherVector(len(values1))
+	neg.Neg(*cv1)
+	mul := libunlynx.NewCipherVector(len(values1))
+	mul.ScalarMul(*cv1, libunlynx.SuiTe.Scalar().SetInt64(3))
+	weighted := libunlynx.NewCipherVector(len(values1))
+	require.NoError(t, weighted.WeightedSum([]libunlynx.CipherVector{*cv1, *cv2}, []int64{2, -1}))
+	ctDot, err := cv1.DotClear(coefficients)
+	require.NoError(t, err)
+
+	for i, v := range values1 {
+		assert.Equal(t, -v, libunlynx.DecryptIntWithNeg(secKey, (*neg)[i]))
+		assert.Equal(t, 3*v, libunlynx.DecryptInt(secKey, (*mul)[i]))
+		assert.Equal(t, 2*v-values2[i], libunlynx.DecryptIntWithNeg(secKey, (*weighted)[i]))
+	}
+	assert.Equal(t, dot, libunlynx.DecryptIntWithNeg(secKey, ctDot))
+
+	// the lengths must match
+	_, err = cv1.DotClear(coefficients[1:])
+	assert.Error(t, err)
+	assert.Error(t, weighted.WeightedSum([]libunlynx.CipherVector{*cv1, *cv2}, []int64{1}))
+	assert.Error(t, weighted.WeightedSum([]libunlynx.CipherVector{*cv1, (*cv2)[1:]}, []int64{1, 1}))
+}
+
 // TestEqualDeterministCipherText tests equality between deterministic ciphertexts.
 func TestEqualDeterministCipherText(t *testing.T) {
 	dcv1 := libunlynx.DeterministCipherVector{libunlynx.DeterministCipherText{Point: libunlynx.SuiTe.Point().Base()}, libunlynx.DeterministCipherText{Point: libunlynx.SuiTe.Point().Null()}}
@@ -326,6 +368,40 @@ func TestDecryptIntVectorWithNeg(t *testing.T) {
 	}
 }
 
+func TestDecryptIntRange(t *testing.T) {
+	secKey, pubKey := libunlynx.GenKey()
+
+	limit := int64(1000000000)
+	target := []int64{0, 1, 100001, 31622, 987654321, limit}
+	cv := libunlynx.EncryptIntVector(pubKey, target)
+
+	results, err := libunlynx.DecryptIntVectorRange(secKey, cv, limit)
+	assert.NoError(t, err)
+	assert.Equal(t, target, results)
+
+	// out of range values are not silently decoded
+	_, err = libunlynx.DecryptIntRange(secKey, *libunlynx.EncryptInt(pubKey, limit+1), limit)
+	assert.Error(t, err)
+	_, err = libunlynx.DecryptIntRange(secKey, *libunlynx.EncryptInt(pubKey, -5), limit)
+	assert.Error(t, err)
+
+	target = []int64{-1, -987654321, 5, -limit}
+	cv = libunlynx.EncryptIntVector(pubKey, target)
+	results, err = libunlynx.DecryptIntVectorWithNegRange(secKey, cv, limit)
+	assert.NoError(t, err)
+	assert.Equal(t, target, results)
+
+	_, err = libunlynx.DecryptIntWithNegRange(secKey, *libunlynx.EncryptInt(pubKey, -limit-1), limit)
+	assert.Error(t, err)
+
+	// the baby-step tables are bounded
+	_, err = libunlynx.DecryptIntRange(secKey, *libunlynx.EncryptInt(pubKey, 1<<50), math.MaxInt64)
+	assert.Error(t, err)
+
+	// the default range still applies to DecryptInt
+	assert.Equal(t, int64(0), libunlynx.DecryptInt(secKey, *libunlynx.EncryptInt(pubKey, libunlynx.MaxHomomorphicInt+1)))
+}
+
 func TestDecryptCheckZero(t *testing.T) {
 	secKey, pubKey := libunlynx.GenKey()
 
@@ -0,0 +1,230 @@
+package libunlynxdecrypt
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ldsec/unlynx/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/proof"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// Structs
+//______________________________________________________________________________________________________________________
+
+// PublishedDecryptionProof contains the partial decryptions (Shares = x*rB) of ciphertexts by one server, with the proof
+// that they were computed with the secret x of its public contribution X = x*B to the collective key
+type PublishedDecryptionProof struct {
+	Public kyber.Point
+	RBs    []kyber.Point
+	Shares []kyber.Point
+	Proof  []byte
+}
+
+// PublishedDecryptionProofBytes is the 'bytes' equivalent of PublishedDecryptionProof
+type PublishedDecryptionProofBytes struct {
+	Public []byte
+	RBs    []byte
+	Shares []byte
+	Proof  []byte
+}
+
+// PublishedDecryptionListProof contains the decryption proofs of all the servers decrypting the same ciphertexts
+type PublishedDecryptionListProof struct {
+	List []PublishedDecryptionProof
+}
+
+// PublishedDecryptionListProofBytes is the 'bytes' equivalent of PublishedDecryptionListProof
+type PublishedDecryptionListProofBytes struct {
+	List []PublishedDecryptionProofBytes
+}
+
+// DECRYPTION proofs
+//______________________________________________________________________________________________________________________
+
+func createPredicateDecryption(nbrCiphertexts int) (predicate proof.Predicate) {
+	// the same secret is used for the public contribution and for all the partial decryptions
+	preds := []proof.Predicate{proof.Rep("X", "x", "B")}
+	for i := 0; i < nbrCiphertexts; i++ {
+		index := strconv.Itoa(i)
+		preds = append(preds, proof.Rep("D"+index, "x", "rB"+index))
+	}
+	predicate = proof.And(preds...)
+	return
+}
+
+func decryptionPoints(pdp PublishedDecryptionProof) map[string]kyber.Point {
+	pval := map[string]kyber.Point{"X": pdp.Public, "B": libunlynx.SuiTe.Point().Base()}
+	for i := range pdp.RBs {
+		index := strconv.Itoa(i)
+		pval["D"+index] = pdp.Shares[i]
+		pval["rB"+index] = pdp.RBs[i]
+	}
+	return pval
+}
+
+// DecryptionProofCreation creates a proof for the partial decryptions (shares[i] = secret*rBs[i]) of one server
+func DecryptionProofCreation(rBs, shares []kyber.Point, secret kyber.Scalar) (PublishedDecryptionProof, error) {
+	if len(rBs) != len(shares) {
+		return PublishedDecryptionProof{}, fmt.Errorf("%d partial decryptions for %d ciphertexts", len(shares), len(rBs))
+	}
+	pdp := PublishedDecryptionProof{Public: libunlynx.SuiTe.Point().Mul(secret, nil), RBs: rBs, Shares: shares}
+
+	predicate := createPredicateDecryption(len(rBs))
+	sval := map[string]kyber.Scalar{"x": secret}
+	prover := predicate.Prover(libunlynx.SuiTe, sval, decryptionPoints(pdp), nil)
+	proofDecryption, err := proof.HashProve(libunlynx.SuiTe, "decryptionProof", prover)
+	if err != nil {
+		return PublishedDecryptionProof{}, fmt.Errorf("---------prover: %v", err)
+	}
+	pdp.Proof = proofDecryption
+
+	return pdp, nil
+}
+
+// DecryptionProofVerification verifies the partial decryptions of one server
+func DecryptionProofVerification(pdp PublishedDecryptionProof) bool {
+	if len(pdp.RBs) != len(pdp.Shares) {
+		log.Error("wrong number of partial decryptions")
+		return false
+	}
+
+	predicate := createPredicateDecryption(len(pdp.RBs))
+	verifier := predicate.Verifier(libunlynx.SuiTe, decryptionPoints(pdp))
+	if err := proof.HashVerify(libunlynx.SuiTe, "decryptionProof", verifier, pdp.Proof); err != nil {
+		log.Error("---------Verifier:", err.Error())
+		return false
+	}
+	return true
+}
+
+// DecryptionListProofVerification verifies that the ciphertexts were correctly decrypted by the servers with the given
+// public contributions (e.g. their public keys if the collective key is their aggregate): there must be exactly one
+// valid proof per server.
+func DecryptionListProofVerification(pdlp PublishedDecryptionListProof, cts libunlynx.CipherVector, publics []kyber.Point) bool {
+	if len(pdlp.List) != len(publics) {
+		log.Error("got ", len(pdlp.List), " decryption proofs for ", len(publics), " servers")
+		return false
+	}
+
+	used := make([]bool, len(publics))
+	for _, pdp := range pdlp.List {
+		found := false
+		for i, pub := range publics {
+			if !used[i] && pub.Equal(pdp.Public) {
+				used[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			log.Error("decryption proof from an unexpected server")
+			return false
+		}
+
+		if len(pdp.RBs) != len(cts) {
+			log.Error("decryption proof for ", len(pdp.RBs), " ciphertexts instead of ", len(cts))
+			return false
+		}
+		for i, ct := range cts {
+			if !pdp.RBs[i].Equal(ct.K) {
+				log.Error("decryption proof for other ciphertexts")
+				return false
+			}
+		}
+	}
+
+	results := make([]bool, len(pdlp.List))
+	wg := libunlynx.StartParallelize(len(pdlp.List))
+	for i, pdp := range pdlp.List {
+		go func(i int, pdp PublishedDecryptionProof) {
+			defer wg.Done()
+			results[i] = DecryptionProofVerification(pdp)
+		}(i, pdp)
+	}
+	libunlynx.EndParallelize(wg)
+
+	finalResult := true
+	for _, v := range results {
+		finalResult = finalResult && v
+	}
+	return finalResult
+}
+
+// Plaintexts removes all the partial decryptions from the ciphertexts and returns the plaintext points
+func (pdlp *PublishedDecryptionListProof) Plaintexts(cts libunlynx.CipherVector) ([]kyber.Point, error) {
+	plaintexts := make([]kyber.Point, len(cts))
+	for i, ct := range cts {
+		plaintexts[i] = ct.C.Clone()
+	}
+	for _, pdp := range pdlp.List {
+		if len(pdp.Shares) != len(cts) {
+			return nil, fmt.Errorf("%d partial decryptions for %d ciphertexts", len(pdp.Shares), len(cts))
+		}
+		for i := range plaintexts {
+			plaintexts[i].Sub(plaintexts[i], pdp.Shares[i])
+		}
+	}
+	return plaintexts, nil
+}
+
+// Marshal
+//______________________________________________________________________________________________________________________
+
+// ToBytes converts PublishedDecryptionProof to bytes
+func (pdp *PublishedDecryptionProof) ToBytes() (PublishedDecryptionProofBytes, error) {
+	pdpb := PublishedDecryptionProofBytes{Proof: pdp.Proof}
+
+	var err error
+	if pdpb.Public, err = pdp.Public.MarshalBinary(); err != nil {
+		return PublishedDecryptionProofBytes{}, err
+	}
+	if pdpb.RBs, err = libunlynx.AbstractPointsToBytes(pdp.RBs); err != nil {
+		return PublishedDecryptionProofBytes{}, err
+	}
+	if pdpb.Shares, err = libunlynx.AbstractPointsToBytes(pdp.Shares); err != nil {
+		return PublishedDecryptionProofBytes{}, err
+	}
+	return pdpb, nil
+}
+
+// FromBytes converts back bytes to PublishedDecryptionProof
+func (pdp *PublishedDecryptionProof) FromBytes(pdpb PublishedDecryptionProofBytes) error {
+	pdp.Proof = pdpb.Proof
+	pdp.Public = libunlynx.SuiTe.Point()
+	if err := pdp.Public.UnmarshalBinary(pdpb.Public); err != nil {
+		return err
+	}
+
+	var err error
+	if pdp.RBs, err = libunlynx.FromBytesToAbstractPoints(pdpb.RBs); err != nil {
+		return err
+	}
+	if pdp.Shares, err = libunlynx.FromBytesToAbstractPoints(pdpb.Shares); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ToBytes converts PublishedDecryptionListProof to bytes
+func (pdlp *PublishedDecryptionListProof) ToBytes() (PublishedDecryptionListProofBytes, error) {
+	pdlpb := PublishedDecryptionListProofBytes{List: make([]PublishedDecryptionProofBytes, len(pdlp.List))}
+	for i, pdp := range pdlp.List {
+		var err error
+		if pdlpb.List[i], err = pdp.ToBytes(); err != nil {
+			return PublishedDecryptionListProofBytes{}, err
+		}
+	}
+	return pdlpb, nil
+}
+
+// FromBytes converts bytes back to PublishedDecryptionListProof
+func (pdlp *PublishedDecryptionListProof) FromBytes(pdlpb PublishedDecryptionListProofBytes) error {
+	pdlp.List = make([]PublishedDecryptionProof, len(pdlpb.List))
+	for i, pdpb := range pdlpb.List {
+		if err := pdlp.List[i].FromBytes(pdpb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
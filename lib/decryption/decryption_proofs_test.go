@@ -0,0 +1,81 @@
+package libunlynxdecrypt_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/decryption"
+	"github.com/stretchr/testify/assert"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/key"
+)
+
+// partialDecryptions computes the partial decryptions of the ciphertexts with a secret key
+func partialDecryptions(cts libunlynx.CipherVector, secret kyber.Scalar) ([]kyber.Point, []kyber.Point) {
+	rBs := make([]kyber.Point, len(cts))
+	shares := make([]kyber.Point, len(cts))
+	for i, v := range cts {
+		rBs[i] = v.K
+		shares[i] = libunlynx.SuiTe.Point().Mul(secret, v.K)
+	}
+	return rBs, shares
+}
+
+// TestDecryptionProof tests the creation and verification of collective decryption proofs
+func TestDecryptionProof(t *testing.T) {
+	keys := []*key.Pair{key.NewKeyPair(libunlynx.SuiTe), key.NewKeyPair(libunlynx.SuiTe), key.NewKeyPair(libunlynx.SuiTe)}
+	publics := make([]kyber.Point, len(keys))
+	collectiveKey := libunlynx.SuiTe.Point().Null()
+	for i, kp := range keys {
+		publics[i] = kp.Public
+		collectiveKey.Add(collectiveKey, kp.Public)
+	}
+
+	data := []int64{0, 1, 42, -3}
+	cts := *libunlynx.EncryptIntVector(collectiveKey, data)
+
+	pdlp := libunlynxdecrypt.PublishedDecryptionListProof{}
+	for _, kp := range keys {
+		rBs, shares := partialDecryptions(cts, kp.Private)
+		pdp, err := libunlynxdecrypt.DecryptionProofCreation(rBs, shares, kp.Private)
+		assert.NoError(t, err)
+		assert.True(t, libunlynxdecrypt.DecryptionProofVerification(pdp))
+		pdlp.List = append(pdlp.List, pdp)
+	}
+	assert.True(t, libunlynxdecrypt.DecryptionListProofVerification(pdlp, cts, publics))
+
+	plaintexts, err := pdlp.Plaintexts(cts)
+	assert.NoError(t, err)
+	for i, v := range data {
+		assert.True(t, plaintexts[i].Equal(libunlynx.IntToPoint(v)))
+	}
+
+	// marshal and unmarshal
+	pdlpb, err := pdlp.ToBytes()
+	assert.NoError(t, err)
+	pdlpCopy := libunlynxdecrypt.PublishedDecryptionListProof{}
+	assert.NoError(t, pdlpCopy.FromBytes(pdlpb))
+	assert.True(t, libunlynxdecrypt.DecryptionListProofVerification(pdlpCopy, cts, publics))
+
+	// a missing server
+	assert.False(t, libunlynxdecrypt.DecryptionListProofVerification(libunlynxdecrypt.PublishedDecryptionListProof{List: pdlp.List[1:]}, cts, publics))
+	// the same server twice
+	twice := libunlynxdecrypt.PublishedDecryptionListProof{List: []libunlynxdecrypt.PublishedDecryptionProof{pdlp.List[0], pdlp.List[0], pdlp.List[1]}}
+	assert.False(t, libunlynxdecrypt.DecryptionListProofVerification(twice, cts, publics))
+	// other ciphertexts
+	otherCts := *libunlynx.EncryptIntVector(collectiveKey, data)
+	assert.False(t, libunlynxdecrypt.DecryptionListProofVerification(pdlp, otherCts, publics))
+
+	// a wrong partial decryption
+	pdlpCopy.List[1].Shares[2] = libunlynx.SuiTe.Point().Add(pdlpCopy.List[1].Shares[2], libunlynx.SuiTe.Point().Base())
+	assert.False(t, libunlynxdecrypt.DecryptionProofVerification(pdlpCopy.List[1]))
+	assert.False(t, libunlynxdecrypt.DecryptionListProofVerification(pdlpCopy, cts, publics))
+
+	// a partial decryption computed with another secret
+	rBs, shares := partialDecryptions(cts, key.NewKeyPair(libunlynx.SuiTe).Private)
+	_, err = libunlynxdecrypt.DecryptionProofCreation(rBs, shares[1:], keys[0].Private)
+	assert.Error(t, err)
+	pdp, err := libunlynxdecrypt.DecryptionProofCreation(rBs, shares, keys[0].Private)
+	assert.NoError(t, err)
+	assert.False(t, libunlynxdecrypt.DecryptionProofVerification(pdp))
+}
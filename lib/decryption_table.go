@@ -0,0 +1,206 @@
+package libunlynx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"go.dedis.ch/kyber/v3"
+)
+
+// decryptionTableMagic identifies a decryption table file
+const decryptionTableMagic = "UNLYNXDT"
+
+// decryptionTableRecordSize is the size of one record (point key + integer) of a decryption table
+const decryptionTableRecordSize = 16
+
+// MaxDecryptionTableLimit is the largest range of a decryption table: its file takes (2*limit+1)*16 bytes (512 MiB)
+const MaxDecryptionTableLimit int64 = 1 << 24
+
+// decryptionTable is the lookup table consulted by DecryptInt & co. before running the baby-step giant-step decoding
+var decryptionTable *DecryptionTable
+var decryptionTableMutex = sync.RWMutex{}
+
+// DecryptionTable maps the points encoding the integers in [-Limit, Limit] to their integer value. Records are kept in
+// the binary format of the file, sorted by point key, so that a loaded table can be searched without being parsed.
+type DecryptionTable struct {
+	Suite string
+	Limit int64
+
+	records []byte
+}
+
+// pointKey returns the key under which a point is stored in a decryption table (the first 8 bytes of its encoding)
+func pointKey(P kyber.Point) (uint64, error) {
+	data, err := P.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 8 {
+		return 0, fmt.Errorf("point encoding is too short (%d bytes)", len(data))
+	}
+	return binary.BigEndian.Uint64(data[:8]), nil
+}
+
+// GenerateDecryptionTable writes the decryption table of all the integers in [-limit, limit] for the current suite
+// in the file at path.
+func GenerateDecryptionTable(path string, limit int64) error {
+	if limit < 0 || limit > MaxDecryptionTableLimit {
+		return fmt.Errorf("invalid decryption table range: %d (at most %d)", limit, MaxDecryptionTableLimit)
+	}
+
+	records := make([]byte, (2*limit+1)*decryptionTableRecordSize)
+	B := SuiTe.Point().Base()
+	P := SuiTe.Point().Null()
+	pos := 0
+	for i := int64(0); i <= limit; i++ {
+		key, err := pointKey(P)
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(records[pos:], key)
+		binary.BigEndian.PutUint64(records[pos+8:], uint64(i))
+		pos += decryptionTableRecordSize
+
+		if i != 0 {
+			key, err = pointKey(SuiTe.Point().Neg(P))
+			if err != nil {
+				return err
+			}
+			binary.BigEndian.PutUint64(records[pos:], key)
+			binary.BigEndian.PutUint64(records[pos+8:], uint64(-i))
+			pos += decryptionTableRecordSize
+		}
+		P = SuiTe.Point().Add(P, B)
+	}
+
+	sort.Sort(recordSorter(records))
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create decryption table file: %v", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	header := make([]byte, 0)
+	header = append(header, []byte(decryptionTableMagic)...)
+	header = append(header, uint8(len(SuiTe.String())))
+	header = append(header, []byte(SuiTe.String())...)
+	header = append(header, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(header[len(header)-8:], uint64(limit))
+
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := writer.Write(records); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// ReadDecryptionTable reads a decryption table file and checks that it has been generated for the current suite.
+func ReadDecryptionTable(path string) (*DecryptionTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read decryption table file: %v", err)
+	}
+
+	if len(data) < len(decryptionTableMagic)+1 || !bytes.Equal(data[:len(decryptionTableMagic)], []byte(decryptionTableMagic)) {
+		return nil, fmt.Errorf("%s is not a decryption table file", path)
+	}
+	pos := len(decryptionTableMagic)
+	suiteLength := int(data[pos])
+	pos++
+	if len(data) < pos+suiteLength+8 {
+		return nil, fmt.Errorf("truncated decryption table header in %s", path)
+	}
+
+	dt := &DecryptionTable{}
+	dt.Suite = string(data[pos : pos+suiteLength])
+	pos += suiteLength
+	dt.Limit = int64(binary.BigEndian.Uint64(data[pos : pos+8]))
+	pos += 8
+	dt.records = data[pos:]
+
+	if dt.Suite != SuiTe.String() {
+		return nil, fmt.Errorf("decryption table was generated for suite %s, not %s", dt.Suite, SuiTe.String())
+	}
+	if dt.Limit < 0 || dt.Limit > MaxDecryptionTableLimit || int64(len(dt.records)) != (2*dt.Limit+1)*decryptionTableRecordSize {
+		return nil, fmt.Errorf("corrupted decryption table in %s", path)
+	}
+	return dt, nil
+}
+
+// LoadDecryptionTable reads a decryption table for the range [-limit, limit] and uses it for all subsequent
+// decryptions.
+func LoadDecryptionTable(path string, limit int64) error {
+	dt, err := ReadDecryptionTable(path)
+	if err != nil {
+		return err
+	}
+	if dt.Limit != limit {
+		return fmt.Errorf("decryption table covers [-%d, %d], expected [-%d, %d]", dt.Limit, dt.Limit, limit, limit)
+	}
+	SetDecryptionTable(dt)
+	return nil
+}
+
+// SetDecryptionTable sets the table consulted before decoding integers (nil disables the lookup).
+func SetDecryptionTable(dt *DecryptionTable) {
+	decryptionTableMutex.Lock()
+	decryptionTable = dt
+	decryptionTableMutex.Unlock()
+}
+
+// getDecryptionTable returns the table currently in use (possibly nil)
+func getDecryptionTable() *DecryptionTable {
+	decryptionTableMutex.RLock()
+	defer decryptionTableMutex.RUnlock()
+	return decryptionTable
+}
+
+// Lookup returns the integer encoded by P if it lies in the range of the table.
+func (dt *DecryptionTable) Lookup(P kyber.Point) (int64, bool) {
+	key, err := pointKey(P)
+	if err != nil {
+		return 0, false
+	}
+
+	n := len(dt.records) / decryptionTableRecordSize
+	first := sort.Search(n, func(i int) bool {
+		return binary.BigEndian.Uint64(dt.records[i*decryptionTableRecordSize:]) >= key
+	})
+
+	// keys are truncated encodings: check every candidate
+	for i := first; i < n && binary.BigEndian.Uint64(dt.records[i*decryptionTableRecordSize:]) == key; i++ {
+		v := int64(binary.BigEndian.Uint64(dt.records[i*decryptionTableRecordSize+8:]))
+		if IntToPoint(v).Equal(P) {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// recordSorter sorts the records of a decryption table by key
+type recordSorter []byte
+
+func (r recordSorter) Len() int {
+	return len(r) / decryptionTableRecordSize
+}
+
+func (r recordSorter) Less(i, j int) bool {
+	return binary.BigEndian.Uint64(r[i*decryptionTableRecordSize:]) < binary.BigEndian.Uint64(r[j*decryptionTableRecordSize:])
+}
+
+func (r recordSorter) Swap(i, j int) {
+	var tmp [decryptionTableRecordSize]byte
+	copy(tmp[:], r[i*decryptionTableRecordSize:(i+1)*decryptionTableRecordSize])
+	copy(r[i*decryptionTableRecordSize:(i+1)*decryptionTableRecordSize], r[j*decryptionTableRecordSize:(j+1)*decryptionTableRecordSize])
+	copy(r[j*decryptionTableRecordSize:(j+1)*decryptionTableRecordSize], tmp[:])
+}
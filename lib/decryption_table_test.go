@@ -0,0 +1,54 @@
+package libunlynx_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+const decryptionTableFile = "decryption_table_test.bin"
+
+func TestDecryptionTable(t *testing.T) {
+	defer os.Remove(decryptionTableFile)
+	defer libunlynx.SetDecryptionTable(nil)
+
+	limit := int64(2000)
+	assert.NoError(t, libunlynx.GenerateDecryptionTable(decryptionTableFile, limit))
+	assert.Error(t, libunlynx.GenerateDecryptionTable(decryptionTableFile, -1))
+	assert.Error(t, libunlynx.GenerateDecryptionTable(decryptionTableFile, libunlynx.MaxDecryptionTableLimit+1))
+
+	dt, err := libunlynx.ReadDecryptionTable(decryptionTableFile)
+	assert.NoError(t, err)
+	assert.Equal(t, limit, dt.Limit)
+	assert.Equal(t, libunlynx.SuiTe.String(), dt.Suite)
+
+	for _, v := range []int64{0, 1, -1, 1999, -2000, 2000} {
+		res, ok := dt.Lookup(libunlynx.IntToPoint(v))
+		assert.True(t, ok)
+		assert.Equal(t, v, res)
+	}
+	_, ok := dt.Lookup(libunlynx.IntToPoint(limit + 1))
+	assert.False(t, ok)
+
+	// a table for another range is rejected
+	assert.Error(t, libunlynx.LoadDecryptionTable(decryptionTableFile, limit+1))
+	assert.NoError(t, libunlynx.LoadDecryptionTable(decryptionTableFile, limit))
+
+	secKey, pubKey := libunlynx.GenKey()
+	assert.Equal(t, int64(-1234), libunlynx.DecryptIntWithNeg(secKey, *libunlynx.EncryptInt(pubKey, -1234)))
+	assert.Equal(t, int64(1234), libunlynx.DecryptInt(secKey, *libunlynx.EncryptInt(pubKey, 1234)))
+	// negative entries of the table are ignored when decrypting without negative values
+	_, err = libunlynx.DecryptIntRange(secKey, *libunlynx.EncryptInt(pubKey, -1234), limit)
+	assert.Error(t, err)
+
+	// a table for another suite is rejected
+	data, err := ioutil.ReadFile(decryptionTableFile)
+	assert.NoError(t, err)
+	data[len("UNLYNXDT")+1] = 'X'
+	assert.NoError(t, ioutil.WriteFile(decryptionTableFile, data, 0644))
+	_, err = libunlynx.ReadDecryptionTable(decryptionTableFile)
+	assert.Error(t, err)
+}
@@ -12,7 +12,8 @@ func GenerateNoiseValues(n int64, mean, b, quanta, limit float64) []float64 {
 	return GenerateNoiseValuesScale(n, mean, b, quanta, 1, limit)
 }
 
-// GenerateNoiseValuesScale generates a number of n noise values from a given probabilistic distribution
+// GenerateNoiseValuesScale generates a number of n noise values from a given probabilistic distribution. Fewer values
+// are returned if the density vanishes before n values are generated.
 func GenerateNoiseValuesScale(n int64, mean, b, quanta, scale, limit float64) []float64 {
 	laplace := stats.Laplace(mean, b)
 
@@ -37,6 +38,9 @@ func GenerateNoiseValuesScale(n int64, mean, b, quanta, scale, limit float64) []
 	countOnes := 0
 	for int64(len(noise)) < n {
 		val := laplace.Pdf(float64(start))
+		if val == 0 {
+			break
+		}
 		rep := math.Ceil(val / quanta)
 		count := 0
 		for i := 0; i < int(rep); i++ {
@@ -58,5 +62,8 @@ func GenerateNoiseValuesScale(n int64, mean, b, quanta, scale, limit float64) []
 			countOnes = countOnes + 1
 		}
 	}
-	return noise[:n]
+	if int64(len(noise)) > n {
+		return noise[:n]
+	}
+	return noise
 }
@@ -1,9 +1,11 @@
 package libunlynxdiffprivacy_test
 
 import (
+	"math"
 	"testing"
 
 	. "github.com/ldsec/unlynx/lib/differential_privacy"
+	"github.com/r0fls/gostats"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,3 +33,28 @@ func TestGenerateNoiseValues(t *testing.T) {
 
 	aux = GenerateNoiseValuesScale(500, 0, 1, 0.005, 100, 60)
 }
+
+func TestGenerateNoiseValuesDistribution(t *testing.T) {
+	laplace := stats.Laplace(0, 2)
+	aux := GenerateNoiseValues(1000, 0, 2, 0.002, 0)
+	assert.Len(t, aux, 1000)
+
+	counts := make(map[float64]int)
+	for _, v := range aux {
+		counts[v]++
+	}
+	// each value appears in proportion to its probability (the last one may be truncated) and the list is symmetric
+	last := 0.0
+	for v := range counts {
+		last = math.Max(last, math.Abs(v))
+	}
+	for v := 0.0; v < last; v++ {
+		assert.Equal(t, int(math.Ceil(laplace.Pdf(v)/0.002)), counts[v])
+		assert.Equal(t, counts[v], counts[-v])
+	}
+
+	// the list stops when the density vanishes
+	aux = GenerateNoiseValues(1000, 0, 0.1, 0.1, 0)
+	assert.True(t, len(aux) < 1000)
+	assert.Equal(t, 0.0, aux[0])
+}
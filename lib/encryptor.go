@@ -0,0 +1,227 @@
+package libunlynx
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"sync"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// fixedBaseWindowBits is the size (in bits) of the windows of the fixed-base tables: one byte of the multiplier
+// selects one point of a window.
+const fixedBaseWindowBits = 8
+
+// int64Bytes is the number of windows needed to multiply a point by any int64
+const int64Bytes = 8
+
+// fixedBaseTable contains the multiples d*(2^8)^i*P of a fixed point P, for every window i and every byte d, as
+// marshalled points packed in words. A multiplication reads every point of each window and keeps the one of its byte
+// with a mask: its memory accesses (and thus its cache timing) do not depend on the multiplier.
+type fixedBaseTable struct {
+	windows [][][]uint64
+}
+
+// baseTables contains the fixed-base table of the base point of each suite, shared by the encryptors
+var baseTables = make(map[string]*fixedBaseTable)
+var baseTablesMutex sync.Mutex
+
+// newFixedBaseTable precomputes the fixed-base table of P for multipliers of nbrWindows bytes.
+func newFixedBaseTable(P kyber.Point, nbrWindows int) *fixedBaseTable {
+	table := &fixedBaseTable{windows: make([][][]uint64, nbrWindows)}
+
+	windowBase := P.Clone()
+	for i := range table.windows {
+		window := make([][]uint64, 1<<fixedBaseWindowBits)
+		multiple := SuiTe.Point().Null()
+		for d := range window {
+			window[d] = marshalTablePoint(multiple)
+			multiple = SuiTe.Point().Add(multiple, windowBase)
+		}
+		table.windows[i] = window
+		// next window base: 2^8 * windowBase
+		windowBase = multiple
+	}
+	return table
+}
+
+// baseTable returns the fixed-base table of the base point of the suite, for multipliers of the size of a scalar
+func baseTable() *fixedBaseTable {
+	baseTablesMutex.Lock()
+	defer baseTablesMutex.Unlock()
+	table, ok := baseTables[SuiTe.String()]
+	if !ok {
+		table = newFixedBaseTable(SuiTe.Point().Base(), SuiTe.Scalar().MarshalSize())
+		baseTables[SuiTe.String()] = table
+	}
+	return table
+}
+
+// mul multiplies the fixed point by the integer whose little-endian bytes are given, in constant time.
+func (t *fixedBaseTable) mul(digits []byte) kyber.Point {
+	result := SuiTe.Point().Null()
+	for i, d := range digits {
+		result.Add(result, selectTablePoint(t.windows[i], int(d)))
+	}
+	return result
+}
+
+// selectTablePoint returns the point of index d of a window. Every point of the window is read, the one of index d is
+// kept with a mask that is all ones for it and all zeros for the others.
+func selectTablePoint(window [][]uint64, d int) kyber.Point {
+	selected := make([]uint64, len(window[0]))
+	for j, words := range window {
+		mask := -uint64(subtle.ConstantTimeEq(int32(j), int32(d)))
+		for k, w := range words {
+			selected[k] |= w & mask
+		}
+	}
+
+	data := make([]byte, 8*len(selected))
+	for k, w := range selected {
+		binary.LittleEndian.PutUint64(data[8*k:], w)
+	}
+	P := SuiTe.Point()
+	if err := P.UnmarshalBinary(data[:P.MarshalSize()]); err != nil {
+		log.Fatal("corrupted fixed-base table: ", err)
+	}
+	return P
+}
+
+// marshalTablePoint marshals a point of a fixed-base table and packs it in words (padded with zeros)
+func marshalTablePoint(P kyber.Point) []uint64 {
+	data, err := P.MarshalBinary()
+	if err != nil {
+		log.Fatal("could not marshal a point of a fixed-base table: ", err)
+	}
+	words := make([]uint64, (len(data)+7)/8)
+	data = append(data, make([]byte, 8*len(words)-len(data))...)
+	for k := range words {
+		words[k] = binary.LittleEndian.Uint64(data[8*k:])
+	}
+	return words
+}
+
+// scalarDigits returns the little-endian bytes of a scalar (the suites marshal their scalars in either byte order)
+func scalarDigits(s kyber.Scalar) []byte {
+	digits, err := s.MarshalBinary()
+	if err != nil {
+		log.Fatal("could not marshal a scalar: ", err)
+	}
+	if one, _ := SuiTe.Scalar().One().MarshalBinary(); one[0] != 1 {
+		for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+			digits[i], digits[j] = digits[j], digits[i]
+		}
+	}
+	return digits
+}
+
+// Encryptor encrypts data under a fixed public key. It precomputes the fixed-base tables of the base point (shared by
+// the encryptors of a suite) and of the public key, so that the encoding of an integer and the multiplications by the
+// encryption randomness only cost point additions and constant-time table lookups. Each lookup unmarshals the point
+// it selects, which dominates the cost of an encryption. Creating an Encryptor is expensive (it computes and marshals
+// 2^8 points per byte of a scalar): it should be reused for all the encryptions under the same key. An Encryptor can be
+// used concurrently.
+type Encryptor struct {
+	PubKey kyber.Point
+
+	base   *fixedBaseTable
+	pubKey *fixedBaseTable
+	// signs contains the points 0 and -2^64*B, selected by the sign bit of an int64 (see IntToPoint)
+	signs [][]uint64
+}
+
+// NewEncryptor creates an Encryptor for the given public key (e.g. the collective key of a roster).
+func NewEncryptor(pubKey kyber.Point) *Encryptor {
+	twoTo32 := SuiTe.Scalar().SetInt64(1 << 32)
+	twoTo64 := SuiTe.Scalar().Mul(twoTo32, twoTo32)
+	return &Encryptor{
+		PubKey: pubKey,
+		base:   baseTable(),
+		pubKey: newFixedBaseTable(pubKey, SuiTe.Scalar().MarshalSize()),
+		signs:  [][]uint64{marshalTablePoint(SuiTe.Point().Null()), marshalTablePoint(SuiTe.Point().Neg(SuiTe.Point().Mul(twoTo64, nil)))},
+	}
+}
+
+// encryptPoint encrypts M and returns the randomness used in the encryption.
+func (e *Encryptor) encryptPoint(M kyber.Point) (*CipherText, kyber.Scalar) {
+	r := SuiTe.Scalar().Pick(random.New()) // ephemeral private key
+	digits := scalarDigits(r)
+
+	K := e.base.mul(digits)                         // ephemeral DH public key
+	C := SuiTe.Point().Add(e.pubKey.mul(digits), M) // message blinded with the ephemeral DH shared secret
+	return &CipherText{K: K, C: C}, r
+}
+
+// IntToPoint maps an integer to a point in the elliptic curve using the precomputed base table.
+func (e *Encryptor) IntToPoint(integer int64) kyber.Point {
+	// two's complement: the bytes of a negative integer encode integer + 2^64, the sign bit selects -2^64*B
+	u := uint64(integer)
+	digits := make([]byte, int64Bytes)
+	for i := range digits {
+		digits[i] = byte(u >> (fixedBaseWindowBits * uint(i)))
+	}
+
+	M := e.base.mul(digits)
+	return M.Add(M, selectTablePoint(e.signs, int(u>>63)))
+}
+
+// EncryptInt encodes i as iB, encrypts it into a CipherText and returns a pointer to it.
+func (e *Encryptor) EncryptInt(integer int64) *CipherText {
+	encryption, _ := e.encryptPoint(e.IntToPoint(integer))
+	return encryption
+}
+
+// EncryptIntGetR encodes i as iB, encrypts it into a CipherText and returns a pointer to it. It also returns the
+// randomness used in the encryption.
+func (e *Encryptor) EncryptIntGetR(integer int64) (*CipherText, kyber.Scalar) {
+	return e.encryptPoint(e.IntToPoint(integer))
+}
+
+// EncryptScalar encodes a scalar s as sB, encrypts it into a CipherText and returns a pointer to it.
+func (e *Encryptor) EncryptScalar(scalar kyber.Scalar) *CipherText {
+	encryption, _ := e.encryptPoint(e.base.mul(scalarDigits(scalar)))
+	return encryption
+}
+
+// EncryptIntVector encrypts a []int into a CipherVector and returns a pointer to it.
+func (e *Encryptor) EncryptIntVector(intArray []int64) *CipherVector {
+	var wg sync.WaitGroup
+	cv := make(CipherVector, len(intArray))
+
+	for i := 0; i < len(intArray); i = i + VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < VPARALLELIZE && (j+i < len(intArray)); j++ {
+				cv[j+i] = *e.EncryptInt(intArray[j+i])
+			}
+		}(i)
+
+	}
+	wg.Wait()
+
+	return &cv
+}
+
+// EncryptScalarVector encrypts a []scalar into a CipherVector and returns a pointer to it.
+func (e *Encryptor) EncryptScalarVector(intArray []kyber.Scalar) *CipherVector {
+	var wg sync.WaitGroup
+	cv := make(CipherVector, len(intArray))
+
+	for i := 0; i < len(intArray); i = i + VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < VPARALLELIZE && (j+i < len(intArray)); j++ {
+				cv[j+i] = *e.EncryptScalar(intArray[j+i])
+			}
+		}(i)
+
+	}
+	wg.Wait()
+
+	return &cv
+}
@@ -0,0 +1,118 @@
+package libunlynx_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
+)
+
+func TestEncryptor(t *testing.T) {
+	secKey, pubKey := libunlynx.GenKey()
+	encryptor := libunlynx.NewEncryptor(pubKey)
+
+	target := []int64{0, 1, -1, 255, 256, -65537, 99999}
+	for _, v := range append([]int64{math.MaxInt64, math.MinInt64, -256}, target...) {
+		assert.True(t, libunlynx.IntToPoint(v).Equal(encryptor.IntToPoint(v)))
+	}
+
+	cv := encryptor.EncryptIntVector(target)
+	assert.Equal(t, target, libunlynx.DecryptIntVectorWithNeg(secKey, cv))
+
+	// the returned randomness is the one used in the encryption
+	ct, r := encryptor.EncryptIntGetR(5)
+	assert.True(t, ct.K.Equal(libunlynx.SuiTe.Point().Mul(r, nil)))
+	assert.True(t, ct.C.Equal(libunlynx.SuiTe.Point().Add(libunlynx.SuiTe.Point().Mul(r, pubKey), libunlynx.IntToPoint(5))))
+
+	scalars := []kyber.Scalar{libunlynx.SuiTe.Scalar().SetInt64(3), libunlynx.SuiTe.Scalar().SetInt64(42)}
+	cv = encryptor.EncryptScalarVector(scalars)
+	assert.Equal(t, []int64{3, 42}, libunlynx.DecryptIntVector(secKey, cv))
+
+	// two encryptions of the same value are different
+	assert.False(t, encryptor.EncryptInt(1).Equal(encryptor.EncryptInt(1)))
+
+	// the scalars of the other suite are marshalled in big-endian order
+	defer func() {
+		require.NoError(t, libunlynx.SetSuite(libunlynx.DefaultSuite))
+	}()
+	require.NoError(t, libunlynx.SetSuite("P256"))
+	secKey, pubKey = libunlynx.GenKey()
+	encryptor = libunlynx.NewEncryptor(pubKey)
+	ct, r = encryptor.EncryptIntGetR(-42)
+	assert.True(t, ct.K.Equal(libunlynx.SuiTe.Point().Mul(r, nil)))
+	assert.Equal(t, int64(-42), libunlynx.DecryptIntWithNeg(secKey, *ct))
+}
+
+func TestEncryptDpClearResponseWithEncryptor(t *testing.T) {
+	secKey, pubKey := libunlynx.GenKey()
+	encryptor := libunlynx.NewEncryptor(pubKey)
+
+	ccr := libunlynx.DpClearResponse{
+		GroupByEnc:               map[string]int64{"g1": 2},
+		WhereEnc:                 map[string]int64{"w1": 3},
+		AggregatingAttributesEnc: map[string]int64{"s1": 4},
+	}
+	cr, err := libunlynx.EncryptDpClearResponseWithEncryptor(ccr, encryptor, true, "survey", "dp")
+	assert.NoError(t, err)
+
+	dr := libunlynx.DpResponse{}
+	assert.NoError(t, dr.FromDpResponseToSend(cr))
+	assert.Equal(t, int64(2), libunlynx.DecryptInt(secKey, dr.GroupByEnc["g1"]))
+	assert.Equal(t, int64(3), libunlynx.DecryptInt(secKey, dr.WhereEnc["w1"]))
+	assert.Equal(t, int64(4), libunlynx.DecryptInt(secKey, dr.AggregatingAttributesEnc["s1"]))
+	assert.Equal(t, int64(1), libunlynx.DecryptInt(secKey, dr.AggregatingAttributesEnc["count"]))
+}
+
+// benchmarkData contains varied non-zero values, some negative
+var benchmarkData = func() []int64 {
+	data := make([]int64, 1000)
+	for i := range data {
+		data[i] = int64(i+1) * 7919 * int64(1-2*(i%2))
+	}
+	return data
+}()
+
+func BenchmarkEncryptIntVector(b *testing.B) {
+	_, pubKey := libunlynx.GenKey()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		libunlynx.EncryptIntVector(pubKey, benchmarkData)
+	}
+}
+
+func BenchmarkEncryptorEncryptIntVector(b *testing.B) {
+	_, pubKey := libunlynx.GenKey()
+	encryptor := libunlynx.NewEncryptor(pubKey)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encryptor.EncryptIntVector(benchmarkData)
+	}
+}
+
+func BenchmarkEncryptInt(b *testing.B) {
+	_, pubKey := libunlynx.GenKey()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		libunlynx.EncryptInt(pubKey, benchmarkData[i%len(benchmarkData)])
+	}
+}
+
+func BenchmarkEncryptorEncryptInt(b *testing.B) {
+	_, pubKey := libunlynx.GenKey()
+	encryptor := libunlynx.NewEncryptor(pubKey)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encryptor.EncryptInt(benchmarkData[i%len(benchmarkData)])
+	}
+}
+
+func BenchmarkNewEncryptor(b *testing.B) {
+	_, pubKey := libunlynx.GenKey()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		libunlynx.NewEncryptor(pubKey)
+	}
+}
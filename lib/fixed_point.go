@@ -0,0 +1,107 @@
+package libunlynx
+
+import (
+	"fmt"
+	"math"
+
+	"go.dedis.ch/kyber/v3"
+)
+
+// maxFixedPointDecimals is the maximum number of decimal digits that can be kept by the fixed-point encoding
+const maxFixedPointDecimals = 15
+
+// FixedPointScales contains, for each fixed-point attribute, the number of decimal digits kept when the attribute is
+// encoded as an integer (the value is multiplied by 10^decimals and rounded).
+type FixedPointScales map[string]int64
+
+// EncodeFixedPoint encodes a float64 as an integer keeping the chosen number of decimal digits.
+func EncodeFixedPoint(value float64, decimals int64) (int64, error) {
+	if decimals < 0 || decimals > maxFixedPointDecimals {
+		return 0, fmt.Errorf("invalid number of decimals: %d", decimals)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("cannot encode %v as a fixed-point value", value)
+	}
+
+	scaled := math.Round(value * math.Pow10(int(decimals)))
+	if scaled > math.MaxInt64 || scaled < math.MinInt64 {
+		return 0, fmt.Errorf("fixed-point encoding of %v with %d decimals overflows", value, decimals)
+	}
+	return int64(scaled), nil
+}
+
+// DecodeFixedPoint decodes an integer produced by EncodeFixedPoint (or a sum of them) back to a float64.
+func DecodeFixedPoint(value int64, decimals int64) float64 {
+	return float64(value) / math.Pow10(int(decimals))
+}
+
+// Validate checks that the number of decimals of each attribute is supported.
+func (fps FixedPointScales) Validate() error {
+	for name, decimals := range fps {
+		if decimals < 0 || decimals > maxFixedPointDecimals {
+			return fmt.Errorf("invalid number of decimals (%d) for attribute %s", decimals, name)
+		}
+	}
+	return nil
+}
+
+// Encode converts a map of attribute values to the integer map used in a DpClearResponse. Attributes without a scale
+// must hold integer values.
+func (fps FixedPointScales) Encode(values map[string]float64) (map[string]int64, error) {
+	result := make(map[string]int64, len(values))
+	for name, v := range values {
+		encoded, err := EncodeFixedPoint(v, fps[name])
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %v", name, err)
+		}
+		if _, ok := fps[name]; !ok && float64(encoded) != v {
+			return nil, fmt.Errorf("attribute %s has no fixed-point scale but a fractional value (%v)", name, v)
+		}
+		result[name] = encoded
+	}
+	return result, nil
+}
+
+// Decode converts the integer values of the named attributes (e.g. the aggregating attributes of a result) back to
+// float64.
+func (fps FixedPointScales) Decode(names []string, values []int64) ([]float64, error) {
+	if len(names) != len(values) {
+		return nil, fmt.Errorf("%d attribute names for %d values", len(names), len(values))
+	}
+	result := make([]float64, len(values))
+	for i, v := range values {
+		result[i] = DecodeFixedPoint(v, fps[names[i]])
+	}
+	return result, nil
+}
+
+// EncryptFloat encodes a float64 with the chosen number of decimal digits and encrypts it.
+func EncryptFloat(pubkey kyber.Point, value float64, decimals int64) (*CipherText, error) {
+	encoded, err := EncodeFixedPoint(value, decimals)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptInt(pubkey, encoded), nil
+}
+
+// EncryptFloatVector encodes a []float64 with the chosen number of decimal digits and encrypts it.
+func EncryptFloatVector(pubkey kyber.Point, values []float64, decimals int64) (*CipherVector, error) {
+	encoded := make([]int64, len(values))
+	for i, v := range values {
+		var err error
+		encoded[i], err = EncodeFixedPoint(v, decimals)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return EncryptIntVector(pubkey, encoded), nil
+}
+
+// DecryptFloatWithNegRange decrypts a fixed-point value whose integer encoding lies in [-limit, limit].
+func DecryptFloatWithNegRange(prikey kyber.Scalar, cipher CipherText, decimals, limit int64) (float64, error) {
+	v, err := DecryptIntWithNegRange(prikey, cipher, limit)
+	if err != nil {
+		return 0, err
+	}
+	return DecodeFixedPoint(v, decimals), nil
+}
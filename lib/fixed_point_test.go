@@ -0,0 +1,62 @@
+package libunlynx_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixedPoint(t *testing.T) {
+	v, err := libunlynx.EncodeFixedPoint(25.376, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2538), v)
+	assert.Equal(t, 25.38, libunlynx.DecodeFixedPoint(v, 2))
+
+	v, err = libunlynx.EncodeFixedPoint(-0.5, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-5), v)
+
+	_, err = libunlynx.EncodeFixedPoint(math.NaN(), 1)
+	assert.Error(t, err)
+	_, err = libunlynx.EncodeFixedPoint(1e300, 2)
+	assert.Error(t, err)
+	_, err = libunlynx.EncodeFixedPoint(1, -1)
+	assert.Error(t, err)
+
+	scales := libunlynx.FixedPointScales{"s1": 2, "s2": 0}
+	assert.NoError(t, scales.Validate())
+	encoded, err := scales.Encode(map[string]float64{"s1": 1.5, "s2": 3, "s3": 4})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"s1": 150, "s2": 3, "s3": 4}, encoded)
+
+	// fractional values need a scale
+	_, err = scales.Encode(map[string]float64{"s3": 4.5})
+	assert.Error(t, err)
+
+	decoded, err := scales.Decode([]string{"s1", "s3"}, []int64{1234, 7})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{12.34, 7}, decoded)
+	_, err = scales.Decode([]string{"s1"}, []int64{1, 2})
+	assert.Error(t, err)
+}
+
+func TestEncryptFloat(t *testing.T) {
+	secKey, pubKey := libunlynx.GenKey()
+
+	cv, err := libunlynx.EncryptFloatVector(pubKey, []float64{1.25, -3.5}, 2)
+	assert.NoError(t, err)
+
+	sum := libunlynx.NewCipherText()
+	sum.Add((*cv)[0], (*cv)[1])
+	res, err := libunlynx.DecryptFloatWithNegRange(secKey, *sum, 2, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, -2.25, res)
+
+	ct, err := libunlynx.EncryptFloat(pubKey, 123456.789, 3)
+	assert.NoError(t, err)
+	res, err = libunlynx.DecryptFloatWithNegRange(secKey, *ct, 3, 1000000000)
+	assert.NoError(t, err)
+	assert.Equal(t, 123456.789, res)
+}
@@ -0,0 +1,71 @@
+package libunlynx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HistogramBinSeparator separates the name of an attribute from the value of one of its histogram bins (e.g. age#42).
+// It cannot appear in an attribute name.
+const HistogramBinSeparator = "#"
+
+// MaxHistogramBins bounds the size of the domains of the attributes encoded as histograms: each bin is an aggregating
+// attribute of the responses
+const MaxHistogramBins = 4096
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// HistogramBinName returns the name of the histogram bin of an attribute counting the responses with the given
+// (encoded) value
+func HistogramBinName(name string, value int64) string {
+	return name + HistogramBinSeparator + strconv.FormatInt(value, 10)
+}
+
+// SplitHistogramBinName returns the attribute and the value of a histogram bin name
+func SplitHistogramBinName(bin string) (string, int64, bool) {
+	i := strings.LastIndex(bin, HistogramBinSeparator)
+	if i < 0 {
+		return "", 0, false
+	}
+	value, err := strconv.ParseInt(bin[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return bin[:i], value, true
+}
+
+// HistogramTotalName returns the name of the sum of the histogram bins of an attribute (e.g. age#), under which the data
+// providers send the proof that exactly one of their bins is 1. It is not the name of a bin nor of an attribute.
+func HistogramTotalName(name string) string {
+	return name + HistogramBinSeparator
+}
+
+// HistogramBins returns the names of the histogram bins of an attribute, one for each value of the domain
+func (d *Domain) HistogramBins(name string) []string {
+	bins := make([]string, 0, d.Max-d.Min+1)
+	for v := d.Min; v <= d.Max; v++ {
+		bins = append(bins, HistogramBinName(name, v))
+	}
+	return bins
+}
+
+// Histogram returns the one-hot encoding of a value of the domain: the bin of the value is 1 and the others are 0, so
+// that the sum of the histograms of the responses counts the responses with each value
+func (d *Domain) Histogram(name string, value int64) map[string]int64 {
+	histogram := make(map[string]int64, d.Max-d.Min+1)
+	for v := d.Min; v <= d.Max; v++ {
+		histogram[HistogramBinName(name, v)] = 0
+	}
+	histogram[HistogramBinName(name, value)] = 1
+	return histogram
+}
+
+// checkHistogram verifies that the domain can be encoded as a histogram
+func (d *Domain) checkHistogram() error {
+	if d.Min > d.Max || d.Max-d.Min < 0 || d.Max-d.Min >= MaxHistogramBins {
+		return fmt.Errorf("domain [%d, %d] has more than %d values for a histogram", d.Min, d.Max, MaxHistogramBins)
+	}
+	return nil
+}
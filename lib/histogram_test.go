@@ -0,0 +1,23 @@
+package libunlynx_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	domain := libunlynx.Domain{Min: -1, Max: 2}
+	assert.Equal(t, []string{"a#-1", "a#0", "a#1", "a#2"}, domain.HistogramBins("a"))
+	assert.Equal(t, map[string]int64{"a#-1": 0, "a#0": 0, "a#1": 1, "a#2": 0}, domain.Histogram("a", 1))
+
+	name, value, ok := libunlynx.SplitHistogramBinName(libunlynx.HistogramBinName("age", -1))
+	assert.True(t, ok)
+	assert.Equal(t, "age", name)
+	assert.Equal(t, int64(-1), value)
+	_, _, ok = libunlynx.SplitHistogramBinName("age")
+	assert.False(t, ok)
+	_, _, ok = libunlynx.SplitHistogramBinName("age#x")
+	assert.False(t, ok)
+}
@@ -0,0 +1,83 @@
+package libunlynx
+
+import (
+	"fmt"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/proof"
+)
+
+// KNOWLEDGE proofs
+//______________________________________________________________________________________________________________________
+
+// knowledgeProofContext binds a proof to the survey, the data provider and the ciphertext itself, such that it cannot
+// be replayed by another data provider or for a modified ciphertext
+func knowledgeProofContext(ct CipherText, surveyID, dpID string) (string, error) {
+	data, err := ct.ToBytes()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("knowledgeProof/%d/%s/%d/%s/", len(surveyID), surveyID, len(dpID), dpID) + string(data), nil
+}
+
+// KnowledgeProofCreation proves the knowledge of the randomness r of a ciphertext (rB, rP + M), and thus of its
+// plaintext M. The proof is only valid for the given survey and data provider.
+func KnowledgeProofCreation(ct CipherText, r kyber.Scalar, surveyID, dpID string) ([]byte, error) {
+	context, err := knowledgeProofContext(ct, surveyID, dpID)
+	if err != nil {
+		return nil, err
+	}
+
+	predicate := proof.Rep("K", "r", "B")
+	sval := map[string]kyber.Scalar{"r": r}
+	pval := map[string]kyber.Point{"K": ct.K, "B": SuiTe.Point().Base()}
+	prover := predicate.Prover(SuiTe, sval, pval, nil)
+	proofKnowledge, err := proof.HashProve(SuiTe, context, prover)
+	if err != nil {
+		return nil, fmt.Errorf("---------prover: %v", err)
+	}
+	return proofKnowledge, nil
+}
+
+// KnowledgeProofVerification verifies a proof of knowledge of the plaintext of a ciphertext for the given survey and
+// data provider
+func KnowledgeProofVerification(proofKnowledge []byte, ct CipherText, surveyID, dpID string) error {
+	context, err := knowledgeProofContext(ct, surveyID, dpID)
+	if err != nil {
+		return err
+	}
+
+	predicate := proof.Rep("K", "r", "B")
+	pval := map[string]kyber.Point{"K": ct.K, "B": SuiTe.Point().Base()}
+	verifier := predicate.Verifier(SuiTe, pval)
+	return proof.HashVerify(SuiTe, context, verifier, proofKnowledge)
+}
+
+// verifyMapKnowledgeProofs verifies the proofs of knowledge of all the ciphertexts of a map
+func verifyMapKnowledgeProofs(encrypted map[string][]byte, proofs map[string][]byte, surveyID, dpID string) error {
+	for name, data := range encrypted {
+		proofKnowledge, ok := proofs[name]
+		if !ok {
+			return fmt.Errorf("no proof of knowledge for attribute %s", name)
+		}
+		ct := CipherText{}
+		if err := ct.FromBytes(data); err != nil {
+			return err
+		}
+		if err := KnowledgeProofVerification(proofKnowledge, ct, surveyID, dpID); err != nil {
+			return fmt.Errorf("wrong proof of knowledge for attribute %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// VerifyKnowledgeProofs checks that the data provider knows the plaintexts of all the ciphertexts of its response
+func (dprts *DpResponseToSend) VerifyKnowledgeProofs(surveyID, dpID string) error {
+	if err := verifyMapKnowledgeProofs(dprts.GroupByEnc, dprts.GroupByEncProofs, surveyID, dpID); err != nil {
+		return err
+	}
+	if err := verifyMapKnowledgeProofs(dprts.WhereEnc, dprts.WhereEncProofs, surveyID, dpID); err != nil {
+		return err
+	}
+	return verifyMapKnowledgeProofs(dprts.AggregatingAttributesEnc, dprts.AggregatingAttributesEncProofs, surveyID, dpID)
+}
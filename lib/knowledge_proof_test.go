@@ -0,0 +1,49 @@
+package libunlynx_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKnowledgeProof(t *testing.T) {
+	_, pubKey := libunlynx.GenKey()
+
+	ct, r := libunlynx.EncryptIntGetR(pubKey, 12)
+	proof, err := libunlynx.KnowledgeProofCreation(*ct, r, "survey", "dp")
+	require.NoError(t, err)
+	assert.NoError(t, libunlynx.KnowledgeProofVerification(proof, *ct, "survey", "dp"))
+
+	// the proof is bound to the survey, the data provider and the ciphertext
+	assert.Error(t, libunlynx.KnowledgeProofVerification(proof, *ct, "other survey", "dp"))
+	assert.Error(t, libunlynx.KnowledgeProofVerification(proof, *ct, "survey", "other dp"))
+	shifted := libunlynx.CipherText{K: ct.K, C: libunlynx.SuiTe.Point().Add(ct.C, libunlynx.IntToPoint(1))}
+	assert.Error(t, libunlynx.KnowledgeProofVerification(proof, shifted, "survey", "dp"))
+
+	// without the randomness, the proof is wrong
+	proof, err = libunlynx.KnowledgeProofCreation(*ct, libunlynx.SuiTe.Scalar().One(), "survey", "dp")
+	require.NoError(t, err)
+	assert.Error(t, libunlynx.KnowledgeProofVerification(proof, *ct, "survey", "dp"))
+}
+
+func TestVerifyKnowledgeProofs(t *testing.T) {
+	_, pubKey := libunlynx.GenKey()
+
+	ccr := libunlynx.DpClearResponse{
+		GroupByEnc:               map[string]int64{"g1": 2},
+		WhereEnc:                 map[string]int64{"w1": 3},
+		AggregatingAttributesEnc: map[string]int64{"s1": 4},
+	}
+	cr, err := libunlynx.EncryptDpClearResponse(ccr, pubKey, true, "survey", "dp")
+	require.NoError(t, err)
+	assert.NoError(t, cr.VerifyKnowledgeProofs("survey", "dp"))
+
+	// another data provider cannot submit the same ciphertexts
+	assert.Error(t, cr.VerifyKnowledgeProofs("survey", "copier"))
+
+	// a ciphertext without proof is refused
+	delete(cr.AggregatingAttributesEncProofs, "count")
+	assert.Error(t, cr.VerifyKnowledgeProofs("survey", "dp"))
+}
@@ -0,0 +1,112 @@
+package libunlynx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// MomentSeparator separates the names of the attributes multiplied in a moment (e.g. height*weight). It cannot appear
+// in an attribute name.
+const MomentSeparator = "*"
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// MomentName returns the name of the aggregating attribute containing the product of two attributes (the square of an
+// attribute if they are the same), the names are sorted so that the product of a and b is the one of b and a
+func MomentName(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + MomentSeparator + b
+}
+
+// SplitMomentName returns the attributes multiplied in a moment
+func SplitMomentName(moment string) (string, string, bool) {
+	names := strings.Split(moment, MomentSeparator)
+	if len(names) != 2 || names[0] == "" || names[1] == "" || names[1] < names[0] {
+		return "", "", false
+	}
+	return names[0], names[1], true
+}
+
+// Moments returns the products of all the pairs of attributes (including the squares) from their values, or an error if
+// a product overflows
+func Moments(values map[string]int64) (map[string]int64, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	moments := make(map[string]int64, len(names)*(len(names)+1)/2)
+	for i, a := range names {
+		for _, b := range names[i:] {
+			p, ok := multiply(values[a], values[b])
+			if !ok {
+				return nil, fmt.Errorf("the moment %s overflows", MomentName(a, b))
+			}
+			moments[MomentName(a, b)] = p
+		}
+	}
+	return moments, nil
+}
+
+// multiply returns the product of two integers and whether it does not overflow
+func multiply(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	p := a * b
+	if p/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return p, true
+}
+
+// withMoments returns the response with the products of its Moments attributes added to its aggregating attributes, a
+// product is encrypted if one of its attributes is. The maps of the response are copied: they can be shared by several
+// responses.
+func (dcr DpClearResponse) withMoments() (DpClearResponse, error) {
+	if len(dcr.Moments) == 0 {
+		return dcr, nil
+	}
+	values := make(map[string]int64, len(dcr.Moments))
+	encrypted := make(map[string]bool, len(dcr.Moments))
+	for _, name := range dcr.Moments {
+		if v, ok := dcr.AggregatingAttributesClear[name]; ok {
+			values[name] = v
+			continue
+		}
+		v, ok := dcr.AggregatingAttributesEnc[name]
+		if !ok {
+			return DpClearResponse{}, fmt.Errorf("no value for the attribute %s of the moments", name)
+		}
+		values[name], encrypted[name] = v, true
+	}
+
+	clear := make(map[string]int64, len(dcr.AggregatingAttributesClear))
+	for name, v := range dcr.AggregatingAttributesClear {
+		clear[name] = v
+	}
+	enc := make(map[string]int64, len(dcr.AggregatingAttributesEnc))
+	for name, v := range dcr.AggregatingAttributesEnc {
+		enc[name] = v
+	}
+	moments, err := Moments(values)
+	if err != nil {
+		return DpClearResponse{}, err
+	}
+	for moment, v := range moments {
+		a, b, _ := SplitMomentName(moment)
+		if encrypted[a] || encrypted[b] {
+			enc[moment] = v
+		} else {
+			clear[moment] = v
+		}
+	}
+	dcr.AggregatingAttributesClear, dcr.AggregatingAttributesEnc, dcr.Moments = clear, enc, nil
+	return dcr, nil
+}
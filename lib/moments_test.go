@@ -0,0 +1,54 @@
+package libunlynx_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoments(t *testing.T) {
+	assert.Equal(t, "x*y", libunlynx.MomentName("y", "x"))
+	a, b, ok := libunlynx.SplitMomentName("x*y")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"x", "y"}, []string{a, b})
+	for _, wrong := range []string{"x", "y*x", "x*y*z", "*x"} {
+		_, _, ok = libunlynx.SplitMomentName(wrong)
+		assert.False(t, ok, wrong)
+	}
+
+	moments, err := libunlynx.Moments(map[string]int64{"x": 2, "y": -3})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"x*x": 4, "x*y": -6, "y*y": 9}, moments)
+	for _, values := range []map[string]int64{{"x": 1 << 32}, {"x": 3, "y": math.MaxInt64 / 2}, {"x": -1, "y": math.MinInt64}} {
+		_, err = libunlynx.Moments(values)
+		assert.Error(t, err, values)
+	}
+}
+
+func TestEncryptDpClearResponseMoments(t *testing.T) {
+	secKey, pubKey := libunlynx.GenKey()
+
+	ccr := libunlynx.DpClearResponse{
+		AggregatingAttributesClear: map[string]int64{"x": 2, "z": 5},
+		AggregatingAttributesEnc:   map[string]int64{"y": 3},
+		Moments:                    []string{"x", "y"},
+	}
+	cr, err := libunlynx.EncryptDpClearResponse(ccr, pubKey, false, "survey", "dp")
+	require.NoError(t, err)
+	// the products with an encrypted attribute are encrypted
+	assert.Equal(t, map[string]int64{"x": 2, "z": 5, "x*x": 4}, cr.AggregatingAttributesClear)
+	enc, err := decryptMapBytes(secKey, cr.AggregatingAttributesEnc)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"y": 3, "x*y": 6, "y*y": 9}, enc)
+	assert.Len(t, cr.AggregatingAttributesEncProofs, 3)
+	// the maps of the response are left as they are
+	assert.Len(t, ccr.AggregatingAttributesClear, 2)
+	assert.Len(t, ccr.AggregatingAttributesEnc, 1)
+
+	ccr.Moments = []string{"x", "w"}
+	_, err = libunlynx.EncryptDpClearResponse(ccr, pubKey, false, "survey", "dp")
+	assert.Error(t, err)
+}
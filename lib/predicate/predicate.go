@@ -0,0 +1,433 @@
+package libunlynxpredicate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ldsec/unlynx/lib"
+)
+
+// Structs
+//______________________________________________________________________________________________________________________
+
+// Op is the operation of a predicate node
+type Op string
+
+const (
+	// OpAnd is true if all its operands are true
+	OpAnd Op = "and"
+	// OpOr is true if one of its operands is true
+	OpOr Op = "or"
+	// OpNot is true if its operand is false
+	OpNot Op = "not"
+	// OpEqual is true if the attribute of the response is equal to the value
+	OpEqual Op = "eq"
+	// OpIn is true if the attribute of the response is equal to one of the values
+	OpIn Op = "in"
+)
+
+// Predicate is a node of the predicate filtering the responses of a survey on their where attributes. The values the
+// attributes are compared to are the where attributes of the query (encrypted by the querier), referenced by their
+// index: a value can only be compared to the attribute it was given for.
+type Predicate struct {
+	Op        Op
+	Operands  []*Predicate // OpAnd, OpOr: at least one, OpNot: one
+	Attribute string       // OpEqual, OpIn: where attribute of the responses
+	Values    []int        // OpEqual: one, OpIn: at least one (indices of the where attributes of the query)
+}
+
+// And returns the conjunction of predicates
+func And(operands ...*Predicate) *Predicate {
+	return &Predicate{Op: OpAnd, Operands: operands}
+}
+
+// Or returns the disjunction of predicates
+func Or(operands ...*Predicate) *Predicate {
+	return &Predicate{Op: OpOr, Operands: operands}
+}
+
+// Not returns the negation of a predicate
+func Not(operand *Predicate) *Predicate {
+	return &Predicate{Op: OpNot, Operands: []*Predicate{operand}}
+}
+
+// Equal returns the predicate comparing an attribute to the where attribute of the query with the given index
+func Equal(attribute string, value int) *Predicate {
+	return &Predicate{Op: OpEqual, Attribute: attribute, Values: []int{value}}
+}
+
+// In returns the predicate comparing an attribute to a set of where attributes of the query
+func In(attribute string, values ...int) *Predicate {
+	return &Predicate{Op: OpIn, Attribute: attribute, Values: values}
+}
+
+// Validate checks the structure of the predicate and that it only compares the where attributes of the query (given
+// by name, in their order) to their own values
+func (p *Predicate) Validate(where []string) error {
+	if p == nil {
+		return fmt.Errorf("empty predicate")
+	}
+	switch p.Op {
+	case OpAnd, OpOr, OpNot:
+		if len(p.Operands) == 0 || (p.Op == OpNot && len(p.Operands) != 1) {
+			return fmt.Errorf("wrong number of operands (%d) for %s", len(p.Operands), p.Op)
+		}
+		if p.Attribute != "" || len(p.Values) > 0 {
+			return fmt.Errorf("%s has no attribute nor values", p.Op)
+		}
+		for _, o := range p.Operands {
+			if err := o.Validate(where); err != nil {
+				return err
+			}
+		}
+	case OpEqual, OpIn:
+		if len(p.Values) == 0 || (p.Op == OpEqual && len(p.Values) != 1) {
+			return fmt.Errorf("wrong number of values (%d) for %s", len(p.Values), p.Op)
+		}
+		if len(p.Operands) > 0 {
+			return fmt.Errorf("%s has no operands", p.Op)
+		}
+		if attributeIndex(p.Attribute, where) < 0 {
+			return fmt.Errorf("%s is not a where attribute", p.Attribute)
+		}
+		for _, v := range p.Values {
+			if v < 0 || v >= len(where) {
+				return fmt.Errorf("no where value $%d", v)
+			}
+			if where[v] != p.Attribute {
+				return fmt.Errorf("%s compared to $%d, a value of %s", p.Attribute, v, where[v])
+			}
+		}
+	default:
+		return fmt.Errorf("unknown predicate operation %q", p.Op)
+	}
+	return nil
+}
+
+// String returns the text of the predicate (see Parse)
+func (p *Predicate) String() string {
+	switch p.Op {
+	case OpAnd, OpOr:
+		operands := make([]string, len(p.Operands))
+		for i, o := range p.Operands {
+			operands[i] = o.String()
+			if o.Op == OpAnd || o.Op == OpOr {
+				operands[i] = "(" + operands[i] + ")"
+			}
+		}
+		return strings.Join(operands, " "+strings.ToUpper(string(p.Op))+" ")
+	case OpNot:
+		return "NOT (" + p.Operands[0].String() + ")"
+	case OpEqual:
+		return p.Attribute + " == $" + strconv.Itoa(p.Values[0])
+	case OpIn:
+		values := make([]string, len(p.Values))
+		for i, v := range p.Values {
+			values[i] = "$" + strconv.Itoa(v)
+		}
+		return p.Attribute + " IN (" + strings.Join(values, ", ") + ")"
+	}
+	return string(p.Op)
+}
+
+// attributeIndex returns the index of the first where attribute with the given name (-1 if there is none)
+func attributeIndex(name string, where []string) int {
+	for i, w := range where {
+		if w == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Filter
+//______________________________________________________________________________________________________________________
+
+// Filter is a predicate compiled for the where attributes of a query
+type Filter struct {
+	keep func(query, response []libunlynx.GroupingKey) bool
+}
+
+// Compile validates a predicate for the where attributes of a query (given by name, in their order) and compiles it
+func Compile(p *Predicate, where []string) (*Filter, error) {
+	if err := p.Validate(where); err != nil {
+		return nil, err
+	}
+	return &Filter{keep: compile(p, where)}, nil
+}
+
+func compile(p *Predicate, where []string) func(query, response []libunlynx.GroupingKey) bool {
+	switch p.Op {
+	case OpAnd, OpOr:
+		operands := make([]func(query, response []libunlynx.GroupingKey) bool, len(p.Operands))
+		for i, o := range p.Operands {
+			operands[i] = compile(o, where)
+		}
+		// OR stops at the first true operand, AND at the first false one
+		stop := p.Op == OpOr
+		return func(query, response []libunlynx.GroupingKey) bool {
+			for _, o := range operands {
+				if o(query, response) == stop {
+					return stop
+				}
+			}
+			return !stop
+		}
+	case OpNot:
+		operand := compile(p.Operands[0], where)
+		return func(query, response []libunlynx.GroupingKey) bool {
+			return !operand(query, response)
+		}
+	default:
+		attribute := attributeIndex(p.Attribute, where)
+		values := p.Values
+		return func(query, response []libunlynx.GroupingKey) bool {
+			if attribute >= len(response) {
+				return false
+			}
+			for _, v := range values {
+				if v < len(query) && query[v] == response[attribute] {
+					return true
+				}
+			}
+			return false
+		}
+	}
+}
+
+// Keep evaluates the filter on the tags of the where attributes of a response, given the tags of the where attributes
+// of the query
+func (f *Filter) Keep(query, response []libunlynx.GroupingKey) bool {
+	return f.keep(query, response)
+}
+
+// Parser
+//______________________________________________________________________________________________________________________
+
+// Parse parses the text of a predicate for the where attributes of a query (given by name, in their order) and
+// validates it. The attributes are referenced by name and the values of the query by their index: $i is the value of
+// the i-th where attribute. Equalities (==, !=) and sets (IN) can be combined with AND (&&), OR (||) and NOT (!), e.g.
+// "(w0 == $0 OR w1 IN ($1, $2)) AND NOT w2 == $3". For compatibility, vi refers to the value of the (i/2)-th where
+// attribute if i is even and to the attribute itself if it is odd, as in "v0 == v1 && v2 != v3". The prefix tags of
+// an attribute are referenced by their name, e.g. "age/3 IN ($0, $1)" (see Query.Range).
+func Parse(text string, where []string) (*Predicate, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	ps := &parser{tokens: tokens, where: where}
+	p, err := ps.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if ps.pos < len(ps.tokens) {
+		return nil, fmt.Errorf("unexpected %q in predicate", ps.tokens[ps.pos])
+	}
+	if err := p.Validate(where); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// tokenize splits the text of a predicate in identifiers, values ($i), operators and parentheses
+func tokenize(text string) ([]string, error) {
+	// the attribute names match libunlynx.AttributeNamePattern, the names of prefix tags also contain a separator
+	isIdent := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || string(r) == libunlynx.PrefixTagSeparator
+	}
+	runes := []rune(text)
+	tokens := make([]string, 0)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '!' && (i+1 == len(runes) || runes[i+1] != '='):
+			tokens = append(tokens, "!")
+			i++
+		case strings.HasPrefix(string(runes[i:]), "==") || strings.HasPrefix(string(runes[i:]), "!=") ||
+			strings.HasPrefix(string(runes[i:]), "&&") || strings.HasPrefix(string(runes[i:]), "||"):
+			tokens = append(tokens, string(runes[i:i+2]))
+			i += 2
+		case r == '$' || isIdent(r):
+			j := i + 1
+			for j < len(runes) && isIdent(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q in predicate", r)
+		}
+	}
+	return tokens, nil
+}
+
+// operand is an attribute or a value of a comparison
+type operand struct {
+	attribute string
+	value     int // if attribute is empty
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+	where  []string
+}
+
+// accept consumes the next token if it is one of the given ones (keywords are case insensitive)
+func (ps *parser) accept(tokens ...string) bool {
+	if ps.pos >= len(ps.tokens) {
+		return false
+	}
+	for _, t := range tokens {
+		if strings.EqualFold(ps.tokens[ps.pos], t) {
+			ps.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (ps *parser) expect(token string) error {
+	if !ps.accept(token) {
+		return fmt.Errorf("expected %q in predicate", token)
+	}
+	return nil
+}
+
+func (ps *parser) parseOr() (*Predicate, error) {
+	return ps.parseList(OpOr, ps.parseAnd, "OR", "||")
+}
+
+func (ps *parser) parseAnd() (*Predicate, error) {
+	return ps.parseList(OpAnd, ps.parseUnary, "AND", "&&")
+}
+
+// parseList parses operands separated by one of the given operators
+func (ps *parser) parseList(op Op, parseOperand func() (*Predicate, error), operators ...string) (*Predicate, error) {
+	first, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	operands := []*Predicate{first}
+	for ps.accept(operators...) {
+		next, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &Predicate{Op: op, Operands: operands}, nil
+}
+
+func (ps *parser) parseUnary() (*Predicate, error) {
+	if ps.accept("NOT", "!") {
+		operand, err := ps.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(operand), nil
+	}
+	if ps.accept("(") {
+		p, err := ps.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return p, ps.expect(")")
+	}
+	return ps.parseComparison()
+}
+
+func (ps *parser) parseComparison() (*Predicate, error) {
+	left, err := ps.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if ps.accept("IN") {
+		if left.attribute == "" {
+			return nil, fmt.Errorf("IN must follow an attribute")
+		}
+		if err := ps.expect("("); err != nil {
+			return nil, err
+		}
+		p := In(left.attribute)
+		for {
+			value, err := ps.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			if value.attribute != "" {
+				return nil, fmt.Errorf("attribute %s in the values of %s", value.attribute, left.attribute)
+			}
+			p.Values = append(p.Values, value.value)
+			if !ps.accept(",") {
+				break
+			}
+		}
+		return p, ps.expect(")")
+	}
+
+	negated := ps.accept("!=")
+	if !negated && !ps.accept("==") {
+		return nil, fmt.Errorf("expected a comparison in predicate")
+	}
+	right, err := ps.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	// the comparison is symmetric: one side is the attribute, the other its value
+	if left.attribute == "" {
+		left, right = right, left
+	}
+	if left.attribute == "" || right.attribute != "" {
+		return nil, fmt.Errorf("a comparison needs an attribute and a value")
+	}
+	p := Equal(left.attribute, right.value)
+	if negated {
+		p = Not(p)
+	}
+	return p, nil
+}
+
+func (ps *parser) parseOperand() (operand, error) {
+	if ps.pos >= len(ps.tokens) {
+		return operand{}, fmt.Errorf("unexpected end of predicate")
+	}
+	token := ps.tokens[ps.pos]
+	ps.pos++
+
+	switch {
+	case strings.HasPrefix(token, "$"):
+		value, err := strconv.Atoi(token[1:])
+		if err != nil || value < 0 {
+			return operand{}, fmt.Errorf("wrong value %s in predicate", token)
+		}
+		return operand{value: value}, nil
+	case token == "(" || token == ")" || token == "," || !unicode.IsLetter([]rune(token)[0]) && token[0] != '_':
+		return operand{}, fmt.Errorf("unexpected %q in predicate", token)
+	}
+
+	// positional references of the former predicates (if no where attribute has that name)
+	if attributeIndex(token, ps.where) < 0 && len(token) > 1 && token[0] == 'v' {
+		if i, err := strconv.Atoi(token[1:]); err == nil && i >= 0 {
+			if i%2 == 0 {
+				return operand{value: i / 2}, nil
+			}
+			if i/2 >= len(ps.where) {
+				return operand{}, fmt.Errorf("no where attribute for %s", token)
+			}
+			return operand{attribute: ps.where[i/2]}, nil
+		}
+	}
+	return operand{attribute: token}, nil
+}
@@ -0,0 +1,129 @@
+package libunlynxpredicate_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/predicate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	where := []string{"w0", "w1", "w1", "w2"}
+
+	p, err := libunlynxpredicate.Parse("(w0 == $0 OR w1 IN ($1, $2)) AND NOT w2 == $3", where)
+	require.NoError(t, err)
+	expected := libunlynxpredicate.And(
+		libunlynxpredicate.Or(libunlynxpredicate.Equal("w0", 0), libunlynxpredicate.In("w1", 1, 2)),
+		libunlynxpredicate.Not(libunlynxpredicate.Equal("w2", 3)))
+	assert.Equal(t, expected, p)
+
+	// the text of a predicate parses to the same predicate
+	p, err = libunlynxpredicate.Parse(p.String(), where)
+	require.NoError(t, err)
+	assert.Equal(t, expected, p)
+
+	// symbols, lower case keywords and values on the left
+	p, err = libunlynxpredicate.Parse("($0 == w0 || w1 in ($1,$2)) && !(w2 == $3)", where)
+	require.NoError(t, err)
+	assert.Equal(t, expected, p)
+
+	// positional references of the former predicates
+	p, err = libunlynxpredicate.Parse("v0 != v1 || (v2 == v3 && v6 == v7)", where)
+	require.NoError(t, err)
+	assert.Equal(t, libunlynxpredicate.Or(
+		libunlynxpredicate.Not(libunlynxpredicate.Equal("w0", 0)),
+		libunlynxpredicate.And(libunlynxpredicate.Equal("w1", 1), libunlynxpredicate.Equal("w2", 3))), p)
+
+	for _, wrong := range []string{
+		"",
+		"w0",
+		"w0 == $0 AND",
+		"(w0 == $0",
+		"w0 == w1",
+		"$0 == $0",
+		"w0 == $1",
+		"w0 == $9",
+		"w3 == $0",
+		"w1 IN (w0)",
+		"w0 == $0 w1 == $1",
+		"w0 > $0",
+		"v9 == v9",
+	} {
+		_, err := libunlynxpredicate.Parse(wrong, where)
+		assert.Error(t, err, wrong)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	where := []string{"w0", "w1"}
+	assert.NoError(t, libunlynxpredicate.Or(libunlynxpredicate.Equal("w0", 0)).Validate(where))
+	assert.Error(t, libunlynxpredicate.And().Validate(where))
+	assert.Error(t, (&libunlynxpredicate.Predicate{Op: libunlynxpredicate.OpNot, Operands: []*libunlynxpredicate.Predicate{libunlynxpredicate.Equal("w0", 0), libunlynxpredicate.Equal("w1", 1)}}).Validate(where))
+	assert.Error(t, libunlynxpredicate.Not(nil).Validate(where))
+	assert.Error(t, libunlynxpredicate.In("w0").Validate(where))
+	assert.Error(t, (&libunlynxpredicate.Predicate{Op: libunlynxpredicate.OpEqual, Attribute: "w0", Values: []int{0, 0}}).Validate(where))
+	assert.Error(t, (&libunlynxpredicate.Predicate{Op: "lt", Attribute: "w0", Values: []int{0}}).Validate(where))
+}
+
+func TestFilter(t *testing.T) {
+	where := []string{"w0", "w1", "w1"}
+	p, err := libunlynxpredicate.Parse("w0 == $0 AND NOT w1 IN ($1, $2)", where)
+	require.NoError(t, err)
+	filter, err := libunlynxpredicate.Compile(p, where)
+	require.NoError(t, err)
+
+	query := []libunlynx.GroupingKey{"a", "b", "c"}
+	assert.True(t, filter.Keep(query, []libunlynx.GroupingKey{"a", "d", "d"}))
+	assert.False(t, filter.Keep(query, []libunlynx.GroupingKey{"a", "b", "b"}))
+	assert.False(t, filter.Keep(query, []libunlynx.GroupingKey{"a", "c", "c"}))
+	assert.False(t, filter.Keep(query, []libunlynx.GroupingKey{"d", "d", "d"}))
+	// missing tags never match
+	assert.False(t, filter.Keep(query[:1], []libunlynx.GroupingKey{"b"}))
+
+	_, err = libunlynxpredicate.Compile(libunlynxpredicate.Equal("w1", 0), where)
+	assert.Error(t, err)
+}
+
+func TestQuery(t *testing.T) {
+	domain := libunlynx.Domain{Min: 0, Max: 99}
+	q := libunlynxpredicate.Query{}
+	smoker := q.Equal("smoker", 1)
+	age, err := q.Range("age", domain, 18, 65)
+	require.NoError(t, err)
+	assert.Equal(t, "smoker == $0 AND (age/1 IN ($1, $2) OR age/2 IN ($3) OR age/3 IN ($4) OR age/5 IN ($5))",
+		libunlynxpredicate.And(smoker, age).String())
+
+	// values are not repeated
+	assert.Equal(t, libunlynxpredicate.Equal("smoker", 0), q.Equal("smoker", 1))
+	_, err = q.Range("age", domain, 100, 120)
+	assert.Error(t, err)
+
+	p, err := libunlynxpredicate.Parse(libunlynxpredicate.And(smoker, age).String(), q.Names())
+	require.NoError(t, err)
+	filter, err := libunlynxpredicate.Compile(p, q.Names())
+	require.NoError(t, err)
+
+	// the tags of the query and of the responses compared in clear
+	query := make([]libunlynx.GroupingKey, len(q.Where))
+	for i, w := range q.Where {
+		query[i] = libunlynx.GroupingKey(strconv.FormatInt(w.Value, 10))
+	}
+	for v := domain.Min; v <= domain.Max; v++ {
+		tags := domain.PrefixTags("age", v)
+		tags["smoker"] = 1
+		response := make([]libunlynx.GroupingKey, len(q.Where))
+		for i, w := range q.Where {
+			response[i] = libunlynx.GroupingKey(strconv.FormatInt(tags[w.Name], 10))
+		}
+		assert.Equal(t, v >= 18 && v <= 65, filter.Keep(query, response), v)
+	}
+
+	atLeast, err := q.AtLeast("age", domain, 50)
+	require.NoError(t, err)
+	atMost, err := q.AtMost("age", domain, 49)
+	require.NoError(t, err)
+	assert.NoError(t, libunlynxpredicate.Or(atLeast, atMost).Validate(q.Names()))
+}
@@ -0,0 +1,104 @@
+package libunlynxpredicate
+
+import (
+	"fmt"
+
+	"github.com/ldsec/unlynx/lib"
+	"go.dedis.ch/kyber/v3"
+)
+
+// WhereValue is a where attribute of a query in clear: the name of an attribute (or of a prefix tag) of the responses
+// and the value it is compared to
+type WhereValue struct {
+	Name  string
+	Value int64
+}
+
+// Query builds the where attributes of a survey query along with the predicate over them: each comparison adds the
+// values it needs to the where attributes and references them by index
+type Query struct {
+	Where []WhereValue
+}
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// value returns the index of a where attribute, which is added if the query does not contain it yet
+func (q *Query) value(name string, value int64) int {
+	for i, w := range q.Where {
+		if w.Name == name && w.Value == value {
+			return i
+		}
+	}
+	q.Where = append(q.Where, WhereValue{Name: name, Value: value})
+	return len(q.Where) - 1
+}
+
+// Equal returns the predicate comparing an attribute to a value
+func (q *Query) Equal(attribute string, value int64) *Predicate {
+	return Equal(attribute, q.value(attribute, value))
+}
+
+// In returns the predicate comparing an attribute to a set of values
+func (q *Query) In(attribute string, values ...int64) *Predicate {
+	p := In(attribute)
+	for _, v := range values {
+		p.Values = append(p.Values, q.value(attribute, v))
+	}
+	return p
+}
+
+// Range returns the predicate testing whether an attribute with ranges (see libunlynx.Attribute.Ranges) is between min
+// and max (included), given the domain of the attribute. The interval is expanded in its cover by prefix tags: the
+// predicate compares the tags of each level to the values of the cover, e.g. "age/0 IN ($0) OR age/2 IN ($1, $2)".
+func (q *Query) Range(attribute string, domain libunlynx.Domain, min, max int64) (*Predicate, error) {
+	cover := domain.PrefixCover(attribute, min, max)
+	if len(cover) == 0 {
+		return nil, fmt.Errorf("empty range [%d, %d] for %s", min, max, attribute)
+	}
+
+	// one comparison per level, in the order of the cover
+	levels := make(map[string]*Predicate)
+	operands := make([]*Predicate, 0)
+	for _, tag := range cover {
+		p, ok := levels[tag.Name]
+		if !ok {
+			p = In(tag.Name)
+			levels[tag.Name] = p
+			operands = append(operands, p)
+		}
+		p.Values = append(p.Values, q.value(tag.Name, tag.Value))
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return Or(operands...), nil
+}
+
+// AtLeast returns the predicate testing whether an attribute with ranges is greater than or equal to min
+func (q *Query) AtLeast(attribute string, domain libunlynx.Domain, min int64) (*Predicate, error) {
+	return q.Range(attribute, domain, min, domain.Max)
+}
+
+// AtMost returns the predicate testing whether an attribute with ranges is less than or equal to max
+func (q *Query) AtMost(attribute string, domain libunlynx.Domain, max int64) (*Predicate, error) {
+	return q.Range(attribute, domain, domain.Min, max)
+}
+
+// Names returns the names of the where attributes of the query, in their order (see Parse and Compile)
+func (q *Query) Names() []string {
+	names := make([]string, len(q.Where))
+	for i, w := range q.Where {
+		names[i] = w.Name
+	}
+	return names
+}
+
+// Encrypt returns the where attributes of the query encrypted with the collective key
+func (q *Query) Encrypt(key kyber.Point) []libunlynx.WhereQueryAttribute {
+	where := make([]libunlynx.WhereQueryAttribute, len(q.Where))
+	for i, w := range q.Where {
+		where[i] = libunlynx.WhereQueryAttribute{Name: w.Name, Value: *libunlynx.EncryptInt(key, w.Value)}
+	}
+	return where
+}
@@ -0,0 +1,103 @@
+package libunlynx
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// PrefixTagSeparator separates the name of an attribute from the level of its prefix tags (e.g. age/3). It cannot
+// appear in an attribute name.
+const PrefixTagSeparator = "/"
+
+// maxPrefixBits bounds the size of the domains decomposed in prefix tags, so that the node values fit in an int64
+const maxPrefixBits = 62
+
+// PrefixTag is the name and value of a prefix tag of an attribute
+type PrefixTag struct {
+	Name  string
+	Value int64
+}
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// PrefixTagName returns the name of the prefix tag of an attribute at a level
+func PrefixTagName(name string, level int) string {
+	return name + PrefixTagSeparator + strconv.Itoa(level)
+}
+
+// SplitPrefixTagName returns the attribute and the level of a prefix tag name
+func SplitPrefixTagName(tag string) (string, int, bool) {
+	i := strings.LastIndex(tag, PrefixTagSeparator)
+	if i < 0 {
+		return "", 0, false
+	}
+	level, err := strconv.Atoi(tag[i+1:])
+	if err != nil || level < 0 {
+		return "", 0, false
+	}
+	return tag[:i], level, true
+}
+
+// PrefixBits returns the number of bits of the offsets of the values of the domain (from its minimum): the levels of
+// the prefix tags go from 0 to PrefixBits
+func (d *Domain) PrefixBits() int {
+	return bits.Len64(uint64(d.Max - d.Min))
+}
+
+// checkPrefix verifies that the domain can be decomposed in prefix tags
+func (d *Domain) checkPrefix() error {
+	if d.Min > d.Max || d.Max-d.Min < 0 || d.PrefixBits() > maxPrefixBits {
+		return fmt.Errorf("domain [%d, %d] is too large for prefix tags", d.Min, d.Max)
+	}
+	return nil
+}
+
+// PrefixTags returns the prefix tags of a value of the domain. The offset of the value from the minimum of the domain
+// is written with PrefixBits bits: the tag of level l is the prefix left after removing the l lowest bits, i.e. the
+// node containing the value in a binary tree over the domain. Two values share the tag of a level if they are in the
+// same aligned interval of 2^l values. Once deterministically tagged, they reveal the length of the common prefix of two
+// values (see Attribute.Ranges).
+func (d *Domain) PrefixTags(name string, value int64) map[string]int64 {
+	offset := value - d.Min
+	tags := make(map[string]int64, d.PrefixBits()+1)
+	for level := 0; level <= d.PrefixBits(); level++ {
+		tags[PrefixTagName(name, level)] = offset >> uint(level)
+	}
+	return tags
+}
+
+// PrefixCover returns the prefix tags covering the values of the domain between min and max (included): a value is in
+// the interval if and only if one of its prefix tags is in the cover. The cover is the canonical decomposition of the
+// interval in aligned intervals, it contains at most two tags per level. It is empty if the interval does not
+// intersect the domain.
+func (d *Domain) PrefixCover(name string, min, max int64) []PrefixTag {
+	if min < d.Min {
+		min = d.Min
+	}
+	if max > d.Max {
+		max = d.Max
+	}
+	cover := make([]PrefixTag, 0)
+	if min > max {
+		return cover
+	}
+
+	// [l, r) shrinks from both ends, the unaligned nodes at its bounds are taken at each level
+	l, r := min-d.Min, max-d.Min+1
+	for level := 0; l < r; level++ {
+		if l&1 == 1 {
+			cover = append(cover, PrefixTag{Name: PrefixTagName(name, level), Value: l})
+			l++
+		}
+		if r&1 == 1 {
+			r--
+			cover = append(cover, PrefixTag{Name: PrefixTagName(name, level), Value: r})
+		}
+		l >>= 1
+		r >>= 1
+	}
+	return cover
+}
@@ -0,0 +1,55 @@
+package libunlynx_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixTags(t *testing.T) {
+	domain := libunlynx.Domain{Min: -3, Max: 9}
+	assert.Equal(t, 4, domain.PrefixBits())
+	assert.Equal(t, 0, (&libunlynx.Domain{Min: 5, Max: 5}).PrefixBits())
+
+	name, level, ok := libunlynx.SplitPrefixTagName(libunlynx.PrefixTagName("age", 3))
+	assert.True(t, ok)
+	assert.Equal(t, "age", name)
+	assert.Equal(t, 3, level)
+	_, _, ok = libunlynx.SplitPrefixTagName("age")
+	assert.False(t, ok)
+	_, _, ok = libunlynx.SplitPrefixTagName("age/x")
+	assert.False(t, ok)
+
+	assert.Equal(t, map[string]int64{"a/0": 6, "a/1": 3, "a/2": 1, "a/3": 0, "a/4": 0}, domain.PrefixTags("a", 3))
+
+	// a value is in an interval iff one of its tags is in the cover of the interval
+	for min := domain.Min - 2; min <= domain.Max+2; min++ {
+		for max := min - 1; max <= domain.Max+2; max++ {
+			cover := domain.PrefixCover("a", min, max)
+			perLevel := make(map[string]int)
+			for _, tag := range cover {
+				perLevel[tag.Name]++
+				assert.True(t, perLevel[tag.Name] <= 2)
+			}
+
+			for v := domain.Min; v <= domain.Max; v++ {
+				tags := domain.PrefixTags("a", v)
+				matches := 0
+				for _, tag := range cover {
+					if tags[tag.Name] == tag.Value {
+						matches++
+					}
+				}
+				// the aligned intervals of the cover are disjoint
+				if v >= min && v <= max {
+					assert.Equal(t, 1, matches, "%d in [%d, %d]", v, min, max)
+				} else {
+					assert.Equal(t, 0, matches, "%d not in [%d, %d]", v, min, max)
+				}
+			}
+		}
+	}
+	assert.Empty(t, domain.PrefixCover("a", 10, 20))
+	assert.Equal(t, []libunlynx.PrefixTag{{Name: "a/4", Value: 0}}, (&libunlynx.Domain{Min: 0, Max: 15}).PrefixCover("a", -5, 20))
+}
@@ -0,0 +1,255 @@
+package libunlynxrange
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+
+	"github.com/ldsec/unlynx/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/proof"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// Structs
+//______________________________________________________________________________________________________________________
+
+// Bounds is the range [Min, Max] declared for an attribute
+type Bounds struct {
+	Min int64
+	Max int64
+}
+
+// PublishedRangeProof proves that a ciphertext encrypts a value in some bounds. The value v is decomposed in bits twice:
+// v - Min and Max - v. Each bit is encrypted (under the same key as the ciphertext) and proven to be 0 or 1, and the bit
+// ciphertexts are homomorphically combined into the original ciphertext.
+type PublishedRangeProof struct {
+	Bits   libunlynx.CipherVector
+	Proofs [][]byte
+}
+
+// Validate checks that the bounds define a non-empty range whose size fits in an int64
+func (b Bounds) Validate() error {
+	if b.Min > b.Max || b.Max-b.Min < 0 {
+		return fmt.Errorf("invalid range [%d, %d]", b.Min, b.Max)
+	}
+	return nil
+}
+
+// Contains checks if a value is in the bounds
+func (b Bounds) Contains(v int64) bool {
+	return v >= b.Min && v <= b.Max
+}
+
+// nbrBits is the number of bits used to decompose the values in the bounds
+func (b Bounds) nbrBits() int {
+	n := bits.Len64(uint64(b.Max - b.Min))
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// RANGE proofs
+//______________________________________________________________________________________________________________________
+
+func createPredicateBit() (predicate proof.Predicate) {
+	// the bit ciphertext (K, C) encrypts 0: K = r*B and C = r*P, or 1: K = r*B and C - B = r*P
+	zero := proof.And(proof.Rep("K", "r0", "B"), proof.Rep("C", "r0", "P"))
+	one := proof.And(proof.Rep("K", "r1", "B"), proof.Rep("CmB", "r1", "P"))
+	predicate = proof.Or(zero, one)
+	return
+}
+
+// bitPoints returns the public points of the bit predicate
+func bitPoints(bit libunlynx.CipherText, pubKey kyber.Point) map[string]kyber.Point {
+	return map[string]kyber.Point{"B": libunlynx.SuiTe.Point().Base(), "P": pubKey, "K": bit.K, "C": bit.C,
+		"CmB": libunlynx.SuiTe.Point().Sub(bit.C, libunlynx.SuiTe.Point().Base())}
+}
+
+// BitProofCreation proves that the ciphertext (rB, rP + bit*B) encrypts 0 or 1
+func BitProofCreation(bitCT libunlynx.CipherText, bit int64, r kyber.Scalar, pubKey kyber.Point) ([]byte, error) {
+	predicate := createPredicateBit()
+	choice := map[proof.Predicate]int{predicate: int(bit)}
+	sval := map[string]kyber.Scalar{"r0": r, "r1": r}
+
+	prover := predicate.Prover(libunlynx.SuiTe, sval, bitPoints(bitCT, pubKey), choice)
+	proofBit, err := proof.HashProve(libunlynx.SuiTe, "rangeProof", prover)
+	if err != nil {
+		return nil, fmt.Errorf("---------prover: %v", err)
+	}
+	return proofBit, nil
+}
+
+// BitProofVerification verifies that a ciphertext encrypts 0 or 1
+func BitProofVerification(proofBit []byte, bitCT libunlynx.CipherText, pubKey kyber.Point) bool {
+	verifier := createPredicateBit().Verifier(libunlynx.SuiTe, bitPoints(bitCT, pubKey))
+	if err := proof.HashVerify(libunlynx.SuiTe, "rangeProof", verifier, proofBit); err != nil {
+		log.Error("---------Verifier:", err.Error())
+		return false
+	}
+	return true
+}
+
+// combineBits computes sum_i 2^i * bitsCV[i]
+func combineBits(bitsCV libunlynx.CipherVector) libunlynx.CipherText {
+	result := *libunlynx.NewCipherText()
+	for i, v := range bitsCV {
+		tmp := libunlynx.CipherText{}
+		tmp.MulCipherTextbyScalar(v, libunlynx.SuiTe.Scalar().SetInt64(int64(1)<<uint(i)))
+		result.Add(result, tmp)
+	}
+	return result
+}
+
+// bitsRandomness picks the randomness of the bit ciphertexts such that sum_i 2^i * rs[i] = r
+func bitsRandomness(r kyber.Scalar, nbrBits int) []kyber.Scalar {
+	rs := make([]kyber.Scalar, nbrBits)
+	rs[0] = r.Clone()
+	for i := 1; i < nbrBits; i++ {
+		rs[i] = libunlynx.SuiTe.Scalar().Pick(libunlynx.SuiTe.RandomStream())
+		rs[0].Sub(rs[0], libunlynx.SuiTe.Scalar().Mul(rs[i], libunlynx.SuiTe.Scalar().SetInt64(int64(1)<<uint(i))))
+	}
+	return rs
+}
+
+// RangeProofCreation creates a range proof for the ciphertext ct = (rB, rP + vB) of a value v, given the randomness r
+// used to encrypt it (e.g. from EncryptIntGetR)
+func RangeProofCreation(ct libunlynx.CipherText, v int64, r kyber.Scalar, bounds Bounds, pubKey kyber.Point) (PublishedRangeProof, error) {
+	if err := bounds.Validate(); err != nil {
+		return PublishedRangeProof{}, err
+	}
+	if !bounds.Contains(v) {
+		return PublishedRangeProof{}, fmt.Errorf("value %d is not in the range [%d, %d]", v, bounds.Min, bounds.Max)
+	}
+
+	nbrBits := bounds.nbrBits()
+	// v - Min is encrypted with randomness r and Max - v with randomness -r
+	values := []uint64{uint64(v - bounds.Min), uint64(bounds.Max - v)}
+	randomness := [][]kyber.Scalar{bitsRandomness(r, nbrBits), bitsRandomness(libunlynx.SuiTe.Scalar().Neg(r), nbrBits)}
+
+	prp := PublishedRangeProof{Bits: make(libunlynx.CipherVector, 2*nbrBits), Proofs: make([][]byte, 2*nbrBits)}
+	for j := range values {
+		for i := 0; i < nbrBits; i++ {
+			index := j*nbrBits + i
+			bit := int64((values[j] >> uint(i)) & 1)
+			ri := randomness[j][i]
+
+			prp.Bits[index] = libunlynx.CipherText{
+				K: libunlynx.SuiTe.Point().Mul(ri, libunlynx.SuiTe.Point().Base()),
+				C: libunlynx.SuiTe.Point().Add(libunlynx.SuiTe.Point().Mul(ri, pubKey), libunlynx.IntToPoint(bit)),
+			}
+			proofBit, err := BitProofCreation(prp.Bits[index], bit, ri, pubKey)
+			if err != nil {
+				return PublishedRangeProof{}, err
+			}
+			prp.Proofs[index] = proofBit
+		}
+	}
+
+	return prp, nil
+}
+
+// EncryptIntWithRangeProof encrypts a value and creates the range proof of the resulting ciphertext
+func EncryptIntWithRangeProof(pubKey kyber.Point, v int64, bounds Bounds) (*libunlynx.CipherText, PublishedRangeProof, error) {
+	ct, r := libunlynx.EncryptIntGetR(pubKey, v)
+	prp, err := RangeProofCreation(*ct, v, r, bounds, pubKey)
+	if err != nil {
+		return nil, PublishedRangeProof{}, err
+	}
+	return ct, prp, nil
+}
+
+// RangeProofVerification verifies that the ciphertext, encrypted under pubKey, contains a value in the bounds
+func RangeProofVerification(prp PublishedRangeProof, ct libunlynx.CipherText, bounds Bounds, pubKey kyber.Point) bool {
+	if err := bounds.Validate(); err != nil {
+		log.Error(err)
+		return false
+	}
+	nbrBits := bounds.nbrBits()
+	if len(prp.Bits) != 2*nbrBits || len(prp.Proofs) != 2*nbrBits {
+		log.Error("wrong number of bit ciphertexts in range proof")
+		return false
+	}
+
+	// the bits encrypt v - Min and Max - v
+	lower := libunlynx.CipherText{K: ct.K, C: libunlynx.SuiTe.Point().Sub(ct.C, libunlynx.IntToPoint(bounds.Min))}
+	upper := libunlynx.CipherText{K: libunlynx.SuiTe.Point().Neg(ct.K), C: libunlynx.SuiTe.Point().Sub(libunlynx.IntToPoint(bounds.Max), ct.C)}
+	lowerBits, upperBits := combineBits(prp.Bits[:nbrBits]), combineBits(prp.Bits[nbrBits:])
+	if !lowerBits.Equal(&lower) || !upperBits.Equal(&upper) {
+		log.Error("bit ciphertexts do not match the ciphertext")
+		return false
+	}
+
+	for i, bit := range prp.Bits {
+		if !BitProofVerification(prp.Proofs[i], bit, pubKey) {
+			return false
+		}
+	}
+	return true
+}
+
+// RangeProofListVerification verifies a list of range proofs, if one is wrong, returns false
+func RangeProofListVerification(prps []PublishedRangeProof, cts []libunlynx.CipherText, bounds []Bounds, pubKey kyber.Point) bool {
+	if len(prps) != len(cts) || len(prps) != len(bounds) {
+		log.Error("wrong number of range proofs")
+		return false
+	}
+	results := make([]bool, len(prps))
+
+	var wg sync.WaitGroup
+	for i := 0; i < len(prps); i += libunlynx.VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < libunlynx.VPARALLELIZE && (i+j) < len(prps); j++ {
+				results[i+j] = RangeProofVerification(prps[i+j], cts[i+j], bounds[i+j], pubKey)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	finalResult := true
+	for _, v := range results {
+		finalResult = finalResult && v
+	}
+	return finalResult
+}
+
+// Marshal
+//______________________________________________________________________________________________________________________
+
+// ToBytes converts PublishedRangeProof to bytes (the bit ciphertexts followed by the bit proofs, which all have the same
+// length)
+func (prp *PublishedRangeProof) ToBytes() ([]byte, error) {
+	data, _, err := prp.Bits.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range prp.Proofs {
+		data = append(data, v...)
+	}
+	return data, nil
+}
+
+// FromBytes converts back bytes to a PublishedRangeProof for the given bounds
+func (prp *PublishedRangeProof) FromBytes(data []byte, bounds Bounds) error {
+	if err := bounds.Validate(); err != nil {
+		return err
+	}
+	nbrBits := 2 * bounds.nbrBits()
+	bitsLength := nbrBits * libunlynx.CipherTextByteSize()
+	if len(data) <= bitsLength || (len(data)-bitsLength)%nbrBits != 0 {
+		return fmt.Errorf("wrong range proof length: %d bytes", len(data))
+	}
+	if err := prp.Bits.FromBytes(data[:bitsLength], nbrBits); err != nil {
+		return err
+	}
+
+	proofLength := (len(data) - bitsLength) / nbrBits
+	prp.Proofs = make([][]byte, nbrBits)
+	for i := range prp.Proofs {
+		prp.Proofs[i] = data[bitsLength+i*proofLength : bitsLength+(i+1)*proofLength]
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package libunlynxrange_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/range"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeProof(t *testing.T) {
+	secKey, pubKey := libunlynx.GenKey()
+
+	bounds := []libunlynxrange.Bounds{{Min: 0, Max: 1}, {Min: 0, Max: 100}, {Min: -10, Max: 5}, {Min: 7, Max: 7}}
+	values := []int64{1, 100, -10, 7}
+
+	cts := make([]libunlynx.CipherText, len(values))
+	prps := make([]libunlynxrange.PublishedRangeProof, len(values))
+	for i, v := range values {
+		ct, prp, err := libunlynxrange.EncryptIntWithRangeProof(pubKey, v, bounds[i])
+		require.NoError(t, err)
+		assert.Equal(t, v, libunlynx.DecryptIntWithNeg(secKey, *ct))
+		assert.True(t, libunlynxrange.RangeProofVerification(prp, *ct, bounds[i], pubKey))
+		cts[i], prps[i] = *ct, prp
+	}
+	assert.True(t, libunlynxrange.RangeProofListVerification(prps, cts, bounds, pubKey))
+
+	// values out of range cannot be proven
+	_, _, err := libunlynxrange.EncryptIntWithRangeProof(pubKey, 1000000, bounds[0])
+	assert.Error(t, err)
+	_, _, err = libunlynxrange.EncryptIntWithRangeProof(pubKey, 0, libunlynxrange.Bounds{Min: 1, Max: 0})
+	assert.Error(t, err)
+
+	// a proof does not verify for another ciphertext, other bounds or another key
+	assert.False(t, libunlynxrange.RangeProofVerification(prps[1], *libunlynx.EncryptInt(pubKey, 1000000), bounds[1], pubKey))
+	assert.False(t, libunlynxrange.RangeProofVerification(prps[1], cts[1], libunlynxrange.Bounds{Min: 0, Max: 99}, pubKey))
+	_, otherKey := libunlynx.GenKey()
+	assert.False(t, libunlynxrange.RangeProofVerification(prps[1], cts[1], bounds[1], otherKey))
+	assert.False(t, libunlynxrange.RangeProofListVerification(prps, []libunlynx.CipherText{cts[1], cts[0], cts[2], cts[3]}, bounds, pubKey))
+
+	// forged bits (e.g. 2 instead of 0/1) are refused
+	forged := libunlynxrange.PublishedRangeProof{Bits: append(libunlynx.CipherVector{}, prps[0].Bits...), Proofs: prps[0].Proofs}
+	forged.Bits[0] = *libunlynx.EncryptInt(pubKey, 2)
+	assert.False(t, libunlynxrange.RangeProofVerification(forged, cts[0], bounds[0], pubKey))
+}
+
+func TestRangeProofBytes(t *testing.T) {
+	_, pubKey := libunlynx.GenKey()
+	bounds := libunlynxrange.Bounds{Min: 0, Max: 20}
+
+	ct, prp, err := libunlynxrange.EncryptIntWithRangeProof(pubKey, 12, bounds)
+	require.NoError(t, err)
+
+	data, err := prp.ToBytes()
+	require.NoError(t, err)
+
+	newPrp := libunlynxrange.PublishedRangeProof{}
+	require.NoError(t, newPrp.FromBytes(data, bounds))
+	assert.True(t, libunlynxrange.RangeProofVerification(newPrp, *ct, bounds, pubKey))
+
+	assert.Error(t, newPrp.FromBytes(data[:10], bounds))
+}
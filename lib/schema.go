@@ -0,0 +1,537 @@
+package libunlynx
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// CountAttribute is the name of the aggregating attribute added to each response of a count query. It is reserved and
+// cannot be declared in a schema.
+const CountAttribute = "count"
+
+// AttributeNamePattern is the pattern of valid attribute names (e.g. age, blood_pressure)
+const AttributeNamePattern = "[A-Za-z_][A-Za-z0-9_]*"
+
+var attributeNameRegex = regexp.MustCompile("^" + AttributeNamePattern + "$")
+
+// AttributeRole defines how an attribute is used in a survey
+type AttributeRole string
+
+const (
+	// RoleWhere is the role of the attributes used to filter the responses
+	RoleWhere AttributeRole = "where"
+	// RoleGroupBy is the role of the attributes used to group the responses
+	RoleGroupBy AttributeRole = "groupBy"
+	// RoleAggregate is the role of the attributes summed in each group
+	RoleAggregate AttributeRole = "aggregate"
+)
+
+// Sensitivity defines whether an attribute is sent in clear or encrypted by the data providers
+type Sensitivity string
+
+const (
+	// SensitivityClear is the sensitivity of the attributes sent in clear
+	SensitivityClear Sensitivity = "clear"
+	// SensitivityEncrypted is the sensitivity of the attributes sent encrypted
+	SensitivityEncrypted Sensitivity = "encrypted"
+)
+
+// Encoding defines how the value of an attribute is encoded as an integer
+type Encoding string
+
+const (
+	// EncodingInteger is the default encoding: the value is an integer
+	EncodingInteger Encoding = "integer"
+	// EncodingBoolean encodes false as 0 and true as 1
+	EncodingBoolean Encoding = "boolean"
+	// EncodingFixedPoint encodes a decimal value with a fixed number of decimal digits (see EncodeFixedPoint)
+	EncodingFixedPoint Encoding = "fixedPoint"
+)
+
+// Domain contains the bounds of the encoded values of an attribute
+type Domain struct {
+	Min int64
+	Max int64
+}
+
+// Attribute describes an attribute of the data providers' responses
+type Attribute struct {
+	Name        string
+	Role        AttributeRole
+	Sensitivity Sensitivity
+	Encoding    Encoding // EncodingInteger if empty
+	Decimals    int64    // number of decimal digits of the fixed-point encoding
+	Domain      *Domain  // any value if nil
+	// Ranges makes the data providers send the prefix tags of the value (see Domain.PrefixTags) along with it, so that
+	// the predicates of the surveys can test whether it is in a range (encrypted where attributes with a domain only).
+	// The tags are deterministically tagged like the other where attributes: the servers learn which responses share
+	// the tag of each level, hence the length of the common prefix of any two values, which nearly reveals their order.
+	// Only enable it for attributes whose order may be disclosed to the servers.
+	Ranges bool
+	// Histogram makes the data providers send the one-hot encoding of the value (see Domain.Histogram) along with it,
+	// so that the sum of the histogram bins gives the distribution of the values, from which the minimum, maximum and
+	// quantiles are derived (aggregating attributes with a domain only). The surveys summing the bins must sum all of
+	// them with the ranges [0, 1] (see libunlynxstatistics.HistogramRanges): the data providers then prove that their
+	// encrypted bins are the one-hot encoding of a value, otherwise a single one could put any count in any bin and move
+	// the minimum, maximum and quantiles freely.
+	Histogram bool
+	// Moments makes the data providers send the products of the value with the ones of the other attributes with
+	// moments, and its square (see Moments), so that the means, variances, covariances and linear regressions of these
+	// attributes can be computed from the aggregated results (aggregating attributes only). A product is encrypted if
+	// one of its attributes is.
+	Moments bool
+}
+
+// Schema declares the attributes of the data providers' responses
+type Schema struct {
+	Attributes []Attribute
+}
+
+// responseAttribute is an attribute found in a response and where it was found
+type responseAttribute struct {
+	role        AttributeRole
+	sensitivity Sensitivity
+	value       *int64 // nil if encrypted
+}
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// Validate checks that the attribute is well defined
+func (a *Attribute) Validate() error {
+	if !attributeNameRegex.MatchString(a.Name) {
+		return fmt.Errorf("invalid attribute name: %q", a.Name)
+	}
+	if a.Name == CountAttribute {
+		return fmt.Errorf("attribute name %s is reserved", CountAttribute)
+	}
+	switch a.Role {
+	case RoleWhere, RoleGroupBy, RoleAggregate:
+	default:
+		return fmt.Errorf("attribute %s: unknown role %q", a.Name, a.Role)
+	}
+	switch a.Sensitivity {
+	case SensitivityClear, SensitivityEncrypted:
+	default:
+		return fmt.Errorf("attribute %s: unknown sensitivity %q", a.Name, a.Sensitivity)
+	}
+	switch a.Encoding {
+	case "", EncodingInteger, EncodingBoolean:
+		if a.Decimals != 0 {
+			return fmt.Errorf("attribute %s: decimals are only allowed with the %s encoding", a.Name, EncodingFixedPoint)
+		}
+	case EncodingFixedPoint:
+		if err := (FixedPointScales{a.Name: a.Decimals}).Validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("attribute %s: unknown encoding %q", a.Name, a.Encoding)
+	}
+	if a.Domain != nil && a.Domain.Min > a.Domain.Max {
+		return fmt.Errorf("attribute %s: empty domain [%d, %d]", a.Name, a.Domain.Min, a.Domain.Max)
+	}
+	if a.Ranges {
+		if a.Role != RoleWhere || a.Sensitivity != SensitivityEncrypted || a.Domain == nil {
+			return fmt.Errorf("attribute %s: ranges are only allowed for encrypted where attributes with a domain", a.Name)
+		}
+		if err := a.Domain.checkPrefix(); err != nil {
+			return fmt.Errorf("attribute %s: %v", a.Name, err)
+		}
+	}
+	if a.Histogram {
+		if a.Role != RoleAggregate || a.Domain == nil {
+			return fmt.Errorf("attribute %s: histograms are only allowed for aggregating attributes with a domain", a.Name)
+		}
+		if err := a.Domain.checkHistogram(); err != nil {
+			return fmt.Errorf("attribute %s: %v", a.Name, err)
+		}
+	}
+	if a.Moments && a.Role != RoleAggregate {
+		return fmt.Errorf("attribute %s: moments are only allowed for aggregating attributes", a.Name)
+	}
+	return nil
+}
+
+// Check verifies that an encoded value is in the domain of the attribute
+func (a *Attribute) Check(value int64) error {
+	if a.Encoding == EncodingBoolean && value != 0 && value != 1 {
+		return fmt.Errorf("attribute %s: %d is not a boolean", a.Name, value)
+	}
+	if a.Domain != nil && (value < a.Domain.Min || value > a.Domain.Max) {
+		return fmt.Errorf("attribute %s: %d is not in the domain [%d, %d]", a.Name, value, a.Domain.Min, a.Domain.Max)
+	}
+	return nil
+}
+
+// Validate checks that all the attributes are well defined and have different names
+func (s *Schema) Validate() error {
+	names := make(map[string]bool, len(s.Attributes))
+	for i := range s.Attributes {
+		if err := s.Attributes[i].Validate(); err != nil {
+			return err
+		}
+		if names[s.Attributes[i].Name] {
+			return fmt.Errorf("duplicate attribute %s", s.Attributes[i].Name)
+		}
+		names[s.Attributes[i].Name] = true
+	}
+	return nil
+}
+
+// Attribute returns the attribute with the given name, nil if it is not in the schema
+func (s *Schema) Attribute(name string) *Attribute {
+	for i := range s.Attributes {
+		if s.Attributes[i].Name == name {
+			return &s.Attributes[i]
+		}
+	}
+	return nil
+}
+
+// prefixTag returns the attribute with ranges and the level of a prefix tag name, nil if the name is not the one of a
+// prefix tag of the schema
+func (s *Schema) prefixTag(tag string) (*Attribute, int) {
+	name, level, ok := SplitPrefixTagName(tag)
+	if !ok {
+		return nil, 0
+	}
+	a := s.Attribute(name)
+	if a == nil || !a.Ranges || level > a.Domain.PrefixBits() {
+		return nil, 0
+	}
+	return a, level
+}
+
+// histogramBin returns the attribute with a histogram and the value of a histogram bin name, nil if the name is not the
+// one of a histogram bin of the schema
+func (s *Schema) histogramBin(bin string) (*Attribute, int64) {
+	name, value, ok := SplitHistogramBinName(bin)
+	if !ok {
+		return nil, 0
+	}
+	a := s.Attribute(name)
+	if a == nil || !a.Histogram || value < a.Domain.Min || value > a.Domain.Max {
+		return nil, 0
+	}
+	return a, value
+}
+
+// moment returns the attributes with moments multiplied in a moment name, nil if the name is not the one of a moment of
+// the schema
+func (s *Schema) moment(moment string) (*Attribute, *Attribute) {
+	a, b, ok := SplitMomentName(moment)
+	if !ok {
+		return nil, nil
+	}
+	attrA, attrB := s.Attribute(a), s.Attribute(b)
+	if attrA == nil || attrB == nil || !attrA.Moments || !attrB.Moments {
+		return nil, nil
+	}
+	return attrA, attrB
+}
+
+// momentSensitivity returns the sensitivity of the product of two attributes: it is only sent in clear if both are
+func momentSensitivity(a, b *Attribute) Sensitivity {
+	if a.Sensitivity == SensitivityClear && b.Sensitivity == SensitivityClear {
+		return SensitivityClear
+	}
+	return SensitivityEncrypted
+}
+
+// Names returns the names of the attributes with the given role and sensitivity, in their declaration order
+func (s *Schema) Names(role AttributeRole, sensitivity Sensitivity) []string {
+	names := make([]string, 0)
+	for _, a := range s.Attributes {
+		if a.Role == role && a.Sensitivity == sensitivity {
+			names = append(names, a.Name)
+		}
+	}
+	return names
+}
+
+// FixedPoint returns the scales of the sum attributes with a fixed-point encoding
+func (s *Schema) FixedPoint(sum []string) FixedPointScales {
+	scales := FixedPointScales{}
+	for _, name := range sum {
+		if a := s.Attribute(name); a != nil && a.Encoding == EncodingFixedPoint {
+			scales[name] = a.Decimals
+		}
+	}
+	return scales
+}
+
+// ValidateQuery checks that the attributes of a query exist and are used according to their role. The count attribute
+// can only be summed in a count query and fixed-point scales must be the ones of the schema. The where attributes can
+// be the prefix tags of the attributes with ranges and the sum attributes the histogram bins and moments of the
+// attributes with a histogram or moments.
+func (s *Schema) ValidateQuery(sum []string, count bool, where []WhereQueryAttribute, groupBy []string, fixedPoint FixedPointScales) error {
+	check := func(name string, role AttributeRole) error {
+		a := s.Attribute(name)
+		if a == nil {
+			return fmt.Errorf("attribute %s is not in the schema", name)
+		}
+		if a.Role != role {
+			return fmt.Errorf("attribute %s has role %s and cannot be used as a %s attribute", name, a.Role, role)
+		}
+		return nil
+	}
+
+	for _, name := range sum {
+		if name == CountAttribute && count {
+			continue
+		}
+		if a, _ := s.histogramBin(name); a != nil {
+			continue
+		}
+		if a, _ := s.moment(name); a != nil {
+			continue
+		}
+		if err := check(name, RoleAggregate); err != nil {
+			return err
+		}
+	}
+	for _, w := range where {
+		if a, _ := s.prefixTag(w.Name); a != nil {
+			continue
+		}
+		if err := check(w.Name, RoleWhere); err != nil {
+			return err
+		}
+	}
+	for _, name := range groupBy {
+		if err := check(name, RoleGroupBy); err != nil {
+			return err
+		}
+	}
+	for name, decimals := range fixedPoint {
+		if a := s.Attribute(name); a == nil || a.Encoding != EncodingFixedPoint || a.Decimals != decimals {
+			return fmt.Errorf("attribute %s is not declared as a fixed-point attribute with %d decimals", name, decimals)
+		}
+	}
+	return nil
+}
+
+// NewDpClearResponse puts the values of the attributes of a response in the maps of a DpClearResponse, according to
+// their role and sensitivity, and adds the prefix tags of the attributes with ranges, the histogram bins of the
+// attributes with a histogram and the moments of the attributes with moments
+func (s *Schema) NewDpClearResponse(values map[string]int64) (DpClearResponse, error) {
+	dcr := DpClearResponse{
+		WhereClear:                 make(map[string]int64),
+		WhereEnc:                   make(map[string]int64),
+		GroupByClear:               make(map[string]int64),
+		GroupByEnc:                 make(map[string]int64),
+		AggregatingAttributesClear: make(map[string]int64),
+		AggregatingAttributesEnc:   make(map[string]int64),
+	}
+	moments := make(map[string]int64)
+	for name, v := range values {
+		a := s.Attribute(name)
+		if a == nil {
+			return DpClearResponse{}, fmt.Errorf("attribute %s is not in the schema", name)
+		}
+		if a.Moments {
+			moments[name] = v
+		}
+		dcr.attributes(a.Role, a.Sensitivity)[name] = v
+		if a.Ranges && a.Check(v) == nil {
+			for tag, tv := range a.Domain.PrefixTags(name, v) {
+				dcr.WhereEnc[tag] = tv
+			}
+		}
+		if a.Histogram && a.Check(v) == nil {
+			for bin, bv := range a.Domain.Histogram(name, v) {
+				dcr.attributes(a.Role, a.Sensitivity)[bin] = bv
+			}
+		}
+	}
+	products, err := Moments(moments)
+	if err != nil {
+		return DpClearResponse{}, err
+	}
+	for moment, v := range products {
+		a, b := s.moment(moment)
+		dcr.attributes(RoleAggregate, momentSensitivity(a, b))[moment] = v
+	}
+	return dcr, s.ValidateDpClearResponse(dcr)
+}
+
+// ValidateDpClearResponse checks that a response contains all the attributes of the schema, each one in the map of
+// its role and sensitivity and with a value in its domain, and the prefix tags, histogram bins and moments of the
+// values of the attributes with ranges, a histogram or moments
+func (s *Schema) ValidateDpClearResponse(dcr DpClearResponse) error {
+	attrs := make(map[string]responseAttribute)
+	for _, role := range []AttributeRole{RoleWhere, RoleGroupBy, RoleAggregate} {
+		for _, sensitivity := range []Sensitivity{SensitivityClear, SensitivityEncrypted} {
+			for name, v := range dcr.attributes(role, sensitivity) {
+				value := v
+				if err := addResponseAttribute(attrs, name, responseAttribute{role: role, sensitivity: sensitivity, value: &value}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return s.checkResponseAttributes(attrs)
+}
+
+// ValidateDpResponse checks that an encrypted response contains all the attributes of the schema (and possibly the
+// count attribute) and the prefix tags, histogram bins and moments of the attributes with ranges, a histogram or
+// moments, each one in the map of its role and sensitivity
+// and, for the ones in clear, with a value in its domain
+func (s *Schema) ValidateDpResponse(dr DpResponse) error {
+	attrs := make(map[string]responseAttribute)
+	clear := map[AttributeRole]map[string]int64{RoleWhere: dr.WhereClear, RoleGroupBy: dr.GroupByClear, RoleAggregate: dr.AggregatingAttributesClear}
+	encrypted := map[AttributeRole]map[string]CipherText{RoleWhere: dr.WhereEnc, RoleGroupBy: dr.GroupByEnc, RoleAggregate: dr.AggregatingAttributesEnc}
+	for _, role := range []AttributeRole{RoleWhere, RoleGroupBy, RoleAggregate} {
+		for name, v := range clear[role] {
+			value := v
+			if err := addResponseAttribute(attrs, name, responseAttribute{role: role, sensitivity: SensitivityClear, value: &value}); err != nil {
+				return err
+			}
+		}
+		for name := range encrypted[role] {
+			if name == CountAttribute && role == RoleAggregate {
+				continue
+			}
+			if err := addResponseAttribute(attrs, name, responseAttribute{role: role, sensitivity: SensitivityEncrypted}); err != nil {
+				return err
+			}
+		}
+	}
+	return s.checkResponseAttributes(attrs)
+}
+
+func addResponseAttribute(attrs map[string]responseAttribute, name string, ra responseAttribute) error {
+	if _, ok := attrs[name]; ok {
+		return fmt.Errorf("attribute %s appears more than once in the response", name)
+	}
+	attrs[name] = ra
+	return nil
+}
+
+func (s *Schema) checkResponseAttributes(attrs map[string]responseAttribute) error {
+	for name, ra := range attrs {
+		if a, level := s.prefixTag(name); a != nil {
+			if err := checkPrefixTag(a, level, ra, attrs[a.Name]); err != nil {
+				return err
+			}
+			continue
+		}
+		if a, value := s.histogramBin(name); a != nil {
+			if err := checkHistogramBin(a, value, ra, attrs[a.Name]); err != nil {
+				return err
+			}
+			continue
+		}
+		if a, b := s.moment(name); a != nil {
+			if err := checkMoment(a, b, ra, attrs[a.Name], attrs[b.Name]); err != nil {
+				return err
+			}
+			continue
+		}
+		a := s.Attribute(name)
+		if a == nil {
+			return fmt.Errorf("attribute %s is not in the schema", name)
+		}
+		if a.Role != ra.role || a.Sensitivity != ra.sensitivity {
+			return fmt.Errorf("attribute %s is a %s %s attribute but was sent as a %s %s one", name, a.Sensitivity, a.Role, ra.sensitivity, ra.role)
+		}
+		if ra.value != nil {
+			if err := a.Check(*ra.value); err != nil {
+				return err
+			}
+		}
+	}
+	for _, a := range s.Attributes {
+		if _, ok := attrs[a.Name]; !ok {
+			return fmt.Errorf("attribute %s is missing from the response", a.Name)
+		}
+		if a.Ranges {
+			for level := 0; level <= a.Domain.PrefixBits(); level++ {
+				if _, ok := attrs[PrefixTagName(a.Name, level)]; !ok {
+					return fmt.Errorf("prefix tag %s is missing from the response", PrefixTagName(a.Name, level))
+				}
+			}
+		}
+		if a.Histogram {
+			for _, bin := range a.Domain.HistogramBins(a.Name) {
+				if _, ok := attrs[bin]; !ok {
+					return fmt.Errorf("histogram bin %s is missing from the response", bin)
+				}
+			}
+		}
+		if a.Moments {
+			for _, b := range s.Attributes {
+				if _, ok := attrs[MomentName(a.Name, b.Name)]; b.Moments && !ok {
+					return fmt.Errorf("moment %s is missing from the response", MomentName(a.Name, b.Name))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkPrefixTag verifies that a prefix tag is sent as an encrypted where attribute and, if it is in clear, that it is
+// the one of the value of its attribute
+func checkPrefixTag(a *Attribute, level int, tag, value responseAttribute) error {
+	name := PrefixTagName(a.Name, level)
+	if tag.role != RoleWhere || tag.sensitivity != SensitivityEncrypted {
+		return fmt.Errorf("prefix tag %s was sent as a %s %s attribute", name, tag.sensitivity, tag.role)
+	}
+	if tag.value != nil && value.value != nil && a.Check(*value.value) == nil &&
+		*tag.value != (*value.value-a.Domain.Min)>>uint(level) {
+		return fmt.Errorf("prefix tag %s does not match the value of %s", name, a.Name)
+	}
+	return nil
+}
+
+// attributes returns the map of the response containing the attributes with the given role and sensitivity
+func (dcr *DpClearResponse) attributes(role AttributeRole, sensitivity Sensitivity) map[string]int64 {
+	clear := sensitivity == SensitivityClear
+	switch {
+	case role == RoleWhere && clear:
+		return dcr.WhereClear
+	case role == RoleWhere:
+		return dcr.WhereEnc
+	case role == RoleGroupBy && clear:
+		return dcr.GroupByClear
+	case role == RoleGroupBy:
+		return dcr.GroupByEnc
+	case clear:
+		return dcr.AggregatingAttributesClear
+	default:
+		return dcr.AggregatingAttributesEnc
+	}
+}
+
+// checkHistogramBin verifies that a histogram bin is sent with the role and sensitivity of its attribute and, if it is
+// in clear, that it is the one-hot encoding of the value of its attribute (an encrypted bin cannot be checked, see
+// Attribute.Histogram)
+func checkHistogramBin(a *Attribute, value int64, bin, attr responseAttribute) error {
+	name := HistogramBinName(a.Name, value)
+	if bin.role != a.Role || bin.sensitivity != a.Sensitivity {
+		return fmt.Errorf("histogram bin %s was sent as a %s %s attribute", name, bin.sensitivity, bin.role)
+	}
+	if bin.value != nil && attr.value != nil && a.Check(*attr.value) == nil {
+		expected := int64(0)
+		if *attr.value == value {
+			expected = 1
+		}
+		if *bin.value != expected {
+			return fmt.Errorf("histogram bin %s does not match the value of %s", name, a.Name)
+		}
+	}
+	return nil
+}
+
+// checkMoment verifies that a moment is sent as an aggregating attribute with the sensitivity of its attributes and,
+// if it is in clear, that it is the product of their values
+func checkMoment(a, b *Attribute, moment, attrA, attrB responseAttribute) error {
+	name := MomentName(a.Name, b.Name)
+	if moment.role != RoleAggregate || moment.sensitivity != momentSensitivity(a, b) {
+		return fmt.Errorf("moment %s was sent as a %s %s attribute", name, moment.sensitivity, moment.role)
+	}
+	if moment.value != nil && attrA.value != nil && attrB.value != nil && *moment.value != *attrA.value**attrB.value {
+		return fmt.Errorf("moment %s does not match the values of %s and %s", name, a.Name, b.Name)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package libunlynx_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSchema() libunlynx.Schema {
+	return libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "smoker", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityEncrypted, Encoding: libunlynx.EncodingBoolean},
+		{Name: "hospital", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityClear},
+		{Name: "age_range", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 0, Max: 9}},
+		{Name: "weight", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Encoding: libunlynx.EncodingFixedPoint, Decimals: 1},
+		{Name: "visits", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityClear},
+	}}
+}
+
+func TestSchema(t *testing.T) {
+	schema := testSchema()
+	assert.NoError(t, schema.Validate())
+
+	assert.Equal(t, []string{"age_range"}, schema.Names(libunlynx.RoleGroupBy, libunlynx.SensitivityEncrypted))
+	assert.Equal(t, libunlynx.FixedPointScales{"weight": 1}, schema.FixedPoint([]string{"weight", "visits"}))
+
+	// invalid schemas
+	invalid := []libunlynx.Attribute{
+		{Name: "s-1", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityClear},
+		{Name: libunlynx.CountAttribute, Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityClear},
+		{Name: "a", Role: "select", Sensitivity: libunlynx.SensitivityClear},
+		{Name: "a", Role: libunlynx.RoleWhere, Sensitivity: "hidden"},
+		{Name: "a", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityClear, Decimals: 2},
+		{Name: "a", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityClear, Domain: &libunlynx.Domain{Min: 1, Max: 0}},
+	}
+	for _, a := range invalid {
+		assert.Error(t, (&libunlynx.Schema{Attributes: []libunlynx.Attribute{a}}).Validate(), a.Name)
+	}
+	assert.Error(t, (&libunlynx.Schema{Attributes: append(schema.Attributes, schema.Attributes[0])}).Validate())
+
+	// queries
+	where := []libunlynx.WhereQueryAttribute{{Name: "smoker"}}
+	assert.NoError(t, schema.ValidateQuery([]string{"weight", "count"}, true, where, []string{"hospital"}, libunlynx.FixedPointScales{"weight": 1}))
+	assert.Error(t, schema.ValidateQuery([]string{"weight", "count"}, false, where, []string{"hospital"}, nil))
+	assert.Error(t, schema.ValidateQuery([]string{"hospital"}, false, where, nil, nil))
+	assert.Error(t, schema.ValidateQuery([]string{"weight"}, false, []libunlynx.WhereQueryAttribute{{Name: "s0"}}, nil, nil))
+	assert.Error(t, schema.ValidateQuery([]string{"visits"}, false, nil, nil, libunlynx.FixedPointScales{"visits": 1}))
+
+	// responses
+	dcr, err := schema.NewDpClearResponse(map[string]int64{"smoker": 1, "hospital": 3, "age_range": 4, "weight": 725, "visits": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"smoker": 1}, dcr.WhereEnc)
+	assert.Equal(t, map[string]int64{"hospital": 3}, dcr.GroupByClear)
+	assert.Equal(t, map[string]int64{"age_range": 4}, dcr.GroupByEnc)
+	assert.Equal(t, map[string]int64{"weight": 725}, dcr.AggregatingAttributesEnc)
+	assert.Equal(t, map[string]int64{"visits": 2}, dcr.AggregatingAttributesClear)
+
+	_, err = schema.NewDpClearResponse(map[string]int64{"smoker": 2, "hospital": 3, "age_range": 4, "weight": 725, "visits": 2})
+	assert.Error(t, err)
+	_, err = schema.NewDpClearResponse(map[string]int64{"smoker": 1, "hospital": 3, "age_range": 10, "weight": 725, "visits": 2})
+	assert.Error(t, err)
+	_, err = schema.NewDpClearResponse(map[string]int64{"smoker": 1, "hospital": 3, "age_range": 4, "weight": 725})
+	assert.Error(t, err)
+	_, err = schema.NewDpClearResponse(map[string]int64{"smoker": 1, "hospital": 3, "age_range": 4, "weight": 725, "visits": 2, "s0": 1})
+	assert.Error(t, err)
+
+	// an attribute in the wrong map
+	dcr.GroupByEnc, dcr.GroupByClear = map[string]int64{"hospital": 3, "age_range": 4}, map[string]int64{}
+	assert.Error(t, schema.ValidateDpClearResponse(dcr))
+
+	// encrypted responses
+	dr := libunlynx.DpResponse{
+		WhereEnc:                   map[string]libunlynx.CipherText{"smoker": libunlynx.IntToCipherText(1)},
+		GroupByClear:               map[string]int64{"hospital": 3},
+		GroupByEnc:                 map[string]libunlynx.CipherText{"age_range": libunlynx.IntToCipherText(4)},
+		AggregatingAttributesClear: map[string]int64{"visits": 2},
+		AggregatingAttributesEnc:   map[string]libunlynx.CipherText{"weight": libunlynx.IntToCipherText(725), "count": libunlynx.IntToCipherText(1)},
+	}
+	assert.NoError(t, schema.ValidateDpResponse(dr))
+	dr.GroupByClear["age_range"] = 4
+	assert.Error(t, schema.ValidateDpResponse(dr))
+}
+
+func TestSchemaRanges(t *testing.T) {
+	age := libunlynx.Attribute{Name: "age", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 18, Max: 25}, Ranges: true}
+	schema := libunlynx.Schema{Attributes: []libunlynx.Attribute{age,
+		{Name: "visits", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted}}}
+	assert.NoError(t, schema.Validate())
+
+	// ranges need an encrypted where attribute with a domain
+	for _, a := range []libunlynx.Attribute{
+		{Name: "a", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityEncrypted, Ranges: true},
+		{Name: "a", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityClear, Domain: &libunlynx.Domain{Min: 0, Max: 9}, Ranges: true},
+		{Name: "a", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 0, Max: 9}, Ranges: true},
+		{Name: "a", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: -1 << 62, Max: 1 << 62}, Ranges: true},
+	} {
+		assert.Error(t, (&libunlynx.Schema{Attributes: []libunlynx.Attribute{a}}).Validate())
+	}
+
+	assert.NoError(t, schema.ValidateQuery([]string{"visits"}, false, []libunlynx.WhereQueryAttribute{{Name: "age"}, {Name: "age/0"}, {Name: "age/3"}}, nil, nil))
+	assert.Error(t, schema.ValidateQuery([]string{"visits"}, false, []libunlynx.WhereQueryAttribute{{Name: "age/4"}}, nil, nil))
+	assert.Error(t, schema.ValidateQuery([]string{"visits"}, false, []libunlynx.WhereQueryAttribute{{Name: "visits/0"}}, nil, nil))
+
+	dcr, err := schema.NewDpClearResponse(map[string]int64{"age": 23, "visits": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"age": 23, "age/0": 5, "age/1": 2, "age/2": 1, "age/3": 0}, dcr.WhereEnc)
+
+	// the tags must be consistent with the value and all present
+	dcr.WhereEnc["age/1"] = 3
+	assert.Error(t, schema.ValidateDpClearResponse(dcr))
+	delete(dcr.WhereEnc, "age/1")
+	assert.Error(t, schema.ValidateDpClearResponse(dcr))
+
+	dr := libunlynx.DpResponse{
+		WhereEnc:                 map[string]libunlynx.CipherText{"age": libunlynx.IntToCipherText(23)},
+		AggregatingAttributesEnc: map[string]libunlynx.CipherText{"visits": libunlynx.IntToCipherText(2)},
+	}
+	assert.Error(t, schema.ValidateDpResponse(dr))
+	for level := 0; level <= 3; level++ {
+		dr.WhereEnc[libunlynx.PrefixTagName("age", level)] = libunlynx.IntToCipherText(0)
+	}
+	assert.NoError(t, schema.ValidateDpResponse(dr))
+}
+
+func TestSchemaHistogram(t *testing.T) {
+	schema := libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "age", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 1, Max: 3}, Histogram: true},
+		{Name: "visits", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityClear}}}
+	assert.NoError(t, schema.Validate())
+
+	// histograms need an aggregating attribute with a small domain
+	for _, a := range []libunlynx.Attribute{
+		{Name: "a", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Histogram: true},
+		{Name: "a", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 0, Max: 9}, Histogram: true},
+		{Name: "a", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 0, Max: libunlynx.MaxHistogramBins}, Histogram: true},
+	} {
+		assert.Error(t, (&libunlynx.Schema{Attributes: []libunlynx.Attribute{a}}).Validate())
+	}
+
+	assert.NoError(t, schema.ValidateQuery([]string{"age#1", "age#3", "visits"}, false, nil, nil, nil))
+	assert.Error(t, schema.ValidateQuery([]string{"age#4"}, false, nil, nil, nil))
+	assert.Error(t, schema.ValidateQuery([]string{"visits#1"}, false, nil, nil, nil))
+
+	dcr, err := schema.NewDpClearResponse(map[string]int64{"age": 2, "visits": 5})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"age": 2, "age#1": 0, "age#2": 1, "age#3": 0}, dcr.AggregatingAttributesEnc)
+
+	// the bins must be the one-hot encoding of the value and all present
+	dcr.AggregatingAttributesEnc["age#3"] = 1
+	assert.Error(t, schema.ValidateDpClearResponse(dcr))
+	delete(dcr.AggregatingAttributesEnc, "age#3")
+	assert.Error(t, schema.ValidateDpClearResponse(dcr))
+	dcr.AggregatingAttributesClear["age#3"] = 0
+	assert.Error(t, schema.ValidateDpClearResponse(dcr))
+}
+
+func TestSchemaMoments(t *testing.T) {
+	schema := libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "height", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityClear, Moments: true},
+		{Name: "weight", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Moments: true},
+		{Name: "visits", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityClear}}}
+	assert.NoError(t, schema.Validate())
+	assert.Error(t, (&libunlynx.Schema{Attributes: []libunlynx.Attribute{{Name: "a", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityClear, Moments: true}}}).Validate())
+
+	assert.NoError(t, schema.ValidateQuery([]string{"height", "height*height", "height*weight", "weight*weight"}, false, nil, nil, nil))
+	assert.Error(t, schema.ValidateQuery([]string{"weight*height"}, false, nil, nil, nil))
+	assert.Error(t, schema.ValidateQuery([]string{"height*visits"}, false, nil, nil, nil))
+
+	dcr, err := schema.NewDpClearResponse(map[string]int64{"height": 17, "weight": 6, "visits": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"height": 17, "height*height": 289, "visits": 2}, dcr.AggregatingAttributesClear)
+	assert.Equal(t, map[string]int64{"weight": 6, "height*weight": 102, "weight*weight": 36}, dcr.AggregatingAttributesEnc)
+
+	// the moments must be the products of the values and all present
+	dcr.AggregatingAttributesClear["height*height"] = 288
+	assert.Error(t, schema.ValidateDpClearResponse(dcr))
+	delete(dcr.AggregatingAttributesClear, "height*height")
+	assert.Error(t, schema.ValidateDpClearResponse(dcr))
+	dcr.AggregatingAttributesEnc["height*height"] = 289
+	assert.Error(t, schema.ValidateDpClearResponse(dcr))
+}
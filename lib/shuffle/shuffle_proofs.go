@@ -450,6 +450,7 @@ func (psp *PublishedShufflingProof) ToBytes() (PublishedShufflingProofBytes, err
 			mutex.Unlock()
 			return
 		}
+		dataG = libunlynx.AddSuitePrefix(dataG)
 		pspb.G = &dataG
 
 		dataH, tmpErr := libunlynx.AbstractPointsToBytes([]kyber.Point{H})
@@ -459,6 +460,7 @@ func (psp *PublishedShufflingProof) ToBytes() (PublishedShufflingProofBytes, err
 			mutex.Unlock()
 			return
 		}
+		dataH = libunlynx.AddSuitePrefix(dataH)
 		pspb.H = &dataH
 
 		pspb.HashProof = psp.HashProof
@@ -485,13 +487,21 @@ func (psp *PublishedShufflingProof) FromBytes(pspb PublishedShufflingProofBytes)
 		return err
 	}
 
-	g, err := libunlynx.FromBytesToAbstractPoints(*pspb.G)
+	dataG, err := libunlynx.RemoveSuitePrefix(*pspb.G)
+	if err != nil {
+		return err
+	}
+	g, err := libunlynx.FromBytesToAbstractPoints(dataG)
 	if err != nil {
 		return err
 	}
 	psp.G = g[0]
 
-	h, err := libunlynx.FromBytesToAbstractPoints(*pspb.H)
+	dataH, err := libunlynx.RemoveSuitePrefix(*pspb.H)
+	if err != nil {
+		return err
+	}
+	h, err := libunlynx.FromBytesToAbstractPoints(dataH)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,231 @@
+package libunlynxstatistics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/range"
+)
+
+// HistogramFunction is a statistic derived from the distribution of the values of an attribute
+type HistogramFunction string
+
+const (
+	// Min is the smallest value of the responses
+	Min HistogramFunction = "min"
+	// Max is the largest value of the responses
+	Max HistogramFunction = "max"
+	// Median is the (lower) median of the values of the responses
+	Median HistogramFunction = "median"
+	// Percentile is the smallest value greater than or equal to a percentage of the values of the responses
+	Percentile HistogramFunction = "percentile"
+)
+
+// HistogramAggregate is a statistic of an attribute with a histogram (see libunlynx.Attribute.Histogram). The query
+// sums the histogram bins of the attribute and the statistic is derived from the aggregated histogram of each group
+// once it is decrypted by the querier.
+type HistogramAggregate struct {
+	Function   HistogramFunction
+	Attribute  string
+	Percentile float64 // Percentile only, in [0, 100]
+}
+
+// HistogramResult is the value of a histogram aggregate in a group of the results
+type HistogramResult struct {
+	Aggregate HistogramAggregate
+	Value     int64
+	Empty     bool // no response of the group has a value
+}
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// Validate checks that the function of the aggregate is known and that only percentiles have a percentage
+func (h HistogramAggregate) Validate() error {
+	switch h.Function {
+	case Min, Max, Median:
+		if h.Percentile != 0 {
+			return fmt.Errorf("%s(%s) has no percentage", strings.ToUpper(string(h.Function)), h.Attribute)
+		}
+	case Percentile:
+		if !(h.Percentile >= 0 && h.Percentile <= 100) {
+			return fmt.Errorf("percentage %v of %s is not in [0, 100]", h.Percentile, h.Attribute)
+		}
+	default:
+		return fmt.Errorf("unknown histogram function %q", h.Function)
+	}
+	return nil
+}
+
+// String returns the text of the aggregate (see ParseHistogramAggregates)
+func (h HistogramAggregate) String() string {
+	if h.Function == Percentile {
+		return fmt.Sprintf("PERCENTILE(%s, %s)", h.Attribute, strconv.FormatFloat(h.Percentile, 'f', -1, 64))
+	}
+	return strings.ToUpper(string(h.Function)) + "(" + h.Attribute + ")"
+}
+
+// ParseHistogramAggregates parses a list of histogram aggregates separated by commas, possibly in braces, e.g.
+// "{MIN(age), MEDIAN(age), PERCENTILE(weight, 90)}" (the function names are case insensitive)
+func ParseHistogramAggregates(text string) ([]HistogramAggregate, error) {
+	calls, err := parseCalls(text)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates := make([]HistogramAggregate, len(calls))
+	for i, c := range calls {
+		h := HistogramAggregate{Function: HistogramFunction(c.function), Attribute: c.args[0]}
+		if h.Function == Percentile {
+			if len(c.args) != 2 {
+				return nil, fmt.Errorf("PERCENTILE(%s) needs a percentage", strings.Join(c.args, ", "))
+			}
+			if h.Percentile, err = strconv.ParseFloat(c.args[1], 64); err != nil {
+				return nil, fmt.Errorf("wrong percentage %q", c.args[1])
+			}
+		} else if len(c.args) != 1 {
+			return nil, fmt.Errorf("%s(%s) has one attribute", strings.ToUpper(c.function), strings.Join(c.args, ", "))
+		}
+		if !attributeNameRegex.MatchString(h.Attribute) {
+			return nil, fmt.Errorf("invalid attribute name: %q", h.Attribute)
+		}
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+		aggregates[i] = h
+	}
+	return aggregates, nil
+}
+
+// HistogramSum returns the sum attributes a query needs to compute histogram aggregates: the histogram bins of their
+// attributes, which must have a histogram in the schema
+func HistogramSum(schema *libunlynx.Schema, aggregates []HistogramAggregate) ([]string, error) {
+	sum := make([]string, 0)
+	added := make(map[string]bool)
+	for _, h := range aggregates {
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+		a := schema.Attribute(h.Attribute)
+		if a == nil || !a.Histogram {
+			return nil, fmt.Errorf("attribute %s has no histogram in the schema", h.Attribute)
+		}
+		if !added[h.Attribute] {
+			sum = append(sum, a.Domain.HistogramBins(a.Name)...)
+			added[h.Attribute] = true
+		}
+	}
+	return sum, nil
+}
+
+// HistogramRanges returns the ranges [0, 1] of the histogram bins of the sum attributes (see
+// SurveyCreationQuery.Ranges): the data providers then prove that each of their encrypted bins is 0 or 1, and that the
+// bins of each attribute sum to 1 (see HistogramTotals), i.e. that they count each response in exactly one bin.
+func HistogramRanges(sum []string) map[string]*libunlynxrange.Bounds {
+	ranges := make(map[string]*libunlynxrange.Bounds)
+	for _, name := range sum {
+		if _, _, ok := libunlynx.SplitHistogramBinName(name); ok {
+			ranges[name] = &libunlynxrange.Bounds{Min: 0, Max: 1}
+		}
+	}
+	return ranges
+}
+
+// HistogramTotals returns the histogram bins with a range, sorted and grouped by attribute: the sum of the bins of each
+// attribute must be 1 and the data providers prove it with a range proof of [1, 1] on the homomorphic sum of their
+// encrypted bins (see libunlynx.HistogramTotalName)
+func HistogramTotals(ranges map[string]*libunlynxrange.Bounds) map[string][]string {
+	totals := make(map[string][]string)
+	for name := range ranges {
+		if attribute, _, ok := libunlynx.SplitHistogramBinName(name); ok {
+			totals[attribute] = append(totals[attribute], name)
+		}
+	}
+	for _, bins := range totals {
+		sort.Strings(bins)
+	}
+	return totals
+}
+
+// Evaluate computes the aggregate from the aggregating attributes of a group of the results, given by name (e.g.
+// ServiceResult.Sum) and value. It returns false if no response has a value (the histogram is empty). The value is the
+// encoded value of the attribute (see libunlynx.DecodeFixedPoint). Negative counts (e.g. after the addition of noise)
+// are taken as 0.
+func (h HistogramAggregate) Evaluate(sum []string, values []int64) (int64, bool, error) {
+	if err := h.Validate(); err != nil {
+		return 0, false, err
+	}
+	if len(sum) != len(values) {
+		return 0, false, fmt.Errorf("%d values for %d aggregating attributes", len(values), len(sum))
+	}
+
+	counts := make(map[int64]int64)
+	for i, name := range sum {
+		attribute, v, ok := libunlynx.SplitHistogramBinName(name)
+		if !ok || attribute != h.Attribute {
+			continue
+		}
+		count := values[i]
+		if count < 0 {
+			count = 0
+		}
+		counts[v] += count
+	}
+	if len(counts) == 0 {
+		return 0, false, fmt.Errorf("no histogram bin of %s in the results", h.Attribute)
+	}
+
+	bins := make([]int64, 0, len(counts))
+	total := int64(0)
+	for v, c := range counts {
+		bins = append(bins, v)
+		total += c
+	}
+	if total == 0 {
+		return 0, false, nil
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i] < bins[j] })
+
+	// rank of the value in the sorted values of the responses (nearest-rank method)
+	var rank int64
+	switch h.Function {
+	case Min:
+		rank = 1
+	case Max:
+		rank = total
+	case Median:
+		rank = (total + 1) / 2
+	default:
+		rank = int64(math.Ceil(h.Percentile / 100 * float64(total)))
+		if rank < 1 {
+			rank = 1
+		}
+	}
+
+	cumulative := int64(0)
+	for _, v := range bins {
+		cumulative += counts[v]
+		if cumulative >= rank {
+			return v, true, nil
+		}
+	}
+	return bins[len(bins)-1], true, nil
+}
+
+// EvaluateHistogramAggregates computes the histogram aggregates from the aggregating attributes of a group of the
+// results (see HistogramAggregate.Evaluate)
+func EvaluateHistogramAggregates(aggregates []HistogramAggregate, sum []string, values []int64) ([]HistogramResult, error) {
+	results := make([]HistogramResult, len(aggregates))
+	for i, h := range aggregates {
+		value, ok, err := h.Evaluate(sum, values)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = HistogramResult{Aggregate: h, Value: value, Empty: !ok}
+	}
+	return results, nil
+}
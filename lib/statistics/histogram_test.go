@@ -0,0 +1,68 @@
+package libunlynxstatistics_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/range"
+	"github.com/ldsec/unlynx/lib/statistics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHistogramAggregates(t *testing.T) {
+	aggregates, err := libunlynxstatistics.ParseHistogramAggregates("{MIN(age), max( age ),median(weight), PERCENTILE(age, 90.5)}")
+	require.NoError(t, err)
+	assert.Equal(t, []libunlynxstatistics.HistogramAggregate{
+		{Function: libunlynxstatistics.Min, Attribute: "age"},
+		{Function: libunlynxstatistics.Max, Attribute: "age"},
+		{Function: libunlynxstatistics.Median, Attribute: "weight"},
+		{Function: libunlynxstatistics.Percentile, Attribute: "age", Percentile: 90.5},
+	}, aggregates)
+	assert.Equal(t, "PERCENTILE(age, 90.5)", aggregates[3].String())
+
+	for _, wrong := range []string{"MIN(age) MAX(age)", "MIN(age),", "SUM(age)", "MIN(age, 3)", "PERCENTILE(age)", "PERCENTILE(age, 101)", "MIN(a-ge)"} {
+		_, err := libunlynxstatistics.ParseHistogramAggregates(wrong)
+		assert.Error(t, err, wrong)
+	}
+}
+
+func TestHistogramAggregates(t *testing.T) {
+	schema := &libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "age", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 1, Max: 5}, Histogram: true},
+		{Name: "visits", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted},
+	}}
+	aggregates, err := libunlynxstatistics.ParseHistogramAggregates("MIN(age), MAX(age), MEDIAN(age), PERCENTILE(age, 90), PERCENTILE(age, 0)")
+	require.NoError(t, err)
+
+	sum, err := libunlynxstatistics.HistogramSum(schema, aggregates)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"age#1", "age#2", "age#3", "age#4", "age#5"}, sum)
+	_, err = libunlynxstatistics.HistogramSum(schema, []libunlynxstatistics.HistogramAggregate{{Function: libunlynxstatistics.Min, Attribute: "visits"}})
+	assert.Error(t, err)
+
+	ranges := libunlynxstatistics.HistogramRanges(append([]string{"visits"}, sum...))
+	assert.Len(t, ranges, len(sum))
+	for _, bin := range sum {
+		assert.Equal(t, libunlynxrange.Bounds{Min: 0, Max: 1}, *ranges[bin])
+	}
+	ranges["visits"] = &libunlynxrange.Bounds{Min: 0, Max: 10}
+	assert.Equal(t, map[string][]string{"age": sum}, libunlynxstatistics.HistogramTotals(ranges))
+
+	// values 2, 2, 3, 3, 3, 3, 3, 4, 4, 4 (with noise on the empty bins)
+	sum = append([]string{"visits"}, sum...)
+	values := []int64{12, -1, 2, 5, 3, 0}
+	expected := []int64{2, 4, 3, 4, 2}
+	for i, h := range aggregates {
+		v, ok, err := h.Evaluate(sum, values)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, expected[i], v, h.String())
+	}
+
+	_, ok, err := aggregates[0].Evaluate(sum, []int64{12, 0, 0, 0, 0, -2})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, _, err = aggregates[0].Evaluate(sum[:1], values[:1])
+	assert.Error(t, err)
+}
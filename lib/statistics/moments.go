@@ -0,0 +1,282 @@
+package libunlynxstatistics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ldsec/unlynx/lib"
+)
+
+// StatisticFunction is a statistic computed from the moments (sums of the values, of their squares and of their
+// products) of attributes
+type StatisticFunction string
+
+const (
+	// Mean is the mean of an attribute
+	Mean StatisticFunction = "mean"
+	// Variance is the (sample) variance of an attribute
+	Variance StatisticFunction = "variance"
+	// Covariance is the (sample) covariance of two attributes
+	Covariance StatisticFunction = "covariance"
+	// Regression is the ordinary least squares regression of an attribute on other attributes
+	Regression StatisticFunction = "regression"
+)
+
+// Statistic is a statistic of attributes with moments (see libunlynx.Attribute.Moments). The query sums the count,
+// the attributes and their moments and the statistic is computed from the aggregated sums of each group once they are
+// decrypted by the querier.
+type Statistic struct {
+	Function StatisticFunction
+	// Attributes are the attribute of a mean or variance, the two attributes of a covariance or the dependent
+	// attribute of a regression followed by its explanatory attributes
+	Attributes []string
+}
+
+// StatisticResult is the value of a statistic in a group of the results. The values are NaN if there are not enough
+// responses (e.g. a single one for a variance) or if the explanatory attributes of a regression are collinear.
+type StatisticResult struct {
+	Statistic Statistic
+	Count     int64   // number of responses of the group
+	Value     float64 // mean, variance or covariance
+	// Coefficients are the intercept and the coefficient of each explanatory attribute of a regression
+	Coefficients []float64
+}
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// Validate checks that the function of the statistic is known and has the right number of attributes
+func (st Statistic) Validate() error {
+	switch st.Function {
+	case Mean, Variance:
+		if len(st.Attributes) != 1 {
+			return fmt.Errorf("%s needs one attribute", st)
+		}
+	case Covariance:
+		if len(st.Attributes) != 2 {
+			return fmt.Errorf("%s needs two attributes", st)
+		}
+	case Regression:
+		if len(st.Attributes) < 2 {
+			return fmt.Errorf("%s needs a dependent attribute and at least one explanatory attribute", st)
+		}
+	default:
+		return fmt.Errorf("unknown statistic %q", st.Function)
+	}
+	return nil
+}
+
+// String returns the text of the statistic (see ParseStatistics)
+func (st Statistic) String() string {
+	return strings.ToUpper(string(st.Function)) + "(" + strings.Join(st.Attributes, ", ") + ")"
+}
+
+// ParseStatistics parses a list of statistics separated by commas, possibly in braces, e.g. "{MEAN(s1), VARIANCE(s1),
+// COVARIANCE(s1, s2), REGRESSION(y, x1, x2)}" (the function names are case insensitive)
+func ParseStatistics(text string) ([]Statistic, error) {
+	calls, err := parseCalls(text)
+	if err != nil {
+		return nil, err
+	}
+
+	statistics := make([]Statistic, len(calls))
+	for i, c := range calls {
+		st := Statistic{Function: StatisticFunction(c.function), Attributes: c.args}
+		for _, name := range c.args {
+			if !attributeNameRegex.MatchString(name) {
+				return nil, fmt.Errorf("invalid attribute name: %q", name)
+			}
+		}
+		if err := st.Validate(); err != nil {
+			return nil, err
+		}
+		statistics[i] = st
+	}
+	return statistics, nil
+}
+
+// moments returns the sum attributes the statistic needs, besides the count
+func (st Statistic) moments() []string {
+	switch st.Function {
+	case Mean:
+		return []string{st.Attributes[0]}
+	case Variance:
+		return []string{st.Attributes[0], libunlynx.MomentName(st.Attributes[0], st.Attributes[0])}
+	case Covariance:
+		a, b := st.Attributes[0], st.Attributes[1]
+		return []string{a, b, libunlynx.MomentName(a, b)}
+	}
+
+	// the normal equations: the sums of the explanatory attributes, of their products and of their products with the
+	// dependent attribute
+	y, xs := st.Attributes[0], st.Attributes[1:]
+	moments := []string{y}
+	for i, x := range xs {
+		moments = append(moments, x, libunlynx.MomentName(x, y))
+		for _, x2 := range xs[:i+1] {
+			moments = append(moments, libunlynx.MomentName(x, x2))
+		}
+	}
+	return moments
+}
+
+// MomentSum returns the sum attributes a query needs to compute statistics: the count attribute (the query must be a
+// count query), the attributes and their moments, which must have moments in the schema
+func MomentSum(schema *libunlynx.Schema, statistics []Statistic) ([]string, error) {
+	sum := []string{libunlynx.CountAttribute}
+	added := map[string]bool{libunlynx.CountAttribute: true}
+	for _, st := range statistics {
+		if err := st.Validate(); err != nil {
+			return nil, err
+		}
+		for _, name := range st.Attributes {
+			if a := schema.Attribute(name); a == nil || !a.Moments {
+				return nil, fmt.Errorf("attribute %s has no moments in the schema", name)
+			}
+		}
+		for _, name := range st.moments() {
+			if !added[name] {
+				sum = append(sum, name)
+				added[name] = true
+			}
+		}
+	}
+	return sum, nil
+}
+
+// Evaluate computes the statistic from the aggregating attributes of a group of the results, given by name (e.g.
+// ServiceResult.Sum) and value, and the fixed-point scales of the attributes (a product has the decimals of both)
+func (st Statistic) Evaluate(sum []string, values []int64, scales libunlynx.FixedPointScales) (StatisticResult, error) {
+	if err := st.Validate(); err != nil {
+		return StatisticResult{}, err
+	}
+	if len(sum) != len(values) {
+		return StatisticResult{}, fmt.Errorf("%d values for %d aggregating attributes", len(values), len(sum))
+	}
+	sums := make(map[string]int64, len(sum))
+	for i, name := range sum {
+		sums[name] = values[i]
+	}
+
+	count, ok := sums[libunlynx.CountAttribute]
+	if !ok {
+		return StatisticResult{}, fmt.Errorf("no %s attribute in the results", libunlynx.CountAttribute)
+	}
+	// s returns the decoded sum of an attribute or of a moment
+	var missing error
+	s := func(name string) float64 {
+		v, ok := sums[name]
+		if !ok {
+			missing = fmt.Errorf("no %s attribute in the results", name)
+		}
+		decimals := scales[name]
+		if a, b, ok := libunlynx.SplitMomentName(name); ok {
+			decimals = scales[a] + scales[b]
+		}
+		return float64(v) / math.Pow10(int(decimals))
+	}
+
+	n := float64(count)
+	result := StatisticResult{Statistic: st, Count: count, Value: math.NaN()}
+	switch st.Function {
+	case Mean:
+		if count > 0 {
+			result.Value = s(st.Attributes[0]) / n
+		}
+	case Variance, Covariance:
+		a := st.Attributes[0]
+		b := st.Attributes[len(st.Attributes)-1]
+		product := s(libunlynx.MomentName(a, b)) - s(a)*s(b)/n
+		if count > 1 {
+			result.Value = product / (n - 1)
+		}
+	case Regression:
+		result.Coefficients = regression(st.Attributes[0], st.Attributes[1:], n, s)
+	}
+	if missing != nil {
+		return StatisticResult{}, missing
+	}
+	return result, nil
+}
+
+// regression solves the normal equations (X^T X) b = X^T y of the regression of y on xs (with an intercept) from the
+// sums of their moments
+func regression(y string, xs []string, n float64, s func(string) float64) []float64 {
+	// the first column of X is 1, whose sums are the count, and the others are the explanatory attributes
+	size := len(xs) + 1
+	sumX := func(i int) float64 {
+		if i == 0 {
+			return n
+		}
+		return s(xs[i-1])
+	}
+
+	// augmented matrix [X^T X | X^T y]
+	m := make([][]float64, size)
+	scale := 0.0
+	for i := range m {
+		m[i] = make([]float64, size+1)
+		for j := 0; j < size; j++ {
+			switch {
+			case i == 0:
+				m[i][j] = sumX(j)
+			case j == 0:
+				m[i][j] = sumX(i)
+			default:
+				m[i][j] = s(libunlynx.MomentName(xs[i-1], xs[j-1]))
+			}
+			scale = math.Max(scale, math.Abs(m[i][j]))
+		}
+		if i == 0 {
+			m[i][size] = s(y)
+		} else {
+			m[i][size] = s(libunlynx.MomentName(xs[i-1], y))
+		}
+	}
+
+	coefficients := make([]float64, size)
+	for i := range coefficients {
+		coefficients[i] = math.NaN()
+	}
+	// Gauss-Jordan elimination with partial pivoting
+	for k := 0; k < size; k++ {
+		pivot := k
+		for i := k + 1; i < size; i++ {
+			if math.Abs(m[i][k]) > math.Abs(m[pivot][k]) {
+				pivot = i
+			}
+		}
+		if math.Abs(m[pivot][k]) <= 1e-12*scale {
+			// singular matrix: not enough responses or collinear explanatory attributes
+			return coefficients
+		}
+		m[k], m[pivot] = m[pivot], m[k]
+		for i := 0; i < size; i++ {
+			if i == k {
+				continue
+			}
+			factor := m[i][k] / m[k][k]
+			for j := k; j <= size; j++ {
+				m[i][j] -= factor * m[k][j]
+			}
+		}
+	}
+	for i := range coefficients {
+		coefficients[i] = m[i][size] / m[i][i]
+	}
+	return coefficients
+}
+
+// EvaluateStatistics computes the statistics from the aggregating attributes of a group of the results (see
+// Statistic.Evaluate)
+func EvaluateStatistics(statistics []Statistic, sum []string, values []int64, scales libunlynx.FixedPointScales) ([]StatisticResult, error) {
+	results := make([]StatisticResult, len(statistics))
+	for i, st := range statistics {
+		var err error
+		if results[i], err = st.Evaluate(sum, values, scales); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,92 @@
+package libunlynxstatistics_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/statistics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatistics(t *testing.T) {
+	statistics, err := libunlynxstatistics.ParseStatistics("{MEAN(s1), variance(s1), COVARIANCE(s1, s2), REGRESSION(y, x1, x2)}")
+	require.NoError(t, err)
+	assert.Equal(t, []libunlynxstatistics.Statistic{
+		{Function: libunlynxstatistics.Mean, Attributes: []string{"s1"}},
+		{Function: libunlynxstatistics.Variance, Attributes: []string{"s1"}},
+		{Function: libunlynxstatistics.Covariance, Attributes: []string{"s1", "s2"}},
+		{Function: libunlynxstatistics.Regression, Attributes: []string{"y", "x1", "x2"}},
+	}, statistics)
+	assert.Equal(t, "REGRESSION(y, x1, x2)", statistics[3].String())
+
+	for _, wrong := range []string{"MEAN(s1, s2)", "COVARIANCE(s1)", "REGRESSION(y)", "MEDIAN(s1)", "MEAN(s-1)", "MEAN(s1) MEAN(s2)"} {
+		_, err := libunlynxstatistics.ParseStatistics(wrong)
+		assert.Error(t, err, wrong)
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	schema := &libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "y", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Moments: true},
+		{Name: "x1", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Encoding: libunlynx.EncodingFixedPoint, Decimals: 1, Moments: true},
+		{Name: "x2", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityClear, Moments: true},
+		{Name: "z", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityClear},
+	}}
+	statistics, err := libunlynxstatistics.ParseStatistics("MEAN(x1), VARIANCE(x1), COVARIANCE(x1, x2), REGRESSION(y, x1, x2)")
+	require.NoError(t, err)
+
+	sum, err := libunlynxstatistics.MomentSum(schema, statistics)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"count", "x1", "x1*x1", "x2", "x1*x2", "y", "x1*y", "x2*y", "x2*x2"}, sum)
+	_, err = libunlynxstatistics.MomentSum(schema, []libunlynxstatistics.Statistic{{Function: libunlynxstatistics.Mean, Attributes: []string{"z"}}})
+	assert.Error(t, err)
+
+	// y = 2 + 10*x1 - x2, with x1 encoded with one decimal
+	x1 := []float64{0.5, 1.2, 2.0, 3.1, 4.4}
+	x2 := []int64{3, 1, 4, 1, 5}
+	values := make([]int64, len(sum))
+	for i := range x1 {
+		encoded := int64(math.Round(x1[i] * 10))
+		y := int64(math.Round(2 + 10*x1[i] - float64(x2[i])))
+		dcr, err := schema.NewDpClearResponse(map[string]int64{"y": y, "x1": encoded, "x2": x2[i], "z": 0})
+		require.NoError(t, err)
+		for j, name := range sum {
+			if name == libunlynx.CountAttribute {
+				values[j]++
+			}
+			values[j] += dcr.AggregatingAttributesEnc[name] + dcr.AggregatingAttributesClear[name]
+		}
+	}
+
+	results, err := libunlynxstatistics.EvaluateStatistics(statistics, sum, values, schema.FixedPoint(sum))
+	require.NoError(t, err)
+	mean := (0.5 + 1.2 + 2.0 + 3.1 + 4.4) / 5
+	variance := 0.0
+	covariance := 0.0
+	for i := range x1 {
+		variance += (x1[i] - mean) * (x1[i] - mean) / 4
+		covariance += (x1[i] - mean) * (float64(x2[i]) - 2.8) / 4
+	}
+	assert.Equal(t, int64(5), results[0].Count)
+	assert.InDelta(t, mean, results[0].Value, 1e-9)
+	assert.InDelta(t, variance, results[1].Value, 1e-9)
+	assert.InDelta(t, covariance, results[2].Value, 1e-9)
+	require.Equal(t, 3, len(results[3].Coefficients))
+	assert.InDelta(t, 2, results[3].Coefficients[0], 1e-6)
+	assert.InDelta(t, 10, results[3].Coefficients[1], 1e-6)
+	assert.InDelta(t, -1, results[3].Coefficients[2], 1e-6)
+
+	// not enough responses
+	single := make([]int64, len(sum))
+	single[0] = 1
+	results, err = libunlynxstatistics.EvaluateStatistics(statistics, sum, single, nil)
+	require.NoError(t, err)
+	assert.False(t, math.IsNaN(results[0].Value))
+	assert.True(t, math.IsNaN(results[1].Value))
+	assert.True(t, math.IsNaN(results[3].Coefficients[0]))
+
+	_, err = statistics[3].Evaluate(sum[:3], values[:3], nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package libunlynxstatistics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ldsec/unlynx/lib"
+)
+
+var attributeNameRegex = regexp.MustCompile("^" + libunlynx.AttributeNamePattern + "$")
+
+// call is a function applied to arguments in the text of a list of statistics, e.g. PERCENTILE(age, 90)
+type call struct {
+	function string // in lower case
+	args     []string
+}
+
+// parseCalls parses a list of function calls separated by commas, possibly in braces, e.g. "{MIN(age), MEAN(weight)}"
+func parseCalls(text string) ([]call, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") && strings.HasSuffix(text, "}") {
+		text = strings.TrimSpace(text[1 : len(text)-1])
+	}
+
+	calls := make([]call, 0)
+	for text != "" {
+		open := strings.Index(text, "(")
+		end := strings.Index(text, ")")
+		if open <= 0 || end < open {
+			return nil, fmt.Errorf("expected a function call in %q", text)
+		}
+		c := call{function: strings.ToLower(strings.TrimSpace(text[:open]))}
+		for _, arg := range strings.Split(text[open+1:end], ",") {
+			c.args = append(c.args, strings.TrimSpace(arg))
+		}
+		calls = append(calls, c)
+
+		text = strings.TrimSpace(text[end+1:])
+		if strings.HasPrefix(text, ",") {
+			text = strings.TrimSpace(text[1:])
+			if text == "" {
+				return nil, fmt.Errorf("missing function call after the last comma")
+			}
+		} else if text != "" {
+			return nil, fmt.Errorf("expected a comma before %q", text)
+		}
+	}
+	return calls, nil
+}
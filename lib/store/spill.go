@@ -0,0 +1,240 @@
+package libunlynxstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/tools"
+)
+
+const (
+	dpChunkPrefix       = "dp"
+	shuffledChunkPrefix = "shuffled"
+)
+
+// NewSpillingStore creates a store keeping at most memoryBudget bytes of DP responses (and of shuffled responses) in
+// memory: beyond it, the responses are written in chunks to files in dir. The chunks are then pulled one at a time
+// (see PullDpResponsesBatch and PullShuffledProcessResponsesBatch), so that a survey can be processed batch by batch.
+func NewSpillingStore(dir string, memoryBudget int64) *Store {
+	s := NewStore()
+	s.SpillDir = dir
+	s.MemoryBudget = memoryBudget
+	return s
+}
+
+// processResponsesSize returns the size in bytes of the ciphertexts of the responses
+func processResponsesSize(prs []libunlynx.ProcessResponse) int64 {
+	size := int64(0)
+	for _, v := range prs {
+		size += int64(len(v.WhereEnc)+len(v.GroupByEnc)+len(v.AggregatingAttributes)) * int64(libunlynx.CipherTextByteSize())
+	}
+	return size
+}
+
+// spilling checks if the responses kept in memory exceed the memory budget
+func (s *Store) spilling(size int64) bool {
+	return s.MemoryBudget > 0 && size > s.MemoryBudget
+}
+
+// writeChunk writes responses to a new chunk file and returns its path
+func (s *Store) writeChunk(prefix string, prs []libunlynx.ProcessResponse) (string, error) {
+	prbs, err := processResponsesToBytes(prs)
+	if err != nil {
+		return "", err
+	}
+	s.lastChunk++
+	path := filepath.Join(s.SpillDir, fmt.Sprintf("%s-%d.gob", prefix, s.lastChunk))
+	if err := libunlynxtools.WriteToGobFile(path, prbs); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// readChunk reads the responses of a chunk file
+func readChunk(path string) ([]libunlynx.ProcessResponse, error) {
+	var prbs []ProcessResponseBytes
+	if err := libunlynxtools.ReadFromGobFile(path, &prbs); err != nil {
+		return nil, err
+	}
+	return processResponsesFromBytes(prbs)
+}
+
+// spillDpResponses writes the DP responses kept in memory to a chunk if they exceed the memory budget
+func (s *Store) spillDpResponses() error {
+	if !s.spilling(s.dpSize) {
+		return nil
+	}
+	path, err := s.writeChunk(dpChunkPrefix, s.DpResponses)
+	if err != nil {
+		return fmt.Errorf("could not spill DP responses: %v", err)
+	}
+	s.dpChunks = append(s.dpChunks, path)
+	s.DpResponses, s.dpSize = s.DpResponses[:0], 0
+	return nil
+}
+
+// spillShuffledProcessResponses writes the shuffled responses kept in memory to a chunk if they exceed the memory
+// budget
+func (s *Store) spillShuffledProcessResponses() error {
+	if !s.spilling(s.shuffledSize) {
+		return nil
+	}
+	path, err := s.writeChunk(shuffledChunkPrefix, s.ShuffledProcessResponses)
+	if err != nil {
+		return fmt.Errorf("could not spill shuffled responses: %v", err)
+	}
+	s.shuffledChunks = append(s.shuffledChunks, path)
+	s.ShuffledProcessResponses, s.shuffledSize = s.ShuffledProcessResponses[:0], 0
+	return nil
+}
+
+// pullChunk reads and removes the oldest chunk of a list
+func pullChunk(chunks *[]string) ([]libunlynx.ProcessResponse, error) {
+	path := (*chunks)[0]
+	prs, err := readChunk(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	*chunks = (*chunks)[1:]
+	return prs, nil
+}
+
+// SpilledChunks returns the number of chunks of DP responses and of shuffled responses written to disk and not pulled
+// yet
+func (s *Store) SpilledChunks() int {
+	return len(s.dpChunks) + len(s.shuffledChunks)
+}
+
+// DpChunks returns the number of chunks of DP responses written to disk and not pulled yet
+func (s *Store) DpChunks() int {
+	return len(s.dpChunks)
+}
+
+// DpChunkCipherTexts returns the ciphertexts of the i-th chunk of DP responses written to disk (see CipherTexts), so
+// that the spilled responses can be re-encrypted one chunk at a time
+func (s *Store) DpChunkCipherTexts(i int) (libunlynx.CipherVector, error) {
+	if i < 0 || i >= len(s.dpChunks) {
+		return nil, fmt.Errorf("no DP chunk %d (%d chunks)", i, len(s.dpChunks))
+	}
+	prs, err := readChunk(s.dpChunks[i])
+	if err != nil {
+		return nil, err
+	}
+	return responsesCipherTexts(prs), nil
+}
+
+// SetDpChunkCipherTexts replaces the ciphertexts of the i-th chunk of DP responses written to disk (see
+// DpChunkCipherTexts)
+func (s *Store) SetDpChunkCipherTexts(i int, cv libunlynx.CipherVector) error {
+	if i < 0 || i >= len(s.dpChunks) {
+		return fmt.Errorf("no DP chunk %d (%d chunks)", i, len(s.dpChunks))
+	}
+	path := s.dpChunks[i]
+	prs, err := readChunk(path)
+	if err != nil {
+		return err
+	}
+	if stored := len(responsesCipherTexts(prs)); len(cv) != stored {
+		return fmt.Errorf("%d ciphertexts given for %d stored ones", len(cv), stored)
+	}
+	replaceResponsesCipherTexts(prs, cv)
+	prbs, err := processResponsesToBytes(prs)
+	if err != nil {
+		return err
+	}
+	return libunlynxtools.WriteToGobFile(path, prbs)
+}
+
+// MixShuffledBatches prepares a second shuffling pass when the responses were shuffled batch by batch, in which a
+// response stays in the batch it was inserted in. The shuffled responses (spilled or not) are dealt round robin into as
+// many new batches of DP responses, so that each new batch contains responses of every shuffled batch: shuffling them
+// again lets a response end up in any of them. The new batches are written to disk one at a time, at the cost of reading the
+// shuffled chunks once for each of them. It returns false (and does nothing) if the responses were shuffled at once.
+func (s *Store) MixShuffledBatches() (bool, error) {
+	if len(s.shuffledChunks) == 0 {
+		return false, nil
+	}
+
+	sources := append([]string{}, s.shuffledChunks...)
+	inMemory := s.ShuffledProcessResponses
+	nbrBatches := len(sources)
+	if len(inMemory) > 0 {
+		nbrBatches++
+	}
+
+	for b := 0; b < nbrBatches; b++ {
+		var batch []libunlynx.ProcessResponse
+		deal := func(prs []libunlynx.ProcessResponse) {
+			for i := b; i < len(prs); i += nbrBatches {
+				batch = append(batch, prs[i])
+			}
+		}
+		for _, path := range sources {
+			prs, err := readChunk(path)
+			if err != nil {
+				return false, err
+			}
+			deal(prs)
+		}
+		deal(inMemory)
+
+		path, err := s.writeChunk(dpChunkPrefix, batch)
+		if err != nil {
+			return false, fmt.Errorf("could not write mixed responses: %v", err)
+		}
+		s.dpChunks = append(s.dpChunks, path)
+	}
+
+	for _, path := range sources {
+		if err := os.Remove(path); err != nil {
+			return false, err
+		}
+	}
+	s.shuffledChunks = nil
+	s.ShuffledProcessResponses, s.shuffledSize = s.ShuffledProcessResponses[:0], 0
+	return true, nil
+}
+
+// HasNextDpBatch verifies if there are DP responses (in memory or spilled) to be processed.
+func (s *Store) HasNextDpBatch() bool {
+	return len(s.dpChunks) > 0 || len(s.DpResponses) > 0 || len(s.DpResponsesAggr) > 0
+}
+
+// PullDpResponsesBatch gets the next batch of DP responses: the spilled chunks first, in the order they were written,
+// then the responses kept in memory together with the responses aggregated in clear.
+func (s *Store) PullDpResponsesBatch() ([]libunlynx.ProcessResponse, error) {
+	if len(s.dpChunks) > 0 {
+		return pullChunk(&s.dpChunks)
+	}
+	result := s.PullDpResponses()
+	s.DpResponsesAggr = make(map[GroupingKeyTuple]libunlynx.ProcessResponse)
+	return result, nil
+}
+
+// HasNextShuffledBatch verifies if there are shuffled responses (in memory or spilled) to be processed.
+func (s *Store) HasNextShuffledBatch() bool {
+	return len(s.shuffledChunks) > 0 || len(s.ShuffledProcessResponses) > 0
+}
+
+// PullShuffledProcessResponsesBatch gets the next batch of shuffled responses: the spilled chunks first, then the
+// responses kept in memory.
+func (s *Store) PullShuffledProcessResponsesBatch() ([]libunlynx.ProcessResponse, error) {
+	if len(s.shuffledChunks) > 0 {
+		return pullChunk(&s.shuffledChunks)
+	}
+	return s.PullShuffledProcessResponses(), nil
+}
+
+// Close removes the spill directory of the store and the chunks it still contains
+func (s *Store) Close() error {
+	if s.SpillDir == "" {
+		return nil
+	}
+	s.dpChunks, s.shuffledChunks = nil, nil
+	return os.RemoveAll(s.SpillDir)
+}
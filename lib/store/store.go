@@ -1,6 +1,8 @@
 package libunlynxstore
 
 import (
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/ldsec/unlynx/lib"
@@ -26,6 +28,17 @@ type Store struct {
 	// before they are key switched and combined in the last step (key switching).
 	GroupedDeterministicFilteredResponses map[libunlynx.GroupingKey]libunlynx.FilteredResponse
 
+	// MemoryBudget is the maximum size in bytes of the DP responses (and of the shuffled responses) kept in memory, no
+	// limit if 0. Beyond it, they are spilled to files in SpillDir (see NewSpillingStore).
+	MemoryBudget int64
+	SpillDir     string
+
+	dpChunks       []string // files of the spilled DP responses, oldest first
+	shuffledChunks []string // files of the spilled shuffled responses, oldest first
+	dpSize         int64    // size of the DP responses kept in memory
+	shuffledSize   int64    // size of the shuffled responses kept in memory
+	lastChunk      uint64
+
 	lastID uint64
 }
 
@@ -64,8 +77,9 @@ func proccessParameters(data []string, clear map[string]int64, encrypted map[str
 	return containerClear, containerEnc
 }
 
-// InsertDpResponse handles the local storage of a new DP response in aggregation or grouping cases.
-func (s *Store) InsertDpResponse(cr libunlynx.DpResponse, proofsB bool, groupBy, sum []string, where []libunlynx.WhereQueryAttribute) {
+// InsertDpResponse handles the local storage of a new DP response in aggregation or grouping cases. The encrypted
+// responses are spilled to disk if they exceed the memory budget of the store.
+func (s *Store) InsertDpResponse(cr libunlynx.DpResponse, proofsB bool, groupBy, sum []string, where []libunlynx.WhereQueryAttribute) error {
 	newResp := libunlynx.ProcessResponse{}
 	clearGrp := make([]int64, 0)
 	clearWhr := make([]int64, 0)
@@ -82,6 +96,10 @@ func (s *Store) InsertDpResponse(cr libunlynx.DpResponse, proofsB bool, groupBy,
 
 	if !noEnc {
 		s.DpResponses = append(s.DpResponses, newResp)
+		s.dpSize += processResponsesSize([]libunlynx.ProcessResponse{newResp})
+		if err := s.spillDpResponses(); err != nil {
+			return err
+		}
 	} else {
 		value, ok := s.DpResponsesAggr[GroupingKeyTuple{libunlynx.Key(clearGrp), libunlynx.Key(clearWhr)}]
 		if ok {
@@ -100,6 +118,7 @@ func (s *Store) InsertDpResponse(cr libunlynx.DpResponse, proofsB bool, groupBy,
 		}
 
 	}
+	return nil
 }
 
 // HasNextDpResponse permits to verify if there are new DP responses to be processed.
@@ -107,25 +126,108 @@ func (s *Store) HasNextDpResponse() bool {
 	return len(s.DpResponses) > 0
 }
 
-// PullDpResponses permits to get the received DP responses
+// PullDpResponses permits to get the received DP responses (the spilled ones are not included, see
+// PullDpResponsesBatch)
 func (s *Store) PullDpResponses() []libunlynx.ProcessResponse {
 	result := s.DpResponses
 	for _, v := range s.DpResponsesAggr {
 		result = append(result, v)
 	}
 	s.DpResponses = s.DpResponses[:0] //clear table
+	s.dpSize = 0
 	return result
 }
 
-// PushShuffledProcessResponses stores shuffled responses
-func (s *Store) PushShuffledProcessResponses(newShuffledProcessResponses []libunlynx.ProcessResponse) {
+// CipherTexts returns the ciphertexts of the DP responses kept in memory that were not processed yet (e.g. to re-encrypt
+// them under a new collective key, the spilled ones are given chunk by chunk by DpChunkCipherTexts). They can be
+// replaced with SetCipherTexts, in the same order, if no response is inserted in between.
+func (s *Store) CipherTexts() (libunlynx.CipherVector, error) {
+	cv := responsesCipherTexts(s.DpResponses)
+	for _, k := range s.sortedAggrKeys() {
+		cv = append(cv, s.DpResponsesAggr[k].AggregatingAttributes...)
+	}
+	return cv, nil
+}
+
+// SetCipherTexts replaces the ciphertexts of the DP responses kept in memory that were not processed yet (see
+// CipherTexts).
+func (s *Store) SetCipherTexts(cv libunlynx.CipherVector) error {
+	stored, err := s.CipherTexts()
+	if err != nil {
+		return err
+	}
+	if len(cv) != len(stored) {
+		return fmt.Errorf("%d ciphertexts given for %d stored ones", len(cv), len(stored))
+	}
+
+	pos := replaceResponsesCipherTexts(s.DpResponses, cv)
+	for _, k := range s.sortedAggrKeys() {
+		value := s.DpResponsesAggr[k]
+		length := len(value.AggregatingAttributes)
+		value.AggregatingAttributes = append(libunlynx.CipherVector{}, cv[pos:pos+length]...)
+		pos += length
+		s.DpResponsesAggr[k] = value
+	}
+	return nil
+}
+
+// responsesCipherTexts returns the encrypted attributes of responses, response by response
+func responsesCipherTexts(prs []libunlynx.ProcessResponse) libunlynx.CipherVector {
+	cv := libunlynx.CipherVector{}
+	for _, v := range prs {
+		cv = append(cv, v.WhereEnc...)
+		cv = append(cv, v.GroupByEnc...)
+		cv = append(cv, v.AggregatingAttributes...)
+	}
+	return cv
+}
+
+// replaceResponsesCipherTexts replaces the encrypted attributes of responses (see responsesCipherTexts) with the first
+// ciphertexts of cv and returns the number of ciphertexts used
+func replaceResponsesCipherTexts(prs []libunlynx.ProcessResponse, cv libunlynx.CipherVector) int {
+	pos := 0
+	next := func(length int) libunlynx.CipherVector {
+		result := make(libunlynx.CipherVector, length)
+		copy(result, cv[pos:pos+length])
+		pos += length
+		return result
+	}
+	for i, v := range prs {
+		prs[i].WhereEnc = next(len(v.WhereEnc))
+		prs[i].GroupByEnc = next(len(v.GroupByEnc))
+		prs[i].AggregatingAttributes = next(len(v.AggregatingAttributes))
+	}
+	return pos
+}
+
+// sortedAggrKeys returns the keys of the locally aggregated DP responses in a deterministic order
+func (s *Store) sortedAggrKeys() []GroupingKeyTuple {
+	keys := make([]GroupingKeyTuple, 0, len(s.DpResponsesAggr))
+	for k := range s.DpResponsesAggr {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].gkt1 != keys[j].gkt1 {
+			return keys[i].gkt1 < keys[j].gkt1
+		}
+		return keys[i].gkt2 < keys[j].gkt2
+	})
+	return keys
+}
+
+// PushShuffledProcessResponses stores shuffled responses (they are spilled to disk if they exceed the memory budget)
+func (s *Store) PushShuffledProcessResponses(newShuffledProcessResponses []libunlynx.ProcessResponse) error {
 	s.ShuffledProcessResponses = append(s.ShuffledProcessResponses, newShuffledProcessResponses...)
+	s.shuffledSize += processResponsesSize(newShuffledProcessResponses)
+	return s.spillShuffledProcessResponses()
 }
 
-// PullShuffledProcessResponses gets shuffled process responses
+// PullShuffledProcessResponses gets shuffled process responses (the spilled ones are not included, see
+// PullShuffledProcessResponsesBatch)
 func (s *Store) PullShuffledProcessResponses() []libunlynx.ProcessResponse {
 	result := s.ShuffledProcessResponses
 	s.ShuffledProcessResponses = s.ShuffledProcessResponses[:0] //clear table
+	s.shuffledSize = 0
 	return result
 }
 
@@ -310,3 +412,179 @@ func (s *Store) DisplayResults() {
 		log.Lvl1("[ ", v.GroupByEnc, " ] : ", v.AggregatingAttributes, ")")
 	}
 }
+
+// Marshal
+//______________________________________________________________________________________________________________________
+
+// ProcessResponseBytes is the 'bytes' equivalent of a ProcessResponse
+type ProcessResponseBytes struct {
+	Data          []byte
+	GroupByLength int64
+	AggrLength    int64
+	WhereLength   int64
+}
+
+// FilteredResponseBytes is the 'bytes' equivalent of a FilteredResponse, with its grouping key (if any)
+type FilteredResponseBytes struct {
+	Key           string
+	Data          []byte
+	GroupByLength int64
+	AggrLength    int64
+}
+
+// StoreBytes is the 'bytes' equivalent of a Store (e.g. to persist it)
+type StoreBytes struct {
+	DpResponses []ProcessResponseBytes
+	// DpResponsesAggr contains the responses aggregated in clear and DpResponsesAggrKeys their two grouping keys
+	DpResponsesAggr          []ProcessResponseBytes
+	DpResponsesAggrKeys      []string
+	ShuffledProcessResponses []ProcessResponseBytes
+
+	LocAggregatedProcessResponse          []FilteredResponseBytes
+	GroupedDeterministicFilteredResponses []FilteredResponseBytes
+	DeliverableResults                    []FilteredResponseBytes
+
+	// MemoryBudget, SpillDir and the files of the spilled chunks of a spilling store
+	MemoryBudget   int64
+	SpillDir       string
+	DpChunks       []string
+	ShuffledChunks []string
+	LastChunk      int64
+
+	LastID int64
+}
+
+func processResponsesToBytes(prs []libunlynx.ProcessResponse) ([]ProcessResponseBytes, error) {
+	result := make([]ProcessResponseBytes, len(prs))
+	for i := range prs {
+		data, grpLength, aggrLength, whereLength, err := prs[i].ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = ProcessResponseBytes{Data: data, GroupByLength: int64(grpLength), AggrLength: int64(aggrLength), WhereLength: int64(whereLength)}
+	}
+	return result, nil
+}
+
+func processResponsesFromBytes(prbs []ProcessResponseBytes) ([]libunlynx.ProcessResponse, error) {
+	result := make([]libunlynx.ProcessResponse, len(prbs))
+	for i, v := range prbs {
+		if err := result[i].FromBytes(v.Data, int(v.GroupByLength), int(v.AggrLength), int(v.WhereLength)); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func filteredResponseToBytes(key libunlynx.GroupingKey, fr libunlynx.FilteredResponse) (FilteredResponseBytes, error) {
+	data, grpLength, aggrLength, err := fr.ToBytes()
+	if err != nil {
+		return FilteredResponseBytes{}, err
+	}
+	return FilteredResponseBytes{Key: string(key), Data: data, GroupByLength: int64(grpLength), AggrLength: int64(aggrLength)}, nil
+}
+
+func filteredResponsesMapToBytes(frs map[libunlynx.GroupingKey]libunlynx.FilteredResponse) ([]FilteredResponseBytes, error) {
+	result := make([]FilteredResponseBytes, 0, len(frs))
+	for k, v := range frs {
+		frb, err := filteredResponseToBytes(k, v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, frb)
+	}
+	return result, nil
+}
+
+func filteredResponsesMapFromBytes(frbs []FilteredResponseBytes) (map[libunlynx.GroupingKey]libunlynx.FilteredResponse, error) {
+	result := make(map[libunlynx.GroupingKey]libunlynx.FilteredResponse, len(frbs))
+	for _, v := range frbs {
+		fr := libunlynx.FilteredResponse{}
+		if err := fr.FromBytes(v.Data, int(v.AggrLength), int(v.GroupByLength)); err != nil {
+			return nil, err
+		}
+		result[libunlynx.GroupingKey(v.Key)] = fr
+	}
+	return result, nil
+}
+
+// ToBytes converts the Store to bytes (its schema is not included, nor the content of the spilled chunks)
+func (s *Store) ToBytes() (StoreBytes, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	sb := StoreBytes{LastID: int64(s.lastID), MemoryBudget: s.MemoryBudget, SpillDir: s.SpillDir, DpChunks: s.dpChunks,
+		ShuffledChunks: s.shuffledChunks, LastChunk: int64(s.lastChunk)}
+	var err error
+	if sb.DpResponses, err = processResponsesToBytes(s.DpResponses); err != nil {
+		return StoreBytes{}, err
+	}
+	if sb.ShuffledProcessResponses, err = processResponsesToBytes(s.ShuffledProcessResponses); err != nil {
+		return StoreBytes{}, err
+	}
+
+	keys := s.sortedAggrKeys()
+	aggr := make([]libunlynx.ProcessResponse, len(keys))
+	for i, k := range keys {
+		aggr[i] = s.DpResponsesAggr[k]
+		sb.DpResponsesAggrKeys = append(sb.DpResponsesAggrKeys, string(k.gkt1), string(k.gkt2))
+	}
+	if sb.DpResponsesAggr, err = processResponsesToBytes(aggr); err != nil {
+		return StoreBytes{}, err
+	}
+
+	if sb.LocAggregatedProcessResponse, err = filteredResponsesMapToBytes(s.LocAggregatedProcessResponse); err != nil {
+		return StoreBytes{}, err
+	}
+	if sb.GroupedDeterministicFilteredResponses, err = filteredResponsesMapToBytes(s.GroupedDeterministicFilteredResponses); err != nil {
+		return StoreBytes{}, err
+	}
+	sb.DeliverableResults = make([]FilteredResponseBytes, len(s.DeliverableResults))
+	for i, v := range s.DeliverableResults {
+		if sb.DeliverableResults[i], err = filteredResponseToBytes("", v); err != nil {
+			return StoreBytes{}, err
+		}
+	}
+	return sb, nil
+}
+
+// FromBytes converts bytes back to a Store
+func (s *Store) FromBytes(sb StoreBytes) error {
+	if len(sb.DpResponsesAggrKeys) != 2*len(sb.DpResponsesAggr) {
+		return fmt.Errorf("%d grouping keys for %d aggregated responses", len(sb.DpResponsesAggrKeys), len(sb.DpResponsesAggr))
+	}
+
+	var err error
+	if s.DpResponses, err = processResponsesFromBytes(sb.DpResponses); err != nil {
+		return err
+	}
+	if s.ShuffledProcessResponses, err = processResponsesFromBytes(sb.ShuffledProcessResponses); err != nil {
+		return err
+	}
+	aggr, err := processResponsesFromBytes(sb.DpResponsesAggr)
+	if err != nil {
+		return err
+	}
+	s.DpResponsesAggr = make(map[GroupingKeyTuple]libunlynx.ProcessResponse, len(aggr))
+	for i, v := range aggr {
+		s.DpResponsesAggr[GroupingKeyTuple{libunlynx.GroupingKey(sb.DpResponsesAggrKeys[2*i]), libunlynx.GroupingKey(sb.DpResponsesAggrKeys[2*i+1])}] = v
+	}
+
+	if s.LocAggregatedProcessResponse, err = filteredResponsesMapFromBytes(sb.LocAggregatedProcessResponse); err != nil {
+		return err
+	}
+	if s.GroupedDeterministicFilteredResponses, err = filteredResponsesMapFromBytes(sb.GroupedDeterministicFilteredResponses); err != nil {
+		return err
+	}
+	s.DeliverableResults = make([]libunlynx.FilteredResponse, len(sb.DeliverableResults))
+	for i, v := range sb.DeliverableResults {
+		if err := s.DeliverableResults[i].FromBytes(v.Data, int(v.AggrLength), int(v.GroupByLength)); err != nil {
+			return err
+		}
+	}
+	s.MemoryBudget, s.SpillDir, s.dpChunks, s.shuffledChunks = sb.MemoryBudget, sb.SpillDir, sb.DpChunks, sb.ShuffledChunks
+	s.dpSize, s.shuffledSize = processResponsesSize(s.DpResponses), processResponsesSize(s.ShuffledProcessResponses)
+	s.lastChunk = uint64(sb.LastChunk)
+	s.lastID = uint64(sb.LastID)
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/ldsec/unlynx/protocols"
 	"github.com/stretchr/testify/assert"
 	"go.dedis.ch/kyber/v3/util/random"
+	"io/ioutil"
+	"os"
 	"strconv"
 	"testing"
 )
@@ -92,6 +94,44 @@ func TestStoring(t *testing.T) {
 
 	assert.True(t, len(storage.DpResponses) == 3)
 
+	// replace the stored ciphertexts (e.g. after a key rotation)
+	cts, err := storage.CipherTexts()
+	assert.NoError(t, err)
+	assert.Equal(t, 3*(len(where)+len(groupBy)+len(sum)), len(cts))
+	replaced := make(libunlynx.CipherVector, len(cts))
+	for i := range replaced {
+		replaced[i] = *libunlynx.EncryptInt(pubKey, int64(i))
+	}
+	assert.NoError(t, storage.SetCipherTexts(replaced))
+	cts, err = storage.CipherTexts()
+	assert.NoError(t, err)
+	assert.Equal(t, replaced, cts)
+	assert.Error(t, storage.SetCipherTexts(replaced[1:]))
+
+	// a store with a single response (aggregated with the other responses of its group)
+	aggrStorage := NewStore()
+	assert.NoError(t, aggrStorage.InsertDpResponse(libunlynx.DpResponse{GroupByClear: map[string]int64{"age": 3}, AggregatingAttributesEnc: map[string]libunlynx.CipherText{"weight": testAggr1[0]}}, false, []string{"age"}, []string{"weight"}, nil))
+
+	// conversion to bytes (e.g. to persist the stores)
+	for _, st := range []*Store{storage, aggrStorage} {
+		sb, err := st.ToBytes()
+		assert.NoError(t, err)
+		restored := NewStore()
+		assert.NoError(t, restored.FromBytes(sb))
+		cts, err := st.CipherTexts()
+		assert.NoError(t, err)
+		restoredCts, err := restored.CipherTexts()
+		assert.NoError(t, err)
+		expected, _, err := cts.ToBytes()
+		assert.NoError(t, err)
+		actual, _, err := restoredCts.ToBytes()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+		assert.Equal(t, len(st.DpResponsesAggr), len(restored.DpResponsesAggr))
+	}
+
+	assert.Equal(t, 1, len(aggrStorage.PullDpResponses()))
+
 	// (5) Test Shuffling pull and push functions
 	listToShuffle := storage.PullDpResponses()
 	storage.PushShuffledProcessResponses(listToShuffle)
@@ -132,6 +172,20 @@ func TestStoring(t *testing.T) {
 
 	storage.PushCothorityAggregatedFilteredResponses(detResponsesMap)
 
+	sb, err := storage.ToBytes()
+	assert.NoError(t, err)
+	restored := NewStore()
+	assert.NoError(t, restored.FromBytes(sb))
+	assert.Equal(t, len(storage.GroupedDeterministicFilteredResponses), len(restored.GroupedDeterministicFilteredResponses))
+	for k, v := range storage.GroupedDeterministicFilteredResponses {
+		expected, _, _, err := v.ToBytes()
+		assert.NoError(t, err)
+		fr := restored.GroupedDeterministicFilteredResponses[k]
+		actual, _, _, err := fr.ToBytes()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
 	assert.True(t, len(storage.PullCothorityAggregatedFilteredResponses(false, libunlynx.CipherText{})) == 2)
 	assert.Empty(t, storage.GroupedDeterministicFilteredResponses, 0)
 
@@ -139,12 +193,121 @@ func TestStoring(t *testing.T) {
 	filteredResponses := []libunlynx.FilteredResponse{{GroupByEnc: testAggr2, AggregatingAttributes: testAggr2},
 		{GroupByEnc: testAggr1, AggregatingAttributes: testAggr2}, {GroupByEnc: testAggr2, AggregatingAttributes: testAggr1}}
 	storage.PushQuerierKeyEncryptedResponses(filteredResponses)
+	sb, err = storage.ToBytes()
+	assert.NoError(t, err)
+	restored = NewStore()
+	assert.NoError(t, restored.FromBytes(sb))
+	assert.Equal(t, len(filteredResponses), len(restored.DeliverableResults))
+	assert.Equal(t, len(testAggr1), len(restored.DeliverableResults[2].AggregatingAttributes))
+	assert.True(t, restored.DeliverableResults[2].AggregatingAttributes[0].C.Equal(testAggr1[0].C))
 	results := storage.PullDeliverableResults(false, libunlynx.CipherText{})
 
 	assert.True(t, len(results) == 3)
 	assert.Empty(t, len(storage.DeliverableResults), 0)
 }
 
+// TestSpillingStore tests that a store with a memory budget spills its responses to disk and gives them back batch by
+// batch.
+func TestSpillingStore(t *testing.T) {
+	secKey, pubKey := libunlynx.GenKey()
+	dir, err := ioutil.TempDir("", "unlynx-store-test")
+	assert.NoError(t, err)
+
+	// a response has one group by attribute and one aggregating attribute: 4 of them exceed the budget
+	responseSize := 2 * int64(libunlynx.CipherTextByteSize())
+	storage := NewSpillingStore(dir, 3*responseSize)
+	defer storage.Close()
+
+	groupBy, sum := []string{"g"}, []string{"s"}
+	for i := 0; i < 10; i++ {
+		dr := libunlynx.DpResponse{GroupByEnc: map[string]libunlynx.CipherText{"g": *libunlynx.EncryptInt(pubKey, 1)}, AggregatingAttributesEnc: map[string]libunlynx.CipherText{"s": *libunlynx.EncryptInt(pubKey, int64(i))}}
+		assert.NoError(t, storage.InsertDpResponse(dr, false, groupBy, sum, nil))
+	}
+	dr := libunlynx.DpResponse{GroupByClear: map[string]int64{"g": 1}, AggregatingAttributesEnc: map[string]libunlynx.CipherText{"s": *libunlynx.EncryptInt(pubKey, 10)}}
+	assert.NoError(t, storage.InsertDpResponse(dr, false, groupBy, sum, nil))
+
+	assert.Equal(t, 2, storage.SpilledChunks())
+	assert.Equal(t, 2, len(storage.DpResponses))
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(files))
+
+	// the spilled ciphertexts can be replaced chunk by chunk (e.g. after a key rotation)
+	cts, err := storage.CipherTexts()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*2+1, len(cts))
+	assert.NoError(t, storage.SetCipherTexts(cts))
+	assert.Equal(t, 2, storage.DpChunks())
+	for i := 0; i < storage.DpChunks(); i++ {
+		cts, err := storage.DpChunkCipherTexts(i)
+		assert.NoError(t, err)
+		assert.Equal(t, 2*4, len(cts))
+		assert.NoError(t, storage.SetDpChunkCipherTexts(i, cts))
+		assert.Error(t, storage.SetDpChunkCipherTexts(i, cts[1:]))
+	}
+	_, err = storage.DpChunkCipherTexts(2)
+	assert.Error(t, err)
+
+	// the spilled chunks are kept when the store is converted to bytes
+	sb, err := storage.ToBytes()
+	assert.NoError(t, err)
+	restored := NewStore()
+	assert.NoError(t, restored.FromBytes(sb))
+	assert.Equal(t, 2, restored.SpilledChunks())
+	assert.Equal(t, storage.MemoryBudget, restored.MemoryBudget)
+
+	// the responses are pulled in their insertion order, the aggregated ones last
+	var sizes []int
+	var values []int64
+	for storage.HasNextDpBatch() {
+		batch, err := storage.PullDpResponsesBatch()
+		assert.NoError(t, err)
+		sizes = append(sizes, len(batch))
+		for _, pr := range batch {
+			values = append(values, libunlynx.DecryptInt(secKey, pr.AggregatingAttributes[0]))
+		}
+		assert.NoError(t, storage.PushShuffledProcessResponses(batch))
+	}
+	assert.Equal(t, []int{4, 4, 3}, sizes)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, values)
+
+	// the shuffled responses are spilled in the same way
+	assert.Equal(t, 2, storage.SpilledChunks())
+
+	// they are dealt into new batches of DP responses which all contain responses of each shuffled batch
+	mixed, err := storage.MixShuffledBatches()
+	assert.NoError(t, err)
+	assert.True(t, mixed)
+	var mixedValues [][]int64
+	for storage.HasNextDpBatch() {
+		batch, err := storage.PullDpResponsesBatch()
+		assert.NoError(t, err)
+		var batchValues []int64
+		for _, pr := range batch {
+			batchValues = append(batchValues, libunlynx.DecryptInt(secKey, pr.AggregatingAttributes[0]))
+		}
+		mixedValues = append(mixedValues, batchValues)
+		assert.NoError(t, storage.PushShuffledProcessResponses(batch))
+	}
+	assert.Equal(t, [][]int64{{0, 3, 4, 7, 8}, {1, 5, 9}, {2, 6, 10}}, mixedValues)
+	assert.Equal(t, 2, storage.SpilledChunks())
+	nbrShuffled := 0
+	for storage.HasNextShuffledBatch() {
+		batch, err := storage.PullShuffledProcessResponsesBatch()
+		assert.NoError(t, err)
+		nbrShuffled += len(batch)
+	}
+	assert.Equal(t, 11, nbrShuffled)
+	assert.Equal(t, 0, storage.SpilledChunks())
+	mixed, err = storage.MixShuffledBatches()
+	assert.NoError(t, err)
+	assert.False(t, mixed)
+
+	assert.NoError(t, storage.Close())
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
 func TestConvertDataToMap(t *testing.T) {
 	test := []int64{0, 1, 2, 3, 4}
 
@@ -25,6 +25,10 @@ type DpClearResponse struct {
 	GroupByEnc                 map[string]int64
 	AggregatingAttributesClear map[string]int64
 	AggregatingAttributesEnc   map[string]int64
+
+	// Moments are aggregating attributes whose products (see Moments) are added to the response when it is encrypted,
+	// for the data providers without a schema (Schema.NewDpClearResponse adds them otherwise)
+	Moments []string
 }
 
 // DpResponse represents an encrypted DP response (as it is sent to a server)
@@ -45,6 +49,15 @@ type DpResponseToSend struct {
 	GroupByEnc                 map[string][]byte
 	AggregatingAttributesClear map[string]int64
 	AggregatingAttributesEnc   map[string][]byte
+
+	// proofs of knowledge of the plaintexts of the encrypted attributes (see KnowledgeProofCreation)
+	WhereEncProofs                 map[string][]byte
+	GroupByEncProofs               map[string][]byte
+	AggregatingAttributesEncProofs map[string][]byte
+
+	// AggregatingAttributesRangeProofs contains the range proofs of the encrypted aggregating attributes whose range was
+	// declared in the survey
+	AggregatingAttributesRangeProofs map[string][]byte
 }
 
 // ProcessResponse is a response in the format used for shuffling and det tag
@@ -149,44 +162,65 @@ func (crd *FilteredResponseDet) FormatAggregationProofs(res map[GroupingKey][]Ci
 	}
 }
 
-// EncryptDpClearResponse encrypts a DP response
-func EncryptDpClearResponse(ccr DpClearResponse, encryptionKey kyber.Point, count bool) (DpResponseToSend, error) {
-	cr := DpResponseToSend{}
-	cr.GroupByClear = ccr.GroupByClear
-	cr.GroupByEnc = make(map[string][]byte, len(ccr.GroupByEnc))
-	for i, v := range ccr.GroupByEnc {
-		data, err := (*EncryptInt(encryptionKey, v)).ToBytes()
+// EncryptDpClearResponse encrypts a DP response. Each ciphertext comes with a proof that the data provider (dpID) knows
+// its plaintext, which is only valid for the given survey.
+func EncryptDpClearResponse(ccr DpClearResponse, encryptionKey kyber.Point, count bool, surveyID, dpID string) (DpResponseToSend, error) {
+	return encryptDpClearResponse(ccr, func(v int64) (*CipherText, kyber.Scalar) { return EncryptIntGetR(encryptionKey, v) }, count, surveyID, dpID)
+}
+
+// EncryptDpClearResponseWithEncryptor encrypts a DP response using the precomputed tables of an Encryptor
+func EncryptDpClearResponseWithEncryptor(ccr DpClearResponse, encryptor *Encryptor, count bool, surveyID, dpID string) (DpResponseToSend, error) {
+	return encryptDpClearResponse(ccr, encryptor.EncryptIntGetR, count, surveyID, dpID)
+}
+
+// encryptMap encrypts all the values of a map, transforms the ciphertexts in bytes and proves the knowledge of their
+// plaintexts
+func encryptMap(clear map[string]int64, encrypt func(int64) (*CipherText, kyber.Scalar), surveyID, dpID string) (map[string][]byte, map[string][]byte, error) {
+	result := make(map[string][]byte, len(clear))
+	proofs := make(map[string][]byte, len(clear))
+	for i, v := range clear {
+		ct, r := encrypt(v)
+		data, err := ct.ToBytes()
 		if err != nil {
-			return DpResponseToSend{}, err
+			return nil, nil, err
 		}
-		cr.GroupByEnc[i] = data
-	}
-	//cr.GroupByEnc = *EncryptIntVector(encryptionKey, ccr.GroupByEnc)
-	cr.WhereClear = ccr.WhereClear
-	cr.WhereEnc = make(map[string][]byte, len(ccr.WhereEnc))
-	for i, v := range ccr.WhereEnc {
-		data, err := (*EncryptInt(encryptionKey, v)).ToBytes()
+		result[i] = data
+		proofs[i], err = KnowledgeProofCreation(*ct, r, surveyID, dpID)
 		if err != nil {
-			return DpResponseToSend{}, err
+			return nil, nil, err
 		}
-		cr.WhereEnc[i] = data
 	}
-	//cr.WhereEnc = *EncryptIntVector(encryptionKey, ccr.WhereEnc)
+	return result, proofs, nil
+}
+
+func encryptDpClearResponse(ccr DpClearResponse, encrypt func(int64) (*CipherText, kyber.Scalar), count bool, surveyID, dpID string) (DpResponseToSend, error) {
+	ccr, err := ccr.withMoments()
+	if err != nil {
+		return DpResponseToSend{}, err
+	}
+	cr := DpResponseToSend{}
+	cr.GroupByClear = ccr.GroupByClear
+	cr.GroupByEnc, cr.GroupByEncProofs, err = encryptMap(ccr.GroupByEnc, encrypt, surveyID, dpID)
+	if err != nil {
+		return DpResponseToSend{}, err
+	}
+	cr.WhereClear = ccr.WhereClear
+	cr.WhereEnc, cr.WhereEncProofs, err = encryptMap(ccr.WhereEnc, encrypt, surveyID, dpID)
+	if err != nil {
+		return DpResponseToSend{}, err
+	}
 	cr.AggregatingAttributesClear = ccr.AggregatingAttributesClear
-	cr.AggregatingAttributesEnc = make(map[string][]byte, len(ccr.AggregatingAttributesEnc))
-	for i, v := range ccr.AggregatingAttributesEnc {
-		data, err := (*EncryptInt(encryptionKey, v)).ToBytes()
-		if err != nil {
-			return DpResponseToSend{}, err
-		}
-		cr.AggregatingAttributesEnc[i] = data
+	cr.AggregatingAttributesEnc, cr.AggregatingAttributesEncProofs, err = encryptMap(ccr.AggregatingAttributesEnc, encrypt, surveyID, dpID)
+	if err != nil {
+		return DpResponseToSend{}, err
 	}
 	if count {
-		data, err := (*EncryptInt(encryptionKey, int64(1))).ToBytes()
+		countMap, countProofs, err := encryptMap(map[string]int64{"count": 1}, encrypt, surveyID, dpID)
 		if err != nil {
 			return DpResponseToSend{}, err
 		}
-		cr.AggregatingAttributesEnc["count"] = data
+		cr.AggregatingAttributesEnc["count"] = countMap["count"]
+		cr.AggregatingAttributesEncProofs["count"] = countProofs["count"]
 	}
 
 	return cr, nil
@@ -258,7 +292,7 @@ func (cv *FilteredResponse) FromBytes(data []byte, aabLength, pgaebLength int) e
 	(*cv).AggregatingAttributes = make(CipherVector, aabLength)
 	(*cv).GroupByEnc = make(CipherVector, pgaebLength)
 
-	lengthCipher := 2 * SuiTe.PointLen()
+	lengthCipher := CipherTextByteSize()
 	aabByteLength := aabLength * lengthCipher
 	pgaebByteLength := pgaebLength * lengthCipher
 
@@ -296,8 +330,8 @@ func (crd *FilteredResponseDet) FromBytes(data []byte, gacbLength, aabLength, dt
 	(*crd).Fr.AggregatingAttributes = make(CipherVector, aabLength)
 	(*crd).Fr.GroupByEnc = make(CipherVector, gacbLength)
 
-	lengthCipher := 2 * SuiTe.PointLen()
-	aabByteLength := aabLength * lengthCipher //CAREFUL: hardcoded 64 (size of el-gamal element C,K)
+	lengthCipher := CipherTextByteSize()
+	aabByteLength := aabLength * lengthCipher
 	gacbByteLength := gacbLength * lengthCipher
 
 	aab := data[:aabByteLength]
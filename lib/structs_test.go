@@ -86,7 +86,7 @@ func TestEncryptDpClearResponse(t *testing.T) {
 		AggregatingAttributesEnc:   aggrEnc,
 	}
 
-	cr, err := libunlynx.EncryptDpClearResponse(ccr, pubKey, false)
+	cr, err := libunlynx.EncryptDpClearResponse(ccr, pubKey, false, "survey", "dp")
 	assert.NoError(t, err)
 
 	assert.Equal(t, ccr.GroupByClear, groupingClear)
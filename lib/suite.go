@@ -1,8 +1,67 @@
 package libunlynx
 
 import (
+	"fmt"
+
 	"go.dedis.ch/kyber/v3/suites"
 )
 
-// SuiTe in this case is the ed25519 curve
-var SuiTe = suites.MustFind("Ed25519")
+// DefaultSuite is the name of the suite used if none is selected (the ed25519 curve)
+const DefaultSuite = "Ed25519"
+
+// suiteIDs contains the suites that can be selected and their identifier in serialized data (e.g. ciphertexts and
+// proofs). Only the suites tested with the protocols and proofs of UnLynx are listed.
+var suiteIDs = map[string]byte{
+	"Ed25519": 1,
+	"P256":    2,
+}
+
+// SuiTe is the suite (group) used to encrypt and prove, the ed25519 curve by default. All servers and clients must use
+// the same suite.
+var SuiTe = suites.MustFind(DefaultSuite)
+
+// SetSuite selects the suite by its name (e.g. Ed25519 or P256). It should be called when configuring a server or a
+// client, before any key or ciphertext is created: these are only valid in the suite they were created in. SuiTe is
+// read without synchronization, so SetSuite must not be called while the suite is in use (e.g. by a running service).
+func SetSuite(name string) error {
+	if _, ok := suiteIDs[name]; !ok {
+		return fmt.Errorf("unsupported suite %s", name)
+	}
+	suite, err := suites.Find(name)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	SuiTe = suite
+	// the decoded points are only valid in the previous suite
+	babyStepsTables = make(map[int64]*babyStepsTable)
+	PointToInt.Clear()
+	mutex.Unlock()
+
+	if dt := getDecryptionTable(); dt != nil && dt.Suite != name {
+		SetDecryptionTable(nil)
+	}
+	return nil
+}
+
+// SuiteID returns the identifier of the current suite in serialized data.
+func SuiteID() byte {
+	return suiteIDs[SuiTe.String()]
+}
+
+// AddSuitePrefix prefixes serialized data with the identifier of the current suite.
+func AddSuitePrefix(data []byte) []byte {
+	return append([]byte{SuiteID()}, data...)
+}
+
+// RemoveSuitePrefix checks that serialized data was produced with the current suite and removes its suite prefix.
+func RemoveSuitePrefix(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no suite identifier in empty data")
+	}
+	if data[0] != SuiteID() {
+		return nil, fmt.Errorf("data serialized with suite %d cannot be read with suite %s (%d)", data[0], SuiTe.String(), SuiteID())
+	}
+	return data[1:], nil
+}
@@ -0,0 +1,45 @@
+package libunlynx_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSetSuite tests that ciphertexts can be used in the selected suite only
+func TestSetSuite(t *testing.T) {
+	defer func() {
+		require.NoError(t, libunlynx.SetSuite(libunlynx.DefaultSuite))
+	}()
+
+	assert.Error(t, libunlynx.SetSuite("Unknown"))
+	// a suite of kyber that is not supported by UnLynx
+	assert.Error(t, libunlynx.SetSuite("bn256.G1"))
+	assert.Equal(t, libunlynx.DefaultSuite, libunlynx.SuiTe.String())
+
+	_, pubKey := libunlynx.GenKey()
+	ctb, err := libunlynx.EncryptInt(pubKey, 42).ToBytes()
+	require.NoError(t, err)
+
+	require.NoError(t, libunlynx.SetSuite("P256"))
+	assert.Equal(t, "P256", libunlynx.SuiTe.String())
+
+	secKey, pubKey := libunlynx.GenKey()
+	ct := libunlynx.EncryptInt(pubKey, 42)
+	assert.Equal(t, int64(42), libunlynx.DecryptInt(secKey, *ct))
+
+	// round trip in the new suite
+	newCtb, err := ct.ToBytes()
+	require.NoError(t, err)
+	assert.Equal(t, libunlynx.CipherTextByteSize(), len(newCtb))
+	newCT := libunlynx.CipherText{}
+	require.NoError(t, newCT.FromBytes(newCtb))
+	assert.Equal(t, int64(42), libunlynx.DecryptInt(secKey, newCT))
+
+	// a ciphertext serialized in another suite is refused
+	assert.Error(t, newCT.FromBytes(ctb))
+	_, err = libunlynx.RemoveSuitePrefix(append([]byte{0}, newCtb[1:]...))
+	assert.Error(t, err)
+}
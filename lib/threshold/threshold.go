@@ -0,0 +1,121 @@
+package libunlynxthreshold
+
+import (
+	"fmt"
+
+	"github.com/ldsec/unlynx/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/share"
+	dkg "go.dedis.ch/kyber/v3/share/dkg/pedersen"
+)
+
+// KeyShare is a server's share of a distributed (t-of-n) collective key. The collective secret key is never known by
+// anyone: any Threshold participants can jointly use it (e.g. to key switch or decrypt) by combining their shares.
+type KeyShare struct {
+	// Participants are the public keys of the servers that generated the key, ordered by their index in the
+	// distributed key generation
+	Participants []kyber.Point
+	Threshold    int
+
+	// Commits are the coefficients of the public polynomial: Commits[0] is the collective public key
+	Commits []kyber.Point
+	Share   *share.PriShare
+}
+
+// NewKeyShare creates a KeyShare from the result of a distributed key generation run by the participants.
+func NewKeyShare(participants []kyber.Point, threshold int, dks *dkg.DistKeyShare) *KeyShare {
+	return &KeyShare{Participants: participants, Threshold: threshold, Commits: dks.Commits, Share: dks.Share}
+}
+
+// Public returns the collective public key.
+func (ks *KeyShare) Public() kyber.Point {
+	return ks.Commits[0]
+}
+
+// Index returns the index of a participant in the distributed key generation.
+func (ks *KeyShare) Index(pub kyber.Point) (int, error) {
+	for i, p := range ks.Participants {
+		if p.Equal(pub) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%v is not a participant of the distributed key", pub)
+}
+
+// PublicShare returns the public counterpart of the share held by the participant with the given index.
+func (ks *KeyShare) PublicShare(index int) kyber.Point {
+	return share.NewPubPoly(libunlynx.SuiTe, libunlynx.SuiTe.Point().Base(), ks.Commits).Eval(index).V
+}
+
+// Secret returns the contribution of this share to the collective secret key when the key is used by the given
+// signers (which must include this participant): the secret key is the sum of the contributions of the signers.
+func (ks *KeyShare) Secret(signers []kyber.Point) (kyber.Scalar, error) {
+	lambda, err := ks.lagrangeCoefficient(ks.Share.I, signers)
+	if err != nil {
+		return nil, err
+	}
+	return libunlynx.SuiTe.Scalar().Mul(lambda, ks.Share.V), nil
+}
+
+// PublicContribution returns the public counterpart of the contribution of a participant to the collective secret key
+// when the key is used by the given signers, i.e. Secret(signers)*B as computed by this participant.
+func (ks *KeyShare) PublicContribution(participant kyber.Point, signers []kyber.Point) (kyber.Point, error) {
+	index, err := ks.Index(participant)
+	if err != nil {
+		return nil, err
+	}
+	lambda, err := ks.lagrangeCoefficient(index, signers)
+	if err != nil {
+		return nil, err
+	}
+	return libunlynx.SuiTe.Point().Mul(lambda, ks.PublicShare(index)), nil
+}
+
+// lagrangeCoefficient computes the Lagrange coefficient of the share with the given index when the key is used by the
+// given signers (which must include this index)
+func (ks *KeyShare) lagrangeCoefficient(index int, signers []kyber.Point) (kyber.Scalar, error) {
+	if len(signers) < ks.Threshold {
+		return nil, fmt.Errorf("%d server(s) cannot use a distributed key with threshold %d", len(signers), ks.Threshold)
+	}
+
+	indices := make([]int, len(signers))
+	own := false
+	for i, s := range signers {
+		var err error
+		indices[i], err = ks.Index(s)
+		if err != nil {
+			return nil, err
+		}
+		if indices[i] == index {
+			own = true
+		}
+	}
+	if !own {
+		return nil, fmt.Errorf("participant %d is not one of the signers", index)
+	}
+
+	return LagrangeCoefficient(index, indices)
+}
+
+// LagrangeCoefficient computes the Lagrange coefficient (at 0) of the share with the given index, for the
+// interpolation over the shares of the given indices. Share i is the evaluation of the polynomial at i+1.
+func LagrangeCoefficient(index int, indices []int) (kyber.Scalar, error) {
+	xi := libunlynx.SuiTe.Scalar().SetInt64(int64(index + 1))
+	num := libunlynx.SuiTe.Scalar().One()
+	den := libunlynx.SuiTe.Scalar().One()
+
+	seen := make(map[int]bool, len(indices))
+	for _, j := range indices {
+		if seen[j] {
+			return nil, fmt.Errorf("duplicate share index %d", j)
+		}
+		seen[j] = true
+		if j == index {
+			continue
+		}
+		xj := libunlynx.SuiTe.Scalar().SetInt64(int64(j + 1))
+		num.Mul(num, xj)
+		den.Mul(den, libunlynx.SuiTe.Scalar().Sub(xj, xi))
+	}
+	return libunlynx.SuiTe.Scalar().Div(num, den), nil
+}
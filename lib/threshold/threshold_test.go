@@ -0,0 +1,89 @@
+package libunlynxthreshold_test
+
+import (
+	"testing"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/threshold"
+	"github.com/stretchr/testify/assert"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/share"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/kyber/v3/util/random"
+)
+
+// dealKeyShares creates the shares of a random t-of-n key (without a distributed key generation)
+func dealKeyShares(t, n int) (kyber.Scalar, []*libunlynxthreshold.KeyShare) {
+	secret := libunlynx.SuiTe.Scalar().Pick(random.New())
+	priPoly := share.NewPriPoly(libunlynx.SuiTe, t, secret, random.New())
+	_, commits := priPoly.Commit(libunlynx.SuiTe.Point().Base()).Info()
+
+	participants := make([]kyber.Point, n)
+	for i := range participants {
+		participants[i] = key.NewKeyPair(libunlynx.SuiTe).Public
+	}
+
+	shares := make([]*libunlynxthreshold.KeyShare, n)
+	for i, s := range priPoly.Shares(n) {
+		shares[i] = &libunlynxthreshold.KeyShare{Participants: participants, Threshold: t, Commits: commits, Share: s}
+	}
+	return secret, shares
+}
+
+func TestKeyShareSecret(t *testing.T) {
+	secret, shares := dealKeyShares(3, 5)
+	participants := shares[0].Participants
+
+	assert.True(t, shares[0].Public().Equal(libunlynx.SuiTe.Point().Mul(secret, nil)))
+	for i, ks := range shares {
+		assert.True(t, ks.PublicShare(i).Equal(libunlynx.SuiTe.Point().Mul(ks.Share.V, nil)))
+	}
+
+	for _, signersIdx := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		signers := make([]kyber.Point, len(signersIdx))
+		for i, idx := range signersIdx {
+			signers[i] = participants[idx]
+		}
+
+		sum := libunlynx.SuiTe.Scalar().Zero()
+		for _, idx := range signersIdx {
+			contribution, err := shares[idx].Secret(signers)
+			assert.NoError(t, err)
+			sum.Add(sum, contribution)
+
+			// anyone can compute the public counterpart of the contribution
+			public, err := shares[0].PublicContribution(participants[idx], signers)
+			assert.NoError(t, err)
+			assert.True(t, public.Equal(libunlynx.SuiTe.Point().Mul(contribution, nil)))
+		}
+		assert.True(t, sum.Equal(secret))
+	}
+
+	// not enough signers
+	_, err := shares[0].Secret(participants[:2])
+	assert.Error(t, err)
+	// the share holder is not a signer
+	_, err = shares[0].Secret(participants[1:4])
+	assert.Error(t, err)
+	// unknown signer
+	_, err = shares[0].Secret([]kyber.Point{participants[0], participants[1], key.NewKeyPair(libunlynx.SuiTe).Public})
+	assert.Error(t, err)
+	// duplicate signer
+	_, err = shares[0].Secret([]kyber.Point{participants[0], participants[1], participants[1]})
+	assert.Error(t, err)
+}
+
+func TestThresholdDecryption(t *testing.T) {
+	_, shares := dealKeyShares(2, 3)
+	ct := libunlynx.EncryptInt(shares[0].Public(), 42)
+
+	signers := []kyber.Point{shares[0].Participants[2], shares[0].Participants[1]}
+	partial := libunlynx.SuiTe.Point().Null()
+	for _, ks := range shares[1:] {
+		contribution, err := ks.Secret(signers)
+		assert.NoError(t, err)
+		partial.Add(partial, libunlynx.SuiTe.Point().Mul(contribution, ct.K))
+	}
+	M := libunlynx.SuiTe.Point().Sub(ct.C, partial)
+	assert.True(t, M.Equal(libunlynx.IntToPoint(42)))
+}
@@ -0,0 +1,252 @@
+// Package protocolsunlynx implements the cell size protocol.
+// It determines which encrypted counts are below a minimum cell size without revealing them: the root compares each
+// count to every value below the minimum and each server blinds and permutes these comparisons and removes its
+// contribution to the collective key. Only the comparisons with a count equal to the value decrypt to 0.
+// This protocol operates in a circuit between the servers: the data is sent sequentially through this circuit and
+// each server applies its transformation.
+// If proofs are requested, each server shuffles the comparisons instead of only permuting them and proves the shuffle,
+// the blinding and the removal of its contribution. The proofs are sent along the circuit and the root verifies them
+// before testing the counts, so that no server can hide a group below the minimum or suppress another one.
+package protocolsunlynx
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/cell_size"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// CellSizeProtocolName is the registered name for the cell size protocol.
+const CellSizeProtocolName = "CellSize"
+
+func init() {
+	network.RegisterMessage(CellSizeMessage{})
+	_, err := onet.GlobalProtocolRegister(CellSizeProtocolName, NewCellSizeProtocol)
+	log.ErrFatal(err, "Failed to register the <CellSize> protocol:")
+}
+
+// Messages
+//______________________________________________________________________________________________________________________
+
+// CellSizeMessage contains the threshold tests of the groups (see libunlynxcellsize.ThresholdTests) in bytes, and the
+// blinding proofs of the servers which blinded them if proofs were requested
+type CellSizeMessage struct {
+	Data           []byte
+	CVLengths      []byte
+	Proofs         bool
+	BlindingProofs []libunlynxcellsize.PublishedBlindingListProofBytes
+}
+
+// Structs
+//______________________________________________________________________________________________________________________
+
+// cellSizeStruct contains a cell size message
+type cellSizeStruct struct {
+	*onet.TreeNode
+	CellSizeMessage
+}
+
+// Protocol
+//______________________________________________________________________________________________________________________
+
+// CellSizeProtocol holds the state of a cell size protocol instance.
+type CellSizeProtocol struct {
+	*onet.TreeNodeInstance
+	ContributionKeys
+
+	// Protocol feedback channel: whether the count of each group is below the minimum cell size
+	FeedbackChannel chan []bool
+
+	// Protocol communication channels
+	PreviousNodeInPathChannel chan cellSizeStruct
+
+	// Protocol state data
+	TargetOfTest      *libunlynx.CipherVector // encrypted counts of the groups
+	MinCellSize       int64
+	Publics           []kyber.Point // current public keys of the servers if some were rotated
+	nextNodeInCircuit *onet.TreeNode
+	position          int                      // position of the node in the circuit
+	tests             []libunlynx.CipherVector // threshold tests before any blinding (root only, if Proofs)
+
+	// Proofs
+	Proofs bool
+
+	// Test (only use in order to test the protocol)
+	ExecTime time.Duration
+}
+
+// NewCellSizeProtocol constructs cell size protocol instances.
+func NewCellSizeProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	csp := &CellSizeProtocol{
+		TreeNodeInstance: n,
+		FeedbackChannel:  make(chan []bool),
+	}
+
+	if err := csp.RegisterChannel(&csp.PreviousNodeInPathChannel); err != nil {
+		return nil, fmt.Errorf("couldn't register data reference channel: %v", err)
+	}
+
+	// choose next node in circuit
+	nodeList := n.Tree().List()
+	for i, node := range nodeList {
+		if n.TreeNode().Equal(node) {
+			csp.nextNodeInCircuit = nodeList[(i+1)%len(nodeList)]
+			csp.position = i
+			break
+		}
+	}
+
+	return csp, nil
+}
+
+// Start is called at the root node and starts the execution of the protocol.
+func (p *CellSizeProtocol) Start() error {
+	if p.TargetOfTest == nil {
+		return fmt.Errorf("no counts given as cell size target")
+	}
+	if p.MinCellSize <= 0 || p.MinCellSize > libunlynxcellsize.MaxMinCellSize {
+		return fmt.Errorf("minimum cell size %d is not in [1, %d]", p.MinCellSize, libunlynxcellsize.MaxMinCellSize)
+	}
+
+	log.Lvl1("["+p.Name()+"]", " started a Cell Size Protocol (", len(*p.TargetOfTest), " groups)")
+	timer := time.Now()
+
+	tests := make([]libunlynx.CipherVector, len(*p.TargetOfTest))
+	for i, count := range *p.TargetOfTest {
+		tests[i] = libunlynxcellsize.ThresholdTests(count, p.MinCellSize)
+	}
+	if p.Proofs {
+		p.tests = tests
+	}
+	blinded, proofs, err := p.blind(tests, nil)
+	if err != nil {
+		return err
+	}
+
+	p.ExecTime += time.Since(timer)
+	return p.sendToNext(blinded, proofs)
+}
+
+// Dispatch is called on each tree node. It waits for incoming messages and handles them.
+func (p *CellSizeProtocol) Dispatch() error {
+	defer p.Done()
+
+	var css cellSizeStruct
+	select {
+	case css = <-p.PreviousNodeInPathChannel:
+	case <-time.After(libunlynx.TIMEOUT):
+		return fmt.Errorf(p.ServerIdentity().String() + " didn't get the <CellSizeMessage> on time")
+	}
+
+	tests, err := libunlynx.FromBytesToArrayCipherVector(css.Data, css.CVLengths)
+	if err != nil {
+		return err
+	}
+
+	timer := time.Now()
+
+	// If this tree node is the root, then protocol reached the end: all the contributions were removed.
+	if p.IsRoot() {
+		if p.Proofs {
+			if err := p.verifyProofs(css.BlindingProofs, tests); err != nil {
+				return err
+			}
+		}
+		below := make([]bool, len(tests))
+		for i, t := range tests {
+			below[i] = libunlynxcellsize.BelowThreshold(t)
+		}
+		p.ExecTime += time.Since(timer)
+		log.Lvl1(p.ServerIdentity(), " completed the cell size tests (", len(tests), " groups)")
+		p.FeedbackChannel <- below
+		return nil
+	}
+
+	p.Proofs = css.Proofs
+	blinded, proofs, err := p.blind(tests, css.BlindingProofs)
+	if err != nil {
+		return err
+	}
+	log.Lvl1(p.ServerIdentity(), " carried on the cell size tests.")
+	return p.sendToNext(blinded, proofs)
+}
+
+// blind blinds the threshold tests and removes the contribution of the server to the collective key. If proofs were
+// requested, the proofs of the server are appended to the ones of the previous servers.
+func (p *CellSizeProtocol) blind(tests []libunlynx.CipherVector, proofs []libunlynxcellsize.PublishedBlindingListProofBytes) ([]libunlynx.CipherVector, []libunlynxcellsize.PublishedBlindingListProofBytes, error) {
+	secretKey, _, err := SecretContribution(p.TreeNodeInstance, p.PrivateKey, p.ThresholdKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.Proofs {
+		return libunlynxcellsize.BlindSequence(tests, secretKey), nil, nil
+	}
+
+	keys, _, err := p.circuitKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	blinded, pblp, err := libunlynxcellsize.BlindSequenceWithProof(tests, secretKey, keys[p.position])
+	if err != nil {
+		return nil, nil, err
+	}
+	pblpb, err := pblp.ToBytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return blinded, append(proofs, pblpb), nil
+}
+
+// verifyProofs checks (at the root) that every server of the circuit correctly blinded the threshold tests
+func (p *CellSizeProtocol) verifyProofs(proofs []libunlynxcellsize.PublishedBlindingListProofBytes, blinded []libunlynx.CipherVector) error {
+	pblps := make([]libunlynxcellsize.PublishedBlindingListProof, len(proofs))
+	for i, pblpb := range proofs {
+		if err := pblps[i].FromBytes(pblpb); err != nil {
+			return err
+		}
+	}
+	keys, publics, err := p.circuitKeys()
+	if err != nil {
+		return err
+	}
+	if !libunlynxcellsize.BlindingListProofVerification(pblps, p.tests, blinded, keys, publics) {
+		return fmt.Errorf("wrong cell size blinding proofs")
+	}
+	return nil
+}
+
+// circuitKeys returns, in the order of the circuit, the keys left to remove from the threshold tests when each node
+// blinds them and the public contributions of the nodes (see PublicContributions)
+func (p *CellSizeProtocol) circuitKeys() ([]kyber.Point, []kyber.Point, error) {
+	contributions, err := PublicContributions(p.TreeNodeInstance, p.Publics, p.ThresholdKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes := p.Tree().List()
+	publics := make([]kyber.Point, len(nodes))
+	h := libunlynx.SuiTe.Point().Null()
+	for i, node := range nodes {
+		publics[i] = contributions[node.RosterIndex]
+		h.Add(h, publics[i])
+	}
+	keys := make([]kyber.Point, len(nodes))
+	for i := range nodes {
+		keys[i] = h.Clone()
+		h.Sub(h, publics[i])
+	}
+	return keys, publics, nil
+}
+
+// Sends the threshold tests to the next node in the circuit based on the next TreeNode in Tree.List().
+func (p *CellSizeProtocol) sendToNext(tests []libunlynx.CipherVector, proofs []libunlynxcellsize.PublishedBlindingListProofBytes) error {
+	data, cvLengths, err := libunlynx.ArrayCipherVectorToBytes(tests)
+	if err != nil {
+		return err
+	}
+	return p.SendTo(p.nextNodeInCircuit, &CellSizeMessage{Data: data, CVLengths: cvLengths, Proofs: p.Proofs, BlindingProofs: proofs})
+}
@@ -0,0 +1,50 @@
+package protocolsunlynx_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/protocols"
+	"github.com/stretchr/testify/assert"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+func TestCellSize(t *testing.T) {
+	testCellSize(t, false)
+}
+
+func TestCellSizeWithProofs(t *testing.T) {
+	testCellSize(t, true)
+}
+
+func testCellSize(t *testing.T, proofs bool) {
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, entityList, tree := local.GenTree(5, true)
+	defer local.CloseAll()
+
+	rootInstance, err := local.CreateProtocol(protocolsunlynx.CellSizeProtocolName, tree)
+	if err != nil {
+		t.Fatal("Couldn't start protocol:", err)
+	}
+	protocol := rootInstance.(*protocolsunlynx.CellSizeProtocol)
+
+	counts := []int64{1, 2, 3, 6, 0, 10, 4}
+	protocol.TargetOfTest = libunlynx.EncryptIntVector(entityList.Aggregate, counts)
+	protocol.MinCellSize = 4
+	protocol.Proofs = proofs
+
+	go func() {
+		err := protocol.Start()
+		assert.NoError(t, err)
+	}()
+
+	timeout := network.WaitRetry * time.Duration(network.MaxRetryConnect*10) * time.Millisecond
+	select {
+	case below := <-protocol.FeedbackChannel:
+		assert.Equal(t, []bool{true, true, true, false, true, false, false}, below)
+	case <-time.After(timeout):
+		t.Fatal("Didn't finish in time")
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/ldsec/unlynx/lib"
 	"github.com/ldsec/unlynx/lib/aggregation"
+	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -101,8 +102,9 @@ type CollectiveAggregationProtocol struct {
 	ChildDataChannel     chan []childAggregatedDataBytesStruct
 
 	// Protocol state data
-	GroupedData *map[libunlynx.GroupingKey]libunlynx.FilteredResponse
-	SimpleData  *[]libunlynx.CipherText
+	GroupedData   *map[libunlynx.GroupingKey]libunlynx.FilteredResponse
+	SimpleData    *[]libunlynx.CipherText
+	CollectiveKey kyber.Point // collective key used to encrypt the data if it is not the roster aggregate
 
 	// Proofs
 	Proofs    bool
@@ -238,9 +240,13 @@ func (p *CollectiveAggregationProtocol) ascendingAggregationPhase(cvMap map[libu
 
 				if ok {
 					if len(localAggr.AggregatingAttributes) != len(aggr.Fr.AggregatingAttributes) {
+						collectiveKey := p.Roster().Aggregate
+						if p.CollectiveKey != nil {
+							collectiveKey = p.CollectiveKey
+						}
 						encZeros := make(libunlynx.CipherVector, int(math.Abs(float64(len(localAggr.AggregatingAttributes)-len(aggr.Fr.AggregatingAttributes)))))
 						for e := range encZeros {
-							encZeros[e] = *libunlynx.EncryptInt(p.Roster().Aggregate, 0)
+							encZeros[e] = *libunlynx.EncryptInt(collectiveKey, 0)
 						}
 						if len(localAggr.AggregatingAttributes) > len(aggr.Fr.AggregatingAttributes) {
 							aggr.Fr.AggregatingAttributes = append(aggr.Fr.AggregatingAttributes, encZeros...)
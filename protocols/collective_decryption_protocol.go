@@ -0,0 +1,251 @@
+// The collective decryption protocol decrypts ciphertexts encrypted under the collective key of the servers taking
+// part in it, or under a distributed (t-of-n) key if any t servers of the key's roster take part in it.
+// It uses a tree structure:
+// 1. the root sends down the ephemeral keys (rB) of the ciphertexts;
+// 2. each server computes its partial decryptions;
+// 3. parent nodes aggregate the partial decryptions of their children and forward them up the tree;
+// 4. the root removes the aggregated partial decryptions from the ciphertexts and outputs the plaintext points.
+// If proofs are requested, each server also proves that its partial decryptions are correct and the proofs are sent up
+// with the partial decryptions. The root verifies them before outputting the plaintext points, and keeps them such that
+// anyone holding the servers' public keys can check the decryption.
+
+package protocolsunlynx
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/decryption"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// CollectiveDecryptionProtocolName is the registered name for the collective decryption protocol.
+const CollectiveDecryptionProtocolName = "CollectiveDecryption"
+
+func init() {
+	network.RegisterMessage(DecryptionDownMessage{})
+	network.RegisterMessage(DecryptionUpMessage{})
+	_, err := onet.GlobalProtocolRegister(CollectiveDecryptionProtocolName, NewCollectiveDecryptionProtocol)
+	log.ErrFatal(err, "Failed to register the <CollectiveDecryption> protocol:")
+}
+
+// Messages
+//______________________________________________________________________________________________________________________
+
+// DecryptionDownMessage message sent down the tree containing all the rB (left part of ciphertexts) in bytes
+type DecryptionDownMessage struct {
+	Data   []byte
+	Proofs bool
+}
+
+// DecryptionUpMessage contains the aggregated partial decryptions of a subtree in bytes, and the decryption proofs of
+// each server of the subtree if proofs were requested
+type DecryptionUpMessage struct {
+	Data   []byte
+	Proofs []libunlynxdecrypt.PublishedDecryptionProofBytes
+}
+
+// Structs
+//______________________________________________________________________________________________________________________
+
+type decryptionDownStruct struct {
+	*onet.TreeNode
+	DecryptionDownMessage
+}
+
+type decryptionUpStruct struct {
+	*onet.TreeNode
+	DecryptionUpMessage
+}
+
+// Protocol
+//______________________________________________________________________________________________________________________
+
+// CollectiveDecryptionProtocol performs the collective decryption of ciphertexts.
+type CollectiveDecryptionProtocol struct {
+	*onet.TreeNodeInstance
+	ContributionKeys
+
+	// Protocol feedback channel
+	FeedbackChannel chan []kyber.Point
+
+	// Protocol communication channels
+	DownChannel      chan decryptionDownStruct
+	ChildDataChannel chan []decryptionUpStruct
+
+	// Protocol root data
+	TargetOfDecryption *libunlynx.CipherVector
+	DecryptionProofs   libunlynxdecrypt.PublishedDecryptionListProof // verified proofs of all the servers (if Proofs)
+	Publics            []kyber.Point                                 // current public keys of the servers if some were rotated
+
+	// Protocol state data
+	PartialDecryptions []kyber.Point
+	ProofsBytes        []libunlynxdecrypt.PublishedDecryptionProofBytes
+
+	// Proofs
+	Proofs bool
+}
+
+// NewCollectiveDecryptionProtocol initializes the protocol instance.
+func NewCollectiveDecryptionProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	pap := &CollectiveDecryptionProtocol{
+		TreeNodeInstance: n,
+		FeedbackChannel:  make(chan []kyber.Point),
+	}
+
+	if err := pap.RegisterChannel(&pap.DownChannel); err != nil {
+		return nil, fmt.Errorf("couldn't register down channel: %v", err)
+	}
+	if err := pap.RegisterChannel(&pap.ChildDataChannel); err != nil {
+		return nil, fmt.Errorf("couldn't register child-data channel: %v", err)
+	}
+
+	return pap, nil
+}
+
+// Start is called at the root to begin the execution of the protocol.
+func (p *CollectiveDecryptionProtocol) Start() error {
+	if p.TargetOfDecryption == nil {
+		return fmt.Errorf("no ciphertext given as decryption target")
+	}
+
+	log.Lvl2("["+p.Name()+"]", " started a Collective Decryption Protocol (", len(*p.TargetOfDecryption), " ciphertexts)")
+
+	rBs := make([]kyber.Point, len(*p.TargetOfDecryption))
+	for i, v := range *p.TargetOfDecryption {
+		rBs[i] = v.K
+	}
+	data, err := libunlynx.AbstractPointsToBytes(rBs)
+	if err != nil {
+		return err
+	}
+
+	// the root computes its partial decryptions as any other server
+	p.DownChannel <- decryptionDownStruct{TreeNode: p.TreeNode(), DecryptionDownMessage: DecryptionDownMessage{Data: data, Proofs: p.Proofs}}
+	return nil
+}
+
+// Dispatch is called at each node and handle incoming messages.
+func (p *CollectiveDecryptionProtocol) Dispatch() error {
+	defer p.Done()
+
+	// 1. Announcement phase
+	var down decryptionDownStruct
+	select {
+	case down = <-p.DownChannel:
+	case <-time.After(libunlynx.TIMEOUT):
+		return fmt.Errorf(p.ServerIdentity().String() + " didn't get the <DecryptionDownMessage> on time")
+	}
+	if !p.IsLeaf() {
+		if err := p.SendToChildren(&down.DecryptionDownMessage); err != nil {
+			return fmt.Errorf("Node "+p.ServerIdentity().String()+" failed to broadcast DecryptionDownMessage: %v", err)
+		}
+	}
+	rBs, err := libunlynx.FromBytesToAbstractPoints(down.Data)
+	if err != nil {
+		return err
+	}
+
+	secretKey, _, err := SecretContribution(p.TreeNodeInstance, p.PrivateKey, p.ThresholdKey)
+	if err != nil {
+		return err
+	}
+	p.PartialDecryptions = PartialDecryptionSequence(rBs, secretKey)
+	p.Proofs = down.Proofs
+
+	if p.Proofs {
+		// the proof is serialized before the partial decryptions are aggregated with the children's ones
+		proof, err := libunlynxdecrypt.DecryptionProofCreation(rBs, p.PartialDecryptions, secretKey)
+		if err != nil {
+			return err
+		}
+		proofBytes, err := proof.ToBytes()
+		if err != nil {
+			return err
+		}
+		p.ProofsBytes = []libunlynxdecrypt.PublishedDecryptionProofBytes{proofBytes}
+	}
+
+	// 2. Ascending aggregation phase
+	if !p.IsLeaf() {
+		for _, child := range <-p.ChildDataChannel {
+			childDecryptions, err := libunlynx.FromBytesToAbstractPoints(child.Data)
+			if err != nil {
+				return err
+			}
+			if len(childDecryptions) != len(p.PartialDecryptions) {
+				return fmt.Errorf("got %d partial decryptions instead of %d", len(childDecryptions), len(p.PartialDecryptions))
+			}
+			for i := range p.PartialDecryptions {
+				p.PartialDecryptions[i].Add(p.PartialDecryptions[i], childDecryptions[i])
+			}
+			p.ProofsBytes = append(p.ProofsBytes, child.Proofs...)
+		}
+	}
+
+	if !p.IsRoot() {
+		data, err := libunlynx.AbstractPointsToBytes(p.PartialDecryptions)
+		if err != nil {
+			return err
+		}
+		if err := p.SendToParent(&DecryptionUpMessage{Data: data, Proofs: p.ProofsBytes}); err != nil {
+			return fmt.Errorf("Node "+p.ServerIdentity().String()+" failed to send DecryptionUpMessage: %v", err)
+		}
+		return nil
+	}
+
+	// 3. Proofs verification
+	if p.Proofs {
+		if err := p.verifyProofs(); err != nil {
+			return err
+		}
+	}
+
+	// 4. Response reporting
+	plaintexts := make([]kyber.Point, len(*p.TargetOfDecryption))
+	for i, v := range *p.TargetOfDecryption {
+		plaintexts[i] = libunlynx.SuiTe.Point().Sub(v.C, p.PartialDecryptions[i])
+	}
+	p.FeedbackChannel <- plaintexts
+	return nil
+}
+
+// verifyProofs checks (at the root) that every server of the tree correctly computed its partial decryptions
+func (p *CollectiveDecryptionProtocol) verifyProofs() error {
+	if err := p.DecryptionProofs.FromBytes(libunlynxdecrypt.PublishedDecryptionListProofBytes{List: p.ProofsBytes}); err != nil {
+		return err
+	}
+	publics, err := PublicContributions(p.TreeNodeInstance, p.Publics, p.ThresholdKey)
+	if err != nil {
+		return err
+	}
+	if !libunlynxdecrypt.DecryptionListProofVerification(p.DecryptionProofs, *p.TargetOfDecryption, publics) {
+		return fmt.Errorf("wrong collective decryption proofs")
+	}
+	return nil
+}
+
+// PartialDecryptionSequence computes the partial decryptions (secretKey * rB) of the ciphertexts whose ephemeral keys
+// are given.
+func PartialDecryptionSequence(rBs []kyber.Point, secretKey kyber.Scalar) []kyber.Point {
+	partials := make([]kyber.Point, len(rBs))
+
+	var wg sync.WaitGroup
+	for i := 0; i < len(rBs); i = i + libunlynx.VPARALLELIZE {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < libunlynx.VPARALLELIZE && (j+i < len(rBs)); j++ {
+				partials[i+j] = libunlynx.SuiTe.Point().Mul(secretKey, rBs[i+j])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return partials
+}
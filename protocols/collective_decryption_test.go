@@ -0,0 +1,92 @@
+package protocolsunlynx_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/protocols"
+	"github.com/stretchr/testify/assert"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// runCollectiveDecryptionTest decrypts the data (encrypted under the given key) and checks the plaintexts, and the
+// decryption proofs if requested.
+func runCollectiveDecryptionTest(t *testing.T, local *onet.LocalTest, name string, tree *onet.Tree, key kyber.Point, proofs bool) {
+	rootInstance, err := local.CreateProtocol(name, tree)
+	if err != nil {
+		t.Fatal("Couldn't start protocol:", err)
+	}
+	protocol := rootInstance.(*protocolsunlynx.CollectiveDecryptionProtocol)
+
+	data := []int64{1, 2, 3, 6, 0, -4, 1000}
+	protocol.TargetOfDecryption = libunlynx.EncryptIntVector(key, data)
+	protocol.Proofs = proofs
+
+	go func() {
+		err := protocol.Start()
+		assert.NoError(t, err)
+	}()
+
+	timeout := network.WaitRetry * time.Duration(network.MaxRetryConnect*10) * time.Millisecond
+	select {
+	case plaintexts := <-protocol.FeedbackChannel:
+		assert.Equal(t, len(data), len(plaintexts))
+		for i, v := range data {
+			assert.True(t, plaintexts[i].Equal(libunlynx.IntToPoint(v)))
+		}
+
+		if proofs {
+			assert.Equal(t, len(tree.Roster.List), len(protocol.DecryptionProofs.List))
+			proofPlaintexts, err := protocol.DecryptionProofs.Plaintexts(*protocol.TargetOfDecryption)
+			assert.NoError(t, err)
+			for i, v := range data {
+				assert.True(t, proofPlaintexts[i].Equal(libunlynx.IntToPoint(v)))
+			}
+		}
+	case <-time.After(timeout):
+		t.Fatal("Didn't finish in time")
+	}
+}
+
+func TestCollectiveDecryption(t *testing.T) {
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, entityList, tree := local.GenTree(5, true)
+	defer local.CloseAll()
+
+	runCollectiveDecryptionTest(t, local, protocolsunlynx.CollectiveDecryptionProtocolName, tree, entityList.Aggregate, false)
+}
+
+func TestCollectiveDecryptionProofs(t *testing.T) {
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, entityList, tree := local.GenTree(5, true)
+	defer local.CloseAll()
+
+	runCollectiveDecryptionTest(t, local, protocolsunlynx.CollectiveDecryptionProtocolName, tree, entityList.Aggregate, true)
+}
+
+func TestThresholdCollectiveDecryption(t *testing.T) {
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	registerDKGTest(t)
+	_, err := onet.GlobalProtocolRegister("ThresholdCollectiveDecryptionTest", NewThresholdCollectiveDecryptionTest)
+	assert.NoError(t, err, "Failed to register the ThresholdCollectiveDecryptionTest protocol")
+
+	_, entityList, tree := local.GenTree(5, true)
+	defer local.CloseAll()
+
+	public := runDKGTest(t, local, tree, 3)
+
+	// only 3 of the 5 servers take part in the decryption
+	subRoster := onet.NewRoster([]*network.ServerIdentity{entityList.List[1], entityList.List[3], entityList.List[4]})
+	runCollectiveDecryptionTest(t, local, "ThresholdCollectiveDecryptionTest", subRoster.GenerateNaryTreeWithRoot(2, entityList.List[1]), public, true)
+}
+
+// NewThresholdCollectiveDecryptionTest is a special purpose protocol constructor specific to tests.
+func NewThresholdCollectiveDecryptionTest(tni *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	pi, err := protocolsunlynx.NewCollectiveDecryptionProtocol(tni)
+	protocol := pi.(*protocolsunlynx.CollectiveDecryptionProtocol)
+	protocol.ThresholdKey = getDKGTestShare(tni)
+	return protocol, err
+}
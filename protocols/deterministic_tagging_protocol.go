@@ -76,6 +76,7 @@ type dtmbLengthStruct struct {
 // DeterministicTaggingProtocol hold the state of a deterministic tagging protocol instance.
 type DeterministicTaggingProtocol struct {
 	*onet.TreeNodeInstance
+	ContributionKeys
 
 	// Protocol feedback channel
 	FeedbackChannel chan []libunlynx.DeterministCipherText
@@ -221,6 +222,11 @@ func (p *DeterministicTaggingProtocol) Dispatch() error {
 		return err
 	}
 
+	secretKey, publicKey, err := SecretContribution(p.TreeNodeInstance, p.PrivateKey, p.ThresholdKey)
+	if err != nil {
+		return err
+	}
+
 	startT = time.Now()
 	roundTotalComputation := libunlynx.StartTimer(p.Name() + "_DetTagging(DISPATCH)")
 
@@ -234,7 +240,7 @@ func (p *DeterministicTaggingProtocol) Dispatch() error {
 				j = len(deterministicTaggingTarget.Data)
 			}
 			cv := deterministicTaggingTarget.Data[i:j]
-			tmpErr := TaggingDet(&cv, p.Private(), *p.SurveySecretKey, p.Public(), p.Proofs)
+			tmpErr := TaggingDet(&cv, secretKey, *p.SurveySecretKey, publicKey, p.Proofs)
 			if tmpErr != nil {
 				mutex.Lock()
 				err = tmpErr
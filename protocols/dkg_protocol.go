@@ -0,0 +1,268 @@
+// The distributed key generation protocol generates a threshold (t-of-n) collective key for the servers of a roster
+// (Pedersen DKG). Each server ends up with a share of the collective secret key, that nobody knows, and any t servers
+// can later use the key (e.g. key switching or collective decryption) without the n-t other servers.
+// 1. the root announces the threshold to all the servers;
+// 2. each server sends its deals to the other servers;
+// 3. each server broadcasts its responses to the deals it received;
+// 4. each server computes its share and notifies the root, which outputs the collective public key.
+// The protocol assumes that all servers are honest during the generation: any complaint aborts it.
+
+package protocolsunlynx
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/threshold"
+	"go.dedis.ch/kyber/v3"
+	dkg "go.dedis.ch/kyber/v3/share/dkg/pedersen"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// DKGProtocolName is the registered name for the distributed key generation protocol.
+const DKGProtocolName = "DKG"
+
+func init() {
+	network.RegisterMessage(DKGAnnouncementMessage{})
+	network.RegisterMessage(DKGDealMessage{})
+	network.RegisterMessage(DKGResponseMessage{})
+	network.RegisterMessage(DKGFinishedMessage{})
+	_, err := onet.GlobalProtocolRegister(DKGProtocolName, NewDKGProtocol)
+	log.ErrFatal(err, "Failed to register the <DKG> protocol:")
+}
+
+// Messages
+//______________________________________________________________________________________________________________________
+
+// DKGAnnouncementMessage is sent by the root to start the key generation.
+type DKGAnnouncementMessage struct {
+	Threshold int
+}
+
+// DKGDealMessage contains the deal of a server for another server.
+type DKGDealMessage struct {
+	Deal *dkg.Deal
+}
+
+// DKGResponseMessage contains the response of a server to a deal.
+type DKGResponseMessage struct {
+	Response *dkg.Response
+}
+
+// DKGFinishedMessage is sent to the root once a server has computed its share.
+type DKGFinishedMessage struct{}
+
+// Structs
+//______________________________________________________________________________________________________________________
+
+type dkgAnnouncementStruct struct {
+	*onet.TreeNode
+	DKGAnnouncementMessage
+}
+
+type dkgDealStruct struct {
+	*onet.TreeNode
+	DKGDealMessage
+}
+
+type dkgResponseStruct struct {
+	*onet.TreeNode
+	DKGResponseMessage
+}
+
+type dkgFinishedStruct struct {
+	*onet.TreeNode
+	DKGFinishedMessage
+}
+
+// keyShareFunction defines a function that does 'stuff' with the generated key share (e.g. store it)
+type keyShareFunction func(*libunlynxthreshold.KeyShare) error
+
+// Protocol
+//______________________________________________________________________________________________________________________
+
+// DKGProtocol performs a distributed key generation between all the servers of the roster.
+type DKGProtocol struct {
+	*onet.TreeNodeInstance
+
+	// Protocol feedback channel
+	FeedbackChannel chan kyber.Point
+
+	// Protocol communication channels
+	AnnouncementChannel chan dkgAnnouncementStruct
+	DealChannel         chan dkgDealStruct
+	ResponseChannel     chan dkgResponseStruct
+	FinishedChannel     chan dkgFinishedStruct
+
+	// Protocol root data
+	Threshold int
+
+	// Protocol state data
+	KeyShare  *libunlynxthreshold.KeyShare
+	ShareFunc keyShareFunction // function called by each server with its share (e.g. to store it)
+}
+
+// NewDKGProtocol initializes the protocol instance.
+func NewDKGProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	pap := &DKGProtocol{
+		TreeNodeInstance: n,
+		FeedbackChannel:  make(chan kyber.Point),
+	}
+
+	nbrNodes := len(n.Tree().List())
+	if err := pap.RegisterChannel(&pap.AnnouncementChannel); err != nil {
+		return nil, fmt.Errorf("couldn't register announcement channel: %v", err)
+	}
+	if err := pap.RegisterChannelLength(&pap.DealChannel, nbrNodes); err != nil {
+		return nil, fmt.Errorf("couldn't register deal channel: %v", err)
+	}
+	// each server receives the responses of all the other servers to all the deals they received
+	if err := pap.RegisterChannelLength(&pap.ResponseChannel, nbrNodes*nbrNodes); err != nil {
+		return nil, fmt.Errorf("couldn't register response channel: %v", err)
+	}
+	if err := pap.RegisterChannelLength(&pap.FinishedChannel, nbrNodes); err != nil {
+		return nil, fmt.Errorf("couldn't register finished channel: %v", err)
+	}
+
+	return pap, nil
+}
+
+// Start is called at the root to begin the execution of the protocol.
+func (p *DKGProtocol) Start() error {
+	nbrNodes := len(p.Roster().List)
+	if p.Threshold < 2 || p.Threshold > nbrNodes {
+		return fmt.Errorf("invalid threshold %d for %d servers", p.Threshold, nbrNodes)
+	}
+	if len(p.Tree().List()) != nbrNodes {
+		return fmt.Errorf("the tree does not contain all the servers of the roster")
+	}
+
+	log.Lvl2("["+p.Name()+"]", " started a DKG Protocol (", p.Threshold, "-of-", nbrNodes, ")")
+
+	announcement := DKGAnnouncementMessage{Threshold: p.Threshold}
+	for _, err := range p.Broadcast(&announcement) {
+		if err != nil {
+			return fmt.Errorf("Root "+p.ServerIdentity().String()+" failed to broadcast DKGAnnouncementMessage: %v", err)
+		}
+	}
+	// the root takes part in the generation as any other server
+	p.AnnouncementChannel <- dkgAnnouncementStruct{TreeNode: p.TreeNode(), DKGAnnouncementMessage: announcement}
+	return nil
+}
+
+// Dispatch is called at each node and handle incoming messages.
+func (p *DKGProtocol) Dispatch() error {
+	defer p.Done()
+
+	var announcement dkgAnnouncementStruct
+	select {
+	case announcement = <-p.AnnouncementChannel:
+	case <-time.After(libunlynx.TIMEOUT):
+		return fmt.Errorf(p.ServerIdentity().String() + " didn't get the <DKGAnnouncementMessage> on time")
+	}
+
+	participants := p.Roster().Publics()
+	generator, err := dkg.NewDistKeyGenerator(libunlynx.SuiTe, p.Private(), participants, announcement.Threshold)
+	if err != nil {
+		return err
+	}
+
+	// 1. Deals
+	deals, err := generator.Deals()
+	if err != nil {
+		return err
+	}
+	for i, deal := range deals {
+		to, err := p.participantNode(i)
+		if err != nil {
+			return err
+		}
+		if err := p.SendTo(to, &DKGDealMessage{Deal: deal}); err != nil {
+			return fmt.Errorf("Node "+p.ServerIdentity().String()+" failed to send DKGDealMessage: %v", err)
+		}
+	}
+
+	// 2. Responses
+	nbrOthers := len(participants) - 1
+	for i := 0; i < nbrOthers; i++ {
+		var deal dkgDealStruct
+		select {
+		case deal = <-p.DealChannel:
+		case <-time.After(libunlynx.TIMEOUT):
+			return fmt.Errorf(p.ServerIdentity().String() + " didn't get the <DKGDealMessage> on time")
+		}
+
+		resp, err := generator.ProcessDeal(deal.Deal)
+		if err != nil {
+			return err
+		}
+		for _, err := range p.Broadcast(&DKGResponseMessage{Response: resp}) {
+			if err != nil {
+				return fmt.Errorf("Node "+p.ServerIdentity().String()+" failed to broadcast DKGResponseMessage: %v", err)
+			}
+		}
+	}
+
+	for i := 0; i < nbrOthers*nbrOthers; i++ {
+		var resp dkgResponseStruct
+		select {
+		case resp = <-p.ResponseChannel:
+		case <-time.After(libunlynx.TIMEOUT):
+			return fmt.Errorf(p.ServerIdentity().String() + " didn't get the <DKGResponseMessage> on time")
+		}
+
+		justification, err := generator.ProcessResponse(resp.Response)
+		if err != nil {
+			return err
+		}
+		if justification != nil {
+			return fmt.Errorf("complaint against the deal of " + p.ServerIdentity().String())
+		}
+	}
+
+	// 3. Key share
+	if !generator.Certified() {
+		return fmt.Errorf(p.ServerIdentity().String() + " could not certify all the deals")
+	}
+	dks, err := generator.DistKeyShare()
+	if err != nil {
+		return err
+	}
+	p.KeyShare = libunlynxthreshold.NewKeyShare(participants, announcement.Threshold, dks)
+	if p.ShareFunc != nil {
+		if err := p.ShareFunc(p.KeyShare); err != nil {
+			return err
+		}
+	}
+
+	if !p.IsRoot() {
+		if err := p.SendTo(p.Root(), &DKGFinishedMessage{}); err != nil {
+			return fmt.Errorf("Node "+p.ServerIdentity().String()+" failed to send DKGFinishedMessage: %v", err)
+		}
+		return nil
+	}
+
+	for i := 0; i < nbrOthers; i++ {
+		select {
+		case <-p.FinishedChannel:
+		case <-time.After(libunlynx.TIMEOUT):
+			return fmt.Errorf(p.ServerIdentity().String() + " didn't get the <DKGFinishedMessage> on time")
+		}
+	}
+	p.FeedbackChannel <- p.KeyShare.Public()
+	return nil
+}
+
+// participantNode returns the tree node of the participant with the given index in the key generation.
+func (p *DKGProtocol) participantNode(index int) (*onet.TreeNode, error) {
+	si := p.Roster().List[index]
+	for _, tn := range p.List() {
+		if tn.ServerIdentity.ID.Equal(si.ID) {
+			return tn, nil
+		}
+	}
+	return nil, fmt.Errorf("server " + si.String() + " is not in the tree")
+}
@@ -0,0 +1,164 @@
+package protocolsunlynx_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/key_switch"
+	"github.com/ldsec/unlynx/lib/threshold"
+	"github.com/ldsec/unlynx/protocols"
+	"github.com/stretchr/testify/assert"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// dkgTestShares contains the key shares generated in the tests, indexed by the public key of their server
+var dkgTestShares = make(map[string]*libunlynxthreshold.KeyShare)
+var dkgTestSharesMutex sync.Mutex
+var dkgTestRegister sync.Once
+
+func getDKGTestShare(tni *onet.TreeNodeInstance) *libunlynxthreshold.KeyShare {
+	dkgTestSharesMutex.Lock()
+	defer dkgTestSharesMutex.Unlock()
+	return dkgTestShares[tni.Public().String()]
+}
+
+// registerDKGTest registers the DKGTest protocol (before the servers are started).
+func registerDKGTest(t *testing.T) {
+	dkgTestRegister.Do(func() {
+		_, err := onet.GlobalProtocolRegister("DKGTest", NewDKGTest)
+		assert.NoError(t, err, "Failed to register the DKGTest protocol")
+	})
+}
+
+// runDKGTest generates a threshold key for all the servers of the tree and returns the collective public key.
+func runDKGTest(t *testing.T, local *onet.LocalTest, tree *onet.Tree, threshold int) kyber.Point {
+	rootInstance, err := local.CreateProtocol("DKGTest", tree)
+	if err != nil {
+		t.Fatal("Couldn't start protocol:", err)
+	}
+	protocol := rootInstance.(*protocolsunlynx.DKGProtocol)
+	protocol.Threshold = threshold
+
+	go func() {
+		err := protocol.Start()
+		assert.NoError(t, err)
+	}()
+
+	timeout := network.WaitRetry * time.Duration(network.MaxRetryConnect*10) * time.Millisecond
+	select {
+	case public := <-protocol.FeedbackChannel:
+		return public
+	case <-time.After(timeout):
+		t.Fatal("Didn't finish in time")
+	}
+	return nil
+}
+
+func TestDKG(t *testing.T) {
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	registerDKGTest(t)
+	_, entityList, tree := local.GenTree(5, true)
+	defer local.CloseAll()
+
+	public := runDKGTest(t, local, tree, 3)
+
+	// all the servers agree on the key, and any 3 of them can combine their shares
+	signers := entityList.Publics()[1:4]
+	secret := libunlynx.SuiTe.Scalar().Zero()
+	for i, server := range entityList.List {
+		dkgTestSharesMutex.Lock()
+		keyShare := dkgTestShares[server.Public.String()]
+		dkgTestSharesMutex.Unlock()
+
+		assert.True(t, public.Equal(keyShare.Public()))
+		assert.Equal(t, i, keyShare.Share.I)
+		if i >= 1 && i < 4 {
+			contribution, err := keyShare.Secret(signers)
+			assert.NoError(t, err)
+			secret.Add(secret, contribution)
+		}
+	}
+	assert.True(t, public.Equal(libunlynx.SuiTe.Point().Mul(secret, nil)))
+}
+
+func TestThresholdKeySwitching(t *testing.T) {
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	registerDKGTest(t)
+	_, err := onet.GlobalProtocolRegister("ThresholdCTKSTest", NewThresholdCTKSTest)
+	assert.NoError(t, err, "Failed to register the ThresholdCTKSTest protocol")
+
+	_, entityList, tree := local.GenTree(5, true)
+	defer local.CloseAll()
+
+	public := runDKGTest(t, local, tree, 3)
+
+	// only 3 of the 5 servers take part in the key switching
+	subRoster := onet.NewRoster([]*network.ServerIdentity{entityList.List[4], entityList.List[0], entityList.List[2]})
+	subTree := subRoster.GenerateNaryTreeWithRoot(2, entityList.List[4])
+
+	rootInstance, err := local.CreateProtocol("ThresholdCTKSTest", subTree)
+	if err != nil {
+		t.Fatal("Couldn't start protocol:", err)
+	}
+	protocol := rootInstance.(*protocolsunlynx.KeySwitchingProtocol)
+
+	data := []int64{1, 2, 3, 6, 0, 42}
+	cv := *libunlynx.EncryptIntVector(public, data)
+	clientPrivate := libunlynx.SuiTe.Scalar().Pick(random.New())
+	clientPublic := libunlynx.SuiTe.Point().Mul(clientPrivate, libunlynx.SuiTe.Point().Base())
+
+	protocol.TargetOfSwitch = &cv
+	protocol.TargetPublicKey = &clientPublic
+
+	go func() {
+		err := protocol.Start()
+		assert.NoError(t, err)
+	}()
+
+	timeout := network.WaitRetry * time.Duration(network.MaxRetryConnect*10) * time.Millisecond
+	select {
+	case encryptedResult := <-protocol.FeedbackChannel:
+		res := libunlynx.DecryptIntVector(clientPrivate, &encryptedResult)
+		if !reflect.DeepEqual(res, data) {
+			t.Fatal("Wrong results, expected", data, "but got", res)
+		}
+	case <-time.After(timeout):
+		t.Fatal("Didn't finish in time")
+	}
+}
+
+// NewDKGTest is a special purpose protocol constructor specific to tests.
+func NewDKGTest(tni *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	pi, err := protocolsunlynx.NewDKGProtocol(tni)
+	protocol := pi.(*protocolsunlynx.DKGProtocol)
+	protocol.ShareFunc = func(keyShare *libunlynxthreshold.KeyShare) error {
+		dkgTestSharesMutex.Lock()
+		defer dkgTestSharesMutex.Unlock()
+		dkgTestShares[tni.Public().String()] = keyShare
+		return nil
+	}
+	return protocol, err
+}
+
+// NewThresholdCTKSTest is a special purpose protocol constructor specific to tests.
+func NewThresholdCTKSTest(tni *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	pi, err := protocolsunlynx.NewKeySwitchingProtocol(tni)
+	protocol := pi.(*protocolsunlynx.KeySwitchingProtocol)
+	protocol.ThresholdKey = getDKGTestShare(tni)
+	protocol.Proofs = true
+	protocol.ProofFunc = func(pubKey, targetPubKey kyber.Point, secretKey kyber.Scalar, ks2s, rBNegs []kyber.Point, vis []kyber.Scalar) *libunlynxkeyswitch.PublishedKSListProof {
+		proof, err := libunlynxkeyswitch.KeySwitchListProofCreation(pubKey, targetPubKey, secretKey, ks2s, rBNegs, vis)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return &proof
+	}
+	return protocol, err
+}
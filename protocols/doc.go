@@ -7,8 +7,12 @@
 // the nodes can:
 //	- a server leaving or joining the cothority can change data encryption to adapt to a new collective key
 //	  (addrm_server_protocol)
+//	- a server can rotate its key without losing the stored ciphertexts, which are re-encrypted under the new
+//	  collective key (key_rotation_protocol)
 //	- collectively aggregate their local results (collective_aggregate_protocol)
+//	- determine which encrypted counts are below a minimum cell size without revealing them (cell_size_protocol)
 //	- participates in the deterministic distributed tag creation (deterministic_tagging_protocol)
 //	- transform an ciphertext encrypted under one key to another key without decrypting it (key_switching_protocol)
+//	- collectively decrypt ciphertexts, with proofs of correct decryption, to publish results (collective_decryption_protocol)
 //	- participates in the shuffle and rerandomization of a list of ciphertext (shuffling_protocol)
 package protocolsunlynx
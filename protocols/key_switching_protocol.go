@@ -91,6 +91,7 @@ type proofKeySwitchFunction func(kyber.Point, kyber.Point, kyber.Scalar, []kyber
 // KeySwitchingProtocol performs an aggregation of the data held by every node in the cothority.
 type KeySwitchingProtocol struct {
 	*onet.TreeNodeInstance
+	ContributionKeys
 
 	// Protocol feedback channel
 	FeedbackChannel chan libunlynx.CipherVector
@@ -165,10 +166,15 @@ func (p *KeySwitchingProtocol) Start() error {
 		initialTab[i+1] = v.K
 	}
 
+	secretKey, publicKey, err := SecretContribution(p.TreeNodeInstance, p.PrivateKey, p.ThresholdKey)
+	if err != nil {
+		return err
+	}
+
 	// root does its key switching
-	switchedCiphers, ks2s, rBNegs, vis := libunlynxkeyswitch.KeySwitchSequence(*p.TargetPublicKey, initialTab[1:], p.Private())
+	switchedCiphers, ks2s, rBNegs, vis := libunlynxkeyswitch.KeySwitchSequence(*p.TargetPublicKey, initialTab[1:], secretKey)
 	if p.Proofs {
-		p.ProofFunc(p.Public(), *p.TargetPublicKey, p.Private(), ks2s, rBNegs, vis)
+		p.ProofFunc(publicKey, *p.TargetPublicKey, secretKey, ks2s, rBNegs, vis)
 	}
 	p.NodeContribution = &switchedCiphers
 
@@ -197,9 +203,14 @@ func (p *KeySwitchingProtocol) Dispatch() error {
 			return err
 		}
 
-		switchedCiphers, ks2s, rBNegs, vis := libunlynxkeyswitch.KeySwitchSequence(targetPublicKey, rbs, p.Private())
+		secretKey, publicKey, err := SecretContribution(p.TreeNodeInstance, p.PrivateKey, p.ThresholdKey)
+		if err != nil {
+			return err
+		}
+
+		switchedCiphers, ks2s, rBNegs, vis := libunlynxkeyswitch.KeySwitchSequence(targetPublicKey, rbs, secretKey)
 		if p.Proofs {
-			p.ProofFunc(p.Public(), targetPublicKey, p.Private(), ks2s, rBNegs, vis)
+			p.ProofFunc(publicKey, targetPublicKey, secretKey, ks2s, rBNegs, vis)
 		}
 		p.NodeContribution = &switchedCiphers
 	}
@@ -80,6 +80,7 @@ type ShufflingProtocol struct {
 	// Protocol state data
 	ShuffleTarget     *[]libunlynx.CipherVector
 	Precomputed       []libunlynxshuffle.CipherVectorScalar
+	CollectiveKey     kyber.Point // collective key used to encrypt the data if it is not the roster aggregate
 	nextNodeInCircuit *onet.TreeNode
 
 	// Proofs
@@ -88,7 +89,6 @@ type ShufflingProtocol struct {
 	MapPIs    map[string]onet.ProtocolInstance // protocol instances to be able to call protocols inside protocols (e.g. proof_collection_protocol)
 
 	// Test (only use in order to test the protocol)
-	CollectiveKey kyber.Point
 	ExecTimeStart time.Duration
 	ExecTime      time.Duration
 }
@@ -139,7 +139,6 @@ func (p *ShufflingProtocol) Start() error {
 	shuffleTarget := *p.ShuffleTarget
 
 	collectiveKey := p.Roster().Aggregate
-	// when testing protocol
 	if p.CollectiveKey != nil {
 		collectiveKey = p.CollectiveKey
 	}
@@ -211,7 +210,6 @@ func (p *ShufflingProtocol) Dispatch() error {
 	shufflingDispatch := libunlynx.StartTimer(p.Name() + "_Shuffling(DISPATCH)")
 
 	collectiveKey := p.Roster().Aggregate
-	// when testing protocol
 	if p.CollectiveKey != nil {
 		collectiveKey = p.CollectiveKey
 	}
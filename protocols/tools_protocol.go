@@ -6,6 +6,9 @@ import (
 	"fmt"
 
 	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/threshold"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
 )
 
 // _____________________ COLLECTIVE_AGGREGATION PROTOCOL _____________________
@@ -87,7 +90,7 @@ func FilteredResponseToCipherVector(fr []libunlynx.FilteredResponse) (libunlynx.
 	return cv, lengths
 }
 
-//CipherVectorToFilteredResponse rebuild a FilteredResponse array with some lengths and a cipherVector source
+// CipherVectorToFilteredResponse rebuild a FilteredResponse array with some lengths and a cipherVector source
 func CipherVectorToFilteredResponse(cv libunlynx.CipherVector, lengths [][]int) []libunlynx.FilteredResponse {
 	filteredResponse := make([]libunlynx.FilteredResponse, len(lengths))
 
@@ -158,3 +161,60 @@ func AdaptCipherTextArray(cipherTexts []libunlynx.CipherText) []libunlynx.Cipher
 	}
 	return result
 }
+
+// _____________________ DKG PROTOCOL _____________________
+
+// ContributionKeys are the keys a node can use instead of its onet private key to remove its contribution to the
+// collective key (see SecretContribution)
+type ContributionKeys struct {
+	// ThresholdKey is the share of the distributed key if the data is not encrypted under the roster aggregate
+	ThresholdKey *libunlynxthreshold.KeyShare
+	// PrivateKey is the rotated private key of the server (see protocolsunlynxutils.KeyRotationProtocol), its onet
+	// private key is used if nil
+	PrivateKey kyber.Scalar
+}
+
+// SecretContribution returns the secret key (and the corresponding public key) a node uses to remove its contribution
+// to the collective key. This is the node's private key (or its rotated private key if not nil), or its Lagrange-weighted
+// share if the data is encrypted under a distributed (t-of-n) key, in which case any t servers of the key's roster can
+// form the tree.
+func SecretContribution(tni *onet.TreeNodeInstance, privateKey kyber.Scalar, keyShare *libunlynxthreshold.KeyShare) (kyber.Scalar, kyber.Point, error) {
+	if keyShare == nil {
+		if privateKey != nil {
+			return privateKey, libunlynx.SuiTe.Point().Mul(privateKey, nil), nil
+		}
+		return tni.Private(), tni.Public(), nil
+	}
+
+	secret, err := keyShare.Secret(tni.Roster().Publics())
+	if err != nil {
+		return nil, nil, err
+	}
+	return secret, libunlynx.SuiTe.Point().Mul(secret, nil), nil
+}
+
+// PublicContributions returns the public counterparts of the secret keys used by the nodes of the tree to remove their
+// contribution to the collective key (see SecretContribution), in the order of the roster. The current public keys of
+// the nodes can be given if some of them were rotated, the roster public keys are used otherwise.
+func PublicContributions(tni *onet.TreeNodeInstance, publics []kyber.Point, keyShare *libunlynxthreshold.KeyShare) ([]kyber.Point, error) {
+	signers := tni.Roster().Publics()
+	if keyShare == nil {
+		if publics != nil {
+			if len(publics) != len(signers) {
+				return nil, fmt.Errorf("%d public keys given for %d servers", len(publics), len(signers))
+			}
+			return publics, nil
+		}
+		return signers, nil
+	}
+
+	contributions := make([]kyber.Point, len(signers))
+	for i, s := range signers {
+		var err error
+		contributions[i], err = keyShare.PublicContribution(s, signers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return contributions, nil
+}
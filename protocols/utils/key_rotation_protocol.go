@@ -0,0 +1,377 @@
+// Package protocolsunlynxutils implements the key rotation protocol.
+// It replaces the private key of one server (the root) without losing the ciphertexts stored by the servers. As in the
+// addrm_server protocol, the contribution of the old key is removed from the ciphertexts and the contribution of the
+// new key is added, i.e. C' = C + (new - old) * rB:
+// 1. the root announces its old and new public keys to all the servers;
+// 2. each server sends its stored ciphertexts to the root (the ones kept out of memory one chunk at a time);
+// 3. the root changes their encryption and proves it (add/rm proofs for the key new - old);
+// 4. each server verifies the proofs and replaces its ciphertexts (before sending its next chunk);
+// 5. each server tells the root once it replaced all its ciphertexts and recorded the new key.
+// The root outputs its new public key: the new collective key is the old one plus new - old.
+package protocolsunlynxutils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/add_rm"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// KeyRotationProtocolName is the registered name for the key rotation protocol.
+const KeyRotationProtocolName = "KeyRotation"
+
+func init() {
+	network.RegisterMessage(KeyRotationAnnouncementMessage{})
+	network.RegisterMessage(KeyRotationDataMessage{})
+	network.RegisterMessage(KeyRotationResultMessage{})
+	network.RegisterMessage(KeyRotationDoneMessage{})
+	_, err := onet.GlobalProtocolRegister(KeyRotationProtocolName, NewKeyRotationProtocol)
+	log.ErrFatal(err, "Failed to register the <KeyRotation> protocol:")
+}
+
+// Messages
+//______________________________________________________________________________________________________________________
+
+// KeyRotationAnnouncementMessage contains the old and new public keys of the root in bytes
+type KeyRotationAnnouncementMessage struct {
+	OldPublic []byte
+	NewPublic []byte
+}
+
+// KeyRotationDataMessage contains ciphertexts of a server in bytes (Last is set on the last message of the server)
+type KeyRotationDataMessage struct {
+	Data []byte
+	Last bool
+}
+
+// KeyRotationResultMessage contains the re-encrypted ciphertexts of a server in bytes and the proofs of their
+// re-encryption
+type KeyRotationResultMessage struct {
+	Data   []byte
+	Proofs [][]byte
+}
+
+// KeyRotationDoneMessage is sent by a server to the root once it replaced its ciphertexts (Error is set if it failed)
+type KeyRotationDoneMessage struct {
+	Error string
+}
+
+// Structs
+//______________________________________________________________________________________________________________________
+
+type keyRotationAnnouncementStruct struct {
+	*onet.TreeNode
+	KeyRotationAnnouncementMessage
+}
+
+type keyRotationDataStruct struct {
+	*onet.TreeNode
+	KeyRotationDataMessage
+}
+
+type keyRotationResultStruct struct {
+	*onet.TreeNode
+	KeyRotationResultMessage
+}
+
+type keyRotationDoneStruct struct {
+	*onet.TreeNode
+	KeyRotationDoneMessage
+}
+
+// CipherTextsChunk is a part of the ciphertexts of a server kept out of memory (e.g. spilled to disk): it is loaded,
+// re-encrypted and replaced on its own.
+type CipherTextsChunk struct {
+	Load    func() (libunlynx.CipherVector, error)
+	Replace func(libunlynx.CipherVector) error
+}
+
+// Protocol
+//______________________________________________________________________________________________________________________
+
+// KeyRotationProtocol rotates the private key of the root and re-encrypts the ciphertexts of all the servers.
+type KeyRotationProtocol struct {
+	*onet.TreeNodeInstance
+
+	// Protocol feedback channel
+	FeedbackChannel chan kyber.Point
+
+	// Protocol communication channels
+	AnnouncementChannel chan keyRotationAnnouncementStruct
+	DataChannel         chan keyRotationDataStruct
+	ResultChannel       chan keyRotationResultStruct
+	DoneChannel         chan keyRotationDoneStruct
+
+	// Protocol state data
+	TargetOfTransformation libunlynx.CipherVector
+	RootPublic             kyber.Point  // current public key of the root, its roster public key if nil
+	PrivateKey             kyber.Scalar // current private key of the root, its onet private key if nil
+	NewKey                 kyber.Scalar // new private key of the root, picked at random if nil
+
+	// Chunks are the ciphertexts of the server kept out of memory, re-encrypted after the TargetOfTransformation, one at a
+	// time
+	Chunks []CipherTextsChunk
+
+	// RotationFunc is called on each server with its re-encrypted ciphertexts and the new public key of the root (e.g. to
+	// replace the stored ciphertexts)
+	RotationFunc func(libunlynx.CipherVector, kyber.Point) error
+}
+
+// NewKeyRotationProtocol is constructor of key rotation protocol instances.
+func NewKeyRotationProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	pkr := &KeyRotationProtocol{
+		TreeNodeInstance: n,
+		FeedbackChannel:  make(chan kyber.Point),
+	}
+
+	if err := pkr.RegisterChannel(&pkr.AnnouncementChannel); err != nil {
+		return nil, fmt.Errorf("couldn't register announcement channel: %v", err)
+	}
+	if err := pkr.RegisterChannel(&pkr.DataChannel); err != nil {
+		return nil, fmt.Errorf("couldn't register data channel: %v", err)
+	}
+	if err := pkr.RegisterChannel(&pkr.ResultChannel); err != nil {
+		return nil, fmt.Errorf("couldn't register result channel: %v", err)
+	}
+	if err := pkr.RegisterChannel(&pkr.DoneChannel); err != nil {
+		return nil, fmt.Errorf("couldn't register done channel: %v", err)
+	}
+
+	return pkr, nil
+}
+
+// Start is called at the root to announce the new key.
+func (p *KeyRotationProtocol) Start() error {
+	log.Lvl1(p.Name(), "starts a Key Rotation Protocol")
+
+	if p.PrivateKey == nil {
+		p.PrivateKey = p.Private()
+	}
+	if p.NewKey == nil {
+		p.NewKey = libunlynx.SuiTe.Scalar().Pick(libunlynx.SuiTe.RandomStream())
+	}
+
+	oldPublic, err := libunlynx.SuiTe.Point().Mul(p.PrivateKey, nil).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	newPublic, err := libunlynx.SuiTe.Point().Mul(p.NewKey, nil).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	announcement := KeyRotationAnnouncementMessage{OldPublic: oldPublic, NewPublic: newPublic}
+	for _, node := range p.List() {
+		if node.ID.Equal(p.TreeNode().ID) {
+			continue
+		}
+		if err := p.SendTo(node, &announcement); err != nil {
+			return fmt.Errorf("Node "+p.ServerIdentity().String()+" failed to send KeyRotationAnnouncementMessage: %v", err)
+		}
+	}
+	p.AnnouncementChannel <- keyRotationAnnouncementStruct{TreeNode: p.TreeNode(), KeyRotationAnnouncementMessage: announcement}
+	return nil
+}
+
+// Dispatch is called on each node. It waits for incoming messages and handle them.
+func (p *KeyRotationProtocol) Dispatch() error {
+	defer p.Done()
+
+	var announcement keyRotationAnnouncementStruct
+	select {
+	case announcement = <-p.AnnouncementChannel:
+	case <-time.After(libunlynx.TIMEOUT):
+		return fmt.Errorf(p.ServerIdentity().String() + " didn't get the <KeyRotationAnnouncementMessage> on time")
+	}
+
+	oldPublic := libunlynx.SuiTe.Point()
+	if err := oldPublic.UnmarshalBinary(announcement.OldPublic); err != nil {
+		return err
+	}
+	newPublic := libunlynx.SuiTe.Point()
+	if err := newPublic.UnmarshalBinary(announcement.NewPublic); err != nil {
+		return err
+	}
+
+	if p.IsRoot() {
+		return p.rotate(newPublic)
+	}
+
+	// the announced old key must be the one the data is encrypted with
+	rootPublic := p.RootPublic
+	if rootPublic == nil {
+		rootPublic = p.Root().ServerIdentity.Public
+	}
+	if !rootPublic.Equal(oldPublic) {
+		return fmt.Errorf("the announced old key of %v is not its current key", p.Root().ServerIdentity)
+	}
+
+	err := p.replaceCipherTexts(oldPublic, newPublic)
+	done := KeyRotationDoneMessage{}
+	if err != nil {
+		done.Error = err.Error()
+	}
+	if errSend := p.SendTo(p.Root(), &done); errSend != nil {
+		return fmt.Errorf("Node "+p.ServerIdentity().String()+" failed to send KeyRotationDoneMessage: %v", errSend)
+	}
+	return err
+}
+
+// replaceCipherTexts gets the ciphertexts of the server re-encrypted by the root and replaces them (at a non-root node)
+func (p *KeyRotationProtocol) replaceCipherTexts(oldPublic, newPublic kyber.Point) error {
+	deltaPublic := libunlynx.SuiTe.Point().Sub(newPublic, oldPublic)
+	rotated, err := p.requestRotation(p.TargetOfTransformation, len(p.Chunks) == 0, deltaPublic)
+	if err != nil {
+		return err
+	}
+	for i, chunk := range p.Chunks {
+		cts, err := chunk.Load()
+		if err != nil {
+			return err
+		}
+		rotatedChunk, err := p.requestRotation(cts, i == len(p.Chunks)-1, deltaPublic)
+		if err != nil {
+			return err
+		}
+		if err := chunk.Replace(rotatedChunk); err != nil {
+			return err
+		}
+	}
+
+	if p.RotationFunc != nil {
+		return p.RotationFunc(rotated, newPublic)
+	}
+	return nil
+}
+
+// rotate changes the encryption of the ciphertexts of all the servers (at the root)
+func (p *KeyRotationProtocol) rotate(newPublic kyber.Point) error {
+	delta := libunlynx.SuiTe.Scalar().Sub(p.NewKey, p.PrivateKey)
+	deltaPublic := libunlynx.SuiTe.Point().Mul(delta, nil)
+
+	for remaining := len(p.List()) - 1; remaining > 0; {
+		var data keyRotationDataStruct
+		select {
+		case data = <-p.DataChannel:
+		case <-time.After(libunlynx.TIMEOUT):
+			return fmt.Errorf(p.ServerIdentity().String() + " didn't get the <KeyRotationDataMessage> on time")
+		}
+		if data.Last {
+			remaining--
+		}
+
+		cts := libunlynx.CipherVector{}
+		if err := cts.FromBytes(data.Data, len(data.Data)/libunlynx.CipherTextByteSize()); err != nil {
+			return err
+		}
+		rotated, proofs, err := rotateCipherTexts(cts, delta, deltaPublic)
+		if err != nil {
+			return err
+		}
+		rotatedBytes, _, err := rotated.ToBytes()
+		if err != nil {
+			return err
+		}
+		if err := p.SendTo(data.TreeNode, &KeyRotationResultMessage{Data: rotatedBytes, Proofs: proofs}); err != nil {
+			return fmt.Errorf("Node "+p.ServerIdentity().String()+" failed to send KeyRotationResultMessage: %v", err)
+		}
+	}
+
+	// the root does not need to prove the re-encryption of its own ciphertexts
+	rotated := libunlynx.CipherVector(changeEncryption(p.TargetOfTransformation, delta, true))
+	for _, chunk := range p.Chunks {
+		cts, err := chunk.Load()
+		if err != nil {
+			return err
+		}
+		if err := chunk.Replace(changeEncryption(cts, delta, true)); err != nil {
+			return err
+		}
+	}
+	if p.RotationFunc != nil {
+		if err := p.RotationFunc(rotated, newPublic); err != nil {
+			return err
+		}
+	}
+
+	// the new key is only output once all the servers replaced their ciphertexts
+	for remaining := len(p.List()) - 1; remaining > 0; remaining-- {
+		select {
+		case done := <-p.DoneChannel:
+			if done.Error != "" {
+				return fmt.Errorf("%v failed to replace its ciphertexts: %s", done.ServerIdentity, done.Error)
+			}
+		case <-time.After(libunlynx.TIMEOUT):
+			return fmt.Errorf(p.ServerIdentity().String() + " didn't get the <KeyRotationDoneMessage> on time")
+		}
+	}
+
+	p.FeedbackChannel <- newPublic
+	return nil
+}
+
+// requestRotation sends ciphertexts to the root and returns their verified re-encryption (at a non-root node)
+func (p *KeyRotationProtocol) requestRotation(cts libunlynx.CipherVector, last bool, deltaPublic kyber.Point) (libunlynx.CipherVector, error) {
+	data, _, err := cts.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.SendTo(p.Root(), &KeyRotationDataMessage{Data: data, Last: last}); err != nil {
+		return nil, fmt.Errorf("Node "+p.ServerIdentity().String()+" failed to send KeyRotationDataMessage: %v", err)
+	}
+
+	var result keyRotationResultStruct
+	select {
+	case result = <-p.ResultChannel:
+	case <-time.After(libunlynx.TIMEOUT):
+		return nil, fmt.Errorf(p.ServerIdentity().String() + " didn't get the <KeyRotationResultMessage> on time")
+	}
+
+	rotated := libunlynx.CipherVector{}
+	if err := rotated.FromBytes(result.Data, len(result.Data)/libunlynx.CipherTextByteSize()); err != nil {
+		return nil, err
+	}
+	if err := verifyRotation(cts, rotated, result.Proofs, deltaPublic); err != nil {
+		return nil, err
+	}
+	return rotated, nil
+}
+
+// rotateCipherTexts changes the encryption of ciphertexts by adding delta * rB and proves it
+func rotateCipherTexts(cts libunlynx.CipherVector, delta kyber.Scalar, deltaPublic kyber.Point) (libunlynx.CipherVector, [][]byte, error) {
+	rotated := libunlynx.CipherVector(changeEncryption(cts, delta, true))
+
+	proofs, err := libunlynxaddrm.AddRmListProofCreation(cts, rotated, deltaPublic, delta, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	proofsBytes := make([][]byte, len(proofs.List))
+	for i, v := range proofs.List {
+		proofsBytes[i] = v.Proof
+	}
+	return rotated, proofsBytes, nil
+}
+
+// verifyRotation checks that the ciphertexts were re-encrypted by adding (new - old) * rB
+func verifyRotation(before, after libunlynx.CipherVector, proofs [][]byte, deltaPublic kyber.Point) error {
+	if len(after) != len(before) || len(proofs) != len(before) {
+		return fmt.Errorf("got %d re-encrypted ciphertexts and %d proofs for %d ciphertexts", len(after), len(proofs), len(before))
+	}
+
+	parp := libunlynxaddrm.PublishedAddRmListProof{List: make([]libunlynxaddrm.PublishedAddRmProof, len(before)), Krm: deltaPublic, ToAdd: true}
+	for i := range before {
+		if !after[i].K.Equal(before[i].K) {
+			return fmt.Errorf("the re-encryption changed the ephemeral key of ciphertext %d", i)
+		}
+		parp.List[i] = libunlynxaddrm.PublishedAddRmProof{Proof: proofs[i], CtBef: before[i], CtAft: after[i], RB: before[i].K}
+	}
+	if !libunlynxaddrm.AddRmListProofVerification(parp, 1.0) {
+		return fmt.Errorf("wrong key rotation proofs")
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package protocolsunlynxutils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/protocols/utils"
+	"github.com/stretchr/testify/assert"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// the ciphertexts of each server (by index in the roster) and the channel receiving their re-encryptions
+var rotationTestKey kyber.Point
+var rotationTestData = [][]int64{{1, 2, 3}, {}, {42}, {0, 7}}
+
+// the ciphertexts of each server kept out of memory, in two chunks
+var rotationTestChunks = [][][]int64{{{5}, {6, 7}}, {{8}, {}}, {{9}, {10}}, {{11, 12}, {13}}}
+
+type rotationTestResult struct {
+	index   int
+	rotated libunlynx.CipherVector
+	chunk   int // -1 for the ciphertexts kept in memory
+}
+
+var rotationTestResults = make(chan rotationTestResult, len(rotationTestData)*3)
+
+func TestKeyRotation(t *testing.T) {
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, err := onet.GlobalProtocolRegister("KeyRotationTest", NewKeyRotationTest)
+	assert.NoError(t, err, "Failed to register the KeyRotationTest protocol")
+
+	servers, entityList, tree := local.GenTree(len(rotationTestData), true)
+	defer local.CloseAll()
+	rotationTestKey = entityList.Aggregate
+
+	rootInstance, err := local.CreateProtocol("KeyRotationTest", tree)
+	if err != nil {
+		t.Fatal("Couldn't start protocol:", err)
+	}
+	protocol := rootInstance.(*protocolsunlynxutils.KeyRotationProtocol)
+	newKey := libunlynx.SuiTe.Scalar().Pick(libunlynx.SuiTe.RandomStream())
+	protocol.NewKey = newKey
+
+	go func() {
+		err := protocol.Start()
+		assert.NoError(t, err)
+	}()
+
+	timeout := network.WaitRetry * time.Duration(network.MaxRetryConnect*10) * time.Millisecond
+
+	select {
+	case newPublic := <-protocol.FeedbackChannel:
+		assert.True(t, newPublic.Equal(libunlynx.SuiTe.Point().Mul(newKey, nil)))
+	case <-time.After(timeout):
+		t.Fatal("Didn't finish in time")
+	}
+
+	// the ciphertexts of all the servers are now encrypted under the new collective key
+	secret := libunlynx.SuiTe.Scalar().Set(newKey)
+	for _, server := range servers[1:] {
+		secret.Add(secret, local.GetPrivate(server))
+	}
+	// each server replaces its chunks and then its ciphertexts kept in memory
+	for i := 0; i < len(rotationTestData)*(len(rotationTestChunks[0])+1); i++ {
+		select {
+		case result := <-rotationTestResults:
+			expected := rotationTestData[result.index]
+			if result.chunk >= 0 {
+				expected = rotationTestChunks[result.index][result.chunk]
+			}
+			assert.Equal(t, len(expected), len(result.rotated))
+			for j, v := range expected {
+				assert.Equal(t, v, libunlynx.DecryptInt(secret, result.rotated[j]))
+			}
+		case <-time.After(timeout):
+			t.Fatal("Didn't finish in time")
+		}
+	}
+}
+
+// NewKeyRotationTest is a special purpose protocol constructor specific to tests.
+func NewKeyRotationTest(tni *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	pi, err := protocolsunlynxutils.NewKeyRotationProtocol(tni)
+	if err != nil {
+		return nil, err
+	}
+	protocol := pi.(*protocolsunlynxutils.KeyRotationProtocol)
+
+	index, _ := tni.Roster().Search(tni.ServerIdentity().ID)
+	protocol.TargetOfTransformation = *libunlynx.EncryptIntVector(rotationTestKey, rotationTestData[index])
+	for i, chunk := range rotationTestChunks[index] {
+		cts, chunkIndex := *libunlynx.EncryptIntVector(rotationTestKey, chunk), i
+		protocol.Chunks = append(protocol.Chunks, protocolsunlynxutils.CipherTextsChunk{
+			Load: func() (libunlynx.CipherVector, error) {
+				return cts, nil
+			},
+			Replace: func(rotated libunlynx.CipherVector) error {
+				rotationTestResults <- rotationTestResult{index: index, rotated: rotated, chunk: chunkIndex}
+				return nil
+			},
+		})
+	}
+	protocol.RotationFunc = func(rotated libunlynx.CipherVector, newPublic kyber.Point) error {
+		rotationTestResults <- rotationTestResult{index: index, rotated: rotated, chunk: -1}
+		return nil
+	}
+	return protocol, nil
+}
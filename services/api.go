@@ -1,7 +1,15 @@
 package servicesunlynx
 
 import (
+	"fmt"
+
 	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/aggregation"
+	"github.com/ldsec/unlynx/lib/decryption"
+	"github.com/ldsec/unlynx/lib/range"
+	"github.com/ldsec/unlynx/lib/statistics"
+	"github.com/ldsec/unlynx/protocols"
+	"github.com/satori/go.uuid"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3"
@@ -17,6 +25,10 @@ type API struct {
 	entryPoint *network.ServerIdentity
 	public     kyber.Point
 	private    kyber.Scalar
+
+	// encryptor is kept to encrypt all the responses sent under the same collective key
+	encryptor      *libunlynx.Encryptor
+	encryptorMutex sync.Mutex
 }
 
 // NewUnLynxClient constructor of a client.
@@ -33,15 +45,19 @@ func NewUnLynxClient(entryPoint *network.ServerIdentity, clientID string) *API {
 	return newClient
 }
 
+// NewUnLynxClientWithKeys constructor of a client with a given key pair (e.g. a querier registered in the query policies
+// of the servers, see QueryPolicy).
+func NewUnLynxClientWithKeys(entryPoint *network.ServerIdentity, clientID string, keys *key.Pair) *API {
+	newClient := NewUnLynxClient(entryPoint, clientID)
+	newClient.public, newClient.private = keys.Public, keys.Private
+	return newClient
+}
+
 // Send Query
 //______________________________________________________________________________________________________________________
 
 // SendSurveyCreationQuery creates a survey based on a set of entities (servers) and a survey description.
 func (c *API) SendSurveyCreationQuery(entities *onet.Roster, surveyID SurveyID, clientPubKey kyber.Point, nbrDPs map[string]int64, proofs, appFlag bool, sum []string, count bool, where []libunlynx.WhereQueryAttribute, predicate string, groupBy []string) (*SurveyID, error) {
-	log.Lvl1(c, "is creating a survey with id: ", surveyID)
-
-	var newSurveyID SurveyID
-
 	scq := SurveyCreationQuery{
 		SurveyID:     surveyID,
 		Roster:       *entities,
@@ -57,6 +73,23 @@ func (c *API) SendSurveyCreationQuery(entities *onet.Roster, surveyID SurveyID,
 		Predicate: predicate,
 		GroupBy:   groupBy,
 	}
+	return c.SendSurveyCreation(scq)
+}
+
+// SendSurveyCreation creates a survey described by a complete survey creation query (e.g. with fixed-point attributes).
+// The query is signed with the client's key.
+func (c *API) SendSurveyCreation(scq SurveyCreationQuery) (*SurveyID, error) {
+	log.Lvl1(c, "is creating a survey with id: ", scq.SurveyID)
+
+	if scq.Suite == "" {
+		scq.Suite = libunlynx.SuiTe.String()
+	}
+	if err := scq.Sign(c.private); err != nil {
+		return nil, err
+	}
+
+	var newSurveyID SurveyID
+
 	resp := ServiceState{}
 	err := c.SendProtobuf(c.entryPoint, &scq, &resp)
 	if err != nil {
@@ -68,12 +101,85 @@ func (c *API) SendSurveyCreationQuery(entities *onet.Roster, surveyID SurveyID,
 	return &newSurveyID, nil
 }
 
+// SendDatasetCreation creates a dataset on the servers of its roster and returns its ID (the one of the query if it is
+// set). The data providers upload their responses once to it (see SendDatasetResponseQuery) and several surveys can
+// then be run on it (see SurveyCreationQuery.DatasetID).
+func (c *API) SendDatasetCreation(dcq DatasetCreationQuery) (*DatasetID, error) {
+	if dcq.DatasetID == "" {
+		dcq.DatasetID = DatasetID(uuid.NewV4().String())
+	}
+	log.Lvl1(c, " is creating a dataset with id: ", dcq.DatasetID)
+
+	var err error
+	if dcq.Auth, err = signQuery(c.private, dcq.statement()); err != nil {
+		return nil, err
+	}
+
+	resp := DatasetState{}
+	err = c.SendProtobuf(c.entryPoint, &dcq, &resp)
+	if err != nil {
+		return nil, err
+	}
+	log.Lvl1(c, " successfully created the dataset with ID ", resp.DatasetID)
+	return &resp.DatasetID, nil
+}
+
+// SendDKGQuery generates a distributed (t-of-n) collective key for a roster and returns it. Surveys can then be run
+// under this key (see SurveyCreationQuery.ThresholdKeyID) by any t servers of the roster. The query is signed with the
+// client's key.
+func (c *API) SendDKGQuery(entities *onet.Roster, threshold int) (kyber.Point, error) {
+	log.Lvl1(c, " asks for a distributed key (", threshold, "-of-", len(entities.List), ")")
+	auth, err := signQuery(c.private, dkgStatement(entities))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := DKGResult{}
+	err = c.SendProtobuf(c.entryPoint, &DKGQuery{Roster: *entities, Threshold: threshold, Auth: auth}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Public, nil
+}
+
+// SendKeyRotationQuery rotates the private key of the server the client is connected to and returns its new public key
+// and the new collective key of the roster, under which the data must now be encrypted. The query is signed with the
+// client's key.
+func (c *API) SendKeyRotationQuery(entities *onet.Roster) (kyber.Point, kyber.Point, error) {
+	log.Lvl1(c, " asks ", c.entryPoint, " to rotate its key")
+	auth, err := signQuery(c.private, keyRotationStatement(entities))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp := KeyRotationResult{}
+	err = c.SendProtobuf(c.entryPoint, &KeyRotationQuery{Roster: *entities, Auth: auth}, &resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Public, resp.CollectiveKey, nil
+}
+
 // SendSurveyResponseQuery handles the encryption and sending of DP responses
 func (c *API) SendSurveyResponseQuery(surveyID SurveyID, clearClientResponses []libunlynx.DpClearResponse, groupKey kyber.Point, dataRepetitions int, count bool) error {
 	log.Lvl1(c, " sends a result for survey ", surveyID)
 	var err error
 
-	s, err := EncryptDataToSurvey(c.String(), surveyID, clearClientResponses, groupKey, dataRepetitions, count)
+	s, err := EncryptDataToSurveyWithEncryptor(c.String(), c.keys(), surveyID, clearClientResponses, c.getEncryptor(groupKey), dataRepetitions, count)
+	if err != nil {
+		return err
+	}
+
+	resp := ServiceState{}
+	return c.SendProtobuf(c.entryPoint, s, &resp)
+}
+
+// SendSurveyResponseQueryWithRangeProofs handles the encryption and sending of DP responses to a survey declaring
+// ranges for its aggregating attributes (see SurveyCreationQuery.Ranges): their range proofs are sent along.
+func (c *API) SendSurveyResponseQueryWithRangeProofs(surveyID SurveyID, clearClientResponses []libunlynx.DpClearResponse, groupKey kyber.Point, ranges map[string]*libunlynxrange.Bounds, dataRepetitions int, count bool) error {
+	log.Lvl1(c, " sends a result (with range proofs) for survey ", surveyID)
+
+	s, err := EncryptDataToSurveyWithRangeProofs(c.String(), c.keys(), surveyID, clearClientResponses, c.getEncryptor(groupKey), ranges, dataRepetitions, count)
 	if err != nil {
 		return err
 	}
@@ -82,11 +188,109 @@ func (c *API) SendSurveyResponseQuery(surveyID SurveyID, clearClientResponses []
 	return c.SendProtobuf(c.entryPoint, s, &resp)
 }
 
+// SendDatasetResponseQuery handles the encryption and upload of DP responses to a dataset. The count attribute must be
+// sent if a survey on the dataset counts the responses.
+func (c *API) SendDatasetResponseQuery(datasetID DatasetID, clearClientResponses []libunlynx.DpClearResponse, groupKey kyber.Point, dataRepetitions int, count bool) error {
+	log.Lvl1(c, " uploads its data to dataset ", datasetID)
+
+	s, err := EncryptDataToSurveyWithEncryptor(c.String(), c.keys(), SurveyID(datasetID), clearClientResponses, c.getEncryptor(groupKey), dataRepetitions, count)
+	if err != nil {
+		return err
+	}
+
+	// the responses are signed for the ID of the dataset
+	resp := DatasetState{}
+	return c.SendProtobuf(c.entryPoint, &DatasetResponseQuery{DatasetID: datasetID, DpPublic: s.DpPublic, Signature: s.Signature, Responses: s.Responses}, &resp)
+}
+
 // SendSurveyResultsQuery to get the result from associated server and decrypt the response using its private key.
 func (c *API) SendSurveyResultsQuery(surveyID SurveyID) (*[][]int64, *[][]int64, error) {
 	log.Lvl1(c, " asks for the results of the survey ", surveyID)
+	resq, err := c.resultsQuery(surveyID)
+	if err != nil {
+		return nil, nil, err
+	}
 	resp := ServiceResult{}
-	err := c.SendProtobuf(c.entryPoint, &SurveyResultsQuery{false, surveyID, c.public}, &resp)
+	err = c.SendProtobuf(c.entryPoint, resq, &resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Lvl1(c, " got the survey result from ", c.entryPoint)
+
+	grp, aggr := c.DecryptResults(&resp)
+	return grp, aggr, nil
+}
+
+// SendAsyncResultsQuery starts the processing of a survey without waiting for its results and returns a ticket to
+// follow it (see PollResults and SubscribeResults).
+func (c *API) SendAsyncResultsQuery(surveyID SurveyID) (string, error) {
+	log.Lvl1(c, " asks for the results of the survey ", surveyID, " (asynchronously)")
+	resq, err := c.resultsQuery(surveyID)
+	if err != nil {
+		return "", err
+	}
+	auth, err := signQuery(c.private, surveyStatement("results", surveyID))
+	if err != nil {
+		return "", err
+	}
+	resp := ResultsTicket{}
+	err = c.SendProtobuf(c.entryPoint, &AsyncResultsQuery{SurveyID: surveyID, ClientPublic: c.public, Signature: resq.Signature, Auth: auth}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return resp.Ticket, nil
+}
+
+// PollResults gets the progress of the processing of a survey started with SendAsyncResultsQuery: the phases it went
+// through and, once it is done, its (key switched) results or the reason of its failure.
+func (c *API) PollResults(ticket string) (*ResultsProgress, error) {
+	resp := ResultsProgress{}
+	err := c.SendProtobuf(c.entryPoint, &ResultsPollQuery{Ticket: ticket}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SubscribeResults follows the processing of a survey started with SendAsyncResultsQuery: onEvent (if not nil) is
+// called when it enters each phase and its (key switched) results are returned once it is done.
+func (c *API) SubscribeResults(ticket string, onEvent func(ProgressEvent)) (*ServiceResult, error) {
+	conn, err := c.Stream(c.entryPoint, &ResultsSubscription{Ticket: ticket})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		progress := ResultsProgress{}
+		if err := conn.ReadMessage(&progress); err != nil {
+			return nil, err
+		}
+		if onEvent != nil {
+			for _, event := range progress.Events {
+				onEvent(event)
+			}
+		}
+		if progress.Done {
+			if progress.Error != "" {
+				return nil, fmt.Errorf("service could not output the results: %s", progress.Error)
+			}
+			log.Lvl1(c, " got the survey result from ", c.entryPoint)
+			return &progress.Result, nil
+		}
+	}
+}
+
+// SendSurveyResultsQueryFixedPoint gets the results of a survey with fixed-point sum attributes and decodes them.
+// The integer encoding of each result (i.e. the scaled sums) must lie in [-limit, limit].
+func (c *API) SendSurveyResultsQueryFixedPoint(surveyID SurveyID, limit int64) (*[][]int64, *[][]float64, error) {
+	log.Lvl1(c, " asks for the (fixed-point) results of the survey ", surveyID)
+	resq, err := c.resultsQuery(surveyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp := ServiceResult{}
+	err = c.SendProtobuf(c.entryPoint, resq, &resp)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -94,19 +298,300 @@ func (c *API) SendSurveyResultsQuery(surveyID SurveyID) (*[][]int64, *[][]int64,
 	log.Lvl1(c, " got the survey result from ", c.entryPoint)
 
 	grp := make([][]int64, len(resp.Results))
-	aggr := make([][]int64, len(resp.Results))
+	aggr := make([][]float64, len(resp.Results))
 	for i, res := range resp.Results {
 		grp[i] = libunlynx.DecryptIntVector(c.private, &res.GroupByEnc)
-		aggr[i] = libunlynx.DecryptIntVector(c.private, &res.AggregatingAttributes)
+		values, err := libunlynx.DecryptIntVectorWithNegRange(c.private, &res.AggregatingAttributes, limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		aggr[i], err = resp.FixedPoint.Decode(resp.Sum, values)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 	return &grp, &aggr, nil
 }
 
+// SendSurveyHistogramResultsQuery gets the results of a survey summing the histogram bins of some attributes (see
+// libunlynxstatistics.HistogramSum) and computes the histogram aggregates (e.g. the median) of each group from them.
+func (c *API) SendSurveyHistogramResultsQuery(surveyID SurveyID, aggregates []libunlynxstatistics.HistogramAggregate) (*[][]int64, *[][]libunlynxstatistics.HistogramResult, error) {
+	log.Lvl1(c, " asks for the histogram results of the survey ", surveyID)
+	resq, err := c.resultsQuery(surveyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp := ServiceResult{}
+	err = c.SendProtobuf(c.entryPoint, resq, &resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Lvl1(c, " got the survey result from ", c.entryPoint)
+
+	grp, aggr := c.DecryptResults(&resp)
+	results := make([][]libunlynxstatistics.HistogramResult, len(*aggr))
+	for i := range *aggr {
+		results[i], err = libunlynxstatistics.EvaluateHistogramAggregates(aggregates, resp.Sum, (*aggr)[i])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return grp, &results, nil
+}
+
+// SendSurveyStatisticsResultsQuery gets the results of a survey summing the moments of some attributes (see
+// libunlynxstatistics.MomentSum) and computes the statistics (e.g. the variance) of each group from them. The sums of
+// each result must lie in [-limit, limit].
+func (c *API) SendSurveyStatisticsResultsQuery(surveyID SurveyID, statistics []libunlynxstatistics.Statistic, limit int64) (*[][]int64, *[][]libunlynxstatistics.StatisticResult, error) {
+	log.Lvl1(c, " asks for the statistics results of the survey ", surveyID)
+	resq, err := c.resultsQuery(surveyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp := ServiceResult{}
+	err = c.SendProtobuf(c.entryPoint, resq, &resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Lvl1(c, " got the survey result from ", c.entryPoint)
+
+	grp := make([][]int64, len(resp.Results))
+	results := make([][]libunlynxstatistics.StatisticResult, len(resp.Results))
+	for i, res := range resp.Results {
+		grp[i] = libunlynx.DecryptIntVector(c.private, &res.GroupByEnc)
+		values, err := libunlynx.DecryptIntVectorWithNegRange(c.private, &res.AggregatingAttributes, limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		results[i], err = libunlynxstatistics.EvaluateStatistics(statistics, resp.Sum, values, resp.FixedPoint)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return &grp, &results, nil
+}
+
+// SendSurveyPublicResultsQuery gets the public results of a survey (see SurveyCreationQuery.PublicResults), verifies
+// that they were correctly decrypted by the servers with the given public keys and decodes them in [-limit, limit].
+func (c *API) SendSurveyPublicResultsQuery(surveyID SurveyID, publics []kyber.Point, limit int64) (*[][]int64, *[][]int64, error) {
+	log.Lvl1(c, " asks for the public results of the survey ", surveyID)
+	resq, err := c.resultsQuery(surveyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp := ServiceResult{}
+	err = c.SendProtobuf(c.entryPoint, resq, &resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Lvl1(c, " got the survey result from ", c.entryPoint)
+
+	return VerifyPublicResults(&resp, publics, limit)
+}
+
+// ListSurveys lists the surveys of the server the client is connected to, with their status on that server.
+func (c *API) ListSurveys() ([]SurveyServerStatus, error) {
+	log.Lvl1(c, " asks ", c.entryPoint, " for its surveys")
+	auth, err := signQuery(c.private, listStatement)
+	if err != nil {
+		return nil, err
+	}
+	resp := SurveyList{}
+	err = c.SendProtobuf(c.entryPoint, &ListSurveysQuery{Auth: auth}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Surveys, nil
+}
+
+// GetSurveyStatus gets the status of a survey on each server of its roster (in the roster order): its phase, the
+// number of data providers the server has received data from and waits for, and the reason of its failure (if any).
+func (c *API) GetSurveyStatus(surveyID SurveyID) ([]SurveyServerStatus, error) {
+	log.Lvl1(c, " asks for the status of the survey ", surveyID)
+	auth, err := signQuery(c.private, surveyStatement("status", surveyID))
+	if err != nil {
+		return nil, err
+	}
+	resp := SurveyStatusReport{}
+	err = c.SendProtobuf(c.entryPoint, &SurveyStatusQuery{SurveyID: surveyID, Auth: auth}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Servers, nil
+}
+
+// CancelSurvey cancels a survey on all the servers of its roster: it stops waiting for data and is not processed any
+// further.
+func (c *API) CancelSurvey(surveyID SurveyID) error {
+	log.Lvl1(c, " cancels the survey ", surveyID)
+	auth, err := signQuery(c.private, surveyStatement("cancel", surveyID))
+	if err != nil {
+		return err
+	}
+	resp := ServiceState{}
+	return c.SendProtobuf(c.entryPoint, &CancelSurveyQuery{SurveyID: surveyID, Auth: auth}, &resp)
+}
+
+// DeleteSurvey cancels a survey (if it is not finished) and deletes it with its data from all the servers of its
+// roster.
+func (c *API) DeleteSurvey(surveyID SurveyID) error {
+	log.Lvl1(c, " deletes the survey ", surveyID)
+	auth, err := signQuery(c.private, surveyStatement("delete", surveyID))
+	if err != nil {
+		return err
+	}
+	resp := ServiceState{}
+	return c.SendProtobuf(c.entryPoint, &DeleteSurveyQuery{SurveyID: surveyID, Auth: auth}, &resp)
+}
+
+// GetPrivacyBudget gets the privacy budget (epsilon) the client (as querier) consumed and has left on a dataset on the
+// server it is connected to.
+func (c *API) GetPrivacyBudget(datasetID DatasetID) (*PrivacyBudgetState, error) {
+	log.Lvl1(c, " asks ", c.entryPoint, " for its privacy budget on the dataset ", datasetID)
+	resp := PrivacyBudgetState{}
+	err := c.SendProtobuf(c.entryPoint, &PrivacyBudgetQuery{Querier: c.public, DatasetID: datasetID}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Helper Functions
 //______________________________________________________________________________________________________________________
 
-// EncryptDataToSurvey is used to encrypt client responses with the collective key
-func EncryptDataToSurvey(name string, surveyID SurveyID, dpClearResponses []libunlynx.DpClearResponse, groupKey kyber.Point, dataRepetitions int, count bool) (*SurveyResponseQuery, error) {
+// DecryptResults decrypts the groups and aggregating attributes of results key switched to the client's key.
+func (c *API) DecryptResults(result *ServiceResult) (*[][]int64, *[][]int64) {
+	grp := make([][]int64, len(result.Results))
+	aggr := make([][]int64, len(result.Results))
+	for i, res := range result.Results {
+		grp[i] = libunlynx.DecryptIntVector(c.private, &res.GroupByEnc)
+		aggr[i] = libunlynx.DecryptIntVector(c.private, &res.AggregatingAttributes)
+	}
+	return &grp, &aggr
+}
+
+// VerifyLinearCombinationProofs verifies that the linear combinations of public results (see
+// SurveyCreationQuery.LinearCombinations and PublicResults) were computed from their sums with the given weights. The
+// proofs, only created if the survey has proofs, contain the aggregated ciphertexts under the collective key: each
+// group of the results must have the proofs of its linear combinations over the very ciphertexts whose decryption is
+// proven (see VerifyPublicResults). The ciphertexts of key-switched results, of results with noise (DiffPrivacy) or of
+// merged small cells were changed after the linear combinations: they cannot be linked to the proofs.
+func VerifyLinearCombinationProofs(result *ServiceResult, linearCombinations []LinearCombination) error {
+	if len(result.DecryptionProofs.List) == 0 {
+		return fmt.Errorf("the linear combinations can only be linked to public results")
+	}
+	proofs := libunlynxaggr.PublishedLinearCombinationListProof{}
+	if err := proofs.FromBytes(result.LinearCombinationProofs); err != nil {
+		return err
+	}
+	nbrSums := len(result.Sum) - len(linearCombinations)
+	if nbrSums < 0 {
+		return fmt.Errorf("%d linear combinations for %d aggregating attributes", len(linearCombinations), len(result.Sum))
+	}
+
+	// the proofs are found by the ciphertext of their linear combination
+	byResult := make(map[string]libunlynxaggr.PublishedLinearCombinationProof, len(proofs.List))
+	for _, proof := range proofs.List {
+		if len(proof.Result) != 1 {
+			return fmt.Errorf("linear combination proof of %d ciphertexts", len(proof.Result))
+		}
+		key, err := proof.Result[0].Serialize()
+		if err != nil {
+			return err
+		}
+		byResult[key] = proof
+	}
+
+	for i, fr := range result.Results {
+		attributes := fr.AggregatingAttributes
+		if len(attributes) != len(result.Sum) {
+			return fmt.Errorf("group %d: %d aggregating attributes instead of %d", i, len(attributes), len(result.Sum))
+		}
+		for k, lc := range linearCombinations {
+			key, err := attributes[nbrSums+k].Serialize()
+			if err != nil {
+				return err
+			}
+			proof, ok := byResult[key]
+			if !ok {
+				return fmt.Errorf("group %d: no proof of the linear combination %s", i, lc.Name)
+			}
+			if len(proof.Data) != nbrSums || len(proof.Weights) != nbrSums {
+				return fmt.Errorf("group %d: the proof of the linear combination %s is not over its sums", i, lc.Name)
+			}
+			for j := 0; j < nbrSums; j++ {
+				if len(proof.Data[j]) != 1 || !proof.Data[j][0].Equal(&attributes[j]) || proof.Weights[j] != lc.Weights[result.Sum[j]] {
+					return fmt.Errorf("group %d: the proof of the linear combination %s is not over its sums", i, lc.Name)
+				}
+			}
+			if !libunlynxaggr.LinearCombinationProofVerification(proof) {
+				return fmt.Errorf("group %d: wrong proof of the linear combination %s", i, lc.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyPublicResults verifies the collective decryption proofs of public results with the current public keys of the
+// servers of the roster (e.g. roster.Publics() if none rotated its key) and returns the decrypted groups and
+// aggregating attributes (in [-limit, limit], e.g. the range of the fixed-point encoding of the sums).
+func VerifyPublicResults(result *ServiceResult, publics []kyber.Point, limit int64) (*[][]int64, *[][]int64, error) {
+	if len(result.DecryptionProofs.List) == 0 {
+		return nil, nil, fmt.Errorf("the results are not public")
+	}
+
+	proofs := libunlynxdecrypt.PublishedDecryptionListProof{}
+	if err := proofs.FromBytes(result.DecryptionProofs); err != nil {
+		return nil, nil, err
+	}
+	cv, lengths := protocolsunlynx.FilteredResponseToCipherVector(result.Results)
+	if !libunlynxdecrypt.DecryptionListProofVerification(proofs, cv, publics) {
+		return nil, nil, fmt.Errorf("wrong decryption proofs for the public results")
+	}
+	plaintexts, err := proofs.Plaintexts(cv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	grp := make([][]int64, len(lengths))
+	aggr := make([][]int64, len(lengths))
+	pos := 0
+	for i, length := range lengths {
+		grp[i] = make([]int64, length[0])
+		for j := range grp[i] {
+			if grp[i][j], err = libunlynx.DecodeIntRange(plaintexts[pos], limit); err != nil {
+				return nil, nil, err
+			}
+			pos++
+		}
+		aggr[i] = make([]int64, length[1])
+		for j := range aggr[i] {
+			if aggr[i][j], err = libunlynx.DecodeIntWithNegRange(plaintexts[pos], limit); err != nil {
+				return nil, nil, err
+			}
+			pos++
+		}
+	}
+	return &grp, &aggr, nil
+}
+
+// EncryptDataToSurvey is used to encrypt client responses with the collective key. The responses are bound to and signed
+// with the key pair of the data provider (dp).
+func EncryptDataToSurvey(name string, dp *key.Pair, surveyID SurveyID, dpClearResponses []libunlynx.DpClearResponse, groupKey kyber.Point, dataRepetitions int, count bool) (*SurveyResponseQuery, error) {
+	return EncryptDataToSurveyWithEncryptor(name, dp, surveyID, dpClearResponses, libunlynx.NewEncryptor(groupKey), dataRepetitions, count)
+}
+
+// EncryptDataToSurveyWithEncryptor is used to encrypt client responses with an Encryptor bound to the collective key
+func EncryptDataToSurveyWithEncryptor(name string, dp *key.Pair, surveyID SurveyID, dpClearResponses []libunlynx.DpClearResponse, encryptor *libunlynx.Encryptor, dataRepetitions int, count bool) (*SurveyResponseQuery, error) {
+	return EncryptDataToSurveyWithRangeProofs(name, dp, surveyID, dpClearResponses, encryptor, nil, dataRepetitions, count)
+}
+
+// encryptResponses encrypts client responses with an Encryptor, their proofs of knowledge are bound to the data
+// provider with the given identity
+func encryptResponses(name, dpID string, surveyID SurveyID, dpClearResponses []libunlynx.DpClearResponse, encryptor *libunlynx.Encryptor, dataRepetitions int, count bool) ([]libunlynx.DpResponseToSend, error) {
 	nbrResponses := len(dpClearResponses)
 
 	log.Lvl1(name, " responds with ", nbrResponses, " response(s)")
@@ -128,7 +613,7 @@ func EncryptDataToSurvey(name string, surveyID SurveyID, dpClearResponses []libu
 			i = i * dataRepetitions
 			if i < len(dpResponses) {
 				var tmpErr error
-				dpResponses[i], tmpErr = libunlynx.EncryptDpClearResponse(v, groupKey, count)
+				dpResponses[i], tmpErr = libunlynx.EncryptDpClearResponseWithEncryptor(v, encryptor, count, string(surveyID), dpID)
 				if tmpErr != nil {
 					mutex.Lock()
 					err = tmpErr
@@ -143,6 +628,9 @@ func EncryptDataToSurvey(name string, surveyID SurveyID, dpClearResponses []libu
 					dpResponses[i+j].WhereEnc = dpResponses[i].WhereEnc
 					dpResponses[i+j].AggregatingAttributesClear = dpResponses[i].AggregatingAttributesClear
 					dpResponses[i+j].AggregatingAttributesEnc = dpResponses[i].AggregatingAttributesEnc
+					dpResponses[i+j].GroupByEncProofs = dpResponses[i].GroupByEncProofs
+					dpResponses[i+j].WhereEncProofs = dpResponses[i].WhereEncProofs
+					dpResponses[i+j].AggregatingAttributesEncProofs = dpResponses[i].AggregatingAttributesEncProofs
 				}
 			}
 		}(i, v)
@@ -154,7 +642,148 @@ func EncryptDataToSurvey(name string, surveyID SurveyID, dpClearResponses []libu
 		return nil, err
 	}
 
-	return &SurveyResponseQuery{SurveyID: surveyID, Responses: dpResponses}, nil
+	return dpResponses, nil
+}
+
+// EncryptDataToSurveyWithRangeProofs is used to encrypt client responses with an Encryptor and to prove that their
+// aggregating attributes lie in the given ranges
+func EncryptDataToSurveyWithRangeProofs(name string, dp *key.Pair, surveyID SurveyID, dpClearResponses []libunlynx.DpClearResponse, encryptor *libunlynx.Encryptor, ranges map[string]*libunlynxrange.Bounds, dataRepetitions int, count bool) (*SurveyResponseQuery, error) {
+	dpID, err := libunlynx.SerializePoint(dp.Public)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := encryptResponses(name, dpID, surveyID, dpClearResponses, encryptor, dataRepetitions, count)
+	if err != nil {
+		return nil, err
+	}
+	resp := &SurveyResponseQuery{SurveyID: surveyID, Responses: responses}
+	if err := addRangeProofsToResponses(resp, dpID, dpClearResponses, encryptor, ranges, dataRepetitions, count); err != nil {
+		return nil, err
+	}
+	if err := resp.Sign(dp.Private); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// addRangeProofsToResponses adds the range proofs of the aggregating attributes with a declared range to the responses
+// of a data provider
+func addRangeProofsToResponses(resp *SurveyResponseQuery, dpID string, dpClearResponses []libunlynx.DpClearResponse, encryptor *libunlynx.Encryptor, ranges map[string]*libunlynxrange.Bounds, dataRepetitions int, count bool) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	var err error
+	mutex := sync.Mutex{}
+	wg := libunlynx.StartParallelize(len(dpClearResponses))
+	for i, v := range dpClearResponses {
+		go func(i int, v libunlynx.DpClearResponse) {
+			defer wg.Done()
+			// the responses repeated dataRepetitions times share the same maps
+			i = i * dataRepetitions
+			if i >= len(resp.Responses) {
+				return
+			}
+			proofs, tmpErr := addRangeProofs(&resp.Responses[i], v.AggregatingAttributesEnc, encryptor, ranges, count, string(resp.SurveyID), dpID)
+			if tmpErr != nil {
+				mutex.Lock()
+				err = tmpErr
+				mutex.Unlock()
+				return
+			}
+			for j := 0; j < dataRepetitions && j+i < len(resp.Responses); j++ {
+				resp.Responses[i+j].AggregatingAttributesRangeProofs = proofs
+			}
+		}(i, v)
+	}
+	libunlynx.EndParallelize(wg)
+	return err
+}
+
+// addRangeProofs re-encrypts the aggregating attributes with a declared range (with their proofs of knowledge) and
+// returns their range proofs, along with the proofs that the histogram bins of each attribute sum to 1 (see
+// libunlynxstatistics.HistogramTotals)
+func addRangeProofs(response *libunlynx.DpResponseToSend, clear map[string]int64, encryptor *libunlynx.Encryptor, ranges map[string]*libunlynxrange.Bounds, count bool, surveyID, dpID string) (map[string][]byte, error) {
+	proofs := make(map[string][]byte)
+	cts := make(map[string]*libunlynx.CipherText)
+	rs := make(map[string]kyber.Scalar)
+	for attr, bounds := range ranges {
+		v, ok := clear[attr]
+		if attr == "count" && count {
+			v, ok = 1, true
+		}
+		if !ok {
+			continue
+		}
+
+		ct, r := encryptor.EncryptIntGetR(v)
+		prp, err := libunlynxrange.RangeProofCreation(*ct, v, r, *bounds, encryptor.PubKey)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %v", attr, err)
+		}
+		if response.AggregatingAttributesEnc[attr], err = ct.ToBytes(); err != nil {
+			return nil, err
+		}
+		if response.AggregatingAttributesEncProofs[attr], err = libunlynx.KnowledgeProofCreation(*ct, r, surveyID, dpID); err != nil {
+			return nil, err
+		}
+		if proofs[attr], err = prp.ToBytes(); err != nil {
+			return nil, err
+		}
+		cts[attr], rs[attr] = ct, r
+	}
+
+	// the sum of the bins is encrypted with the sum of their randomness
+	for attribute, bins := range libunlynxstatistics.HistogramTotals(ranges) {
+		total, r, value := *libunlynx.NewCipherText(), libunlynx.SuiTe.Scalar().Zero(), int64(0)
+		encrypted := true
+		for _, bin := range bins {
+			if cts[bin] == nil {
+				encrypted = false
+				break
+			}
+			total.Add(total, *cts[bin])
+			r.Add(r, rs[bin])
+			value += clear[bin]
+		}
+		// the servers check the sum of the bins sent in clear themselves
+		if !encrypted {
+			continue
+		}
+		prp, err := libunlynxrange.RangeProofCreation(total, value, r, libunlynxrange.Bounds{Min: 1, Max: 1}, encryptor.PubKey)
+		if err != nil {
+			return nil, fmt.Errorf("histogram of %s: %v", attribute, err)
+		}
+		if proofs[libunlynx.HistogramTotalName(attribute)], err = prp.ToBytes(); err != nil {
+			return nil, err
+		}
+	}
+	return proofs, nil
+}
+
+// resultsQuery returns a results query for a survey, signed with the client's key, to get its results switched to the
+// client's key
+func (c *API) resultsQuery(surveyID SurveyID) (*SurveyResultsQuery, error) {
+	resq := &SurveyResultsQuery{SurveyID: surveyID, ClientPublic: c.public}
+	if err := resq.Sign(c.private); err != nil {
+		return nil, err
+	}
+	return resq, nil
+}
+
+// keys returns the key pair of the client
+func (c *API) keys() *key.Pair {
+	return &key.Pair{Public: c.public, Private: c.private}
+}
+
+// getEncryptor returns an Encryptor for the given collective key, reusing the previous one if the key did not change.
+func (c *API) getEncryptor(groupKey kyber.Point) *libunlynx.Encryptor {
+	c.encryptorMutex.Lock()
+	defer c.encryptorMutex.Unlock()
+	if c.encryptor == nil || !c.encryptor.PubKey.Equal(groupKey) {
+		c.encryptor = libunlynx.NewEncryptor(groupKey)
+	}
+	return c.encryptor
 }
 
 // String permits to have the string representation of a client.
@@ -0,0 +1,270 @@
+package servicesunlynx
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// keepAliveInterval is the interval at which an empty progress is streamed to the subscribers when no phase starts
+// (the client connections time out without messages)
+const keepAliveInterval = time.Minute
+
+// ticketRetention is how long a ticket is kept once its processing is done, for its querier to get the results
+const ticketRetention = time.Hour
+
+func init() {
+	network.RegisterMessage(&AsyncResultsQuery{})
+	network.RegisterMessage(&ResultsTicket{})
+	network.RegisterMessage(&ResultsPollQuery{})
+	network.RegisterMessage(&ResultsSubscription{})
+	network.RegisterMessage(&ResultsProgress{})
+}
+
+// Messages
+//______________________________________________________________________________________________________________________
+
+// AsyncResultsQuery is used by the querier to start the processing of a survey without waiting for its results: a
+// ticket is returned to poll its progress (see ResultsPollQuery) or to subscribe to it (see ResultsSubscription).
+type AsyncResultsQuery struct {
+	SurveyID     SurveyID
+	ClientPublic kyber.Point
+	Signature    []byte // see SurveyResultsQuery.Signature
+	// Auth is the querier's signature of the query, with the nonce and expiry rejecting its replays (see
+	// Service.authorize)
+	Auth QuerierSignature
+}
+
+// ResultsTicket identifies the processing of a survey started by an AsyncResultsQuery.
+type ResultsTicket struct {
+	Ticket   string
+	SurveyID SurveyID
+}
+
+// ResultsPollQuery is used to get the progress (and the results when it is done) of the processing of a survey.
+type ResultsPollQuery struct {
+	Ticket string
+}
+
+// ResultsSubscription is used to stream the progress of the processing of a survey: a ResultsProgress is sent with the
+// events of each phase, the last one is done and contains the results.
+type ResultsSubscription struct {
+	Ticket string
+}
+
+// ResultsProgress contains the progress events of the processing of a survey and, once it is done, its results or the
+// reason of its failure.
+type ResultsProgress struct {
+	Ticket string
+	Events []ProgressEvent
+	Done   bool
+	Error  string
+	Result ServiceResult
+}
+
+// ProgressEvent is sent when the processing of a survey enters a phase
+type ProgressEvent struct {
+	Phase SurveyPhase
+	Time  int64 // in nanoseconds since the Unix epoch
+}
+
+// Tickets
+//______________________________________________________________________________________________________________________
+
+// resultsTicket records the progress of the processing of a survey started by an AsyncResultsQuery
+type resultsTicket struct {
+	mutex    sync.Mutex
+	surveyID SurveyID
+	events   []ProgressEvent
+	done     bool
+	err      string
+	result   ServiceResult
+
+	// updated is closed (and replaced) at each update to wake up the subscribers
+	updated chan struct{}
+}
+
+func newResultsTicket(sid SurveyID) *resultsTicket {
+	return &resultsTicket{surveyID: sid, updated: make(chan struct{})}
+}
+
+// addEvent records that the processing entered a phase
+func (rt *resultsTicket) addEvent(phase SurveyPhase) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	rt.events = append(rt.events, ProgressEvent{Phase: phase, Time: time.Now().UnixNano()})
+	close(rt.updated)
+	rt.updated = make(chan struct{})
+}
+
+// finish records the results of the processing or its error
+func (rt *resultsTicket) finish(result *ServiceResult, err error) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	rt.done = true
+	if err != nil {
+		rt.err = err.Error()
+	} else {
+		rt.result = *result
+	}
+	close(rt.updated)
+	rt.updated = make(chan struct{})
+}
+
+// progress returns the events from the given one (and the results if the processing is done) with the channel closed
+// at the next update
+func (rt *resultsTicket) progress(ticket string, from int) (*ResultsProgress, chan struct{}) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	progress := &ResultsProgress{Ticket: ticket, Events: append([]ProgressEvent{}, rt.events[from:]...), Done: rt.done, Error: rt.err}
+	if rt.done {
+		progress.Result = rt.result
+	}
+	return progress, rt.updated
+}
+
+func (s *Service) getTicket(ticket string) (*resultsTicket, error) {
+	rt, err := s.tickets.Get(ticket)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting ticket "+ticket+": %v", err)
+	}
+	if rt == nil {
+		return nil, fmt.Errorf("unknown ticket " + ticket)
+	}
+	return rt.(*resultsTicket), nil
+}
+
+// surveyTickets returns the IDs of the tickets of a survey
+func (s *Service) surveyTickets(sid SurveyID) []string {
+	var tickets []string
+	for _, e := range s.tickets.ToSlice() {
+		if e.Value().(*resultsTicket).surveyID == sid {
+			tickets = append(tickets, e.Key().(string))
+		}
+	}
+	return tickets
+}
+
+// notifyPhase adds a progress event to the tickets of a survey
+func (s *Service) notifyPhase(sid SurveyID, phase SurveyPhase) {
+	for _, ticket := range s.surveyTickets(sid) {
+		if rt, err := s.getTicket(ticket); err == nil {
+			rt.addEvent(phase)
+		}
+	}
+}
+
+// Handlers
+//______________________________________________________________________________________________________________________
+
+// HandleAsyncResultsQuery starts the processing of a survey in the background and returns a ticket to follow it.
+func (s *Service) HandleAsyncResultsQuery(arq *AsyncResultsQuery) (network.Message, error) {
+	log.Lvl1(s.ServerIdentity(), " received an asynchronous survey result query")
+
+	resq := &SurveyResultsQuery{SurveyID: arq.SurveyID, ClientPublic: arq.ClientPublic, Signature: arq.Signature}
+	ticket := uuid.NewV4().String()
+	rt := newResultsTicket(arq.SurveyID)
+	if err := s.addTicket(arq, resq, ticket, rt); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		msg, err := s.HandleSurveyResultsQuery(resq)
+		if err != nil {
+			log.Error(s.ServerIdentity(), " could not process survey ", arq.SurveyID, ": ", err)
+			rt.finish(nil, err)
+		} else {
+			rt.finish(msg.(*ServiceResult), nil)
+		}
+		time.AfterFunc(ticketRetention, func() { s.tickets.Remove(ticket) })
+	}()
+	return &ResultsTicket{Ticket: ticket, SurveyID: arq.SurveyID}, nil
+}
+
+// addTicket checks an asynchronous results query and adds its ticket, under the lock of the survey: only one query
+// starts the processing of a survey
+func (s *Service) addTicket(arq *AsyncResultsQuery, resq *SurveyResultsQuery, ticket string, rt *resultsTicket) error {
+	defer s.lockSurvey(arq.SurveyID)()
+
+	survey, err := s.getSurvey(arq.SurveyID)
+	if err != nil {
+		return err
+	}
+	if survey.Status != SurveyCollecting {
+		return fmt.Errorf("survey %s is %s", arq.SurveyID, survey.Status)
+	}
+	if len(s.surveyTickets(arq.SurveyID)) > 0 {
+		return fmt.Errorf("the results of survey %s were already asked for", arq.SurveyID)
+	}
+	if s.Policy != nil {
+		if err := s.Policy.AuthorizeResults(survey.Query, resq); err != nil {
+			return err
+		}
+		if err := s.authorize(arq.Auth, surveyStatement("results", arq.SurveyID), survey.Query.Querier); err != nil {
+			return err
+		}
+	}
+	_, err = s.tickets.Put(ticket, rt)
+	return err
+}
+
+// HandleResultsPollQuery returns the progress of the processing of a survey (and its results if it is done).
+func (s *Service) HandleResultsPollQuery(rpq *ResultsPollQuery) (network.Message, error) {
+	rt, err := s.getTicket(rpq.Ticket)
+	if err != nil {
+		return nil, err
+	}
+	progress, _ := rt.progress(rpq.Ticket, 0)
+	return progress, nil
+}
+
+// HandleResultsSubscription streams the progress of the processing of a survey: the events of each phase as it starts
+// and, at last, the results.
+func (s *Service) HandleResultsSubscription(rs *ResultsSubscription) (chan *ResultsProgress, chan bool, error) {
+	rt, err := s.getTicket(rs.Ticket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan *ResultsProgress, 10)
+	stop := make(chan bool)
+	go func() {
+		defer close(out)
+		sent := 0
+		for {
+			progress, updated := rt.progress(rs.Ticket, sent)
+			if len(progress.Events) > 0 || progress.Done {
+				select {
+				case out <- progress:
+				case <-stop:
+					return
+				}
+				sent += len(progress.Events)
+			}
+			if progress.Done {
+				return
+			}
+
+			select {
+			case <-updated:
+			case <-time.After(keepAliveInterval):
+				select {
+				case out <- &ResultsProgress{Ticket: rs.Ticket}:
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return out, stop, nil
+}
@@ -0,0 +1,596 @@
+package servicesunlynx
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/tools"
+	"github.com/satori/go.uuid"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.etcd.io/bbolt"
+)
+
+// DatasetID identifies a dataset
+type DatasetID string
+
+func init() {
+	network.RegisterMessage(&DatasetResponseQuery{})
+	network.RegisterMessage(&DatasetState{})
+	network.RegisterMessage(&DatasetRecord{})
+}
+
+// Messages
+//______________________________________________________________________________________________________________________
+
+// DatasetCreationQuery is used to create a dataset on all the servers of its roster: the data providers upload their
+// responses once to the dataset, and several surveys can then be run on them (see SurveyCreationQuery.DatasetID).
+type DatasetCreationQuery struct {
+	DatasetID    DatasetID // chosen by the server receiving the query if empty
+	Roster       onet.Roster
+	IntraMessage bool
+	Source       *network.ServerIdentity
+
+	// ThresholdKeyID identifies the distributed key the responses are encrypted under (the roster aggregate is used if
+	// nil), see SurveyCreationQuery.ThresholdKeyID
+	ThresholdKeyID onet.RosterID
+
+	// Schema declares the attributes of the responses: the uploaded responses and the surveys run on the dataset are
+	// validated against it
+	Schema *libunlynx.Schema
+
+	// Expiry is the time (in seconds since the Unix epoch) after which the dataset and its responses are deleted (never
+	// if 0)
+	Expiry int64
+
+	// Auth is the signature of the querier creating the dataset: the servers with a query policy only create the
+	// datasets of the queriers it allows (the querier then chooses the dataset ID)
+	Auth QuerierSignature
+}
+
+// DatasetCreationFinished is used to ensure that all servers have created the dataset
+type DatasetCreationFinished struct {
+	DatasetID DatasetID
+	// Refusal is set if the server could not create the dataset
+	Refusal string
+}
+
+// DatasetCreationCancelled is sent by the server receiving a dataset creation query to the other servers when the
+// dataset could not be created by all of them, so that they remove their copy
+type DatasetCreationCancelled struct {
+	DatasetID DatasetID
+	Source    *network.ServerIdentity
+	Reason    string
+}
+
+// DatasetResponseQuery is used by a data provider to upload its responses to a dataset.
+type DatasetResponseQuery struct {
+	DatasetID DatasetID
+	DpPublic  kyber.Point // key of the data provider, to which the proofs of knowledge of the responses are bound
+	Signature []byte      // signature of the data provider (see SurveyResponseQuery.Sign)
+	Responses []libunlynx.DpResponseToSend
+}
+
+// DatasetState is the state of a dataset on the server answering a dataset query.
+type DatasetState struct {
+	DatasetID DatasetID
+	DpCount   int64 // number of data providers who have uploaded their responses to the server
+}
+
+// Dataset
+//______________________________________________________________________________________________________________________
+
+// Dataset contains the responses uploaded by the data providers to a server. They are never processed directly: each
+// survey run on the dataset gets its own copy of them.
+type Dataset struct {
+	Query     DatasetCreationQuery
+	Responses []libunlynx.DpResponseToSend
+	DpCount   int64 // number of data providers who have already uploaded their responses
+
+	// DataProviders contains the identities of the data providers who have already uploaded their responses (they
+	// cannot upload them twice)
+	DataProviders map[string]bool
+
+	CreationChannel chan string // To wait for the dataset to be created by all the servers ("" or the refusal of a server)
+}
+
+// expired checks if the expiry of the dataset is passed
+func (dataset *Dataset) expired() bool {
+	return dataset.Query.Expiry > 0 && time.Now().Unix() >= dataset.Query.Expiry
+}
+
+func (s *Service) getDataset(did DatasetID) (Dataset, error) {
+	dataset, err := s.Datasets.Get(string(did))
+	if err != nil {
+		return Dataset{}, fmt.Errorf("error while getting dataset "+string(did)+": %v", err)
+	}
+	if dataset == nil {
+		return Dataset{}, fmt.Errorf("unknown dataset " + string(did))
+	}
+
+	result := dataset.(Dataset)
+	if result.expired() {
+		if err := s.deleteDataset(did); err != nil {
+			log.Error(err)
+		}
+		return Dataset{}, fmt.Errorf("dataset %s expired", did)
+	}
+	return result, nil
+}
+
+func (s *Service) putDataset(did DatasetID, dataset Dataset) error {
+	if _, err := s.Datasets.Put(string(did), dataset); err != nil {
+		return err
+	}
+	return s.saveDataset(did, dataset)
+}
+
+// deleteDataset removes a dataset with its responses (the surveys run on it keep their copy)
+func (s *Service) deleteDataset(did DatasetID) error {
+	s.Datasets.Remove(string(did))
+	if s.DatasetStore != nil {
+		return s.DatasetStore.Delete(did)
+	}
+	return nil
+}
+
+// datasetKey returns the collective key the responses of a dataset are encrypted under
+func (s *Service) datasetKey(query DatasetCreationQuery) (kyber.Point, error) {
+	collectiveKey, _, err := s.surveyKeys(SurveyCreationQuery{Roster: query.Roster, ThresholdKeyID: query.ThresholdKeyID})
+	return collectiveKey, err
+}
+
+// Handlers
+//______________________________________________________________________________________________________________________
+
+// HandleDatasetCreationQuery creates a dataset on the server. The server receiving the query from the client forwards
+// it to the other servers of the roster and waits for them to create it.
+func (s *Service) HandleDatasetCreationQuery(dcq *DatasetCreationQuery) (network.Message, error) {
+	log.Lvl1(s.ServerIdentity(), " received a dataset creation query")
+
+	if s.Policy != nil {
+		err := fmt.Errorf("the dataset ID must be chosen by the querier")
+		if dcq.DatasetID != "" {
+			err = s.authorize(dcq.Auth, dcq.statement(), nil)
+		}
+		if err != nil {
+			return nil, s.refuseDataset(dcq, err)
+		}
+	}
+
+	// if this server is the one receiving the query from the client
+	if !dcq.IntraMessage && dcq.DatasetID == "" {
+		dcq.DatasetID = DatasetID(uuid.NewV4().String())
+	}
+
+	if err := s.createDataset(dcq); err != nil {
+		return nil, s.refuseDataset(dcq, err)
+	}
+	if dcq.IntraMessage {
+		// warn 'root' node that it has created the dataset
+		return nil, s.SendRaw(dcq.Source, &DatasetCreationFinished{DatasetID: dcq.DatasetID})
+	}
+	log.Lvl1(s.ServerIdentity(), " handles this new dataset ", dcq.DatasetID)
+
+	dcq.IntraMessage = true
+	dcq.Source = s.ServerIdentity()
+	if err := libunlynxtools.SendISMOthers(s.ServiceProcessor, &dcq.Roster, dcq); err != nil {
+		return nil, err
+	}
+	dcq.IntraMessage = false
+
+	dataset, err := s.getDataset(dcq.DatasetID)
+	if err != nil {
+		return nil, err
+	}
+	// all the answers are awaited before a refused dataset is cancelled, so that no server creates it afterwards
+	reason := ""
+	timeout := time.After(libunlynx.TIMEOUT)
+	for counter := len(dcq.Roster.List) - 1; counter > 0; counter-- {
+		select {
+		case refusal := <-dataset.CreationChannel:
+			if refusal != "" && reason == "" {
+				reason = "refused by " + refusal
+			}
+		case <-timeout:
+			reason = "not created by all the servers before the timeout"
+			counter = 0
+		}
+	}
+	if reason != "" {
+		s.cancelDatasetCreation(dcq, reason)
+		return nil, fmt.Errorf("dataset %s %s", dcq.DatasetID, reason)
+	}
+	return &DatasetState{DatasetID: dcq.DatasetID}, nil
+}
+
+// cancelDatasetCreation removes a dataset that could not be created by all the servers of its roster, on this server
+// and on the others
+func (s *Service) cancelDatasetCreation(dcq *DatasetCreationQuery, reason string) {
+	if err := s.deleteDataset(dcq.DatasetID); err != nil {
+		log.Error(err)
+	}
+	if err := libunlynxtools.SendISMOthers(s.ServiceProcessor, &dcq.Roster, &DatasetCreationCancelled{DatasetID: dcq.DatasetID, Source: s.ServerIdentity(), Reason: reason}); err != nil {
+		log.Error(err)
+	}
+}
+
+// refuseDataset warns the server that forwarded a dataset creation query (if any) that this server refuses to create
+// the dataset, and returns the reason
+func (s *Service) refuseDataset(dcq *DatasetCreationQuery, reason error) error {
+	if dcq.IntraMessage {
+		refusal := s.ServerIdentity().String() + ": " + reason.Error()
+		if errSend := s.SendRaw(dcq.Source, &DatasetCreationFinished{DatasetID: dcq.DatasetID, Refusal: refusal}); errSend != nil {
+			return errSend
+		}
+	}
+	return reason
+}
+
+// HandleDatasetCreationFinished handles the message DatasetCreationFinished: one of the nodes has created the dataset
+func (s *Service) HandleDatasetCreationFinished(dcf *DatasetCreationFinished) (network.Message, error) {
+	dataset, err := s.Datasets.Get(string(dcf.DatasetID))
+	if err != nil {
+		return nil, err
+	}
+	if dataset == nil {
+		return nil, fmt.Errorf("unknown dataset " + string(dcf.DatasetID))
+	}
+	dataset.(Dataset).CreationChannel <- dcf.Refusal
+	return nil, nil
+}
+
+// HandleDatasetCreationCancelled handles the message DatasetCreationCancelled: the dataset is removed from the server if
+// it was created from the cancelled query (a server refusing a dataset keeps the one it already had under this ID)
+func (s *Service) HandleDatasetCreationCancelled(dcc *DatasetCreationCancelled) (network.Message, error) {
+	dataset, err := s.Datasets.Get(string(dcc.DatasetID))
+	if err != nil {
+		return nil, err
+	}
+	if dataset == nil {
+		return nil, nil
+	}
+	if source := dataset.(Dataset).Query.Source; source == nil || !source.Equal(dcc.Source) {
+		return nil, fmt.Errorf("dataset %s was not created by %s", dcc.DatasetID, dcc.Source)
+	}
+	log.Lvl1(s.ServerIdentity(), " removes the dataset ", dcc.DatasetID, " (", dcc.Reason, ")")
+	return nil, s.deleteDataset(dcc.DatasetID)
+}
+
+// HandleDatasetResponseQuery handles the upload of the responses of a data provider to a dataset.
+func (s *Service) HandleDatasetResponseQuery(drq *DatasetResponseQuery) (network.Message, error) {
+	// the uploads are serialized as they all append to the dataset
+	s.datasetMutex.Lock()
+	defer s.datasetMutex.Unlock()
+
+	dataset, err := s.getDataset(drq.DatasetID)
+	if err != nil {
+		return nil, err
+	}
+
+	dpID, err := verifyResponsesSignature(string(drq.DatasetID), drq.DpPublic, drq.Signature, drq.Responses)
+	if err != nil {
+		return nil, err
+	}
+	if dataset.DataProviders[dpID] {
+		return nil, fmt.Errorf("data provider %s already uploaded its responses to dataset %s", dpID, drq.DatasetID)
+	}
+
+	for i, v := range drq.Responses {
+		// the data provider must know the plaintexts of its ciphertexts (e.g. it cannot copy another one's responses)
+		if err := v.VerifyKnowledgeProofs(string(drq.DatasetID), dpID); err != nil {
+			return nil, fmt.Errorf("response %d: %v", i, err)
+		}
+		if dataset.Query.Schema != nil {
+			dr := libunlynx.DpResponse{}
+			if err := dr.FromDpResponseToSend(v); err != nil {
+				return nil, err
+			}
+			if err := dataset.Query.Schema.ValidateDpResponse(dr); err != nil {
+				return nil, fmt.Errorf("response %d: %v", i, err)
+			}
+		}
+	}
+
+	dataset.Responses = append(dataset.Responses, drq.Responses...)
+	dataset.DpCount++
+	if dataset.DataProviders == nil {
+		dataset.DataProviders = make(map[string]bool)
+	}
+	dataset.DataProviders[dpID] = true
+	if err := s.putDataset(drq.DatasetID, dataset); err != nil {
+		return nil, err
+	}
+
+	log.Lvl1(s.ServerIdentity(), " uploaded response data for dataset ", drq.DatasetID)
+	return &DatasetState{DatasetID: drq.DatasetID, DpCount: dataset.DpCount}, nil
+}
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// createDataset validates a dataset creation query and instantiates the dataset
+func (s *Service) createDataset(dcq *DatasetCreationQuery) error {
+	if dcq.Expiry > 0 && time.Now().Unix() >= dcq.Expiry {
+		return fmt.Errorf("dataset %s expires in the past", dcq.DatasetID)
+	}
+	if dcq.Schema != nil {
+		if err := dcq.Schema.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.datasetKey(*dcq); err != nil {
+		return err
+	}
+	if dataset, _ := s.Datasets.Get(string(dcq.DatasetID)); dataset != nil {
+		return fmt.Errorf("dataset %s already exists", dcq.DatasetID)
+	}
+
+	return s.putDataset(dcq.DatasetID, Dataset{Query: *dcq, CreationChannel: make(chan string, 100)})
+}
+
+// prepareDatasetSurvey checks that a survey can be run on its dataset and completes its query: the schema of the
+// dataset is used if the query has none. A survey on a dataset does not wait for data providers.
+func (s *Service) prepareDatasetSurvey(query *SurveyCreationQuery) error {
+	dataset, err := s.getDataset(query.DatasetID)
+	if err != nil {
+		return err
+	}
+	if len(query.MapDPs) > 0 || query.AppFlag {
+		return fmt.Errorf("survey on dataset %s cannot wait for data providers", query.DatasetID)
+	}
+	if len(query.Ranges) > 0 {
+		return fmt.Errorf("survey on dataset %s cannot declare ranges: the responses are already uploaded", query.DatasetID)
+	}
+	if query.Schema == nil {
+		query.Schema = dataset.Query.Schema
+	}
+
+	datasetKey, err := s.datasetKey(dataset.Query)
+	if err != nil {
+		return err
+	}
+	surveyKey, _, err := s.surveyKeys(*query)
+	if err != nil {
+		return err
+	}
+	if !datasetKey.Equal(surveyKey) {
+		return fmt.Errorf("survey key differs from the key of dataset %s", query.DatasetID)
+	}
+	return nil
+}
+
+// loadDataset inserts a copy of the responses of its dataset in a survey, as if they were sent by the data providers
+func (s *Service) loadDataset(sid SurveyID) error {
+	var datasetID DatasetID
+	err := s.updateSurvey(sid, func(survey *Survey) error {
+		datasetID = survey.Query.DatasetID
+		dataset, err := s.getDataset(datasetID)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range dataset.Responses {
+			dr := libunlynx.DpResponse{}
+			if err := dr.FromDpResponseToSend(v); err != nil {
+				return err
+			}
+			if err := survey.InsertDpResponse(dr, survey.Query.Proofs, survey.Query.GroupBy, survey.Query.Sum, survey.Query.Where); err != nil {
+				return err
+			}
+		}
+		survey.DpCount = dataset.DpCount
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Lvl1(s.ServerIdentity(), " loaded the responses of dataset ", datasetID, " for survey ", sid)
+	return nil
+}
+
+// sortedKeys returns the keys of a map of ciphertext bytes in increasing order
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// datasetsCipherTexts returns the ciphertexts of the responses of the datasets stored by the server that are
+// re-encrypted by a key rotation and a function to replace them (see surveysCipherTexts).
+func (s *Service) datasetsCipherTexts(tn *onet.TreeNodeInstance) (libunlynx.CipherVector, func(libunlynx.CipherVector) error) {
+	entries := s.Datasets.ToSlice()
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		dataset, err := s.getDataset(DatasetID(e.Key().(string)))
+		if err == nil && s.rotatedBy(tn, &dataset.Query.Roster, dataset.Query.ThresholdKeyID) {
+			ids = append(ids, e.Key().(string))
+		}
+	}
+	sort.Strings(ids)
+
+	cv := libunlynx.CipherVector{}
+	lengths := make([]int, len(ids))
+	for i, id := range ids {
+		dataset, err := s.getDataset(DatasetID(id))
+		if err != nil {
+			continue
+		}
+		cts, err := responsesCipherTexts(dataset.Responses)
+		if err != nil {
+			log.Error(s.ServerIdentity(), " could not read the ciphertexts of dataset ", id, ": ", err)
+			continue
+		}
+		lengths[i] = len(cts)
+		cv = append(cv, cts...)
+	}
+
+	return cv, func(rotated libunlynx.CipherVector) error {
+		if len(rotated) != len(cv) {
+			return fmt.Errorf("%d re-encrypted ciphertexts for %d ciphertexts", len(rotated), len(cv))
+		}
+		pos := 0
+		for i, id := range ids {
+			if lengths[i] == 0 {
+				continue
+			}
+			dataset, err := s.getDataset(DatasetID(id))
+			if err != nil {
+				return err
+			}
+			for j, r := range dataset.Responses {
+				// the maps may be shared with other responses (e.g. repeated data)
+				r.WhereEnc, r.GroupByEnc, r.AggregatingAttributesEnc = copyBytesMap(r.WhereEnc), copyBytesMap(r.GroupByEnc), copyBytesMap(r.AggregatingAttributesEnc)
+				for _, m := range []map[string][]byte{r.WhereEnc, r.GroupByEnc, r.AggregatingAttributesEnc} {
+					for _, k := range sortedKeys(m) {
+						if m[k], err = rotated[pos].ToBytes(); err != nil {
+							return err
+						}
+						pos++
+					}
+				}
+				dataset.Responses[j] = r
+			}
+			if err := s.putDataset(DatasetID(id), dataset); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// responsesCipherTexts returns the encrypted attributes of responses, in the order of their names
+func responsesCipherTexts(responses []libunlynx.DpResponseToSend) (libunlynx.CipherVector, error) {
+	cv := libunlynx.CipherVector{}
+	for _, r := range responses {
+		for _, m := range []map[string][]byte{r.WhereEnc, r.GroupByEnc, r.AggregatingAttributesEnc} {
+			for _, k := range sortedKeys(m) {
+				ct := libunlynx.CipherText{}
+				if err := ct.FromBytes(m[k]); err != nil {
+					return nil, err
+				}
+				cv = append(cv, ct)
+			}
+		}
+	}
+	return cv, nil
+}
+
+// copyBytesMap returns a copy of a map of ciphertext bytes (nil if it is nil)
+func copyBytesMap(m map[string][]byte) map[string][]byte {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string][]byte, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+// Persistence
+//______________________________________________________________________________________________________________________
+
+// DatasetRecord is the persistent part of a dataset: its definition and the uploaded responses.
+type DatasetRecord struct {
+	Query         DatasetCreationQuery
+	Responses     []libunlynx.DpResponseToSend
+	DpCount       int64
+	DataProviders map[string]bool
+}
+
+// DatasetStore persists the datasets of a server so that they survive a restart.
+type DatasetStore interface {
+	// Save creates or replaces the record of a dataset
+	Save(did DatasetID, record *DatasetRecord) error
+	// Delete removes the record of a dataset
+	Delete(did DatasetID) error
+	// LoadAll returns the records of all the datasets
+	LoadAll() (map[DatasetID]*DatasetRecord, error)
+}
+
+// boltDatasetStore is a DatasetStore keeping the datasets in a bucket of a bbolt database
+type boltDatasetStore struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+// NewBoltDatasetStore creates a DatasetStore keeping the datasets in an existing bucket of a bbolt database.
+func NewBoltDatasetStore(db *bbolt.DB, bucket []byte) DatasetStore {
+	return &boltDatasetStore{db: db, bucket: bucket}
+}
+
+// Save creates or replaces the record of a dataset
+func (bds *boltDatasetStore) Save(did DatasetID, record *DatasetRecord) error {
+	return boltPut(bds.db, bds.bucket, string(did), record)
+}
+
+// Delete removes the record of a dataset
+func (bds *boltDatasetStore) Delete(did DatasetID) error {
+	return boltDelete(bds.db, bds.bucket, string(did))
+}
+
+// LoadAll returns the records of all the datasets
+func (bds *boltDatasetStore) LoadAll() (map[DatasetID]*DatasetRecord, error) {
+	records := make(map[DatasetID]*DatasetRecord)
+	err := boltForEach(bds.db, bds.bucket, func(key string, msg network.Message) error {
+		record, ok := msg.(*DatasetRecord)
+		if !ok {
+			return fmt.Errorf("dataset %s: wrong record type", key)
+		}
+		records[DatasetID(key)] = record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// saveDataset persists a dataset in the dataset store of the service (if any)
+func (s *Service) saveDataset(did DatasetID, dataset Dataset) error {
+	if s.DatasetStore == nil {
+		return nil
+	}
+	record := DatasetRecord{Query: dataset.Query, Responses: dataset.Responses, DpCount: dataset.DpCount, DataProviders: dataset.DataProviders}
+	if err := s.DatasetStore.Save(did, &record); err != nil {
+		return fmt.Errorf("could not save dataset %s: %v", did, err)
+	}
+	return nil
+}
+
+// LoadDatasets restores the datasets of the dataset store (it is called when the service starts). The expired datasets
+// are deleted.
+func (s *Service) LoadDatasets() error {
+	if s.DatasetStore == nil {
+		return nil
+	}
+
+	records, err := s.DatasetStore.LoadAll()
+	if err != nil {
+		return err
+	}
+	for did, record := range records {
+		dataset := Dataset{Query: record.Query, Responses: record.Responses, DpCount: record.DpCount, DataProviders: record.DataProviders, CreationChannel: make(chan string, 100)}
+		if dataset.expired() {
+			if err := s.DatasetStore.Delete(did); err != nil {
+				return err
+			}
+			log.Lvl1(s.ServerIdentity(), " deleted the expired dataset ", did)
+			continue
+		}
+		if _, err := s.Datasets.Put(string(did), dataset); err != nil {
+			return err
+		}
+		log.Lvl1(s.ServerIdentity(), " restored dataset ", did)
+	}
+	return nil
+}
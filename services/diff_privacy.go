@@ -0,0 +1,207 @@
+package servicesunlynx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/differential_privacy"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// LaplaceMechanism adds noise drawn from a (discretized) Laplace distribution of scale sensitivity/epsilon
+const LaplaceMechanism = "laplace"
+
+// DefaultNoiseListSize is the number of values of the noise lists if DiffPrivacyParameters.NoiseListSize is 0
+const DefaultNoiseListSize = 1000
+
+// DiffPrivacyParameters configure the noise added to the results of a survey by the Distributed Results Obfuscation
+// (DRO) phase. The root server generates a noise list for each aggregating attribute, in which each value appears in
+// proportion to its probability (see libunlynxdiffprivacy.GenerateNoiseValuesScale), and encrypts it. The servers then
+// collectively shuffle these lists and each group of the results gets the noise values of one row: no server knows
+// them. The noise is calibrated with the epsilon of the survey (see SurveyCreationQuery.Epsilon), split evenly between
+// its k aggregating attributes: each one gets noise of scale sensitivity/(epsilon/k), so that the results as a whole
+// are epsilon-differentially private.
+type DiffPrivacyParameters struct {
+	// Mechanism is the noise distribution (LaplaceMechanism if empty)
+	Mechanism string
+	// Sensitivity contains the sensitivity of each aggregating attribute (sum attributes and linear combinations),
+	// in the units of the attribute (the noise of a fixed-point attribute is encoded with its decimals)
+	Sensitivity map[string]float64
+	// NoiseListSize is the number of values of each noise list (DefaultNoiseListSize if 0), it bounds the number of
+	// groups of the results
+	NoiseListSize int64
+	// Quanta is the probability of a single occurrence of a value in the noise list (computed from Limit if 0): it
+	// should be about 1/NoiseListSize, otherwise the distribution is truncated or its tail is over-represented
+	Quanta float64
+	// Limit is the largest absolute value of the noise list, used if Quanta is 0
+	Limit float64
+}
+
+// listSize returns the number of values of the noise lists
+func (dp *DiffPrivacyParameters) listSize() int64 {
+	if dp.NoiseListSize == 0 {
+		return DefaultNoiseListSize
+	}
+	return dp.NoiseListSize
+}
+
+// NoiseValues returns the noise list of an aggregating attribute with the given sensitivity, encoded with the given
+// number of decimals
+func (dp *DiffPrivacyParameters) NoiseValues(epsilon, sensitivity float64, decimals int64) []float64 {
+	return libunlynxdiffprivacy.GenerateNoiseValuesScale(dp.listSize(), 0, sensitivity/epsilon, dp.Quanta, math.Pow10(int(decimals)), dp.Limit)
+}
+
+// checkDiffPrivacy verifies that the differential privacy parameters of a query are complete and valid
+func checkDiffPrivacy(query *SurveyCreationQuery) error {
+	dp := query.DiffPrivacy
+	if dp.Mechanism != "" && dp.Mechanism != LaplaceMechanism {
+		return fmt.Errorf("unknown differential privacy mechanism %q", dp.Mechanism)
+	}
+	if query.Epsilon <= 0 {
+		return fmt.Errorf("differential privacy requires a positive epsilon")
+	}
+	if dp.NoiseListSize < 0 || dp.Quanta < 0 || dp.Limit < 0 {
+		return fmt.Errorf("negative noise list size, quanta or limit")
+	}
+	if dp.Quanta == 0 && dp.Limit == 0 {
+		return fmt.Errorf("differential privacy requires a quanta or a limit for the noise list")
+	}
+
+	attributes := aggregatingAttributes(*query)
+	if len(dp.Sensitivity) != len(attributes) {
+		return fmt.Errorf("differential privacy requires the sensitivity of each aggregating attribute: %v", attributes)
+	}
+	for _, name := range attributes {
+		if sensitivity, ok := dp.Sensitivity[name]; !ok || !(sensitivity > 0) {
+			return fmt.Errorf("invalid or missing sensitivity for attribute %s", name)
+		}
+	}
+	return nil
+}
+
+// checkSensitivity verifies that the sensitivity of each aggregating attribute bounds what a single response can add to
+// it, i.e. the largest absolute value of its declared range (see SurveyCreationQuery.Ranges) or of its domain in the
+// schema. A smaller sensitivity would make the noise too small for the epsilon charged to the querier.
+func checkSensitivity(query *SurveyCreationQuery) error {
+	bounds := make(map[string]float64, len(query.Sum))
+	for _, name := range query.Sum {
+		var min, max int64
+		if b, ok := query.Ranges[name]; ok {
+			min, max = b.Min, b.Max
+		} else if a := schemaAttribute(query.Schema, name); a != nil && a.Domain != nil {
+			min, max = a.Domain.Min, a.Domain.Max
+		} else {
+			return fmt.Errorf("attribute %s needs a declared range or domain to check its sensitivity", name)
+		}
+		bounds[name] = math.Max(math.Abs(float64(min)), math.Abs(float64(max)))
+	}
+	for _, lc := range query.LinearCombinations {
+		bound := 0.0
+		for attr, weight := range lc.Weights {
+			bound += math.Abs(float64(weight)) * bounds[attr]
+		}
+		bounds[lc.Name] = bound
+	}
+
+	for _, name := range aggregatingAttributes(*query) {
+		// the bounds are encoded values, the sensitivity is in the units of the attribute
+		sensitivity := query.DiffPrivacy.Sensitivity[name] * math.Pow10(int(query.FixedPoint[name]))
+		if sensitivity < bounds[name] {
+			return fmt.Errorf("the sensitivity of attribute %s is below the largest (encoded) value of its range: %v", name, bounds[name])
+		}
+	}
+	return nil
+}
+
+// schemaAttribute returns the attribute of a schema with the given name, nil if there is no schema or no such attribute
+func schemaAttribute(schema *libunlynx.Schema, name string) *libunlynx.Attribute {
+	if schema == nil {
+		return nil
+	}
+	return schema.Attribute(name)
+}
+
+// aggregatingAttributes returns the names of the aggregating attributes of the results, in their order
+func aggregatingAttributes(query SurveyCreationQuery) []string {
+	names := append([]string{}, query.Sum...)
+	for _, lc := range query.LinearCombinations {
+		names = append(names, lc.Name)
+	}
+	return names
+}
+
+// noiseResponses generates and encrypts the noise lists of the aggregating attributes of a survey, with the values of
+// each list in a random order, as the responses to shuffle by the DRO phase
+func noiseResponses(query SurveyCreationQuery, collectiveKey kyber.Point) ([]libunlynx.ProcessResponse, error) {
+	attributes := aggregatingAttributes(query)
+	lists := make([][]float64, len(attributes))
+	size := query.DiffPrivacy.listSize()
+	// the attributes are released together: their epsilons add up to the one of the survey
+	epsilon := query.Epsilon / float64(len(attributes))
+	for i, name := range attributes {
+		values := query.DiffPrivacy.NoiseValues(epsilon, query.DiffPrivacy.Sensitivity[name], query.FixedPoint[name])
+		if int64(len(values)) < size {
+			size = int64(len(values))
+		}
+
+		// the lists are permuted independently, otherwise the rows would combine values of the same magnitude
+		lists[i] = make([]float64, len(values))
+		for j, k := range libunlynx.RandomPermutation(len(values)) {
+			lists[i][j] = values[k]
+		}
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("empty noise list")
+	}
+	log.Lvl2("generated ", len(attributes), " noise lists of ", size, " values")
+
+	responses := make([]libunlynx.ProcessResponse, size)
+	for j := range responses {
+		row := make([]int64, len(attributes))
+		for i := range attributes {
+			row[i] = int64(math.Round(lists[i][j]))
+		}
+		responses[j] = libunlynx.ProcessResponse{AggregatingAttributes: *libunlynx.EncryptIntVector(collectiveKey, row)}
+	}
+	return responses, nil
+}
+
+// addNoise adds to each group of the aggregated results the noise of one row of the shuffled noise lists
+func addNoise(results []libunlynx.FilteredResponse, noise []libunlynx.CipherVector) error {
+	if len(noise) < len(results) {
+		return fmt.Errorf("not enough noise values (%d) for %d groups", len(noise), len(results))
+	}
+	for i := range results {
+		aggr := results[i].AggregatingAttributes
+		if len(noise[i]) != len(aggr) {
+			return fmt.Errorf("%d noise values for %d aggregating attributes", len(noise[i]), len(aggr))
+		}
+		results[i].AggregatingAttributes.Add(aggr, noise[i])
+	}
+	return nil
+}
+
+// writeDiffPrivacy writes the differential privacy parameters signed by the querier in a canonical order
+func writeDiffPrivacy(dp *DiffPrivacyParameters, writeString func(string), writeFloat func(float64)) {
+	if dp == nil {
+		writeString("")
+		return
+	}
+	writeString(dp.Mechanism)
+	names := make([]string, 0, len(dp.Sensitivity))
+	for name := range dp.Sensitivity {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeString(name)
+		writeFloat(dp.Sensitivity[name])
+	}
+	writeString("")
+	writeFloat(float64(dp.NoiseListSize))
+	writeFloat(dp.Quanta)
+	writeFloat(dp.Limit)
+}
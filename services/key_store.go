@@ -0,0 +1,223 @@
+package servicesunlynx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ldsec/unlynx/lib/threshold"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.etcd.io/bbolt"
+)
+
+func init() {
+	network.RegisterMessage(&libunlynxthreshold.KeyShare{})
+	network.RegisterMessage(&PrivateKeyRecord{})
+	network.RegisterMessage(&RotatedKeyRecord{})
+}
+
+const (
+	// keySharePrefix prefixes the keys under which the shares of the distributed keys are stored
+	keySharePrefix = "share/"
+	// rotatedKeyPrefix prefixes the keys under which the rotated public keys of the servers are stored
+	rotatedKeyPrefix = "rotated/"
+	// privateKeyKey is the key under which the rotated private key of the server is stored
+	privateKeyKey = "private"
+)
+
+// PrivateKeyRecord is the rotated private key of a server
+type PrivateKeyRecord struct {
+	Private kyber.Scalar
+}
+
+// RotatedKeyRecord is the current public key of a server that rotated its key
+type RotatedKeyRecord struct {
+	Public kyber.Point
+}
+
+// KeyStore persists the keys of a server (the shares of the distributed keys it generated, its rotated private key and
+// the rotated public keys of the other servers) so that they survive a restart.
+type KeyStore interface {
+	// SaveKeyShare creates or replaces the share of the distributed key of a roster
+	SaveKeyShare(rosterID string, keyShare *libunlynxthreshold.KeyShare) error
+	// LoadKeyShares returns the shares of all the distributed keys, indexed by the ID of their roster
+	LoadKeyShares() (map[string]*libunlynxthreshold.KeyShare, error)
+	// SavePrivateKey creates or replaces the rotated private key of the server
+	SavePrivateKey(private kyber.Scalar) error
+	// LoadPrivateKey returns the rotated private key of the server (nil if it never rotated its key)
+	LoadPrivateKey() (kyber.Scalar, error)
+	// SaveRotatedKey creates or replaces the rotated public key of a server
+	SaveRotatedKey(serverID string, public kyber.Point) error
+	// LoadRotatedKeys returns the rotated public keys of the servers, indexed by their ID
+	LoadRotatedKeys() (map[string]kyber.Point, error)
+}
+
+// boltKeyStore is a KeyStore keeping the keys in a bucket of a bbolt database
+type boltKeyStore struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+// NewBoltKeyStore creates a KeyStore keeping the keys in an existing bucket of a bbolt database.
+func NewBoltKeyStore(db *bbolt.DB, bucket []byte) KeyStore {
+	return &boltKeyStore{db: db, bucket: bucket}
+}
+
+// SaveKeyShare creates or replaces the share of the distributed key of a roster
+func (bks *boltKeyStore) SaveKeyShare(rosterID string, keyShare *libunlynxthreshold.KeyShare) error {
+	return boltPut(bks.db, bks.bucket, keySharePrefix+rosterID, keyShare)
+}
+
+// LoadKeyShares returns the shares of all the distributed keys, indexed by the ID of their roster
+func (bks *boltKeyStore) LoadKeyShares() (map[string]*libunlynxthreshold.KeyShare, error) {
+	keyShares := make(map[string]*libunlynxthreshold.KeyShare)
+	err := boltForEach(bks.db, bks.bucket, func(key string, msg network.Message) error {
+		if !strings.HasPrefix(key, keySharePrefix) {
+			return nil
+		}
+		keyShare, ok := msg.(*libunlynxthreshold.KeyShare)
+		if !ok {
+			return fmt.Errorf("key share %s: wrong record type", key)
+		}
+		keyShares[strings.TrimPrefix(key, keySharePrefix)] = keyShare
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keyShares, nil
+}
+
+// SavePrivateKey creates or replaces the rotated private key of the server
+func (bks *boltKeyStore) SavePrivateKey(private kyber.Scalar) error {
+	return boltPut(bks.db, bks.bucket, privateKeyKey, &PrivateKeyRecord{Private: private})
+}
+
+// LoadPrivateKey returns the rotated private key of the server (nil if it never rotated its key)
+func (bks *boltKeyStore) LoadPrivateKey() (kyber.Scalar, error) {
+	var private kyber.Scalar
+	err := boltForEach(bks.db, bks.bucket, func(key string, msg network.Message) error {
+		if key != privateKeyKey {
+			return nil
+		}
+		record, ok := msg.(*PrivateKeyRecord)
+		if !ok {
+			return fmt.Errorf("private key: wrong record type")
+		}
+		private = record.Private
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return private, nil
+}
+
+// SaveRotatedKey creates or replaces the rotated public key of a server
+func (bks *boltKeyStore) SaveRotatedKey(serverID string, public kyber.Point) error {
+	return boltPut(bks.db, bks.bucket, rotatedKeyPrefix+serverID, &RotatedKeyRecord{Public: public})
+}
+
+// LoadRotatedKeys returns the rotated public keys of the servers, indexed by their ID
+func (bks *boltKeyStore) LoadRotatedKeys() (map[string]kyber.Point, error) {
+	publics := make(map[string]kyber.Point)
+	err := boltForEach(bks.db, bks.bucket, func(key string, msg network.Message) error {
+		if !strings.HasPrefix(key, rotatedKeyPrefix) {
+			return nil
+		}
+		record, ok := msg.(*RotatedKeyRecord)
+		if !ok {
+			return fmt.Errorf("rotated key %s: wrong record type", key)
+		}
+		publics[strings.TrimPrefix(key, rotatedKeyPrefix)] = record.Public
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return publics, nil
+}
+
+// Persistence
+//______________________________________________________________________________________________________________________
+
+// putThresholdKey records the share of the distributed key of a roster and persists it in the key store of the service
+// (if any). The share of a roster that already has a distributed key is not replaced.
+func (s *Service) putThresholdKey(rosterID string, keyShare *libunlynxthreshold.KeyShare) error {
+	previous, err := s.ThresholdKeys.PutIfAbsent(rosterID, keyShare)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		return fmt.Errorf("roster %s already has a distributed key", rosterID)
+	}
+	if s.KeyStore == nil {
+		return nil
+	}
+	if err := s.KeyStore.SaveKeyShare(rosterID, keyShare); err != nil {
+		return fmt.Errorf("could not save the distributed key of roster %s: %v", rosterID, err)
+	}
+	return nil
+}
+
+// putRotatedKey records the rotated keys of a server (its private key if it is this server) and persists them in the
+// key store of the service (if any)
+func (s *Service) putRotatedKey(serverID string, public kyber.Point, private kyber.Scalar) error {
+	if private != nil {
+		s.setPrivateKey(private)
+	}
+	if _, err := s.RotatedKeys.Put(serverID, public); err != nil {
+		return err
+	}
+	if s.KeyStore == nil {
+		return nil
+	}
+	if private != nil {
+		if err := s.KeyStore.SavePrivateKey(private); err != nil {
+			return fmt.Errorf("could not save the rotated private key: %v", err)
+		}
+	}
+	if err := s.KeyStore.SaveRotatedKey(serverID, public); err != nil {
+		return fmt.Errorf("could not save the rotated key of server %s: %v", serverID, err)
+	}
+	return nil
+}
+
+// LoadKeys restores the keys of the key store (it is called when the service starts, before the surveys are restored
+// as they may be encrypted under a distributed key).
+func (s *Service) LoadKeys() error {
+	if s.KeyStore == nil {
+		return nil
+	}
+
+	keyShares, err := s.KeyStore.LoadKeyShares()
+	if err != nil {
+		return err
+	}
+	for rosterID, keyShare := range keyShares {
+		if _, err := s.ThresholdKeys.Put(rosterID, keyShare); err != nil {
+			return err
+		}
+		log.Lvl1(s.ServerIdentity(), " restored the distributed key of roster ", rosterID)
+	}
+
+	private, err := s.KeyStore.LoadPrivateKey()
+	if err != nil {
+		return err
+	}
+	if private != nil {
+		s.setPrivateKey(private)
+		log.Lvl1(s.ServerIdentity(), " restored its rotated private key")
+	}
+	publics, err := s.KeyStore.LoadRotatedKeys()
+	if err != nil {
+		return err
+	}
+	for serverID, public := range publics {
+		if _, err := s.RotatedKeys.Put(serverID, public); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package servicesunlynx
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/ldsec/unlynx/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.etcd.io/bbolt"
+)
+
+// anonymousQuerier identifies the queriers who do not sign their queries in the privacy ledger
+const anonymousQuerier = "anonymous"
+
+func init() {
+	network.RegisterMessage(&LedgerEntry{})
+	network.RegisterMessage(&PrivacyBudgetQuery{})
+	network.RegisterMessage(&PrivacyBudgetState{})
+}
+
+// Messages
+//______________________________________________________________________________________________________________________
+
+// PrivacyBudgetQuery is used to get the privacy budget a querier has left on a dataset on the server receiving it.
+type PrivacyBudgetQuery struct {
+	Querier   kyber.Point
+	DatasetID DatasetID
+}
+
+// PrivacyBudgetState contains the privacy budget (epsilon) consumed and left by a querier on a dataset.
+type PrivacyBudgetState struct {
+	Spent     float64
+	Budget    float64 // 0 if the budget is not limited
+	Remaining float64 // only meaningful if the budget is limited
+}
+
+// Ledger
+//______________________________________________________________________________________________________________________
+
+// LedgerEntry is the privacy budget (epsilon) consumed by a querier on a dataset
+type LedgerEntry struct {
+	Querier   string
+	DatasetID DatasetID
+	Spent     float64
+}
+
+// PrivacyLedger records the privacy budget consumed by each querier on each dataset. It is persisted in a bucket of a
+// bbolt database (if any), so that a restart does not reset the budgets.
+type PrivacyLedger struct {
+	mutex   sync.Mutex
+	entries map[string]*LedgerEntry
+	db      *bbolt.DB
+	bucket  []byte
+}
+
+// NewPrivacyLedger creates a ledger kept in an existing bucket of a bbolt database (in memory only if db is nil) and
+// loads its entries.
+func NewPrivacyLedger(db *bbolt.DB, bucket []byte) (*PrivacyLedger, error) {
+	pl := &PrivacyLedger{entries: make(map[string]*LedgerEntry), db: db, bucket: bucket}
+	if db == nil {
+		return pl, nil
+	}
+	err := boltForEach(db, bucket, func(key string, msg network.Message) error {
+		entry, ok := msg.(*LedgerEntry)
+		if !ok {
+			return fmt.Errorf("ledger entry %s: wrong record type", key)
+		}
+		pl.entries[key] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
+
+// ledgerKey returns the key of the entry of a querier on a dataset
+func ledgerKey(querier string, did DatasetID) string {
+	return querier + "/" + string(did)
+}
+
+// querierIdentity returns the identity of a querier in the ledger (the encoding of its public key)
+func querierIdentity(querier kyber.Point) string {
+	if querier == nil {
+		return anonymousQuerier
+	}
+	id, err := libunlynx.SerializePoint(querier)
+	if err != nil {
+		return anonymousQuerier
+	}
+	return id
+}
+
+// Spent returns the privacy budget consumed by a querier on a dataset
+func (pl *PrivacyLedger) Spent(querier string, did DatasetID) float64 {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+	if entry, ok := pl.entries[ledgerKey(querier, did)]; ok {
+		return entry.Spent
+	}
+	return 0
+}
+
+// Spend charges epsilon to the budget of a querier on a dataset, unless it would exceed the given budget (not limited if
+// 0)
+func (pl *PrivacyLedger) Spend(querier string, did DatasetID, epsilon, budget float64) error {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	key := ledgerKey(querier, did)
+	entry, ok := pl.entries[key]
+	if !ok {
+		entry = &LedgerEntry{Querier: querier, DatasetID: did}
+	}
+	if budget > 0 && entry.Spent+epsilon > budget {
+		return fmt.Errorf("epsilon %v exceeds the privacy budget left (%v of %v)", epsilon, budget-entry.Spent, budget)
+	}
+
+	updated := *entry
+	updated.Spent += epsilon
+	if pl.db != nil {
+		if err := boltPut(pl.db, pl.bucket, key, &updated); err != nil {
+			return fmt.Errorf("could not save the privacy ledger: %v", err)
+		}
+	}
+	pl.entries[key] = &updated
+	return nil
+}
+
+// Refund gives back epsilon to the budget of a querier on a dataset (e.g. the survey it was charged for ended before
+// its results were released)
+func (pl *PrivacyLedger) Refund(querier string, did DatasetID, epsilon float64) error {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	key := ledgerKey(querier, did)
+	entry, ok := pl.entries[key]
+	if !ok {
+		return nil
+	}
+
+	updated := *entry
+	updated.Spent = math.Max(0, updated.Spent-epsilon)
+	if pl.db != nil {
+		if err := boltPut(pl.db, pl.bucket, key, &updated); err != nil {
+			return fmt.Errorf("could not save the privacy ledger: %v", err)
+		}
+	}
+	pl.entries[key] = &updated
+	return nil
+}
+
+// Handlers
+//______________________________________________________________________________________________________________________
+
+// HandlePrivacyBudgetQuery returns the privacy budget consumed and left by a querier on a dataset on this server.
+func (s *Service) HandlePrivacyBudgetQuery(pbq *PrivacyBudgetQuery) (network.Message, error) {
+	log.Lvl1(s.ServerIdentity(), " received a privacy budget query")
+
+	state := &PrivacyBudgetState{Spent: s.Ledger.Spent(querierIdentity(pbq.Querier), pbq.DatasetID)}
+	if s.Policy != nil && s.Policy.PrivacyBudget > 0 {
+		state.Budget = s.Policy.PrivacyBudget
+		state.Remaining = state.Budget - state.Spent
+	}
+	return state, nil
+}
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// chargePrivacyBudget charges the epsilon of a survey to the budget of its querier on its dataset. The budget is
+// reserved when the survey is created and refunded if the survey ends before its results are released (see
+// refundPrivacyBudget). The surveys that are not run on a dataset collect their own responses: they are left out of the
+// ledger, their epsilon alone must fit in the budget. With a limited budget, the sensitivities of the survey must bound
+// the values of its responses (see checkSensitivity).
+func (s *Service) chargePrivacyBudget(query *SurveyCreationQuery) error {
+	if query.Epsilon < 0 {
+		return fmt.Errorf("negative epsilon: %v", query.Epsilon)
+	}
+
+	budget := 0.0
+	if s.Policy != nil {
+		budget = s.Policy.PrivacyBudget
+	}
+	if budget > 0 && query.Epsilon == 0 {
+		return fmt.Errorf("the survey must declare its epsilon: the privacy budget of the queriers is limited")
+	}
+	if budget > 0 && query.DiffPrivacy == nil {
+		// the epsilon of results without noise would not bound what they reveal
+		return fmt.Errorf("the survey must be differentially private: the privacy budget of the queriers is limited")
+	}
+	if budget > 0 {
+		if err := checkSensitivity(query); err != nil {
+			return err
+		}
+	}
+	if query.Epsilon == 0 {
+		return nil
+	}
+	if query.DatasetID == "" {
+		if budget > 0 && query.Epsilon > budget {
+			return fmt.Errorf("epsilon %v exceeds the privacy budget %v", query.Epsilon, budget)
+		}
+		return nil
+	}
+	return s.Ledger.Spend(querierIdentity(query.Querier), query.DatasetID, query.Epsilon, budget)
+}
+
+// refundPrivacyBudget refunds the epsilon of a survey that ends before its results are released (refused by a server,
+// cancelled or failed)
+func (s *Service) refundPrivacyBudget(query SurveyCreationQuery) {
+	if query.Epsilon <= 0 || query.DatasetID == "" || s.Ledger == nil {
+		return
+	}
+	if err := s.Ledger.Refund(querierIdentity(query.Querier), query.DatasetID, query.Epsilon); err != nil {
+		log.Error(s.ServerIdentity(), " could not refund the privacy budget of survey ", query.SurveyID, ": ", err)
+	}
+}
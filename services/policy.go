@@ -0,0 +1,608 @@
+package servicesunlynx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/range"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/onet/v3"
+)
+
+// PolicyFile is the query policy file (TOML) loaded by the services when they start (no policy if empty)
+var PolicyFile = ""
+
+const (
+	// defaultQueryValidity is how long a signed query is valid if its querier does not choose its expiry
+	defaultQueryValidity = 10 * time.Minute
+	// maxQueryValidity bounds the expiry of the signed queries, hence how long the servers remember their nonces
+	maxQueryValidity = time.Hour
+	// nonceBits is the size of the random nonces of the signed queries
+	nonceBits = 128
+)
+
+// QuerierSignature authenticates a query by its querier: the signature covers the statement of the query, a random
+// nonce and an expiry, so that the servers with a query policy reject the expired and the replayed queries.
+type QuerierSignature struct {
+	Querier   kyber.Point
+	Nonce     []byte
+	Expiry    int64 // time (in seconds since the Unix epoch) after which the query is rejected
+	Signature []byte
+}
+
+// QueryPolicy is the local policy of a server: it only takes part in the surveys of the allowed queriers matching it.
+// The queriers sign their survey creation and results queries (see SurveyCreationQuery.Querier).
+type QueryPolicy struct {
+	// Queriers are the queriers allowed to create surveys and to get their results, and to manage the keys of the server
+	// (distributed key generations and key rotations)
+	Queriers []PolicyQuerier
+	// Attributes are the attributes the queries can use (any if empty), the count attribute is always allowed and the
+	// prefix tags and histogram bins of an attribute are allowed with it
+	Attributes []string
+	// MaxGroupByDepth is the maximum number of group by attributes of a query (no limit if 0)
+	MaxGroupByDepth int
+	// ProofsRequired forces the queries to be run with proofs
+	ProofsRequired bool
+	// PrivacyBudget is the total epsilon each querier can consume on a dataset (no limit if 0): the surveys must then
+	// declare their epsilon (see SurveyCreationQuery.Epsilon)
+	PrivacyBudget float64
+	// MinCellSize is the minimum cell size the queries must enforce (see SurveyCreationQuery.MinCellSize), none if 0
+	MinCellSize int64
+}
+
+// PolicyQuerier is a querier allowed by a query policy
+type PolicyQuerier struct {
+	Name   string
+	Public string // base64 encoding of the public key (see libunlynx.SerializePoint)
+}
+
+// LoadQueryPolicy reads and validates a query policy (TOML file with a [[Queriers]] table per querier)
+func LoadQueryPolicy(file string) (*QueryPolicy, error) {
+	policy := &QueryPolicy{}
+	if _, err := toml.DecodeFile(file, policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Validate checks that the public keys of the queriers are valid and that the limits are not negative
+func (p *QueryPolicy) Validate() error {
+	for _, q := range p.Queriers {
+		if _, err := libunlynx.DeserializePoint(q.Public); err != nil {
+			return fmt.Errorf("querier %s: %v", q.Name, err)
+		}
+	}
+	if p.MaxGroupByDepth < 0 {
+		return fmt.Errorf("negative maximum group by depth: %d", p.MaxGroupByDepth)
+	}
+	if p.PrivacyBudget < 0 {
+		return fmt.Errorf("negative privacy budget: %v", p.PrivacyBudget)
+	}
+	if p.MinCellSize < 0 {
+		return fmt.Errorf("negative minimum cell size: %d", p.MinCellSize)
+	}
+	return nil
+}
+
+// querier returns the name of the querier with the given public key, or an error if it is not allowed
+func (p *QueryPolicy) querier(public kyber.Point) (string, error) {
+	if public == nil {
+		return "", fmt.Errorf("anonymous querier")
+	}
+	for _, q := range p.Queriers {
+		if point, err := libunlynx.DeserializePoint(q.Public); err == nil && point.Equal(public) {
+			return q.Name, nil
+		}
+	}
+	return "", fmt.Errorf("querier %v is not allowed", public)
+}
+
+// AuthorizeQuery checks that a survey creation query is signed by an allowed querier and matches the policy (the
+// replays are detected by the service, see Service.checkFreshness)
+func (p *QueryPolicy) AuthorizeQuery(scq *SurveyCreationQuery) error {
+	name, err := p.querier(scq.Querier)
+	if err != nil {
+		return err
+	}
+	if err := scq.VerifySignature(); err != nil {
+		return fmt.Errorf("querier %s: %v", name, err)
+	}
+
+	if p.ProofsRequired && !scq.Proofs {
+		return fmt.Errorf("querier %s: the query must be run with proofs", name)
+	}
+	if p.MaxGroupByDepth > 0 && len(scq.GroupBy) > p.MaxGroupByDepth {
+		return fmt.Errorf("querier %s: %d group by attributes (at most %d)", name, len(scq.GroupBy), p.MaxGroupByDepth)
+	}
+	if scq.MinCellSize < p.MinCellSize {
+		return fmt.Errorf("querier %s: the minimum cell size must be at least %d", name, p.MinCellSize)
+	}
+	if len(p.Attributes) > 0 {
+		allowed := map[string]bool{libunlynx.CountAttribute: true}
+		for _, v := range p.Attributes {
+			allowed[v] = true
+		}
+		attributes := append(append([]string{}, scq.Sum...), scq.GroupBy...)
+		for _, w := range scq.Where {
+			attributes = append(attributes, w.Name)
+		}
+		for _, v := range attributes {
+			if !allowed[v] && !allowed[derivedFrom(v)] {
+				return fmt.Errorf("querier %s: attribute %s is not allowed", name, v)
+			}
+		}
+	}
+	return nil
+}
+
+// AuthorizeResults checks that a results query is signed by the querier of the survey, who is still allowed
+func (p *QueryPolicy) AuthorizeResults(query SurveyCreationQuery, resq *SurveyResultsQuery) error {
+	name, err := p.querier(query.Querier)
+	if err != nil {
+		return err
+	}
+	if err := resq.VerifySignature(query.Querier); err != nil {
+		return fmt.Errorf("querier %s: %v", name, err)
+	}
+	return nil
+}
+
+// derivedFrom returns the attribute a prefix tag or histogram bin is derived from (the name itself otherwise)
+func derivedFrom(name string) string {
+	if attribute, _, ok := libunlynx.SplitPrefixTagName(name); ok {
+		return attribute
+	}
+	if attribute, _, ok := libunlynx.SplitHistogramBinName(name); ok {
+		return attribute
+	}
+	return name
+}
+
+// Signatures
+//______________________________________________________________________________________________________________________
+
+// Sign sets the querier of the survey creation query and signs it with the querier's private key. A random nonce and
+// an expiry are chosen if they are not set.
+func (scq *SurveyCreationQuery) Sign(private kyber.Scalar) error {
+	scq.Querier = libunlynx.SuiTe.Point().Mul(private, nil)
+	if len(scq.Nonce) == 0 {
+		scq.Nonce = newNonce()
+	}
+	if scq.Expiry == 0 {
+		scq.Expiry = time.Now().Add(defaultQueryValidity).Unix()
+	}
+	digest, err := scq.digest()
+	if err != nil {
+		return err
+	}
+	scq.Signature, err = schnorr.Sign(libunlynx.SuiTe, private, digest)
+	return err
+}
+
+// VerifySignature checks the signature of the survey creation query by its querier
+func (scq *SurveyCreationQuery) VerifySignature() error {
+	if scq.Querier == nil || len(scq.Signature) == 0 {
+		return fmt.Errorf("unsigned survey creation query")
+	}
+	digest, err := scq.digest()
+	if err != nil {
+		return err
+	}
+	if err := schnorr.Verify(libunlynx.SuiTe, scq.Querier, digest, scq.Signature); err != nil {
+		return fmt.Errorf("wrong signature of the survey creation query: %v", err)
+	}
+	return nil
+}
+
+// digest returns the hash of the parts of the survey creation query signed by the querier: all of them but the survey
+// ID, the signature and the fields set by the servers forwarding the query (IntraMessage and Source).
+func (scq *SurveyCreationQuery) digest() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writeString := func(s string) {
+		// the length prefix makes the encoding unambiguous
+		_ = binary.Write(buf, binary.BigEndian, int64(len(s)))
+		buf.WriteString(s)
+	}
+	writePoint := func(p kyber.Point) error {
+		if p == nil {
+			writeString("")
+			return nil
+		}
+		data, err := p.MarshalBinary()
+		writeString(string(data))
+		return err
+	}
+
+	writeInt := func(i int64) { _ = binary.Write(buf, binary.BigEndian, i) }
+
+	writeString(string(scq.Nonce))
+	writeInt(scq.Expiry)
+	buf.Write(scq.Roster.ID[:])
+	buf.Write(scq.ThresholdKeyID[:])
+	writeString(scq.Suite)
+	writeString(string(scq.DatasetID))
+	if err := writePoint(scq.ClientPubKey); err != nil {
+		return nil, err
+	}
+	if err := writePoint(scq.Querier); err != nil {
+		return nil, err
+	}
+	writeInts(scq.MapDPs, writeString, writeInt)
+	_ = binary.Write(buf, binary.BigEndian, []bool{scq.Proofs, scq.AppFlag, scq.Count, scq.PublicResults})
+	_ = binary.Write(buf, binary.BigEndian, scq.Epsilon)
+	writeInt(scq.MemoryBudget)
+	writeInt(scq.MinCellSize)
+	_ = binary.Write(buf, binary.BigEndian, scq.MergeSmallCells)
+	writeInts(scq.FixedPoint, writeString, writeInt)
+	writeRanges(scq.Ranges, writeString, writeInt)
+	writeSchema(scq.Schema, writeString, writeInt)
+	writeDiffPrivacy(scq.DiffPrivacy, writeString, func(f float64) { _ = binary.Write(buf, binary.BigEndian, f) })
+	for _, v := range scq.Sum {
+		writeString(v)
+	}
+	writeString("")
+	for _, w := range scq.Where {
+		writeString(w.Name)
+		if err := writePoint(w.Value.K); err != nil {
+			return nil, err
+		}
+		if err := writePoint(w.Value.C); err != nil {
+			return nil, err
+		}
+	}
+	writeString("")
+	writeString(scq.Predicate)
+	for _, v := range scq.GroupBy {
+		writeString(v)
+	}
+	writeString("")
+	for _, lc := range scq.LinearCombinations {
+		writeString(lc.Name)
+		attrs := make([]string, 0, len(lc.Weights))
+		for attr := range lc.Weights {
+			attrs = append(attrs, attr)
+		}
+		sort.Strings(attrs)
+		for _, attr := range attrs {
+			writeString(attr)
+			_ = binary.Write(buf, binary.BigEndian, lc.Weights[attr])
+		}
+		writeString("")
+	}
+
+	digest := sha256.Sum256(buf.Bytes())
+	return digest[:], nil
+}
+
+// writeInts writes a map of integers (e.g. the fixed-point scales) in a canonical order
+func writeInts(m map[string]int64, writeString func(string), writeInt func(int64)) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	writeInt(int64(len(keys)))
+	for _, k := range keys {
+		writeString(k)
+		writeInt(m[k])
+	}
+}
+
+// writeRanges writes the bounds of the sum attributes in a canonical order
+func writeRanges(ranges map[string]*libunlynxrange.Bounds, writeString func(string), writeInt func(int64)) {
+	names := make([]string, 0, len(ranges))
+	for name := range ranges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeInt(int64(len(names)))
+	for _, name := range names {
+		writeString(name)
+		if bounds := ranges[name]; bounds != nil {
+			writeInt(1)
+			writeInt(bounds.Min)
+			writeInt(bounds.Max)
+		} else {
+			writeInt(0)
+		}
+	}
+}
+
+// writeSchema writes the attributes of a schema (in their order)
+func writeSchema(schema *libunlynx.Schema, writeString func(string), writeInt func(int64)) {
+	if schema == nil {
+		writeInt(-1)
+		return
+	}
+	writeInt(int64(len(schema.Attributes)))
+	writeBool := func(b bool) {
+		if b {
+			writeInt(1)
+		} else {
+			writeInt(0)
+		}
+	}
+	for _, a := range schema.Attributes {
+		writeString(a.Name)
+		writeString(string(a.Role))
+		writeString(string(a.Sensitivity))
+		writeString(string(a.Encoding))
+		writeInt(a.Decimals)
+		writeBool(a.Domain != nil)
+		if a.Domain != nil {
+			writeInt(a.Domain.Min)
+			writeInt(a.Domain.Max)
+		}
+		writeBool(a.Ranges)
+		writeBool(a.Histogram)
+		writeBool(a.Moments)
+	}
+}
+
+// newNonce returns a random nonce for a signed query
+func newNonce() []byte {
+	return random.Bits(nonceBits, true, libunlynx.SuiTe.RandomStream())
+}
+
+// signQuery signs the statement of a query with the querier's private key, a random nonce and an expiry
+func signQuery(private kyber.Scalar, statement []byte) (QuerierSignature, error) {
+	qs := QuerierSignature{
+		Querier: libunlynx.SuiTe.Point().Mul(private, nil),
+		Nonce:   newNonce(),
+		Expiry:  time.Now().Add(defaultQueryValidity).Unix(),
+	}
+	var err error
+	qs.Signature, err = schnorr.Sign(libunlynx.SuiTe, private, qs.digest(statement))
+	return qs, err
+}
+
+// Verify checks the signature of the statement of a query by its querier (but not its freshness, see
+// Service.checkFreshness)
+func (qs QuerierSignature) Verify(statement []byte) error {
+	if qs.Querier == nil || len(qs.Signature) == 0 {
+		return fmt.Errorf("unsigned query")
+	}
+	if err := schnorr.Verify(libunlynx.SuiTe, qs.Querier, qs.digest(statement), qs.Signature); err != nil {
+		return fmt.Errorf("wrong signature of the query: %v", err)
+	}
+	return nil
+}
+
+// digest returns the hash of the statement of a query, of its nonce and of its expiry
+func (qs QuerierSignature) digest(statement []byte) []byte {
+	h := sha256.New()
+	_ = binary.Write(h, binary.BigEndian, int64(len(statement)))
+	h.Write(statement)
+	_ = binary.Write(h, binary.BigEndian, int64(len(qs.Nonce)))
+	h.Write(qs.Nonce)
+	_ = binary.Write(h, binary.BigEndian, qs.Expiry)
+	return h.Sum(nil)
+}
+
+// listStatement is the statement signed by the querier of a ListSurveysQuery
+var listStatement = []byte("list")
+
+// surveyStatement returns the statement signed by the querier of a query managing a survey (e.g. "cancel")
+func surveyStatement(action string, sid SurveyID) []byte {
+	return []byte(action + "/" + string(sid))
+}
+
+// keyRotationStatement returns the statement signed by the querier of a KeyRotationQuery on a roster
+func keyRotationStatement(roster *onet.Roster) []byte {
+	return []byte("rotate/" + roster.ID.String())
+}
+
+// dkgStatement returns the statement signed by the querier of a DKGQuery on a roster
+func dkgStatement(roster *onet.Roster) []byte {
+	return []byte("dkg/" + roster.ID.String())
+}
+
+// statement returns the parts of the dataset creation query signed by its querier
+func (dcq *DatasetCreationQuery) statement() []byte {
+	buf := new(bytes.Buffer)
+	writeString := func(s string) {
+		_ = binary.Write(buf, binary.BigEndian, int64(len(s)))
+		buf.WriteString(s)
+	}
+	writeInt := func(i int64) { _ = binary.Write(buf, binary.BigEndian, i) }
+
+	writeString("dataset")
+	writeString(string(dcq.DatasetID))
+	buf.Write(dcq.Roster.ID[:])
+	buf.Write(dcq.ThresholdKeyID[:])
+	writeSchema(dcq.Schema, writeString, writeInt)
+	writeInt(dcq.Expiry)
+	return buf.Bytes()
+}
+
+// Authorization
+//______________________________________________________________________________________________________________________
+
+// authorize checks, if the server has a query policy, that a query is signed by an allowed querier (the querier of
+// the survey it manages if it is not nil) and is neither expired nor replayed
+func (s *Service) authorize(qs QuerierSignature, statement []byte, surveyQuerier kyber.Point) error {
+	if s.Policy == nil {
+		return nil
+	}
+	if surveyQuerier != nil && (qs.Querier == nil || !qs.Querier.Equal(surveyQuerier)) {
+		return fmt.Errorf("the query is not signed by the querier of the survey")
+	}
+	name, err := s.Policy.querier(qs.Querier)
+	if err != nil {
+		return err
+	}
+	if err := qs.Verify(statement); err != nil {
+		return fmt.Errorf("querier %s: %v", name, err)
+	}
+	if err := s.checkFreshness(qs.Querier, qs.Nonce, qs.Expiry); err != nil {
+		return fmt.Errorf("querier %s: %v", name, err)
+	}
+	return nil
+}
+
+// checkFreshness rejects the signed queries that are expired, expire too late or whose nonce was already received
+// (replays). The nonces are remembered until their query expires.
+func (s *Service) checkFreshness(querier kyber.Point, nonce []byte, expiry int64) error {
+	now := time.Now()
+	if expiry <= now.Unix() {
+		return fmt.Errorf("the query expired")
+	}
+	if expiry > now.Add(maxQueryValidity).Unix() {
+		return fmt.Errorf("the query expires more than %v from now", maxQueryValidity)
+	}
+	if len(nonce) == 0 {
+		return fmt.Errorf("the query has no nonce")
+	}
+	id, err := libunlynx.SerializePoint(querier)
+	if err != nil {
+		return err
+	}
+	id += "/" + string(nonce)
+
+	s.noncesMutex.Lock()
+	defer s.noncesMutex.Unlock()
+	for n, exp := range s.nonces {
+		if exp <= now.Unix() {
+			delete(s.nonces, n)
+		}
+	}
+	if _, ok := s.nonces[id]; ok {
+		return fmt.Errorf("the query was already received")
+	}
+	if s.nonces == nil {
+		s.nonces = make(map[string]int64)
+	}
+	s.nonces[id] = expiry
+	return nil
+}
+
+// Sign signs the results query (the survey and the key the results are switched to) with the querier's private key
+func (resq *SurveyResultsQuery) Sign(private kyber.Scalar) error {
+	var err error
+	resq.Signature, err = schnorr.Sign(libunlynx.SuiTe, private, resq.digest())
+	return err
+}
+
+// VerifySignature checks the signature of the results query by the given querier
+func (resq *SurveyResultsQuery) VerifySignature(querier kyber.Point) error {
+	if len(resq.Signature) == 0 {
+		return fmt.Errorf("unsigned survey results query")
+	}
+	if err := schnorr.Verify(libunlynx.SuiTe, querier, resq.digest(), resq.Signature); err != nil {
+		return fmt.Errorf("wrong signature of the survey results query: %v", err)
+	}
+	return nil
+}
+
+// digest returns the hash of the survey ID and of the key the results are switched to
+func (resq *SurveyResultsQuery) digest() []byte {
+	h := sha256.New()
+	h.Write([]byte(resq.SurveyID))
+	if resq.ClientPublic != nil {
+		if data, err := resq.ClientPublic.MarshalBinary(); err == nil {
+			h.Write(data)
+		}
+	}
+	return h.Sum(nil)
+}
+
+// Sign sets the data provider of the response query and signs the responses with the data provider's private key
+func (resp *SurveyResponseQuery) Sign(private kyber.Scalar) error {
+	resp.DpPublic = libunlynx.SuiTe.Point().Mul(private, nil)
+	digest, err := responsesDigest(string(resp.SurveyID), resp.DpPublic, resp.Responses)
+	if err != nil {
+		return err
+	}
+	resp.Signature, err = schnorr.Sign(libunlynx.SuiTe, private, digest)
+	return err
+}
+
+// VerifySignature checks the signature of the response query by its data provider and returns the identity of the
+// data provider (the encoding of its key), to which the proofs of knowledge of the responses are bound
+func (resp *SurveyResponseQuery) VerifySignature() (string, error) {
+	return verifyResponsesSignature(string(resp.SurveyID), resp.DpPublic, resp.Signature, resp.Responses)
+}
+
+// verifyResponsesSignature checks the signature of the responses sent by a data provider to a survey or a dataset and
+// returns the identity of the data provider
+func verifyResponsesSignature(id string, dpPublic kyber.Point, signature []byte, responses []libunlynx.DpResponseToSend) (string, error) {
+	if dpPublic == nil || len(signature) == 0 {
+		return "", fmt.Errorf("unsigned responses")
+	}
+	digest, err := responsesDigest(id, dpPublic, responses)
+	if err != nil {
+		return "", err
+	}
+	if err := schnorr.Verify(libunlynx.SuiTe, dpPublic, digest, signature); err != nil {
+		return "", fmt.Errorf("wrong signature of the responses: %v", err)
+	}
+	return libunlynx.SerializePoint(dpPublic)
+}
+
+// responsesDigest returns the hash of the survey (or dataset) ID, of the key of the data provider and of its responses
+func responsesDigest(id string, dpPublic kyber.Point, responses []libunlynx.DpResponseToSend) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writeString := func(s string) {
+		// the length prefix makes the encoding unambiguous
+		_ = binary.Write(buf, binary.BigEndian, int64(len(s)))
+		buf.WriteString(s)
+	}
+	writeInts := func(m map[string]int64) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		_ = binary.Write(buf, binary.BigEndian, int64(len(keys)))
+		for _, k := range keys {
+			writeString(k)
+			_ = binary.Write(buf, binary.BigEndian, m[k])
+		}
+	}
+	writeBytes := func(m map[string][]byte) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		_ = binary.Write(buf, binary.BigEndian, int64(len(keys)))
+		for _, k := range keys {
+			writeString(k)
+			writeString(string(m[k]))
+		}
+	}
+
+	writeString(id)
+	data, err := dpPublic.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	writeString(string(data))
+	_ = binary.Write(buf, binary.BigEndian, int64(len(responses)))
+	for _, r := range responses {
+		writeInts(r.WhereClear)
+		writeBytes(r.WhereEnc)
+		writeBytes(r.WhereEncProofs)
+		writeInts(r.GroupByClear)
+		writeBytes(r.GroupByEnc)
+		writeBytes(r.GroupByEncProofs)
+		writeInts(r.AggregatingAttributesClear)
+		writeBytes(r.AggregatingAttributesEnc)
+		writeBytes(r.AggregatingAttributesEncProofs)
+		writeBytes(r.AggregatingAttributesRangeProofs)
+	}
+
+	digest := sha256.Sum256(buf.Bytes())
+	return digest[:], nil
+}
@@ -3,22 +3,31 @@ package servicesunlynx
 import (
 	"fmt"
 	"golang.org/x/xerrors"
+	"io/ioutil"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/Knetic/govaluate"
 	"github.com/fanliao/go-concurrentMap"
 	"github.com/ldsec/unlynx/data"
 	"github.com/ldsec/unlynx/lib"
 	"github.com/ldsec/unlynx/lib/aggregation"
-	"github.com/ldsec/unlynx/lib/differential_privacy"
+	"github.com/ldsec/unlynx/lib/cell_size"
+	"github.com/ldsec/unlynx/lib/decryption"
 	"github.com/ldsec/unlynx/lib/key_switch"
+	"github.com/ldsec/unlynx/lib/predicate"
+	"github.com/ldsec/unlynx/lib/range"
 	"github.com/ldsec/unlynx/lib/shuffle"
+	"github.com/ldsec/unlynx/lib/statistics"
 	"github.com/ldsec/unlynx/lib/store"
+	"github.com/ldsec/unlynx/lib/threshold"
 	"github.com/ldsec/unlynx/lib/tools"
 	"github.com/ldsec/unlynx/protocols"
+	"github.com/ldsec/unlynx/protocols/utils"
 	"github.com/satori/go.uuid"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -44,11 +53,82 @@ type SurveyCreationQuery struct {
 	Source       *network.ServerIdentity
 
 	// query statement
-	Sum       []string
-	Count     bool
-	Where     []libunlynx.WhereQueryAttribute
+	Sum   []string
+	Count bool
+	Where []libunlynx.WhereQueryAttribute
+	// Predicate filters the responses on their where attributes, referenced by name, with the where attributes of the
+	// query as values (see libunlynxpredicate.Parse), e.g. "w0 == $0 AND w1 IN ($1, $2)"
 	Predicate string
 	GroupBy   []string
+
+	// FixedPoint contains the number of decimals of the sum attributes encoded as fixed-point values
+	FixedPoint libunlynx.FixedPointScales
+
+	// LinearCombinations are aggregating attributes derived from the aggregated sum attributes (e.g. a weighted
+	// prevalence), appended to the results
+	LinearCombinations []LinearCombination
+
+	// Ranges contains the bounds of some sum attributes (e.g. count): the data providers must prove that their encrypted
+	// values lie in them
+	Ranges map[string]*libunlynxrange.Bounds
+
+	// ThresholdKeyID identifies the distributed (t-of-n) key the data is encrypted under (i.e. the ID of the roster that
+	// generated it). The survey roster can then be any t servers of that roster. The roster aggregate is used if nil.
+	ThresholdKeyID onet.RosterID
+
+	// Suite is the name of the suite (group) the survey data is encrypted in: servers configured with another suite
+	// refuse to participate
+	Suite string
+
+	// PublicResults releases the results publicly: instead of being key switched to the querier's key, they are
+	// collectively decrypted with proofs that anyone holding the roster public keys can verify
+	PublicResults bool
+
+	// Schema declares the attributes of the data providers' responses: the query and the responses are validated
+	// against it. The fixed-point scales of the sum attributes are taken from it if FixedPoint is empty.
+	Schema *libunlynx.Schema
+
+	// MemoryBudget is the maximum size in bytes of the responses each server keeps in memory for this survey (no limit
+	// if 0). Beyond it, the responses are spilled to disk and shuffled and tagged batch by batch: a response is then
+	// only shuffled with the ones of its batch.
+	MemoryBudget int64
+
+	// DatasetID identifies the dataset the survey is run on: each server copies the responses uploaded to it instead of
+	// waiting for data providers (MapDPs must be empty). The schema of the dataset is used if Schema is nil.
+	DatasetID DatasetID
+
+	// Querier is the public key of the querier, who signs the query (see Sign) and its results query: the servers with
+	// a query policy only take part in the surveys of the queriers it allows
+	Querier   kyber.Point
+	Signature []byte
+	// Nonce and Expiry (in seconds since the Unix epoch) are signed with the query: the servers with a query policy
+	// reject it once expired or if they already received it
+	Nonce  []byte
+	Expiry int64
+
+	// Epsilon is the privacy budget consumed by the survey: each server charges it to the querier's budget on the
+	// dataset (see PrivacyLedger) and refuses the survey if it would exceed it
+	Epsilon float64
+
+	// DiffPrivacy makes the results differentially private with the survey's epsilon: noise is added to them by the
+	// DRO phase (no noise if nil)
+	DiffPrivacy *DiffPrivacyParameters
+
+	// MinCellSize is the minimum number of responses of a group of the results (no minimum if 0). The servers determine
+	// which groups are below it without revealing their counts (see protocolsunlynx.CellSizeProtocol) and suppress them
+	// before the results are released. The query must count the responses. The exact counts are tested, before the DRO
+	// phase adds noise: with DiffPrivacy, the noisy results are differentially private but the set of released groups
+	// is not, since it reveals which groups have fewer responses than the minimum.
+	MinCellSize int64
+	// MergeSmallCells merges the groups below the minimum cell size in an "other" group, which has no group by values,
+	// instead of suppressing them. The "other" group is suppressed too if it is still below the minimum.
+	MergeSmallCells bool
+}
+
+// LinearCombination describes an aggregating attribute computed as sum_i Weights[s_i]*s_i over the sum attributes s_i
+type LinearCombination struct {
+	Name    string
+	Weights map[string]int64
 }
 
 // Survey represents a survey with the corresponding params
@@ -57,15 +137,33 @@ type Survey struct {
 	Query             SurveyCreationQuery
 	SurveySecretKey   kyber.Scalar
 	ShufflePrecompute []libunlynxshuffle.CipherVectorScalar
+	Filter            *libunlynxpredicate.Filter // compiled predicate of the query (nil if there is none)
 	Lengths           [][]int
 	TargetOfSwitch    []libunlynx.ProcessResponse
+	DecryptionProofs  libunlynxdecrypt.PublishedDecryptionListProofBytes // proofs of the decryption of public results
 
-	// channels
-	SurveyChannel chan int // To wait for the survey to be created before loading data
-	DpChannel     chan int // To wait for all data to be read before starting unlynx service protocol
-	DDTChannel    chan int // To wait for all nodes to finish the tagging before continuing
+	// LinearCombinationProofs are the proofs of the linear combinations of the aggregated results (see
+	// SurveyCreationQuery.LinearCombinations)
+	LinearCombinationProofs libunlynxaggr.PublishedLinearCombinationListProofBytes
 
-	Noise libunlynx.CipherText
+	Status  SurveyStatus
+	Phase   SurveyPhase // phase of the protocol the survey is (or was last) processed in
+	Error   string      // reason of the failure of the survey
+	DpCount int64       // number of data providers who have already pushed their data
+
+	// DataProviders contains the identities of the data providers who have already pushed their data (they cannot push
+	// it twice)
+	DataProviders map[string]bool
+
+	// channels
+	SurveyChannel chan int      // To wait for the survey to be created before loading data
+	RefuseChannel chan string   // To stop waiting if a node refuses the survey
+	DpChannel     chan int      // To wait for all data to be read before starting unlynx service protocol
+	DDTChannel    chan int      // To wait for all nodes to finish the tagging before continuing
+	Cancelled     chan struct{} // Closed when the survey is cancelled to stop all the waits
+
+	// Noise contains the shuffled noise values of each group of the results (see DiffPrivacyParameters)
+	Noise []libunlynx.CipherVector
 }
 
 // MsgTypes defines the Message Type ID for all the service's intra-messages.
@@ -74,6 +172,14 @@ type MsgTypes struct {
 	msgSurveyResultsQuery     network.MessageTypeID
 	msgDDTfinished            network.MessageTypeID
 	msgQueryBroadcastFinished network.MessageTypeID
+	msgSurveyStatusQuery      network.MessageTypeID
+	msgSurveyStatusReply      network.MessageTypeID
+	msgCancelSurveyQuery      network.MessageTypeID
+	msgDeleteSurveyQuery      network.MessageTypeID
+	msgDatasetCreationQuery   network.MessageTypeID
+	msgDatasetCreationDone    network.MessageTypeID
+	msgDatasetCreationCancel  network.MessageTypeID
+	msgStartProcessing        network.MessageTypeID
 }
 
 var msgTypes = MsgTypes{}
@@ -86,15 +192,33 @@ func init() {
 	msgTypes.msgSurveyResultsQuery = network.RegisterMessage(&SurveyResultsQuery{})
 	msgTypes.msgDDTfinished = network.RegisterMessage(&DDTfinished{})
 	msgTypes.msgQueryBroadcastFinished = network.RegisterMessage(&QueryBroadcastFinished{})
+	msgTypes.msgSurveyStatusQuery = network.RegisterMessage(&SurveyStatusQuery{})
+	msgTypes.msgSurveyStatusReply = network.RegisterMessage(&SurveyStatusReply{})
+	msgTypes.msgCancelSurveyQuery = network.RegisterMessage(&CancelSurveyQuery{})
+	msgTypes.msgDeleteSurveyQuery = network.RegisterMessage(&DeleteSurveyQuery{})
+	msgTypes.msgDatasetCreationQuery = network.RegisterMessage(&DatasetCreationQuery{})
+	msgTypes.msgDatasetCreationDone = network.RegisterMessage(&DatasetCreationFinished{})
+	msgTypes.msgDatasetCreationCancel = network.RegisterMessage(&DatasetCreationCancelled{})
+	msgTypes.msgStartProcessing = network.RegisterMessage(&StartProcessing{})
 
 	network.RegisterMessage(&SurveyResponseQuery{})
 	network.RegisterMessage(&ServiceState{})
 	network.RegisterMessage(&ServiceResult{})
+	network.RegisterMessage(&DKGQuery{})
+	network.RegisterMessage(&DKGResult{})
+	network.RegisterMessage(&KeyRotationQuery{})
+	network.RegisterMessage(&KeyRotationResult{})
+	network.RegisterMessage(&ListSurveysQuery{})
+	network.RegisterMessage(&SurveyList{})
+	network.RegisterMessage(&SurveyStatusReport{})
 }
 
-// QueryBroadcastFinished is used to ensure that all servers have received the query/survey
+// QueryBroadcastFinished is used to ensure that all servers have received (and accepted) the query/survey or its
+// results query
 type QueryBroadcastFinished struct {
 	SurveyID SurveyID
+	// Refusal is set if the server refused to participate in the survey
+	Refusal string
 }
 
 // DDTfinished is used to ensure that all servers perform the shuffling+DDT before collectively aggregating the results
@@ -105,6 +229,8 @@ type DDTfinished struct {
 // SurveyResponseQuery is used to ask a client for its response to a survey.
 type SurveyResponseQuery struct {
 	SurveyID  SurveyID
+	DpPublic  kyber.Point // key of the data provider, to which the proofs of knowledge of the responses are bound
+	Signature []byte      // signature of the data provider (see Sign)
 	Responses []libunlynx.DpResponseToSend
 }
 
@@ -113,6 +239,42 @@ type SurveyResultsQuery struct {
 	IntraMessage bool
 	SurveyID     SurveyID
 	ClientPublic kyber.Point
+	Signature    []byte // signature of the querier of the survey (see Sign)
+	Source       *network.ServerIdentity
+}
+
+// StartProcessing is used to start the processing of a survey once all the servers accepted its results query
+type StartProcessing struct {
+	SurveyID SurveyID
+}
+
+// DKGQuery is used to generate a distributed (t-of-n) collective key for a roster.
+type DKGQuery struct {
+	Roster    onet.Roster
+	Threshold int
+	// Auth is the signature of the querier asking for the key: a server with a query policy only generates the keys of
+	// the queriers it allows
+	Auth QuerierSignature
+}
+
+// DKGResult contains the distributed collective key generated for a roster.
+type DKGResult struct {
+	Public kyber.Point
+}
+
+// KeyRotationQuery is used to rotate the private key of the server receiving it. The ciphertexts stored by the servers
+// of the roster are re-encrypted under the new collective key.
+type KeyRotationQuery struct {
+	Roster onet.Roster
+	// Auth is the signature of the querier asking for the rotation: a server with a query policy only rotates its key
+	// for the queriers it allows
+	Auth QuerierSignature
+}
+
+// KeyRotationResult contains the new public key of the server and the new collective key of the roster.
+type KeyRotationResult struct {
+	Public        kyber.Point
+	CollectiveKey kyber.Point
 }
 
 // ServiceState represents the service "state".
@@ -123,12 +285,64 @@ type ServiceState struct {
 // ServiceResult will contain final results of a survey and be sent to querier.
 type ServiceResult struct {
 	Results []libunlynx.FilteredResponse
+
+	// Sum and FixedPoint describe the aggregating attributes of the results
+	Sum        []string
+	FixedPoint libunlynx.FixedPointScales
+
+	// LinearCombinationProofs are the proofs of the linear combinations of the aggregated results (still encrypted under
+	// the collective key): they can only be verified against public results, see VerifyLinearCombinationProofs
+	LinearCombinationProofs libunlynxaggr.PublishedLinearCombinationListProofBytes
+
+	// DecryptionProofs are the proofs of the collective decryption of the results (still encrypted under the
+	// collective key) if they are public
+	DecryptionProofs libunlynxdecrypt.PublishedDecryptionListProofBytes
 }
 
 // Service defines a service in unlynx with a survey.
 type Service struct {
 	*onet.ServiceProcessor
-	Survey *concurrent.ConcurrentMap
+	Survey        *concurrent.ConcurrentMap
+	ThresholdKeys *concurrent.ConcurrentMap // shares of the distributed keys, indexed by the ID of their roster
+	RotatedKeys   *concurrent.ConcurrentMap // current public keys of the servers that rotated their key, indexed by their ID
+
+	// SurveyStore persists the surveys (they are only kept in memory if nil)
+	SurveyStore SurveyStore
+	// KeyStore persists the keys of the server (they are only kept in memory if nil)
+	KeyStore KeyStore
+
+	// Datasets contains the responses uploaded once by the data providers to be queried by several surveys
+	Datasets     *concurrent.ConcurrentMap
+	DatasetStore DatasetStore // persists the datasets (they are only kept in memory if nil)
+	datasetMutex sync.Mutex
+
+	// Policy is the local query policy of the server (all the queries are accepted if nil)
+	Policy *QueryPolicy
+
+	// Ledger records the privacy budget consumed by the queriers on each dataset
+	Ledger *PrivacyLedger
+
+	// SpillDir is the directory in which the surveys with a memory budget spill their responses (the default directory
+	// for temporary files if empty)
+	SpillDir string
+
+	// privateKey is the rotated private key of this server (its onet private key is used if nil)
+	privateKey kyber.Scalar
+	keyMutex   sync.Mutex
+
+	// nonces contains the expiry of the signed queries received by the server, indexed by their querier and nonce
+	nonces      map[string]int64
+	noncesMutex sync.Mutex
+
+	// statusRequests contains the channels of the survey status queries waiting for the answers of the other servers
+	statusRequests *concurrent.ConcurrentMap
+
+	// surveyLocks contains the locks of the surveys (see updateSurvey), created on first use
+	surveyLocks      map[SurveyID]*sync.Mutex
+	surveyLocksMutex sync.Mutex
+
+	// tickets contains the progress of the surveys processed asynchronously, indexed by ticket
+	tickets *concurrent.ConcurrentMap
 }
 
 func (s *Service) getSurvey(sid SurveyID) (Survey, error) {
@@ -143,15 +357,131 @@ func (s *Service) getSurvey(sid SurveyID) (Survey, error) {
 }
 
 func (s *Service) putSurvey(sid SurveyID, surv Survey) error {
-	_, err := s.Survey.Put(string(sid), surv)
+	if _, err := s.Survey.Put(string(sid), surv); err != nil {
+		return err
+	}
+	return s.saveSurvey(sid, surv)
+}
+
+// lockSurvey locks a survey against concurrent modifications and returns the function unlocking it
+func (s *Service) lockSurvey(sid SurveyID) func() {
+	s.surveyLocksMutex.Lock()
+	if s.surveyLocks == nil {
+		s.surveyLocks = make(map[SurveyID]*sync.Mutex)
+	}
+	lock, ok := s.surveyLocks[sid]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.surveyLocks[sid] = lock
+	}
+	s.surveyLocksMutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// updateSurvey gets a survey, modifies it and puts it back under the lock of the survey, so that concurrent
+// modifications (e.g. the responses of two data providers, a phase change and a cancellation) are not lost. The
+// modification must not wait for the processing of the survey.
+func (s *Service) updateSurvey(sid SurveyID, update func(*Survey) error) error {
+	defer s.lockSurvey(sid)()
+
+	survey, err := s.getSurvey(sid)
+	if err != nil {
+		return err
+	}
+	if err := update(&survey); err != nil {
+		return err
+	}
+	return s.putSurvey(sid, survey)
+}
+
+// setSurveyStatus updates the status of a survey (and the reason of its failure), the privacy budget of a failing
+// survey is refunded
+func (s *Service) setSurveyStatus(sid SurveyID, status SurveyStatus, reason string) error {
+	refund, query := false, SurveyCreationQuery{}
+	err := s.updateSurvey(sid, func(survey *Survey) error {
+		refund, query = status == SurveyFailed && !survey.Status.ended(), survey.Query
+		survey.Status, survey.Error = status, reason
+		return nil
+	})
+	if err == nil && refund {
+		s.refundPrivacyBudget(query)
+	}
 	return err
 }
 
+func (s *Service) getThresholdKey(rid onet.RosterID) (*libunlynxthreshold.KeyShare, error) {
+	keyShare, err := s.ThresholdKeys.Get(rid.String())
+	if err != nil {
+		return nil, fmt.Errorf("error while getting the distributed key of roster "+rid.String()+": %v", err)
+	}
+	if keyShare == nil {
+		return nil, fmt.Errorf("no distributed key for roster " + rid.String())
+	}
+	return keyShare.(*libunlynxthreshold.KeyShare), nil
+}
+
+func (s *Service) getPrivateKey() kyber.Scalar {
+	s.keyMutex.Lock()
+	defer s.keyMutex.Unlock()
+	return s.privateKey
+}
+
+func (s *Service) setPrivateKey(privateKey kyber.Scalar) {
+	s.keyMutex.Lock()
+	defer s.keyMutex.Unlock()
+	s.privateKey = privateKey
+}
+
+// publicKey returns the current public key of a server, i.e. its rotated public key if it rotated its key
+func (s *Service) publicKey(si *network.ServerIdentity) kyber.Point {
+	if public, err := s.RotatedKeys.Get(si.ID.String()); err == nil && public != nil {
+		return public.(kyber.Point)
+	}
+	return si.Public
+}
+
+// publicKeys returns the current public keys of the servers of a roster
+func (s *Service) publicKeys(roster *onet.Roster) []kyber.Point {
+	publics := make([]kyber.Point, len(roster.List))
+	for i, si := range roster.List {
+		publics[i] = s.publicKey(si)
+	}
+	return publics
+}
+
+// collectiveKey returns the collective key of a roster (the aggregate of the current public keys of its servers)
+func (s *Service) collectiveKey(roster *onet.Roster) kyber.Point {
+	collectiveKey := libunlynx.SuiTe.Point().Null()
+	for _, public := range s.publicKeys(roster) {
+		collectiveKey.Add(collectiveKey, public)
+	}
+	return collectiveKey
+}
+
+// surveyKeys returns the collective key of a survey and, if it is a distributed key, the share of this server.
+func (s *Service) surveyKeys(query SurveyCreationQuery) (kyber.Point, *libunlynxthreshold.KeyShare, error) {
+	if query.ThresholdKeyID.IsNil() {
+		return s.collectiveKey(&query.Roster), nil, nil
+	}
+	keyShare, err := s.getThresholdKey(query.ThresholdKeyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyShare.Public(), keyShare, nil
+}
+
 // NewService constructor which registers the needed messages.
 func NewService(c *onet.Context) (onet.Service, error) {
 	newUnLynxInstance := &Service{
 		ServiceProcessor: onet.NewServiceProcessor(c),
 		Survey:           concurrent.NewConcurrentMap(),
+		Datasets:         concurrent.NewConcurrentMap(),
+		ThresholdKeys:    concurrent.NewConcurrentMap(),
+		RotatedKeys:      concurrent.NewConcurrentMap(),
+		statusRequests:   concurrent.NewConcurrentMap(),
+		tickets:          concurrent.NewConcurrentMap(),
 	}
 	var cerr error
 	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleSurveyCreationQuery); cerr != nil {
@@ -169,11 +499,87 @@ func NewService(c *onet.Context) (onet.Service, error) {
 	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleQueryBroadcastFinished); cerr != nil {
 		return nil, fmt.Errorf("wrong Handler: %v", cerr)
 	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleDKGQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleKeyRotationQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleListSurveysQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleSurveyStatusQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleCancelSurveyQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleDeleteSurveyQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleAsyncResultsQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleResultsPollQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterStreamingHandler(newUnLynxInstance.HandleResultsSubscription); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleDatasetCreationQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandleDatasetResponseQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
+	if cerr = newUnLynxInstance.RegisterHandler(newUnLynxInstance.HandlePrivacyBudgetQuery); cerr != nil {
+		return nil, fmt.Errorf("wrong Handler: %v", cerr)
+	}
 
 	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgSurveyCreationQuery)
 	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgSurveyResultsQuery)
 	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgDDTfinished)
 	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgQueryBroadcastFinished)
+	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgSurveyStatusQuery)
+	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgSurveyStatusReply)
+	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgCancelSurveyQuery)
+	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgDeleteSurveyQuery)
+	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgDatasetCreationQuery)
+	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgDatasetCreationDone)
+	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgDatasetCreationCancel)
+	c.RegisterProcessor(newUnLynxInstance, msgTypes.msgStartProcessing)
+
+	if PolicyFile != "" {
+		policy, err := LoadQueryPolicy(PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the query policy: %v", err)
+		}
+		newUnLynxInstance.Policy = policy
+	}
+
+	// the keys, the surveys, the datasets and the privacy ledger are kept in the onet database of the server and
+	// restored when it restarts
+	db, bucket := c.GetAdditionalBucket([]byte("keys"))
+	newUnLynxInstance.KeyStore = NewBoltKeyStore(db, bucket)
+	if err := newUnLynxInstance.LoadKeys(); err != nil {
+		log.Error(c.ServerIdentity(), " could not restore its keys: ", err)
+	}
+	db, bucket = c.GetAdditionalBucket([]byte("ledger"))
+	ledger, err := NewPrivacyLedger(db, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not restore the privacy ledger: %v", err)
+	}
+	newUnLynxInstance.Ledger = ledger
+	db, bucket = c.GetAdditionalBucket([]byte("surveys"))
+	newUnLynxInstance.SurveyStore = NewBoltSurveyStore(db, bucket)
+	if err := newUnLynxInstance.LoadSurveys(); err != nil {
+		log.Error(c.ServerIdentity(), " could not restore its surveys: ", err)
+	}
+	db, bucket = c.GetAdditionalBucket([]byte("datasets"))
+	newUnLynxInstance.DatasetStore = NewBoltDatasetStore(db, bucket)
+	if err := newUnLynxInstance.LoadDatasets(); err != nil {
+		log.Error(c.ServerIdentity(), " could not restore its datasets: ", err)
+	}
 	return newUnLynxInstance, cerr
 }
 
@@ -203,6 +609,54 @@ func (s *Service) Process(msg *network.Envelope) {
 		if err != nil {
 			log.Error(err)
 		}
+	} else if msg.MsgType.Equal(msgTypes.msgSurveyStatusQuery) {
+		msgSurveyStatusQuery := (msg.Msg).(*SurveyStatusQuery)
+		_, err := s.processSurveyStatusQuery(msgSurveyStatusQuery, msg.ServerIdentity)
+		if err != nil {
+			log.Error(err)
+		}
+	} else if msg.MsgType.Equal(msgTypes.msgSurveyStatusReply) {
+		msgSurveyStatusReply := (msg.Msg).(*SurveyStatusReply)
+		_, err := s.HandleSurveyStatusReply(msgSurveyStatusReply)
+		if err != nil {
+			log.Error(err)
+		}
+	} else if msg.MsgType.Equal(msgTypes.msgCancelSurveyQuery) {
+		msgCancelSurveyQuery := (msg.Msg).(*CancelSurveyQuery)
+		_, err := s.processCancelSurveyQuery(msgCancelSurveyQuery, msg.ServerIdentity)
+		if err != nil {
+			log.Error(err)
+		}
+	} else if msg.MsgType.Equal(msgTypes.msgDeleteSurveyQuery) {
+		msgDeleteSurveyQuery := (msg.Msg).(*DeleteSurveyQuery)
+		_, err := s.processDeleteSurveyQuery(msgDeleteSurveyQuery, msg.ServerIdentity)
+		if err != nil {
+			log.Error(err)
+		}
+	} else if msg.MsgType.Equal(msgTypes.msgDatasetCreationQuery) {
+		msgDatasetCreationQuery := (msg.Msg).(*DatasetCreationQuery)
+		_, err := s.HandleDatasetCreationQuery(msgDatasetCreationQuery)
+		if err != nil {
+			log.Error(err)
+		}
+	} else if msg.MsgType.Equal(msgTypes.msgDatasetCreationDone) {
+		msgDatasetCreationFinished := (msg.Msg).(*DatasetCreationFinished)
+		_, err := s.HandleDatasetCreationFinished(msgDatasetCreationFinished)
+		if err != nil {
+			log.Error(err)
+		}
+	} else if msg.MsgType.Equal(msgTypes.msgDatasetCreationCancel) {
+		msgDatasetCreationCancelled := (msg.Msg).(*DatasetCreationCancelled)
+		_, err := s.HandleDatasetCreationCancelled(msgDatasetCreationCancelled)
+		if err != nil {
+			log.Error(err)
+		}
+	} else if msg.MsgType.Equal(msgTypes.msgStartProcessing) {
+		msgStartProcessing := (msg.Msg).(*StartProcessing)
+		_, err := s.HandleStartProcessing(msgStartProcessing)
+		if err != nil {
+			log.Error(err)
+		}
 	}
 }
 
@@ -212,15 +666,68 @@ func (s *Service) PushData(resp *SurveyResponseQuery, proofs bool) error {
 	if err != nil {
 		return err
 	}
+	if survey.Status != SurveyCollecting {
+		return fmt.Errorf("survey %s is %s and does not accept responses anymore", resp.SurveyID, survey.Status)
+	}
+
+	dpID, err := resp.VerifySignature()
+	if err != nil {
+		return err
+	}
+	if survey.DataProviders[dpID] {
+		return fmt.Errorf("data provider %s already pushed its responses to survey %s", dpID, resp.SurveyID)
+	}
+
+	drs := make([]libunlynx.DpResponse, len(resp.Responses))
+	for i, v := range resp.Responses {
+		// the data provider must know the plaintexts of its ciphertexts (e.g. it cannot copy another one's responses)
+		if err := v.VerifyKnowledgeProofs(string(resp.SurveyID), dpID); err != nil {
+			return fmt.Errorf("response %d: %v", i, err)
+		}
+		if err := drs[i].FromDpResponseToSend(v); err != nil {
+			return err
+		}
+		// checked before inserting any response of the batch
+		if survey.Query.Schema != nil {
+			if err := survey.Query.Schema.ValidateDpResponse(drs[i]); err != nil {
+				return fmt.Errorf("response %d: %v", i, err)
+			}
+		}
+	}
+	if len(survey.Query.Ranges) > 0 {
+		collectiveKey, _, err := s.surveyKeys(survey.Query)
+		if err != nil {
+			return err
+		}
+		if err := checkRangeProofs(resp.Responses, drs, survey.Query.Ranges, collectiveKey); err != nil {
+			return err
+		}
+	}
+
+	// the responses are verified concurrently, the survey is then modified under its lock
+	defer s.lockSurvey(resp.SurveyID)()
+	survey, err = s.getSurvey(resp.SurveyID)
+	if err != nil {
+		return err
+	}
+	if survey.Status != SurveyCollecting {
+		return fmt.Errorf("survey %s is %s and does not accept responses anymore", resp.SurveyID, survey.Status)
+	}
+	if survey.DataProviders[dpID] {
+		return fmt.Errorf("data provider %s already pushed its responses to survey %s", dpID, resp.SurveyID)
+	}
 
-	for _, v := range resp.Responses {
-		dr := libunlynx.DpResponse{}
-		if err := dr.FromDpResponseToSend(v); err != nil {
+	for _, dr := range drs {
+		if err := survey.InsertDpResponse(dr, proofs, survey.Query.GroupBy, survey.Query.Sum, survey.Query.Where); err != nil {
 			return err
 		}
-		survey.InsertDpResponse(dr, proofs, survey.Query.GroupBy, survey.Query.Sum, survey.Query.Where)
 	}
-	err = s.putSurvey(resp.SurveyID, survey)
+	survey.DpCount++
+	if survey.DataProviders == nil {
+		survey.DataProviders = make(map[string]bool)
+	}
+	survey.DataProviders[dpID] = true
+	err = s.appendResponses(resp.SurveyID, survey, &ResponsesRecord{DataProvider: dpID, Proofs: proofs, Responses: resp.Responses})
 	if err != nil {
 		return err
 	}
@@ -236,6 +743,14 @@ func (s *Service) PushData(resp *SurveyResponseQuery, proofs bool) error {
 func (s *Service) HandleSurveyCreationQuery(recq *SurveyCreationQuery) (network.Message, error) {
 	log.Lvl1(s.ServerIdentity().String(), " received a Survey Creation Query")
 
+	// the query is forwarded to the other servers as signed by the querier, not as completed by this server
+	signed := *recq
+
+	filter, collectiveKey, err := s.checkSurveyQuery(recq)
+	if err != nil {
+		return nil, s.refuseSurvey(recq, err)
+	}
+
 	// if this server is the one receiving the query from the client
 	if !recq.IntraMessage {
 		id := uuid.NewV4()
@@ -245,41 +760,76 @@ func (s *Service) HandleSurveyCreationQuery(recq *SurveyCreationQuery) (network.
 
 	}
 
+	// the privacy budget is charged once the query is known to be valid
+	if err := s.chargePrivacyBudget(recq); err != nil {
+		return nil, s.refuseSurvey(recq, err)
+	}
+
 	// chooses an ephemeral secret for this survey
 	surveySecret := libunlynx.SuiTe.Scalar().Pick(libunlynx.SuiTe.RandomStream())
 
+	// the privacy budget is refunded if the survey cannot be instantiated, and once instantiated if it is cancelled
+	refuse := func(err error) error {
+		s.refundPrivacyBudget(*recq)
+		return s.refuseSurvey(recq, err)
+	}
+
 	// prepares the precomputation for shuffling
-	lineSize := int(len(recq.Sum)) + int(len(recq.Where)) + int(len(recq.GroupBy)) + 1 // + 1 is for the possible count attribute
-	precomputeShuffle, err := libunlynxshuffle.PrecomputationWritingForShuffling(recq.AppFlag, gobFile, s.ServerIdentity().String(), surveySecret, recq.Roster.Aggregate, lineSize)
+	precomputeShuffle, err := libunlynxshuffle.PrecomputationWritingForShuffling(recq.AppFlag, gobFile, s.ServerIdentity().String(), surveySecret, collectiveKey, shuffleLineSize(*recq))
 	if err != nil {
-		return nil, err
+		return nil, refuse(err)
 	}
 
 	// survey instantiation
-	_, err = s.Survey.Put((string)(recq.SurveyID), Survey{
-		Store:             libunlynxstore.NewStore(),
+	store := libunlynxstore.NewStore()
+	if recq.MemoryBudget > 0 {
+		dir, err := ioutil.TempDir(s.SpillDir, "unlynx-survey-"+string(recq.SurveyID)+"-")
+		if err != nil {
+			return nil, refuse(err)
+		}
+		store = libunlynxstore.NewSpillingStore(dir, recq.MemoryBudget)
+	}
+	err = s.putSurvey(recq.SurveyID, Survey{
+		Store:             store,
 		Query:             *recq,
 		SurveySecretKey:   surveySecret,
 		ShufflePrecompute: precomputeShuffle,
+		Filter:            filter,
+		Status:            SurveyCollecting,
 
 		SurveyChannel: make(chan int, 100),
+		RefuseChannel: make(chan string, 100),
 		DpChannel:     make(chan int, 100),
 		DDTChannel:    make(chan int, 100),
+		Cancelled:     make(chan struct{}),
 	})
 	if err != nil {
-		return nil, err
+		s.Survey.Remove(string(recq.SurveyID))
+		return nil, refuse(err)
 	}
 	log.Lvl1(s.ServerIdentity(), " initiated the survey ", recq.SurveyID)
 
+	if recq.DatasetID != "" {
+		if err := s.loadDataset(recq.SurveyID); err != nil {
+			if errCancel := s.cancelSurvey(recq.SurveyID, err.Error()); errCancel != nil {
+				log.Error(errCancel)
+			}
+			return nil, s.refuseSurvey(recq, err)
+		}
+	}
+
 	if !recq.IntraMessage {
-		recq.IntraMessage = true
-		recq.Source = s.ServerIdentity()
+		signed.SurveyID = recq.SurveyID
+		signed.IntraMessage = true
+		signed.Source = s.ServerIdentity()
 		// broadcasts the query
-		err := libunlynxtools.SendISMOthers(s.ServiceProcessor, &recq.Roster, recq)
+		err := libunlynxtools.SendISMOthers(s.ServiceProcessor, &recq.Roster, &signed)
 		if err != nil {
+			if _, errCancel := s.handleCancelSurvey(&CancelSurveyQuery{SurveyID: recq.SurveyID, Reason: err.Error()}); errCancel != nil {
+				log.Error(errCancel)
+			}
 			return nil, err
 		}
-		recq.IntraMessage = false
 	} else {
 		// warn 'root' node that it has received the query
 		err := s.SendRaw(recq.Source, &QueryBroadcastFinished{SurveyID: recq.SurveyID})
@@ -296,12 +846,12 @@ func (s *Service) HandleSurveyCreationQuery(recq *SurveyCreationQuery) (network.
 				break
 			}
 		}
-		testData, err := dataunlynx.ReadDataFromFile("unlynx_test_data.txt")
+		testData, err := dataunlynx.ReadDataFromFileWithSchema("unlynx_test_data.txt", recq.Schema)
 		if err != nil {
 			return nil, err
 		}
 
-		resp, err := EncryptDataToSurvey(s.ServerIdentity().String(), recq.SurveyID, testData[strconv.Itoa(index)], recq.Roster.Aggregate, 1, recq.Count)
+		resp, err := EncryptDataToSurveyWithRangeProofs(s.ServerIdentity().String(), key.NewKeyPair(libunlynx.SuiTe), recq.SurveyID, testData[strconv.Itoa(index)], libunlynx.NewEncryptor(collectiveKey), recq.Ranges, 1, recq.Count)
 		if err != nil {
 			return nil, err
 		}
@@ -324,14 +874,133 @@ func (s *Service) HandleSurveyCreationQuery(recq *SurveyCreationQuery) (network.
 			return nil, err
 		}
 
+		// all the answers are awaited before a refused survey is cancelled, so that no server creates it afterwards (unless
+		// it does not answer on time)
+		reason := ""
 		counter := len(recq.Roster.List) - 1
 		for counter > 0 {
-			counter = counter - (<-survey.SurveyChannel)
+			select {
+			case nbr := <-survey.SurveyChannel:
+				counter = counter - nbr
+			case r := <-survey.RefuseChannel:
+				counter--
+				if reason == "" {
+					reason = "refused by " + r
+				}
+			case <-survey.Cancelled:
+				return nil, fmt.Errorf("survey %s was cancelled", recq.SurveyID)
+			case <-time.After(libunlynx.TIMEOUT):
+				counter = 0
+				if reason == "" {
+					reason = "not accepted by all the servers on time"
+				}
+			}
+		}
+		if reason != "" {
+			// the servers that accepted the survey cancel it (and refund its privacy budget)
+			if _, err := s.handleCancelSurvey(&CancelSurveyQuery{SurveyID: recq.SurveyID, Reason: reason}); err != nil {
+				log.Error(err)
+			}
+			s.Survey.Remove(string(recq.SurveyID))
+			if err := survey.Close(); err != nil {
+				log.Error(err)
+			}
+			if s.SurveyStore != nil {
+				if err := s.SurveyStore.Delete(recq.SurveyID); err != nil {
+					log.Error(err)
+				}
+			}
+			return nil, fmt.Errorf("survey %s %s", recq.SurveyID, reason)
 		}
 	}
 	return &ServiceState{recq.SurveyID}, nil
 }
 
+// checkSurveyQuery checks that this server can take part in a survey (the query matches its policy, is valid and can
+// use the collective key) and completes the query (e.g. with the schema of its dataset). It returns the compiled
+// predicate and the collective key of the survey.
+func (s *Service) checkSurveyQuery(recq *SurveyCreationQuery) (*libunlynxpredicate.Filter, kyber.Point, error) {
+	if err := checkSuite(recq.Suite); err != nil {
+		return nil, nil, err
+	}
+
+	// the query is authorized as signed, before it is completed with the schema of its dataset
+	if s.Policy != nil {
+		if err := s.Policy.AuthorizeQuery(recq); err != nil {
+			return nil, nil, err
+		}
+		if err := s.checkFreshness(recq.Querier, recq.Nonce, recq.Expiry); err != nil {
+			return nil, nil, err
+		}
+	}
+	if recq.DatasetID != "" {
+		if err := s.prepareDatasetSurvey(recq); err != nil {
+			return nil, nil, err
+		}
+	}
+	if recq.Schema != nil {
+		if err := applySchema(recq); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := checkFixedPoint(recq.Sum, recq.FixedPoint); err != nil {
+		return nil, nil, err
+	}
+	if err := checkRanges(recq.Sum, recq.Ranges); err != nil {
+		return nil, nil, err
+	}
+	// the responses of a dataset are uploaded without range proofs
+	if recq.Schema != nil && recq.DatasetID == "" {
+		if err := checkHistogramRanges(*recq); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := checkLinearCombinations(recq.Sum, recq.LinearCombinations); err != nil {
+		return nil, nil, err
+	}
+	if recq.DiffPrivacy != nil {
+		if err := checkDiffPrivacy(recq); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := checkMinCellSize(*recq); err != nil {
+		return nil, nil, err
+	}
+	filter, err := compilePredicate(*recq)
+	if err != nil {
+		return nil, nil, err
+	}
+	if recq.MemoryBudget < 0 {
+		return nil, nil, fmt.Errorf("negative memory budget: %d", recq.MemoryBudget)
+	}
+	if recq.PublicResults && !recq.ThresholdKeyID.IsNil() {
+		return nil, nil, fmt.Errorf("public results can only be verified with the roster aggregate as collective key")
+	}
+
+	collectiveKey, keyShare, err := s.surveyKeys(*recq)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keyShare != nil {
+		// checks that the survey roster can use the distributed key
+		if _, err := keyShare.Secret(recq.Roster.Publics()); err != nil {
+			return nil, nil, err
+		}
+	}
+	return filter, collectiveKey, nil
+}
+
+// refuseSurvey warns the 'root' node that this server does not participate in a survey (if the query comes from it)
+// and returns the reason
+func (s *Service) refuseSurvey(recq *SurveyCreationQuery, reason error) error {
+	if recq.IntraMessage {
+		if errSend := s.SendRaw(recq.Source, &QueryBroadcastFinished{SurveyID: recq.SurveyID, Refusal: s.ServerIdentity().String() + ": " + reason.Error()}); errSend != nil {
+			return errSend
+		}
+	}
+	return reason
+}
+
 // HandleSurveyResponseQuery handles a survey answers submission by a subject.
 func (s *Service) HandleSurveyResponseQuery(resp *SurveyResponseQuery) (network.Message, error) {
 	survey, err := s.getSurvey(resp.SurveyID)
@@ -351,45 +1020,98 @@ func (s *Service) HandleSurveyResponseQuery(resp *SurveyResponseQuery) (network.
 func (s *Service) HandleSurveyResultsQuery(resq *SurveyResultsQuery) (network.Message, error) {
 	log.Lvl1(s.ServerIdentity(), " received a survey result query")
 
+	err := s.acceptResultsQuery(resq)
+	if resq.IntraMessage {
+		// warn 'root' node that this server accepts (or refuses) to process the survey
+		refusal := ""
+		if err != nil {
+			refusal = s.ServerIdentity().String() + ": " + err.Error()
+		}
+		if errSend := s.SendRaw(resq.Source, &QueryBroadcastFinished{SurveyID: resq.SurveyID, Refusal: refusal}); errSend != nil {
+			return nil, errSend
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
 	survey, err := s.getSurvey(resq.SurveyID)
 	if err != nil {
 		return nil, err
 	}
 
-	survey.Query.ClientPubKey = resq.ClientPublic
-	err = s.putSurvey(resq.SurveyID, survey)
+	resq.IntraMessage = true
+	resq.Source = s.ServerIdentity()
+	err = libunlynxtools.SendISMOthers(s.ServiceProcessor, &survey.Query.Roster, resq)
 	if err != nil {
 		return nil, err
 	}
 
-	if !resq.IntraMessage {
-		resq.IntraMessage = true
-
-		err := libunlynxtools.SendISMOthers(s.ServiceProcessor, &survey.Query.Roster, resq)
-		if err != nil {
-			return nil, err
-		}
-		err = s.StartService(resq.SurveyID, true)
-		if err != nil {
-			return nil, err
+	// the servers start processing the survey once they all accepted it
+	counter := len(survey.Query.Roster.List) - 1
+	for counter > 0 {
+		select {
+		case nbr := <-survey.SurveyChannel:
+			counter = counter - nbr
+		case refusal := <-survey.RefuseChannel:
+			reason := "refused by " + refusal
+			if _, err := s.handleCancelSurvey(&CancelSurveyQuery{SurveyID: resq.SurveyID, Reason: reason}); err != nil {
+				log.Error(err)
+			}
+			return nil, fmt.Errorf("survey %s %s", resq.SurveyID, reason)
+		case <-survey.Cancelled:
+			return nil, fmt.Errorf("survey %s was cancelled", resq.SurveyID)
+		case <-time.After(libunlynx.TIMEOUT):
+			return nil, fmt.Errorf(s.ServerIdentity().String() + " didn't get the <results query acceptances> on time")
 		}
+	}
+	err = libunlynxtools.SendISMOthers(s.ServiceProcessor, &survey.Query.Roster, &StartProcessing{SurveyID: resq.SurveyID})
+	if err != nil {
+		return nil, err
+	}
 
-		log.Lvl1(s.ServerIdentity(), " completed the query processing...")
+	err = s.StartService(resq.SurveyID, true)
+	if err != nil {
+		return nil, err
+	}
 
-		survey, err := s.getSurvey(resq.SurveyID)
-		if err != nil {
-			return nil, err
+	log.Lvl1(s.ServerIdentity(), " completed the query processing...")
+
+	var results []libunlynx.FilteredResponse
+	err = s.updateSurvey(resq.SurveyID, func(target *Survey) error {
+		results = target.PullDeliverableResults(false, libunlynx.CipherText{})
+		survey = *target
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceResult{Results: results, Sum: resultAttributes(survey.Query), FixedPoint: survey.Query.FixedPoint, LinearCombinationProofs: survey.LinearCombinationProofs, DecryptionProofs: survey.DecryptionProofs}, nil
+}
+
+// acceptResultsQuery checks that the survey can be processed and that the results query is authorized by the query
+// policy of the server, then records the key the results are switched to
+func (s *Service) acceptResultsQuery(resq *SurveyResultsQuery) error {
+	return s.updateSurvey(resq.SurveyID, func(survey *Survey) error {
+		if survey.Status == SurveyFailed || survey.Status == SurveyCancelled {
+			return fmt.Errorf("survey %s %s: %s", resq.SurveyID, survey.Status, survey.Error)
 		}
-		results := survey.PullDeliverableResults(false, libunlynx.CipherText{})
-		err = s.putSurvey(resq.SurveyID, survey)
-		if err != nil {
-			return nil, err
+		if s.Policy != nil {
+			if err := s.Policy.AuthorizeResults(survey.Query, resq); err != nil {
+				return err
+			}
 		}
 
-		return &ServiceResult{Results: results}, nil
-	}
+		survey.Query.ClientPubKey = resq.ClientPublic
+		return nil
+	})
+}
 
-	return nil, s.StartService(resq.SurveyID, false)
+// HandleStartProcessing handles the message StartProcessing: all the servers accepted the results query of a survey,
+// which can be processed
+func (s *Service) HandleStartProcessing(sp *StartProcessing) (network.Message, error) {
+	return nil, s.StartService(sp.SurveyID, false)
 }
 
 // HandleDDTfinished handles the message DDTfinished: one of the nodes is ready to perform a collective aggregation
@@ -408,21 +1130,110 @@ func (s *Service) HandleQueryBroadcastFinished(recq *QueryBroadcastFinished) (ne
 	if err != nil {
 		return nil, err
 	}
+	if recq.Refusal != "" {
+		survey.RefuseChannel <- recq.Refusal
+		return nil, nil
+	}
 	survey.SurveyChannel <- 1
 	return nil, nil
 }
 
-// Protocol Handlers
-//______________________________________________________________________________________________________________________
+// HandleDKGQuery handles the generation of a distributed (t-of-n) collective key for a roster: each server stores its
+// share of the key, which can then be used by surveys run by any t servers of the roster. A roster has a single
+// distributed key: the data encrypted under it would be lost if it was replaced.
+func (s *Service) HandleDKGQuery(recq *DKGQuery) (network.Message, error) {
+	log.Lvl1(s.ServerIdentity(), " received a DKG query")
+
+	if err := s.authorize(recq.Auth, dkgStatement(&recq.Roster), nil); err != nil {
+		return nil, err
+	}
+	if _, si := recq.Roster.Search(s.ServerIdentity().ID); si == nil {
+		return nil, fmt.Errorf("%v is not in the roster", s.ServerIdentity())
+	}
+	if recq.Threshold < 2 || recq.Threshold > len(recq.Roster.List) {
+		return nil, fmt.Errorf("invalid threshold %d for %d servers", recq.Threshold, len(recq.Roster.List))
+	}
+	if keyShare, _ := s.ThresholdKeys.Get(recq.Roster.ID.String()); keyShare != nil {
+		return nil, fmt.Errorf("roster %s already has a distributed key", recq.Roster.ID)
+	}
+
+	pi, err := s.startProtocol(protocolsunlynx.DKGProtocolName, &recq.Roster, "", func(pi onet.ProtocolInstance) {
+		pi.(*protocolsunlynx.DKGProtocol).Threshold = recq.Threshold
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var public kyber.Point
+	select {
+	case public = <-pi.(*protocolsunlynx.DKGProtocol).FeedbackChannel:
+	case <-time.After(libunlynx.TIMEOUT):
+		return nil, fmt.Errorf(s.ServerIdentity().String() + " didn't get the <DKG public key> on time")
+	}
+
+	log.Lvl1(s.ServerIdentity(), " generated a distributed key for roster ", recq.Roster.ID)
+	return &DKGResult{Public: public}, nil
+}
+
+// HandleKeyRotationQuery handles the rotation of the private key of this server: the ciphertexts of the surveys and of
+// the datasets encrypted under the collective key of the roster are re-encrypted by all the servers of the roster,
+// which should not receive data or process surveys in the meantime. The rotation is refused if the server takes part in
+// surveys or datasets encrypted under the collective key of another roster (see checkKeyRotation).
+func (s *Service) HandleKeyRotationQuery(recq *KeyRotationQuery) (network.Message, error) {
+	log.Lvl1(s.ServerIdentity(), " received a key rotation query")
+
+	if err := s.authorize(recq.Auth, keyRotationStatement(&recq.Roster), nil); err != nil {
+		return nil, err
+	}
+	if _, si := recq.Roster.Search(s.ServerIdentity().ID); si == nil {
+		return nil, fmt.Errorf("%v is not in the roster", s.ServerIdentity())
+	}
+	if err := s.checkKeyRotation(&recq.Roster); err != nil {
+		return nil, err
+	}
+
+	pi, err := s.startProtocol(protocolsunlynxutils.KeyRotationProtocolName, &recq.Roster, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var public kyber.Point
+	select {
+	case public = <-pi.(*protocolsunlynxutils.KeyRotationProtocol).FeedbackChannel:
+	case <-time.After(libunlynx.TIMEOUT):
+		return nil, fmt.Errorf(s.ServerIdentity().String() + " didn't get the <new public key> on time")
+	}
+
+	log.Lvl1(s.ServerIdentity(), " rotated its key")
+	return &KeyRotationResult{Public: public, CollectiveKey: s.collectiveKey(&recq.Roster)}, nil
+}
+
+// Protocol Handlers
+//______________________________________________________________________________________________________________________
 
 // NewProtocol creates a protocol instance executed by all nodes
 func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfig) (onet.ProtocolInstance, error) {
+	if tn.ProtocolName() == protocolsunlynx.DKGProtocolName {
+		return s.newDKGProtocol(tn)
+	}
+	if tn.ProtocolName() == protocolsunlynxutils.KeyRotationProtocolName {
+		return s.newKeyRotationProtocol(tn)
+	}
+
 	var pi onet.ProtocolInstance
 	target := SurveyID(string(conf.Data))
 	survey, err := s.getSurvey(SurveyID(conf.Data))
 	if err != nil {
 		return nil, err
 	}
+	// the server does not take part in the processing of a cancelled (e.g. refused) survey
+	if survey.cancelled() {
+		return nil, fmt.Errorf("survey %s was cancelled", target)
+	}
+	collectiveKey, keyShare, err := s.surveyKeys(survey.Query)
+	if err != nil {
+		return nil, err
+	}
 
 	switch tn.ProtocolName() {
 	case protocolsunlynx.ShufflingProtocolName:
@@ -441,13 +1252,18 @@ func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfi
 			return &proof
 		}
 		shuffle.Precomputed = survey.ShufflePrecompute
+		shuffle.CollectiveKey = collectiveKey
 		if tn.IsRoot() {
-			dpResponses := survey.PullDpResponses()
-			var toShuffleCV []libunlynx.CipherVector
-			toShuffleCV, survey.Lengths = protocolsunlynx.ProcessResponseToMatrixCipherText(dpResponses)
-			shuffle.ShuffleTarget = &toShuffleCV
-
-			err = s.putSurvey(target, survey)
+			err := s.updateSurvey(target, func(survey *Survey) error {
+				dpResponses, err := survey.PullDpResponsesBatch()
+				if err != nil {
+					return err
+				}
+				var toShuffleCV []libunlynx.CipherVector
+				toShuffleCV, survey.Lengths = protocolsunlynx.ProcessResponseToMatrixCipherText(dpResponses)
+				shuffle.ShuffleTarget = &toShuffleCV
+				return nil
+			})
 			if err != nil {
 				return nil, err
 			}
@@ -462,24 +1278,30 @@ func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfi
 
 		aux := survey.SurveySecretKey
 		hashCreation.SurveySecretKey = &aux
+		hashCreation.ThresholdKey = keyShare
+		hashCreation.PrivateKey = s.getPrivateKey()
 		hashCreation.Proofs = survey.Query.Proofs
 		if tn.IsRoot() {
-			shuffledClientResponses := survey.PullShuffledProcessResponses()
-
-			var queryWhereToTag []libunlynx.ProcessResponse
-			for _, v := range survey.Query.Where {
-				cv := libunlynx.CipherVector{v.Value}
-				queryWhereToTag = append(queryWhereToTag, libunlynx.ProcessResponse{WhereEnc: cv, GroupByEnc: nil, AggregatingAttributes: nil})
-			}
-			shuffledClientResponses = append(queryWhereToTag, shuffledClientResponses...)
-			deterministicTOS := protocolsunlynx.ProcessResponseToCipherVector(shuffledClientResponses)
-			survey.TargetOfSwitch = shuffledClientResponses
-			err = s.putSurvey(target, survey)
+			err := s.updateSurvey(target, func(survey *Survey) error {
+				shuffledClientResponses, err := survey.PullShuffledProcessResponsesBatch()
+				if err != nil {
+					return err
+				}
+
+				var queryWhereToTag []libunlynx.ProcessResponse
+				for _, v := range survey.Query.Where {
+					cv := libunlynx.CipherVector{v.Value}
+					queryWhereToTag = append(queryWhereToTag, libunlynx.ProcessResponse{WhereEnc: cv, GroupByEnc: nil, AggregatingAttributes: nil})
+				}
+				shuffledClientResponses = append(queryWhereToTag, shuffledClientResponses...)
+				deterministicTOS := protocolsunlynx.ProcessResponseToCipherVector(shuffledClientResponses)
+				survey.TargetOfSwitch = shuffledClientResponses
+				hashCreation.TargetOfSwitch = &deterministicTOS
+				return nil
+			})
 			if err != nil {
 				return nil, err
 			}
-
-			hashCreation.TargetOfSwitch = &deterministicTOS
 		}
 
 	case protocolsunlynx.CollectiveAggregationProtocolName:
@@ -489,14 +1311,18 @@ func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfi
 		}
 
 		// waits for all other nodes to finish the tagging phase
-		groupedData := survey.PullLocallyAggregatedResponses()
-		err = s.putSurvey(target, survey)
+		var groupedData map[libunlynx.GroupingKey]libunlynx.FilteredResponse
+		err = s.updateSurvey(target, func(survey *Survey) error {
+			groupedData = survey.PullLocallyAggregatedResponses()
+			return nil
+		})
 		if err != nil {
 			return nil, err
 		}
 
 		collectiveAggr := pi.(*protocolsunlynx.CollectiveAggregationProtocol)
 		collectiveAggr.GroupedData = &groupedData
+		collectiveAggr.CollectiveKey = collectiveKey
 		collectiveAggr.Proofs = survey.Query.Proofs
 		collectiveAggr.ProofFunc = func(data []libunlynx.CipherVector, res libunlynx.CipherVector) *libunlynxaggr.PublishedAggregationListProof {
 			proof := libunlynxaggr.AggregationListProofCreation(data, res)
@@ -505,9 +1331,27 @@ func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfi
 
 		counter := len(tn.Roster().List) - 1
 		for counter > 0 {
-			counter = counter - (<-survey.DDTChannel)
+			select {
+			case nbr := <-survey.DDTChannel:
+				counter = counter - nbr
+			case <-survey.Cancelled:
+				return nil, fmt.Errorf("survey %s was cancelled", target)
+			}
+		}
+
+	case protocolsunlynx.CellSizeProtocolName:
+		pi, err = protocolsunlynx.NewCellSizeProtocol(tn)
+		if err != nil {
+			return nil, err
 		}
 
+		cellSize := pi.(*protocolsunlynx.CellSizeProtocol)
+		cellSize.MinCellSize = survey.Query.MinCellSize
+		cellSize.ThresholdKey = keyShare
+		cellSize.PrivateKey = s.getPrivateKey()
+		cellSize.Publics = s.publicKeys(tn.Roster())
+		cellSize.Proofs = survey.Query.Proofs
+
 	case protocolsunlynx.DROProtocolName:
 		pi, err := protocolsunlynx.NewShufflingProtocol(tn)
 		if err != nil {
@@ -524,16 +1368,22 @@ func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfi
 			return &proof
 		}
 		shuffle.Precomputed = nil
+		shuffle.CollectiveKey = collectiveKey
 
 		if tn.IsRoot() {
-			clientResponses := make([]libunlynx.ProcessResponse, 0)
-			noiseArray := libunlynxdiffprivacy.GenerateNoiseValues(1000, 0, 1, 0.1, 0)
-			for _, v := range noiseArray {
-				clientResponses = append(clientResponses, libunlynx.ProcessResponse{GroupByEnc: nil, AggregatingAttributes: libunlynx.IntArrayToCipherVector([]int64{int64(v)})})
+			clientResponses, err := noiseResponses(survey.Query, collectiveKey)
+			if err != nil {
+				return nil, err
+			}
+			err = s.updateSurvey(target, func(survey *Survey) error {
+				var toShuffleCV []libunlynx.CipherVector
+				toShuffleCV, survey.Lengths = protocolsunlynx.ProcessResponseToMatrixCipherText(clientResponses)
+				shuffle.ShuffleTarget = &toShuffleCV
+				return nil
+			})
+			if err != nil {
+				return nil, err
 			}
-			var toShuffleCV []libunlynx.CipherVector
-			toShuffleCV, survey.Lengths = protocolsunlynx.ProcessResponseToMatrixCipherText(clientResponses)
-			shuffle.ShuffleTarget = &toShuffleCV
 		}
 		return pi, nil
 
@@ -544,6 +1394,8 @@ func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfi
 		}
 
 		keySwitch := pi.(*protocolsunlynx.KeySwitchingProtocol)
+		keySwitch.ThresholdKey = keyShare
+		keySwitch.PrivateKey = s.getPrivateKey()
 		keySwitch.Proofs = survey.Query.Proofs
 		keySwitch.ProofFunc = func(pubKey, targetPubKey kyber.Point, secretKey kyber.Scalar, ks2s, rBNegs []kyber.Point, vis []kyber.Scalar) *libunlynxkeyswitch.PublishedKSListProof {
 			proof, err := libunlynxkeyswitch.KeySwitchListProofCreation(pubKey, targetPubKey, secretKey, ks2s, rBNegs, vis)
@@ -554,23 +1406,54 @@ func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfi
 		}
 
 		if tn.IsRoot() {
-			var coaggr []libunlynx.FilteredResponse
-
-			if libunlynx.DIFFPRI {
-				coaggr = survey.PullCothorityAggregatedFilteredResponses(true, survey.Noise)
-			} else {
-				coaggr = survey.PullCothorityAggregatedFilteredResponses(false, libunlynx.CipherText{})
+			err = s.updateSurvey(target, func(survey *Survey) error {
+				coaggr := survey.PullCothorityAggregatedFilteredResponses(false, libunlynx.CipherText{})
+				if survey.Query.DiffPrivacy != nil {
+					if err := addNoise(coaggr, survey.Noise); err != nil {
+						return err
+					}
+				}
+				var cv libunlynx.CipherVector
+				cv, survey.Lengths = protocolsunlynx.FilteredResponseToCipherVector(coaggr)
+				keySwitch.TargetOfSwitch = &cv
+				cpk := survey.Query.ClientPubKey
+				keySwitch.TargetPublicKey = &cpk
+				return nil
+			})
+			if err != nil {
+				return nil, err
 			}
-			var cv libunlynx.CipherVector
-			cv, survey.Lengths = protocolsunlynx.FilteredResponseToCipherVector(coaggr)
-			keySwitch.TargetOfSwitch = &cv
-			cpk := survey.Query.ClientPubKey
-			keySwitch.TargetPublicKey = &cpk
+		}
+
+	case protocolsunlynx.CollectiveDecryptionProtocolName:
+		pi, err = protocolsunlynx.NewCollectiveDecryptionProtocol(tn)
+		if err != nil {
+			return nil, err
+		}
+
+		decryption := pi.(*protocolsunlynx.CollectiveDecryptionProtocol)
+		decryption.ThresholdKey = keyShare
+		decryption.PrivateKey = s.getPrivateKey()
+		decryption.Publics = s.publicKeys(tn.Roster())
 
-			err = s.putSurvey(target, survey)
+		if tn.IsRoot() {
+			err = s.updateSurvey(target, func(survey *Survey) error {
+				coaggr := survey.PullCothorityAggregatedFilteredResponses(false, libunlynx.CipherText{})
+				if survey.Query.DiffPrivacy != nil {
+					if err := addNoise(coaggr, survey.Noise); err != nil {
+						return err
+					}
+				}
+				var cv libunlynx.CipherVector
+				cv, survey.Lengths = protocolsunlynx.FilteredResponseToCipherVector(coaggr)
+				decryption.TargetOfDecryption = &cv
+				return nil
+			})
 			if err != nil {
 				return nil, err
 			}
+			// public results must always be verifiable
+			decryption.Proofs = true
 		}
 	default:
 		return nil, fmt.Errorf("service attempts to start an unknown protocol: " + tn.ProtocolName())
@@ -578,18 +1461,218 @@ func (s *Service) NewProtocol(tn *onet.TreeNodeInstance, conf *onet.GenericConfi
 	return pi, nil
 }
 
+// newDKGProtocol creates a distributed key generation protocol instance, which stores the share of the server as the
+// distributed key of the roster of the protocol. The server does not take part in it if the roster already has a
+// distributed key.
+func (s *Service) newDKGProtocol(tn *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	rosterID := tn.Roster().ID.String()
+	if keyShare, _ := s.ThresholdKeys.Get(rosterID); keyShare != nil {
+		return nil, fmt.Errorf("roster %s already has a distributed key", rosterID)
+	}
+
+	pi, err := protocolsunlynx.NewDKGProtocol(tn)
+	if err != nil {
+		return nil, err
+	}
+
+	dkg := pi.(*protocolsunlynx.DKGProtocol)
+	dkg.ShareFunc = func(keyShare *libunlynxthreshold.KeyShare) error {
+		return s.putThresholdKey(rosterID, keyShare)
+	}
+	return pi, nil
+}
+
+// newKeyRotationProtocol creates a key rotation protocol instance, which re-encrypts the ciphertexts of the surveys
+// and of the datasets stored by the server and records the new key of the root.
+func (s *Service) newKeyRotationProtocol(tn *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	pi, err := protocolsunlynxutils.NewKeyRotationProtocol(tn)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := pi.(*protocolsunlynxutils.KeyRotationProtocol)
+	rotation.RootPublic = s.publicKey(tn.Root().ServerIdentity)
+	rotation.PrivateKey = s.getPrivateKey()
+
+	surveysCipherTexts, setSurveysCipherTexts, surveysChunks := s.surveysCipherTexts(tn)
+	datasetsCipherTexts, setDatasetsCipherTexts := s.datasetsCipherTexts(tn)
+	rotation.TargetOfTransformation = append(surveysCipherTexts, datasetsCipherTexts...)
+	rotation.Chunks = surveysChunks
+	rotation.RotationFunc = func(rotated libunlynx.CipherVector, newPublic kyber.Point) error {
+		if len(rotated) != len(rotation.TargetOfTransformation) {
+			return fmt.Errorf("%d re-encrypted ciphertexts for %d ciphertexts", len(rotated), len(rotation.TargetOfTransformation))
+		}
+		if err := setSurveysCipherTexts(rotated[:len(surveysCipherTexts)]); err != nil {
+			return err
+		}
+		if err := setDatasetsCipherTexts(rotated[len(surveysCipherTexts):]); err != nil {
+			return err
+		}
+		var newPrivate kyber.Scalar
+		if tn.IsRoot() {
+			newPrivate = rotation.NewKey
+		}
+		return s.putRotatedKey(tn.Root().ServerIdentity.ID.String(), newPublic, newPrivate)
+	}
+	return pi, nil
+}
+
+// rotatedBy checks if the data of a survey or a dataset, encrypted for a roster (under the distributed key with the
+// given ID if it is not nil), is re-encrypted by the key rotation of the root of a protocol: the data must be encrypted
+// under the collective key of the roster of the protocol, to which the root contributes. The shares of the distributed
+// keys are not rotated.
+func (s *Service) rotatedBy(tn *onet.TreeNodeInstance, roster *onet.Roster, thresholdKeyID onet.RosterID) bool {
+	if !thresholdKeyID.IsNil() {
+		return false
+	}
+	if i, _ := roster.Search(tn.Root().ServerIdentity.ID); i < 0 {
+		return false
+	}
+	return s.collectiveKey(roster).Equal(s.collectiveKey(tn.Roster()))
+}
+
+// checkKeyRotation checks that the rotation of the key of this server on a roster does not leave data encrypted under
+// the old key: the surveys that are not finished and the datasets of the server must be encrypted under the collective
+// key of the roster, under a distributed key or for rosters without this server.
+func (s *Service) checkKeyRotation(roster *onet.Roster) error {
+	collectiveKey := s.collectiveKey(roster)
+	affected := func(dataRoster *onet.Roster, thresholdKeyID onet.RosterID) bool {
+		if i, _ := dataRoster.Search(s.ServerIdentity().ID); i < 0 || !thresholdKeyID.IsNil() {
+			return false
+		}
+		return !s.collectiveKey(dataRoster).Equal(collectiveKey)
+	}
+
+	for _, e := range s.Survey.ToSlice() {
+		survey, err := s.getSurvey(SurveyID(e.Key().(string)))
+		if err != nil || survey.Status.ended() {
+			continue
+		}
+		if affected(&survey.Query.Roster, survey.Query.ThresholdKeyID) {
+			return fmt.Errorf("survey %s is encrypted under the collective key of another roster", e.Key())
+		}
+	}
+	for _, e := range s.Datasets.ToSlice() {
+		dataset, err := s.getDataset(DatasetID(e.Key().(string)))
+		if err != nil {
+			continue
+		}
+		if affected(&dataset.Query.Roster, dataset.Query.ThresholdKeyID) {
+			return fmt.Errorf("dataset %s is encrypted under the collective key of another roster", e.Key())
+		}
+	}
+	return nil
+}
+
+// surveysCipherTexts returns the ciphertexts of the surveys stored by the server that are re-encrypted by a key
+// rotation (see rotatedBy) and depend on the collective key (the responses of the data providers, the where attributes
+// of the query and the shuffling precomputations), a function to replace them and the chunks of responses spilled to
+// disk (re-encrypted one at a time).
+func (s *Service) surveysCipherTexts(tn *onet.TreeNodeInstance) (libunlynx.CipherVector, func(libunlynx.CipherVector) error, []protocolsunlynxutils.CipherTextsChunk) {
+	entries := s.Survey.ToSlice()
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		survey, err := s.getSurvey(SurveyID(e.Key().(string)))
+		if err == nil && s.rotatedBy(tn, &survey.Query.Roster, survey.Query.ThresholdKeyID) {
+			ids = append(ids, e.Key().(string))
+		}
+	}
+	sort.Strings(ids)
+
+	cv := libunlynx.CipherVector{}
+	var chunks []protocolsunlynxutils.CipherTextsChunk
+	lengths := make([]int, len(ids))
+	for i, id := range ids {
+		survey, err := s.getSurvey(SurveyID(id))
+		if err != nil {
+			continue
+		}
+		for j := 0; j < survey.DpChunks(); j++ {
+			store, index := survey.Store, j
+			chunks = append(chunks, protocolsunlynxutils.CipherTextsChunk{
+				Load: func() (libunlynx.CipherVector, error) {
+					return store.DpChunkCipherTexts(index)
+				},
+				Replace: func(rotated libunlynx.CipherVector) error {
+					return store.SetDpChunkCipherTexts(index, rotated)
+				},
+			})
+		}
+		cts, err := survey.CipherTexts()
+		if err != nil {
+			log.Error(s.ServerIdentity(), " could not read the ciphertexts of survey ", id, ": ", err)
+			continue
+		}
+		for _, w := range survey.Query.Where {
+			cts = append(cts, w.Value)
+		}
+		for _, p := range survey.ShufflePrecompute {
+			cts = append(cts, p.CipherV...)
+		}
+		lengths[i] = len(cts)
+		cv = append(cv, cts...)
+	}
+
+	setCipherTexts := func(rotated libunlynx.CipherVector) error {
+		if len(rotated) != len(cv) {
+			return fmt.Errorf("%d re-encrypted ciphertexts for %d ciphertexts", len(rotated), len(cv))
+		}
+		pos := 0
+		for i, id := range ids {
+			if lengths[i] == 0 {
+				continue
+			}
+			cts := rotated[pos : pos+lengths[i]]
+			pos += lengths[i]
+
+			err := s.updateSurvey(SurveyID(id), func(survey *Survey) error {
+				stored, err := survey.CipherTexts()
+				if err != nil {
+					return err
+				}
+				nbrStored := len(stored)
+				if err := survey.SetCipherTexts(cts[:nbrStored]); err != nil {
+					return err
+				}
+				cts = cts[nbrStored:]
+				for j := range survey.Query.Where {
+					survey.Query.Where[j].Value = cts[j]
+				}
+				cts = cts[len(survey.Query.Where):]
+				for j := range survey.ShufflePrecompute {
+					length := len(survey.ShufflePrecompute[j].CipherV)
+					survey.ShufflePrecompute[j].CipherV = append(libunlynx.CipherVector{}, cts[:length]...)
+					cts = cts[length:]
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return cv, setCipherTexts, chunks
+}
+
 // StartProtocol starts a specific protocol (Pipeline, Shuffling, etc.)
 func (s *Service) StartProtocol(name string, targetSurvey SurveyID) (onet.ProtocolInstance, error) {
 	survey, err := s.getSurvey(targetSurvey)
 	if err != nil {
 		return nil, err
 	}
-	tree := survey.Query.Roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity())
+	return s.startProtocol(name, &survey.Query.Roster, string(targetSurvey), nil)
+}
+
+// startProtocol starts a protocol on the given roster. The configuration data is given to all the protocol instances
+// and the root instance can be initialized before it starts.
+func (s *Service) startProtocol(name string, roster *onet.Roster, confData string, initRoot func(onet.ProtocolInstance)) (onet.ProtocolInstance, error) {
+	tree := roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity())
 
 	var tn *onet.TreeNodeInstance
 	tn = s.NewTreeNodeInstance(tree, tree.Root, name)
 
-	conf := onet.GenericConfig{Data: []byte(string(targetSurvey))}
+	conf := onet.GenericConfig{Data: []byte(confData)}
 
 	if err := tn.SetConfig(&conf); err != nil {
 		return nil, xerrors.Errorf("couldn't set config: %+v", err)
@@ -598,6 +1681,9 @@ func (s *Service) StartProtocol(name string, targetSurvey SurveyID) (onet.Protoc
 	if err != nil {
 		return nil, fmt.Errorf("error running "+name+" : %v", err)
 	}
+	if initRoot != nil {
+		initRoot(pi)
+	}
 
 	err = s.RegisterProtocolInstance(pi)
 	if err != nil {
@@ -606,12 +1692,12 @@ func (s *Service) StartProtocol(name string, targetSurvey SurveyID) (onet.Protoc
 
 	go func(pname string) {
 		if tmpErr := pi.Dispatch(); tmpErr != nil {
-			log.Error("Error running Dispatch ->" + name + " :" + err.Error())
+			log.Error("Error running Dispatch ->" + pname + " :" + tmpErr.Error())
 		}
 	}(name)
 	go func(pname string) {
 		if tmpErr := pi.Start(); tmpErr != nil {
-			log.Error("Error running Start ->" + name + " :" + err.Error())
+			log.Error("Error running Start ->" + pname + " :" + tmpErr.Error())
 		}
 	}(name)
 
@@ -623,6 +1709,31 @@ func (s *Service) StartProtocol(name string, targetSurvey SurveyID) (onet.Protoc
 
 // StartService starts the service (with all its different steps/protocols)
 func (s *Service) StartService(targetSurvey SurveyID, root bool) error {
+	err := s.startService(targetSurvey, root)
+
+	// the responses are processed, their spilled chunks are not needed anymore
+	if survey, errGet := s.getSurvey(targetSurvey); errGet == nil {
+		if errClose := survey.Close(); errClose != nil {
+			log.Error(errClose)
+		}
+	}
+
+	// a cancelled survey keeps its status
+	if survey, errGet := s.getSurvey(targetSurvey); errGet == nil && survey.cancelled() {
+		return fmt.Errorf("survey %s was cancelled: %s", targetSurvey, survey.Error)
+	}
+
+	status, reason := SurveyFinished, ""
+	if err != nil {
+		status, reason = SurveyFailed, err.Error()
+	}
+	if errStatus := s.setSurveyStatus(targetSurvey, status, reason); errStatus != nil && err == nil {
+		err = errStatus
+	}
+	return err
+}
+
+func (s *Service) startService(targetSurvey SurveyID, root bool) error {
 	log.Lvl1(s.ServerIdentity(), " is waiting on channel")
 
 	survey, err := s.getSurvey(targetSurvey)
@@ -633,9 +1744,17 @@ func (s *Service) StartService(targetSurvey SurveyID, root bool) error {
 	counter := survey.Query.MapDPs[s.ServerIdentity().String()]
 	for counter > int64(0) {
 		log.Lvl1(s.ServerIdentity(), " is waiting for ", counter, " data providers to send their data")
-		counter = counter - int64(<-survey.DpChannel)
+		select {
+		case nbr := <-survey.DpChannel:
+			counter = counter - int64(nbr)
+		case <-survey.Cancelled:
+			return fmt.Errorf("survey %s was cancelled", targetSurvey)
+		}
 	}
 	log.Lvl1("All data providers (", survey.Query.MapDPs[s.ServerIdentity().String()], ") for server ", s.ServerIdentity(), " have sent their data")
+	if err := s.setSurveyStatus(targetSurvey, SurveyProcessing, ""); err != nil {
+		return err
+	}
 
 	log.Lvl1(s.ServerIdentity(), " starts a UnLynx Protocol for survey ", targetSurvey)
 
@@ -645,6 +1764,9 @@ func (s *Service) StartService(targetSurvey SurveyID, root bool) error {
 	}
 
 	// Shuffling Phase
+	if err := s.enterPhase(targetSurvey, PhaseShuffling); err != nil {
+		return err
+	}
 	start := libunlynx.StartTimer(s.ServerIdentity().String() + "_ShufflingPhase")
 
 	err = s.ShufflingPhase(survey.Query.SurveyID)
@@ -654,6 +1776,9 @@ func (s *Service) StartService(targetSurvey SurveyID, root bool) error {
 
 	libunlynx.EndTimer(start)
 	// Tagging Phase
+	if err := s.enterPhase(targetSurvey, PhaseTagging); err != nil {
+		return err
+	}
 	start = libunlynx.StartTimer(s.ServerIdentity().String() + "_TaggingPhase")
 
 	err = s.TaggingPhase(target.Query.SurveyID)
@@ -672,6 +1797,9 @@ func (s *Service) StartService(targetSurvey SurveyID, root bool) error {
 
 	// Aggregation Phase
 	if root {
+		if err := s.enterPhase(targetSurvey, PhaseAggregation); err != nil {
+			return err
+		}
 		start := libunlynx.StartTimer(s.ServerIdentity().String() + "_AggregationPhase")
 
 		err = s.AggregationPhase(target.Query.SurveyID)
@@ -682,8 +1810,26 @@ func (s *Service) StartService(targetSurvey SurveyID, root bool) error {
 		libunlynx.EndTimer(start)
 	}
 
+	// Suppression Phase
+	if root && target.Query.MinCellSize > 0 {
+		if err := s.enterPhase(targetSurvey, PhaseSuppression); err != nil {
+			return err
+		}
+		start := libunlynx.StartTimer(s.ServerIdentity().String() + "_SuppressionPhase")
+
+		err := s.SuppressionPhase(target.Query.SurveyID)
+		if err != nil {
+			return fmt.Errorf("error in the Suppression Phase: %v", err)
+		}
+
+		libunlynx.EndTimer(start)
+	}
+
 	// DRO Phase
-	if root && libunlynx.DIFFPRI {
+	if root && target.Query.DiffPrivacy != nil {
+		if err := s.enterPhase(targetSurvey, PhaseDRO); err != nil {
+			return err
+		}
 		start := libunlynx.StartTimer(s.ServerIdentity().String() + "_DROPhase")
 
 		err := s.DROPhase(target.Query.SurveyID)
@@ -694,8 +1840,26 @@ func (s *Service) StartService(targetSurvey SurveyID, root bool) error {
 		libunlynx.EndTimer(start)
 	}
 
+	// Decryption Phase
+	if root && target.Query.PublicResults {
+		if err := s.enterPhase(targetSurvey, PhaseDecryption); err != nil {
+			return err
+		}
+		start := libunlynx.StartTimer(s.ServerIdentity().String() + "_DecryptionPhase")
+
+		err := s.DecryptionPhase(target.Query.SurveyID)
+		if err != nil {
+			return fmt.Errorf("error in the Decryption Phase: %v", err)
+		}
+
+		libunlynx.EndTimer(start)
+	}
+
 	// Key Switch Phase
-	if root {
+	if root && !target.Query.PublicResults {
+		if err := s.enterPhase(targetSurvey, PhaseKeySwitching); err != nil {
+			return err
+		}
 		start := libunlynx.StartTimer(s.ServerIdentity().String() + "_KeySwitchingPhase")
 
 		err := s.KeySwitchingPhase(target.Query.SurveyID)
@@ -709,88 +1873,132 @@ func (s *Service) StartService(targetSurvey SurveyID, root bool) error {
 	return nil
 }
 
-// ShufflingPhase performs the shuffling of the ClientResponses
+// ShufflingPhase performs the shuffling of the ClientResponses (batch by batch, in two passes, if they were spilled to
+// disk)
 func (s *Service) ShufflingPhase(targetSurvey SurveyID) error {
 	survey, err := s.getSurvey(targetSurvey)
 	if err != nil {
 		return err
 	}
 
-	if len(survey.DpResponses) == 0 && len(survey.DpResponsesAggr) == 0 {
+	if !survey.HasNextDpBatch() {
 		log.Lvl1(s.ServerIdentity(), " no data to shuffle")
 		return nil
 	}
 
+	for survey.HasNextDpBatch() {
+		if err := s.shuffleBatch(targetSurvey); err != nil {
+			return err
+		}
+	}
+
+	// the responses spilled to disk were only shuffled inside their batch, the batches are mixed and shuffled again
+	mixed := false
+	err = s.updateSurvey(targetSurvey, func(survey *Survey) error {
+		mixed, err = survey.MixShuffledBatches()
+		return err
+	})
+	if err != nil || !mixed {
+		return err
+	}
+	for survey.HasNextDpBatch() {
+		if err := s.shuffleBatch(targetSurvey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shuffleBatch shuffles the next batch of ClientResponses
+func (s *Service) shuffleBatch(targetSurvey SurveyID) error {
 	pi, err := s.StartProtocol(protocolsunlynx.ShufflingProtocolName, targetSurvey)
 	if err != nil {
 		return err
 	}
 
+	survey, err := s.getSurvey(targetSurvey)
+	if err != nil {
+		return err
+	}
+
 	var tmpShufflingResult []libunlynx.CipherVector
 	select {
 	case tmpShufflingResult = <-pi.(*protocolsunlynx.ShufflingProtocol).FeedbackChannel:
+	case <-survey.Cancelled:
+		return fmt.Errorf("survey %s was cancelled", targetSurvey)
 	case <-time.After(libunlynx.TIMEOUT):
 		return fmt.Errorf(s.ServerIdentity().String() + " didn't get the <tmpShufflingResult> on time")
 	}
 
-	survey, err = s.getSurvey(targetSurvey)
-	if err != nil {
-		return err
-	}
-	shufflingResult := protocolsunlynx.MatrixCipherTextToProcessResponse(tmpShufflingResult, survey.Lengths)
-
-	survey.PushShuffledProcessResponses(shufflingResult)
-	err = s.putSurvey(targetSurvey, survey)
-	return err
+	return s.updateSurvey(targetSurvey, func(survey *Survey) error {
+		shufflingResult := protocolsunlynx.MatrixCipherTextToProcessResponse(tmpShufflingResult, survey.Lengths)
+		return survey.PushShuffledProcessResponses(shufflingResult)
+	})
 }
 
-// TaggingPhase performs the private grouping on the currently collected data.
+// TaggingPhase performs the private grouping on the currently collected data (batch by batch if it was spilled to
+// disk).
 func (s *Service) TaggingPhase(targetSurvey SurveyID) error {
 	survey, err := s.getSurvey(targetSurvey)
 	if err != nil {
 		return err
 	}
 
-	if len(survey.ShuffledProcessResponses) == 0 {
+	if !survey.HasNextShuffledBatch() {
 		log.Lvl1(s.ServerIdentity(), "  for survey ", survey.Query.SurveyID, " has no data to det tag")
 		return nil
 	}
 
+	for survey.HasNextShuffledBatch() {
+		if err := s.tagBatch(targetSurvey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagBatch tags, filters and locally aggregates the next batch of shuffled responses. The where attributes of the query
+// are tagged with each batch.
+func (s *Service) tagBatch(targetSurvey SurveyID) error {
 	pi, err := s.StartProtocol(protocolsunlynx.DeterministicTaggingProtocolName, targetSurvey)
 	if err != nil {
 		return err
 	}
 
+	survey, err := s.getSurvey(targetSurvey)
+	if err != nil {
+		return err
+	}
+
 	var tmpDeterministicTaggingResult []libunlynx.DeterministCipherText
 	select {
 	case tmpDeterministicTaggingResult = <-pi.(*protocolsunlynx.DeterministicTaggingProtocol).FeedbackChannel:
+	case <-survey.Cancelled:
+		return fmt.Errorf("survey %s was cancelled", targetSurvey)
 	case <-time.After(libunlynx.TIMEOUT):
 		return fmt.Errorf(s.ServerIdentity().String() + " didn't get the <tmpDeterministicTaggingResult> on time")
 	}
 
-	survey, err = s.getSurvey(targetSurvey)
-	if err != nil {
-		return err
-	}
-	deterministicTaggingResult := protocolsunlynx.DeterCipherVectorToProcessResponseDet(tmpDeterministicTaggingResult, survey.TargetOfSwitch)
+	return s.updateSurvey(targetSurvey, func(survey *Survey) error {
+		deterministicTaggingResult := protocolsunlynx.DeterCipherVectorToProcessResponseDet(tmpDeterministicTaggingResult, survey.TargetOfSwitch)
 
-	var queryWhereTag []libunlynx.WhereQueryAttributeTagged
-	for i, v := range deterministicTaggingResult[:len(survey.Query.Where)] {
-		newElem := libunlynx.WhereQueryAttributeTagged{Name: survey.Query.Where[i].Name, Value: v.DetTagWhere[0]}
-		queryWhereTag = append(queryWhereTag, newElem)
-	}
-	deterministicTaggingResult = deterministicTaggingResult[len(survey.Query.Where):]
+		var queryWhereTag []libunlynx.WhereQueryAttributeTagged
+		for i, v := range deterministicTaggingResult[:len(survey.Query.Where)] {
+			newElem := libunlynx.WhereQueryAttributeTagged{Name: survey.Query.Where[i].Name, Value: v.DetTagWhere[0]}
+			queryWhereTag = append(queryWhereTag, newElem)
+		}
+		deterministicTaggingResult = deterministicTaggingResult[len(survey.Query.Where):]
 
-	var filteredResponses []libunlynx.FilteredResponseDet
-	if survey.Query.Predicate == "" || len(queryWhereTag) == 0 {
-		filteredResponses = FilterNone(deterministicTaggingResult)
-	} else {
-		filteredResponses = FilterResponses(survey.Query.Predicate, queryWhereTag, deterministicTaggingResult)
-	}
+		var filteredResponses []libunlynx.FilteredResponseDet
+		if survey.Filter == nil || len(queryWhereTag) == 0 {
+			filteredResponses = FilterNone(deterministicTaggingResult)
+		} else {
+			filteredResponses = filterResponses(survey.Filter, queryWhereTag, deterministicTaggingResult)
+		}
 
-	survey.PushDeterministicFilteredResponses(filteredResponses, s.ServerIdentity().String(), survey.Query.Proofs)
-	err = s.putSurvey(targetSurvey, survey)
-	return err
+		survey.PushDeterministicFilteredResponses(filteredResponses, s.ServerIdentity().String(), survey.Query.Proofs)
+		return nil
+	})
 }
 
 // AggregationPhase performs the per-group aggregation on the currently grouped data.
@@ -800,24 +2008,141 @@ func (s *Service) AggregationPhase(targetSurvey SurveyID) error {
 		return err
 	}
 
+	survey, err := s.getSurvey(targetSurvey)
+	if err != nil {
+		return err
+	}
+
 	var tmpAggreagtionResult protocolsunlynx.CothorityAggregatedData
 	select {
 	case tmpAggreagtionResult = <-pi.(*protocolsunlynx.CollectiveAggregationProtocol).FeedbackChannel:
+	case <-survey.Cancelled:
+		return fmt.Errorf("survey %s was cancelled", targetSurvey)
 	case <-time.After(libunlynx.TIMEOUT):
 		return fmt.Errorf(s.ServerIdentity().String() + " didn't get the <tmpAggreagtionResult> on time")
 	}
 
+	return s.updateSurvey(targetSurvey, func(survey *Survey) error {
+		if len(survey.Query.LinearCombinations) > 0 {
+			proofs, err := applyLinearCombinations(tmpAggreagtionResult.GroupedData, survey.Query)
+			if err != nil {
+				return err
+			}
+			// the proofs are published with the results, which are linked to them if they are public
+			if survey.Query.Proofs {
+				if survey.LinearCombinationProofs, err = proofs.ToBytes(); err != nil {
+					return err
+				}
+			}
+		}
+
+		survey.PushCothorityAggregatedFilteredResponses(tmpAggreagtionResult.GroupedData)
+		return nil
+	})
+}
+
+// SuppressionPhase suppresses the groups of the aggregated data with fewer responses than the minimum cell size of the
+// survey, or merges them in an "other" group. The servers only learn which groups are below the minimum. It runs before
+// the DRO phase: the exact counts are tested (see SurveyCreationQuery.MinCellSize).
+func (s *Service) SuppressionPhase(targetSurvey SurveyID) error {
 	survey, err := s.getSurvey(targetSurvey)
 	if err != nil {
 		return err
 	}
 
-	survey.PushCothorityAggregatedFilteredResponses(tmpAggreagtionResult.GroupedData)
-	err = s.putSurvey(targetSurvey, survey)
-	return err
+	grouped := survey.GroupedDeterministicFilteredResponses
+	keys := make([]libunlynx.GroupingKey, 0, len(grouped))
+	for k := range grouped {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	if len(keys) == 0 {
+		log.Lvl1(s.ServerIdentity(), " no group to test against the minimum cell size")
+		return nil
+	}
+
+	groups := make([]libunlynx.FilteredResponse, len(keys))
+	for i, k := range keys {
+		groups[i] = grouped[k]
+	}
+	below, err := s.belowMinCellSize(targetSurvey, groups)
+	if err != nil {
+		return err
+	}
+
+	var other *libunlynx.FilteredResponse
+	var suppressed []libunlynx.GroupingKey
+	for i, k := range keys {
+		if !below[i] {
+			continue
+		}
+		if survey.Query.MergeSmallCells {
+			if other == nil {
+				merged := libunlynx.NewFilteredResponse(0, len(groups[i].AggregatingAttributes))
+				other = &merged
+			}
+			other.AggregatingAttributes.Add(other.AggregatingAttributes, groups[i].AggregatingAttributes)
+		}
+		suppressed = append(suppressed, k)
+	}
+	log.Lvl1(s.ServerIdentity(), " suppressed ", len(suppressed), " groups below the minimum cell size")
+
+	if other != nil {
+		below, err := s.belowMinCellSize(targetSurvey, []libunlynx.FilteredResponse{*other})
+		if err != nil {
+			return err
+		}
+		if below[0] {
+			other = nil
+		}
+	}
+
+	return s.updateSurvey(targetSurvey, func(survey *Survey) error {
+		for _, k := range suppressed {
+			delete(survey.GroupedDeterministicFilteredResponses, k)
+		}
+		if other != nil {
+			survey.GroupedDeterministicFilteredResponses[otherGroup] = *other
+		}
+		return nil
+	})
+}
+
+// belowMinCellSize runs the cell size protocol on the counts of the groups and returns which ones are below the minimum
+// cell size of the survey
+func (s *Service) belowMinCellSize(targetSurvey SurveyID, groups []libunlynx.FilteredResponse) ([]bool, error) {
+	survey, err := s.getSurvey(targetSurvey)
+	if err != nil {
+		return nil, err
+	}
+
+	index := countIndex(survey.Query.Sum)
+	counts := make(libunlynx.CipherVector, len(groups))
+	for i, g := range groups {
+		counts[i] = g.AggregatingAttributes[index]
+	}
+	pi, err := s.startProtocol(protocolsunlynx.CellSizeProtocolName, &survey.Query.Roster, string(targetSurvey), func(pi onet.ProtocolInstance) {
+		pi.(*protocolsunlynx.CellSizeProtocol).TargetOfTest = &counts
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var below []bool
+	select {
+	case below = <-pi.(*protocolsunlynx.CellSizeProtocol).FeedbackChannel:
+	case <-survey.Cancelled:
+		return nil, fmt.Errorf("survey %s was cancelled", targetSurvey)
+	case <-time.After(libunlynx.TIMEOUT):
+		return nil, fmt.Errorf(s.ServerIdentity().String() + " didn't get the <tmpCellSizeResult> on time")
+	}
+	if len(below) != len(groups) {
+		return nil, fmt.Errorf("%d cell size tests for %d groups", len(below), len(groups))
+	}
+	return below, nil
 }
 
-// DROPhase shuffles the list of noise values.
+// DROPhase shuffles the lists of noise values.
 func (s *Service) DROPhase(targetSurvey SurveyID) error {
 	pi, err := s.StartProtocol(protocolsunlynx.DROProtocolName, targetSurvey)
 	if err != nil {
@@ -832,15 +2157,21 @@ func (s *Service) DROPhase(targetSurvey SurveyID) error {
 	var tmpShufflingResult []libunlynx.CipherVector
 	select {
 	case tmpShufflingResult = <-pi.(*protocolsunlynx.ShufflingProtocol).FeedbackChannel:
+	case <-survey.Cancelled:
+		return fmt.Errorf("survey %s was cancelled", targetSurvey)
 	case <-time.After(libunlynx.TIMEOUT):
 		return fmt.Errorf(s.ServerIdentity().String() + " didn't get the <tmpShufflingResult> on time")
 	}
 
-	shufflingResult := protocolsunlynx.MatrixCipherTextToProcessResponse(tmpShufflingResult, survey.Lengths)
+	return s.updateSurvey(targetSurvey, func(survey *Survey) error {
+		shufflingResult := protocolsunlynx.MatrixCipherTextToProcessResponse(tmpShufflingResult, survey.Lengths)
 
-	survey.Noise = shufflingResult[0].AggregatingAttributes[0]
-	err = s.putSurvey(targetSurvey, survey)
-	return err
+		survey.Noise = make([]libunlynx.CipherVector, len(shufflingResult))
+		for i, r := range shufflingResult {
+			survey.Noise[i] = r.AggregatingAttributes
+		}
+		return nil
+	})
 }
 
 // KeySwitchingPhase performs the switch to the querier's key on the currently aggregated data.
@@ -858,48 +2189,106 @@ func (s *Service) KeySwitchingPhase(targetSurvey SurveyID) error {
 	var tmpKeySwitchingResult libunlynx.CipherVector
 	select {
 	case tmpKeySwitchingResult = <-pi.(*protocolsunlynx.KeySwitchingProtocol).FeedbackChannel:
+	case <-survey.Cancelled:
+		return fmt.Errorf("survey %s was cancelled", targetSurvey)
 	case <-time.After(libunlynx.TIMEOUT):
 		return fmt.Errorf(s.ServerIdentity().String() + " didn't get the <tmpKeySwitchingResult> on time")
 	}
 
-	keySwitchedAggregatedResponses := protocolsunlynx.CipherVectorToFilteredResponse(tmpKeySwitchingResult, survey.Lengths)
+	return s.updateSurvey(targetSurvey, func(survey *Survey) error {
+		keySwitchedAggregatedResponses := protocolsunlynx.CipherVectorToFilteredResponse(tmpKeySwitchingResult, survey.Lengths)
+		survey.PushQuerierKeyEncryptedResponses(keySwitchedAggregatedResponses)
+		return nil
+	})
+}
 
-	survey.PushQuerierKeyEncryptedResponses(keySwitchedAggregatedResponses)
-	err = s.putSurvey(targetSurvey, survey)
-	return err
+// DecryptionPhase collectively decrypts the currently aggregated data with proofs. The results are kept encrypted under
+// the collective key, along with the proofs, such that anyone can verify and decrypt them.
+func (s *Service) DecryptionPhase(targetSurvey SurveyID) error {
+	pi, err := s.StartProtocol(protocolsunlynx.CollectiveDecryptionProtocolName, targetSurvey)
+	if err != nil {
+		return err
+	}
+	decryption := pi.(*protocolsunlynx.CollectiveDecryptionProtocol)
+
+	survey, err := s.getSurvey(targetSurvey)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-decryption.FeedbackChannel:
+	case <-survey.Cancelled:
+		return fmt.Errorf("survey %s was cancelled", targetSurvey)
+	case <-time.After(libunlynx.TIMEOUT):
+		return fmt.Errorf(s.ServerIdentity().String() + " didn't get the <tmpDecryptionResult> on time")
+	}
+
+	return s.updateSurvey(targetSurvey, func(survey *Survey) error {
+		var err error
+		survey.DecryptionProofs, err = decryption.DecryptionProofs.ToBytes()
+		if err != nil {
+			return err
+		}
+		survey.PushQuerierKeyEncryptedResponses(protocolsunlynx.CipherVectorToFilteredResponse(*decryption.TargetOfDecryption, survey.Lengths))
+		return nil
+	})
 }
 
 // Support Functions
 //______________________________________________________________________________________________________________________
 
-// FilterResponses evaluates the predicate and keeps the entries that satisfy the conditions
+// FilterResponses parses the predicate for the where attributes of the query and keeps the entries that satisfy it (no
+// entry if the predicate is wrong). The surveys compile their predicate once (see Survey.Filter).
 func FilterResponses(pred string, whereQueryValues []libunlynx.WhereQueryAttributeTagged, responsesToFilter []libunlynx.ProcessResponseDet) []libunlynx.FilteredResponseDet {
+	where := make([]string, len(whereQueryValues))
+	for i, w := range whereQueryValues {
+		where[i] = w.Name
+	}
+	predicate, err := libunlynxpredicate.Parse(pred, where)
+	if err != nil {
+		log.Error("wrong predicate: ", err)
+		return nil
+	}
+	filter, err := libunlynxpredicate.Compile(predicate, where)
+	if err != nil {
+		log.Error("wrong predicate: ", err)
+		return nil
+	}
+	return filterResponses(filter, whereQueryValues, responsesToFilter)
+}
+
+// filterResponses keeps the entries whose where attributes satisfy the compiled predicate of the query
+func filterResponses(filter *libunlynxpredicate.Filter, whereQueryValues []libunlynx.WhereQueryAttributeTagged, responsesToFilter []libunlynx.ProcessResponseDet) []libunlynx.FilteredResponseDet {
+	query := make([]libunlynx.GroupingKey, len(whereQueryValues))
+	for i, w := range whereQueryValues {
+		query[i] = w.Value
+	}
 	var result []libunlynx.FilteredResponseDet
 	for _, v := range responsesToFilter {
-		expression, err := govaluate.NewEvaluableExpression(pred)
-		if err != nil {
-			return result
-		}
-		parameters := make(map[string]interface{}, len(whereQueryValues)+len(responsesToFilter[0].DetTagWhere))
-		counter := 0
-		for i := 0; i < len(whereQueryValues)+len(responsesToFilter[0].DetTagWhere); i++ {
-
-			if i%2 == 0 {
-				parameters["v"+strconv.Itoa(i)] = string(whereQueryValues[counter].Value)
-			} else {
-				parameters["v"+strconv.Itoa(i)] = string(v.DetTagWhere[counter])
-				counter++
-			}
-
-		}
-		keep, err := expression.Evaluate(parameters)
-		if keep.(bool) {
+		if filter.Keep(query, v.DetTagWhere) {
 			result = append(result, libunlynx.FilteredResponseDet{DetTagGroupBy: v.DetTagGroupBy, Fr: libunlynx.FilteredResponse{GroupByEnc: v.PR.GroupByEnc, AggregatingAttributes: v.PR.AggregatingAttributes}})
 		}
 	}
 	return result
 }
 
+// compilePredicate parses and compiles the predicate of a query for its where attributes (nil if it has no predicate)
+func compilePredicate(query SurveyCreationQuery) (*libunlynxpredicate.Filter, error) {
+	if query.Predicate == "" {
+		return nil, nil
+	}
+	where := make([]string, len(query.Where))
+	for i, w := range query.Where {
+		where[i] = w.Name
+	}
+	predicate, err := libunlynxpredicate.Parse(query.Predicate, where)
+	if err != nil {
+		return nil, err
+	}
+	return libunlynxpredicate.Compile(predicate, where)
+}
+
 // FilterNone skips the filtering of attributes when there is no predicate (the number of where attributes == 0)
 func FilterNone(responsesToFilter []libunlynx.ProcessResponseDet) []libunlynx.FilteredResponseDet {
 	var result []libunlynx.FilteredResponseDet
@@ -909,6 +2298,278 @@ func FilterNone(responsesToFilter []libunlynx.ProcessResponseDet) []libunlynx.Fi
 	return result
 }
 
+// checkSuite verifies that the survey data is encrypted in the suite of this server (the default suite if none is given)
+func checkSuite(suite string) error {
+	if suite == "" {
+		suite = libunlynx.DefaultSuite
+	}
+	if suite != libunlynx.SuiTe.String() {
+		return fmt.Errorf("survey suite %s differs from server suite %s", suite, libunlynx.SuiTe.String())
+	}
+	return nil
+}
+
+// applySchema validates the schema of a query and the query against it, and takes the fixed-point scales of the sum
+// attributes from the schema if the query does not set them
+func applySchema(query *SurveyCreationQuery) error {
+	if err := query.Schema.Validate(); err != nil {
+		return err
+	}
+	if len(query.FixedPoint) == 0 {
+		query.FixedPoint = query.Schema.FixedPoint(query.Sum)
+	}
+	return query.Schema.ValidateQuery(query.Sum, query.Count, query.Where, query.GroupBy, query.FixedPoint)
+}
+
+// checkLinearCombinations verifies that the linear combinations have new names and only use sum attributes
+func checkLinearCombinations(sum []string, lcs []LinearCombination) error {
+	names := make(map[string]bool, len(sum)+len(lcs))
+	for _, v := range sum {
+		names[v] = true
+	}
+	for _, lc := range lcs {
+		if lc.Name == "" || names[lc.Name] {
+			return fmt.Errorf("invalid or duplicate name for linear combination: %q", lc.Name)
+		}
+		for attr := range lc.Weights {
+			found := false
+			for _, v := range sum {
+				found = found || v == attr
+			}
+			if !found {
+				return fmt.Errorf("linear combination %s uses %s which is not a sum attribute", lc.Name, attr)
+			}
+		}
+		names[lc.Name] = true
+	}
+	return nil
+}
+
+// applyLinearCombinations appends the linear combinations to the aggregating attributes of each group and returns the
+// proofs of these computations (if the survey uses proofs)
+func applyLinearCombinations(groupedData map[libunlynx.GroupingKey]libunlynx.FilteredResponse, query SurveyCreationQuery) (libunlynxaggr.PublishedLinearCombinationListProof, error) {
+	coefficients := make([][]int64, len(query.LinearCombinations))
+	for k, lc := range query.LinearCombinations {
+		coefficients[k] = make([]int64, len(query.Sum))
+		for i, attr := range query.Sum {
+			coefficients[k][i] = lc.Weights[attr]
+		}
+	}
+
+	proofs := libunlynxaggr.PublishedLinearCombinationListProof{}
+	for key, fr := range groupedData {
+		combinations := make(libunlynx.CipherVector, len(coefficients))
+		for k := range coefficients {
+			var err error
+			combinations[k], err = fr.AggregatingAttributes.DotClear(coefficients[k])
+			if err != nil {
+				return libunlynxaggr.PublishedLinearCombinationListProof{}, err
+			}
+			if query.Proofs {
+				proofs.List = append(proofs.List, libunlynxaggr.DotClearProofCreation(fr.AggregatingAttributes, coefficients[k], combinations[k]))
+			}
+		}
+		fr.AggregatingAttributes = append(fr.AggregatingAttributes, combinations...)
+		groupedData[key] = fr
+	}
+	return proofs, nil
+}
+
+// resultAttributes returns the names of the aggregating attributes of the results of a survey
+func resultAttributes(query SurveyCreationQuery) []string {
+	attributes := append([]string{}, query.Sum...)
+	for _, lc := range query.LinearCombinations {
+		attributes = append(attributes, lc.Name)
+	}
+	return attributes
+}
+
+// checkRanges verifies that the ranges are valid and declared for aggregating attributes
+func checkRanges(sum []string, ranges map[string]*libunlynxrange.Bounds) error {
+	for name, bounds := range ranges {
+		if bounds == nil {
+			return fmt.Errorf("no bounds for attribute %s", name)
+		}
+		if err := bounds.Validate(); err != nil {
+			return fmt.Errorf("attribute %s: %v", name, err)
+		}
+		found := false
+		for _, v := range sum {
+			found = found || v == name
+		}
+		if !found {
+			return fmt.Errorf("range attribute %s is not an aggregating attribute", name)
+		}
+	}
+	return nil
+}
+
+// checkRangeProofs verifies that the aggregating attributes of the responses lie in their declared ranges
+func checkRangeProofs(responses []libunlynx.DpResponseToSend, drs []libunlynx.DpResponse, ranges map[string]*libunlynxrange.Bounds, pubKey kyber.Point) error {
+	prps := make([]libunlynxrange.PublishedRangeProof, 0)
+	cts := make([]libunlynx.CipherText, 0)
+	bounds := make([]libunlynxrange.Bounds, 0)
+	totals := libunlynxstatistics.HistogramTotals(ranges)
+	for i, dr := range drs {
+		for name, b := range ranges {
+			if v, ok := dr.AggregatingAttributesClear[name]; ok {
+				if !b.Contains(v) {
+					return fmt.Errorf("response %d: attribute %s is not in the range [%d, %d]", i, name, b.Min, b.Max)
+				}
+				continue
+			}
+			// a data provider could otherwise escape the range check by leaving the attribute out
+			ct, ok := dr.AggregatingAttributesEnc[name]
+			if !ok {
+				return fmt.Errorf("response %d: no value for the range attribute %s", i, name)
+			}
+			data, ok := responses[i].AggregatingAttributesRangeProofs[name]
+			if !ok {
+				return fmt.Errorf("response %d: no range proof for attribute %s", i, name)
+			}
+			prp := libunlynxrange.PublishedRangeProof{}
+			if err := prp.FromBytes(data, *b); err != nil {
+				return fmt.Errorf("response %d: attribute %s: %v", i, name, err)
+			}
+			prps, cts, bounds = append(prps, prp), append(cts, ct), append(bounds, *b)
+		}
+
+		// exactly one of the histogram bins of an attribute is 1
+		for attribute, bins := range totals {
+			prp, ct, err := checkHistogramTotal(responses[i], dr, attribute, bins)
+			if err != nil {
+				return fmt.Errorf("response %d: %v", i, err)
+			}
+			if prp != nil {
+				prps, cts, bounds = append(prps, *prp), append(cts, ct), append(bounds, libunlynxrange.Bounds{Min: 1, Max: 1})
+			}
+		}
+	}
+
+	if !libunlynxrange.RangeProofListVerification(prps, cts, bounds, pubKey) {
+		return fmt.Errorf("range proofs verification failed: an aggregating attribute is not in its declared range")
+	}
+	return nil
+}
+
+// checkHistogramTotal checks that the histogram bins of an attribute in a response sum to 1: directly if they are in
+// clear, otherwise it returns the range proof of [1, 1] of their homomorphic sum and this sum
+func checkHistogramTotal(response libunlynx.DpResponseToSend, dr libunlynx.DpResponse, attribute string, bins []string) (*libunlynxrange.PublishedRangeProof, libunlynx.CipherText, error) {
+	total, sum := *libunlynx.NewCipherText(), int64(0)
+	nbrClear := 0
+	for _, bin := range bins {
+		if v, ok := dr.AggregatingAttributesClear[bin]; ok {
+			sum += v
+			nbrClear++
+			continue
+		}
+		total.Add(total, dr.AggregatingAttributesEnc[bin])
+	}
+
+	switch nbrClear {
+	case len(bins):
+		if sum != 1 {
+			return nil, libunlynx.CipherText{}, fmt.Errorf("the histogram bins of %s sum to %d instead of 1", attribute, sum)
+		}
+		return nil, libunlynx.CipherText{}, nil
+	case 0:
+		data, ok := response.AggregatingAttributesRangeProofs[libunlynx.HistogramTotalName(attribute)]
+		if !ok {
+			return nil, libunlynx.CipherText{}, fmt.Errorf("no proof that the histogram bins of %s sum to 1", attribute)
+		}
+		prp := libunlynxrange.PublishedRangeProof{}
+		if err := prp.FromBytes(data, libunlynxrange.Bounds{Min: 1, Max: 1}); err != nil {
+			return nil, libunlynx.CipherText{}, fmt.Errorf("histogram of %s: %v", attribute, err)
+		}
+		return &prp, total, nil
+	default:
+		return nil, libunlynx.CipherText{}, fmt.Errorf("the histogram bins of %s are partly in clear", attribute)
+	}
+}
+
+// checkHistogramRanges verifies that a survey summing some histogram bins of an attribute of its schema sums all of them
+// with the range [0, 1], so that the data providers prove that their bins are the one-hot encoding of a value
+func checkHistogramRanges(query SurveyCreationQuery) error {
+	summed := make(map[string]bool, len(query.Sum))
+	for _, name := range query.Sum {
+		summed[name] = true
+	}
+	checked := make(map[string]bool)
+	for _, name := range query.Sum {
+		attribute, _, ok := libunlynx.SplitHistogramBinName(name)
+		a := query.Schema.Attribute(attribute)
+		if !ok || checked[attribute] || a == nil || !a.Histogram {
+			continue
+		}
+		for _, bin := range a.Domain.HistogramBins(a.Name) {
+			if b, ok := query.Ranges[bin]; !summed[bin] || !ok || *b != (libunlynxrange.Bounds{Min: 0, Max: 1}) {
+				return fmt.Errorf("the survey must sum all the histogram bins of %s with the range [0, 1]", attribute)
+			}
+		}
+		checked[attribute] = true
+	}
+	return nil
+}
+
+// checkFixedPoint verifies that the fixed-point attributes are valid sum attributes
+func checkFixedPoint(sum []string, fixedPoint libunlynx.FixedPointScales) error {
+	if err := fixedPoint.Validate(); err != nil {
+		return err
+	}
+	for name := range fixedPoint {
+		found := false
+		for _, v := range sum {
+			if v == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("fixed-point attribute %s is not a sum attribute", name)
+		}
+	}
+	return nil
+}
+
+// otherGroup is the key of the group merging the groups below the minimum cell size of a survey
+const otherGroup libunlynx.GroupingKey = "other"
+
+// countIndex returns the index of the count attribute in the aggregating attributes of the responses (-1 if it is not
+// a sum attribute)
+func countIndex(sum []string) int {
+	for i, name := range sum {
+		if name == libunlynx.CountAttribute {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkMinCellSize verifies that the minimum cell size of a query can be tested on the count attribute
+func checkMinCellSize(query SurveyCreationQuery) error {
+	if query.MinCellSize < 0 {
+		return fmt.Errorf("negative minimum cell size: %d", query.MinCellSize)
+	}
+	if query.MinCellSize == 0 {
+		if query.MergeSmallCells {
+			return fmt.Errorf("merging the small cells needs a minimum cell size")
+		}
+		return nil
+	}
+	if query.MinCellSize > libunlynxcellsize.MaxMinCellSize {
+		return fmt.Errorf("minimum cell size %d is larger than %d", query.MinCellSize, libunlynxcellsize.MaxMinCellSize)
+	}
+	if !query.Count || countIndex(query.Sum) < 0 {
+		return fmt.Errorf("a minimum cell size needs a count query with the %s attribute", libunlynx.CountAttribute)
+	}
+	return nil
+}
+
+// shuffleLineSize returns the maximum number of ciphertexts of a response to shuffle
+func shuffleLineSize(query SurveyCreationQuery) int {
+	return len(query.Sum) + len(query.Where) + len(query.GroupBy) + 1 // + 1 is for the possible count attribute
+}
+
 // CountDPs counts the number of data providers targeted by a query/survey
 func CountDPs(m map[string]int64) int64 {
 	result := int64(0)
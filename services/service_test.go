@@ -1,17 +1,34 @@
 package servicesunlynx_test
 
 import (
+	"fmt"
+	"github.com/fanliao/go-concurrentMap"
+	"github.com/ldsec/unlynx/data"
 	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/predicate"
+	"github.com/ldsec/unlynx/lib/range"
+	"github.com/ldsec/unlynx/lib/statistics"
+	"github.com/ldsec/unlynx/lib/tools"
 	"github.com/ldsec/unlynx/services"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.etcd.io/bbolt"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // numberGrpAttr is the number of group attributes.
@@ -1076,6 +1093,43 @@ func TestConcurrentSurveys(t *testing.T) {
 	wg.Wait()
 }
 
+func TestConcurrentResponses(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	// the data providers of the first server push their responses at the same time
+	numberDPs := 8
+	nbrDPs := map[string]int64{el.List[0].String(): int64(numberDPs), el.List[1].String(): 0, el.List[2].String(): 0}
+	surveyID, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: proofsService, Sum: []string{"s1"}, GroupBy: []string{"g1"}})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numberDPs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dataHolder := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(i+1))
+			responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": int64(i)}}}
+			assert.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+		}(i)
+	}
+	wg.Wait()
+
+	statuses, err := client.GetSurveyStatus(*surveyID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(numberDPs), statuses[0].DpReceived)
+
+	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]int64{{1}}, *grp)
+	assert.Equal(t, [][]int64{{0 + 1 + 2 + 3 + 4 + 5 + 6 + 7}}, *aggr)
+}
+
 func TestFilteringFunc(t *testing.T) {
 	predicate := "(v0 == v1 && v2 == v3) && v4 == v5"
 	whereQueryValues := []libunlynx.WhereQueryAttributeTagged{{Name: "age", Value: libunlynx.GroupingKey("1")}, {Name: "salary", Value: libunlynx.GroupingKey("1")}, {Name: "joao", Value: libunlynx.GroupingKey("1")}}
@@ -1085,3 +1139,1734 @@ func TestFilteringFunc(t *testing.T) {
 	log.Lvl1(whereQueryValues)
 	log.Lvl1(servicesunlynx.FilterResponses(predicate, whereQueryValues, responsesToFilter))
 }
+
+//______________________________________________________________________________________________________________________
+/// Fixed-point sum attributes
+func TestServiceFixedPoint(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+
+	scales := libunlynx.FixedPointScales{"s1": 2}
+	surveyID, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{
+		Roster:     *el,
+		MapDPs:     nbrDPs,
+		Proofs:     proofsService,
+		Sum:        []string{"s1", "s2"},
+		GroupBy:    []string{"g1"},
+		FixedPoint: scales,
+	})
+	if err != nil {
+		t.Fatal("Service did not start.", err)
+	}
+
+	// a fixed-point attribute must be a sum attribute
+	_, err = client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1"}, FixedPoint: libunlynx.FixedPointScales{"s3": 1}})
+	assert.Error(t, err)
+
+	// the servers refuse a survey encrypted in another suite
+	_, err = client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1"}, Suite: "P256"})
+	assert.Error(t, err)
+
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		aggr, err := scales.Encode(map[string]float64{"s1": 1234.56, "s2": 2})
+		assert.NoError(t, err)
+		responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: aggr}}
+		err = dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false)
+		assert.NoError(t, err)
+	}
+
+	grp, aggr, err := client.SendSurveyResultsQueryFixedPoint(*surveyID, 10000000)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int64{{1}}, *grp)
+	assert.Equal(t, 1, len(*aggr))
+	assert.InDelta(t, 3703.68, (*aggr)[0][0], 1e-9)
+	assert.InDelta(t, 6, (*aggr)[0][1], 1e-9)
+}
+
+func TestServiceThresholdKey(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	servers, el, _ := local.GenTree(5, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	_, err := client.SendDKGQuery(el, 6)
+	assert.Error(t, err)
+	thresholdKey, err := client.SendDKGQuery(el, 3)
+	require.NoError(t, err)
+	// the distributed key of a roster is not replaced
+	_, err = servicesunlynx.NewUnLynxClient(el.List[1], strconv.Itoa(0)).SendDKGQuery(el, 3)
+	assert.Error(t, err)
+
+	// the survey is run by 3 of the 5 servers, the others are offline
+	for _, i := range []int{1, 4} {
+		require.NoError(t, servers[i].Close())
+		delete(local.Servers, servers[i].ServerIdentity.ID)
+	}
+	servers = []*onet.Server{servers[0], servers[2], servers[3]}
+	subRoster := onet.NewRoster([]*network.ServerIdentity{el.List[0], el.List[2], el.List[3]})
+	nbrDPs := make(map[string]int64)
+	for _, server := range subRoster.List {
+		nbrDPs[server.String()] = 1
+	}
+	scq := servicesunlynx.SurveyCreationQuery{
+		Roster:         *subRoster,
+		MapDPs:         nbrDPs,
+		Proofs:         proofsService,
+		Sum:            []string{"s1"},
+		GroupBy:        []string{"g1"},
+		ThresholdKeyID: el.ID,
+	}
+
+	// not enough servers to use the distributed key
+	tooSmall := scq
+	tooSmall.Roster = *onet.NewRoster(subRoster.List[:2])
+	_, err = client.SendSurveyCreation(tooSmall)
+	assert.Error(t, err)
+
+	// a server without its share of the distributed key refuses the survey
+	unlynx := local.GetServices(servers, onet.ServiceFactory.ServiceID(servicesunlynx.ServiceName))[1].(*servicesunlynx.Service)
+	keyShares := unlynx.ThresholdKeys
+	unlynx.ThresholdKeys = concurrent.NewConcurrentMap()
+	_, err = client.SendSurveyCreation(scq)
+	assert.Error(t, err)
+	unlynx.ThresholdKeys = keyShares
+
+	surveyID, err := client.SendSurveyCreation(scq)
+	require.NoError(t, err)
+
+	for i, server := range subRoster.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(server, strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": int64(i % 2)}, AggregatingAttributesEnc: map[string]int64{"s1": int64(i + 1)}}}
+		err = dataHolder.SendSurveyResponseQuery(*surveyID, responses, thresholdKey, 1, false)
+		assert.NoError(t, err)
+
+		// the servers lose their keys and surveys during the collection and restore them from their database
+		if i == 0 {
+			for _, service := range local.GetServices(servers, onet.ServiceFactory.ServiceID(servicesunlynx.ServiceName)) {
+				unlynx := service.(*servicesunlynx.Service)
+				unlynx.ThresholdKeys = concurrent.NewConcurrentMap()
+				unlynx.Survey = concurrent.NewConcurrentMap()
+				assert.NoError(t, unlynx.LoadKeys())
+				assert.NoError(t, unlynx.LoadSurveys())
+			}
+		}
+	}
+
+	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+	require.NoError(t, err)
+
+	results := make(map[int64]int64)
+	for i := range *grp {
+		results[(*grp)[i][0]] = (*aggr)[i][0]
+	}
+	assert.Equal(t, map[int64]int64{0: 4, 1: 2}, results)
+}
+
+func TestServiceRangeProofs(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+
+	ranges := map[string]*libunlynxrange.Bounds{"s1": {Min: 0, Max: 10}, "count": {Min: 1, Max: 1}}
+	scq := servicesunlynx.SurveyCreationQuery{
+		Roster:  *el,
+		MapDPs:  nbrDPs,
+		Proofs:  proofsService,
+		Sum:     []string{"s1", "count"},
+		Count:   true,
+		GroupBy: []string{"g1"},
+		Ranges:  ranges,
+	}
+
+	// a range must be declared for an aggregating attribute
+	invalid := scq
+	invalid.Ranges = map[string]*libunlynxrange.Bounds{"s2": {Min: 0, Max: 10}}
+	_, err := client.SendSurveyCreation(invalid)
+	assert.Error(t, err)
+
+	surveyID, err := client.SendSurveyCreation(scq)
+	require.NoError(t, err)
+
+	responses := func(v int64) []libunlynx.DpClearResponse {
+		return []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": v}}}
+	}
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		err = dataHolder.SendSurveyResponseQueryWithRangeProofs(*surveyID, responses(int64(i+2)), el.Aggregate, ranges, 1, true)
+		assert.NoError(t, err)
+	}
+
+	dataHolder := servicesunlynx.NewUnLynxClient(el.List[1], "malicious")
+
+	// responses without range proofs or out of the range are refused
+	err = dataHolder.SendSurveyResponseQuery(*surveyID, responses(5), el.Aggregate, 1, true)
+	assert.Error(t, err)
+	err = dataHolder.SendSurveyResponseQueryWithRangeProofs(*surveyID, responses(1000000), el.Aggregate, ranges, 1, true)
+	assert.Error(t, err)
+
+	// a response whose ciphertext was replaced after creating the range proof is refused
+	malicious := key.NewKeyPair(libunlynx.SuiTe)
+	resp, err := servicesunlynx.EncryptDataToSurveyWithRangeProofs("malicious", malicious, *surveyID, responses(5), libunlynx.NewEncryptor(el.Aggregate), ranges, 1, true)
+	require.NoError(t, err)
+	resp.Responses[0].AggregatingAttributesEnc["s1"], err = libunlynx.EncryptInt(el.Aggregate, 1000000).ToBytes()
+	require.NoError(t, err)
+	require.NoError(t, resp.Sign(malicious.Private))
+	err = dataHolder.SendProtobuf(el.List[1], resp, &servicesunlynx.ServiceState{})
+	assert.Error(t, err)
+
+	// a response leaving out a range attribute is refused
+	delete(resp.Responses[0].AggregatingAttributesEnc, "s1")
+	delete(resp.Responses[0].AggregatingAttributesRangeProofs, "s1")
+	require.NoError(t, resp.Sign(malicious.Private))
+	err = dataHolder.SendProtobuf(el.List[1], resp, &servicesunlynx.ServiceState{})
+	assert.Error(t, err)
+
+	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]int64{{1}}, *grp)
+	assert.Equal(t, [][]int64{{9, 3}}, *aggr)
+}
+
+func TestServiceKnowledgeProofs(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	surveyID, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: proofsService, Sum: []string{"s1"}, GroupBy: []string{"g1"}})
+	require.NoError(t, err)
+
+	responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": 5}}}
+	var honest *servicesunlynx.SurveyResponseQuery
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		resp, err := servicesunlynx.EncryptDataToSurvey(dataHolder.String(), key.NewKeyPair(libunlynx.SuiTe), *surveyID, responses, el.Aggregate, 1, false)
+		require.NoError(t, err)
+		err = dataHolder.SendProtobuf(el.List[i], resp, &servicesunlynx.ServiceState{})
+		assert.NoError(t, err)
+		honest = resp
+	}
+
+	// a data provider cannot submit the ciphertexts of another one
+	copied := *honest
+	require.NoError(t, copied.Sign(key.NewKeyPair(libunlynx.SuiTe).Private))
+	err = client.SendProtobuf(el.List[1], &copied, &servicesunlynx.ServiceState{})
+	assert.Error(t, err)
+
+	// nor replay them, and a data provider cannot submit its responses twice
+	err = client.SendProtobuf(el.List[2], honest, &servicesunlynx.ServiceState{})
+	assert.Error(t, err)
+
+	// the responses must be signed by the data provider
+	unsigned := *honest
+	unsigned.Signature = nil
+	err = client.SendProtobuf(el.List[2], &unsigned, &servicesunlynx.ServiceState{})
+	assert.Error(t, err)
+
+	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]int64{{1}}, *grp)
+	assert.Equal(t, [][]int64{{15}}, *aggr)
+}
+
+func TestServiceLinearCombinations(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	scq := servicesunlynx.SurveyCreationQuery{
+		Roster:             *el,
+		MapDPs:             nbrDPs,
+		Proofs:             proofsService,
+		Sum:                []string{"s1", "s2"},
+		GroupBy:            []string{"g1"},
+		LinearCombinations: []servicesunlynx.LinearCombination{{Name: "weighted", Weights: map[string]int64{"s1": 3, "s2": 1}}},
+	}
+
+	// a linear combination must have a new name and use sum attributes
+	invalid := scq
+	invalid.LinearCombinations = []servicesunlynx.LinearCombination{{Name: "s1", Weights: map[string]int64{"s2": 1}}}
+	_, err := client.SendSurveyCreation(invalid)
+	assert.Error(t, err)
+	invalid.LinearCombinations = []servicesunlynx.LinearCombination{{Name: "weighted", Weights: map[string]int64{"s3": 1}}}
+	_, err = client.SendSurveyCreation(invalid)
+	assert.Error(t, err)
+
+	surveyID, err := client.SendSurveyCreation(scq)
+	require.NoError(t, err)
+
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": int64(i % 2)}, AggregatingAttributesEnc: map[string]int64{"s1": int64(i + 1), "s2": 10}}}
+		err = dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false)
+		assert.NoError(t, err)
+	}
+
+	ticket, err := client.SendAsyncResultsQuery(*surveyID)
+	require.NoError(t, err)
+	result, err := client.SubscribeResults(ticket, nil)
+	require.NoError(t, err)
+
+	// the linear combinations of the two groups are proven, but key-switched results cannot be linked to the proofs
+	assert.Error(t, servicesunlynx.VerifyLinearCombinationProofs(result, scq.LinearCombinations))
+	assert.Equal(t, 2, len(result.LinearCombinationProofs.List))
+
+	grp, aggr := client.DecryptResults(result)
+	results := make(map[int64][]int64)
+	for i := range *grp {
+		results[(*grp)[i][0]] = (*aggr)[i]
+	}
+	assert.Equal(t, map[int64][]int64{0: {4, 20, 32}, 1: {2, 10, 16}}, results)
+}
+
+func TestServicePublicResults(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	scq := servicesunlynx.SurveyCreationQuery{
+		Roster:        *el,
+		MapDPs:        nbrDPs,
+		Proofs:        proofsService,
+		Sum:           []string{"s1", "s2"},
+		GroupBy:       []string{"g1"},
+		PublicResults: true,
+
+		LinearCombinations: []servicesunlynx.LinearCombination{{Name: "weighted", Weights: map[string]int64{"s1": 3, "s2": 1}}},
+	}
+
+	// public results cannot be verified with a distributed key
+	invalid := scq
+	invalid.ThresholdKeyID = el.ID
+	_, err := client.SendSurveyCreation(invalid)
+	assert.Error(t, err)
+
+	surveyID, err := client.SendSurveyCreation(scq)
+	require.NoError(t, err)
+
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": int64(i % 2)}, AggregatingAttributesEnc: map[string]int64{"s1": int64(i + 1), "s2": -10}}}
+		err = dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false)
+		assert.NoError(t, err)
+	}
+
+	// anyone can verify the results with the public keys of the roster
+	result := servicesunlynx.ServiceResult{}
+	err = client.SendProtobuf(el.List[0], &servicesunlynx.SurveyResultsQuery{SurveyID: *surveyID, ClientPublic: el.Aggregate}, &result)
+	require.NoError(t, err)
+
+	grp, aggr, err := servicesunlynx.VerifyPublicResults(&result, el.Publics(), libunlynx.MaxHomomorphicInt)
+	require.NoError(t, err)
+
+	results := make(map[int64][]int64)
+	for i := range *grp {
+		results[(*grp)[i][0]] = (*aggr)[i]
+	}
+	assert.Equal(t, map[int64][]int64{0: {4, -20, -8}, 1: {2, -10, -4}}, results)
+
+	// the linear combinations are proven over the public results, with the weights of the query
+	assert.NoError(t, servicesunlynx.VerifyLinearCombinationProofs(&result, scq.LinearCombinations))
+	other := []servicesunlynx.LinearCombination{{Name: "weighted", Weights: map[string]int64{"s1": 2, "s2": 1}}}
+	assert.Error(t, servicesunlynx.VerifyLinearCombinationProofs(&result, other))
+
+	// the results are only decoded in the given range
+	_, _, err = servicesunlynx.VerifyPublicResults(&result, el.Publics(), 10)
+	assert.Error(t, err)
+
+	// the results cannot be verified with other keys or if they were modified
+	_, otherRoster, _ := local.GenTree(3, false)
+	_, _, err = servicesunlynx.VerifyPublicResults(&result, otherRoster.Publics(), libunlynx.MaxHomomorphicInt)
+	assert.Error(t, err)
+	result.Results[0].AggregatingAttributes[0] = libunlynx.IntToCipherText(0)
+	_, _, err = servicesunlynx.VerifyPublicResults(&result, el.Publics(), libunlynx.MaxHomomorphicInt)
+	assert.Error(t, err)
+	assert.Error(t, servicesunlynx.VerifyLinearCombinationProofs(&result, scq.LinearCombinations))
+	_, _, err = servicesunlynx.VerifyPublicResults(&servicesunlynx.ServiceResult{Results: result.Results}, el.Publics(), libunlynx.MaxHomomorphicInt)
+	assert.Error(t, err)
+}
+
+func TestServiceKeyRotation(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	servers, el, _ := local.GenTree(4, true)
+	defer local.CloseAll()
+
+	// the key is rotated on the roster of the first 3 servers, the other roster does not contain the rotating server
+	roster := onet.NewRoster(el.List[:3])
+	other := onet.NewRoster([]*network.ServerIdentity{el.List[0], el.List[2], el.List[3]})
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	surveyQuery := func(roster *onet.Roster) servicesunlynx.SurveyCreationQuery {
+		nbrDPs := make(map[string]int64)
+		for _, server := range roster.List {
+			nbrDPs[server.String()] = 1
+		}
+		return servicesunlynx.SurveyCreationQuery{
+			Roster:  *roster,
+			MapDPs:  nbrDPs,
+			Proofs:  proofsService,
+			Sum:     []string{"s1", "s2"},
+			GroupBy: []string{"g1"},
+		}
+	}
+	scq := surveyQuery(roster)
+	surveyID, err := client.SendSurveyCreation(scq)
+	require.NoError(t, err)
+	scq.PublicResults = true
+	publicSurveyID, err := client.SendSurveyCreation(scq)
+	require.NoError(t, err)
+	otherSurveyID, err := client.SendSurveyCreation(surveyQuery(other))
+	require.NoError(t, err)
+
+	responses := func(i int) []libunlynx.DpClearResponse {
+		return []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": int64(i % 2)}, AggregatingAttributesEnc: map[string]int64{"s1": int64(i + 1), "s2": 10}}}
+	}
+	sendResponses := func(i int, key kyber.Point) {
+		dataHolder := servicesunlynx.NewUnLynxClient(roster.List[i], strconv.Itoa(i+1))
+		assert.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses(i), key, 1, false))
+		assert.NoError(t, dataHolder.SendSurveyResponseQuery(*publicSurveyID, responses(i), key, 1, false))
+	}
+
+	// the first data provider sends its data before the key rotation, the others after it
+	sendResponses(0, roster.Aggregate)
+	// the survey on the other roster gets all its data before the key rotation
+	for i, server := range other.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(server, strconv.Itoa(i+4))
+		assert.NoError(t, dataHolder.SendSurveyResponseQuery(*otherSurveyID, responses(i), other.Aggregate, 1, false))
+	}
+
+	// the first server cannot rotate its key on a roster while it takes part in a survey of another roster
+	_, _, err = client.SendKeyRotationQuery(roster)
+	assert.Error(t, err)
+
+	rotatingClient := servicesunlynx.NewUnLynxClient(roster.List[1], strconv.Itoa(4))
+	public, collectiveKey, err := rotatingClient.SendKeyRotationQuery(roster)
+	require.NoError(t, err)
+	assert.False(t, public.Equal(roster.List[1].Public))
+	expectedKey := libunlynx.SuiTe.Point().Sub(roster.Aggregate, roster.List[1].Public)
+	assert.True(t, collectiveKey.Equal(expectedKey.Add(expectedKey, public)))
+
+	// the servers restart with the rotated keys (and the re-encrypted surveys) of their database
+	services := local.GetServices(servers, onet.ServiceFactory.ServiceID(servicesunlynx.ServiceName))
+	for _, service := range services {
+		unlynx := service.(*servicesunlynx.Service)
+		unlynx.RotatedKeys = concurrent.NewConcurrentMap()
+		unlynx.Survey = concurrent.NewConcurrentMap()
+		assert.NoError(t, unlynx.LoadKeys())
+		assert.NoError(t, unlynx.LoadSurveys())
+	}
+	private, err := services[1].(*servicesunlynx.Service).KeyStore.LoadPrivateKey()
+	require.NoError(t, err)
+	assert.True(t, public.Equal(libunlynx.SuiTe.Point().Mul(private, nil)))
+
+	sendResponses(1, collectiveKey)
+	sendResponses(2, collectiveKey)
+
+	expected := map[int64][]int64{0: {4, 20}, 1: {2, 10}}
+
+	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+	require.NoError(t, err)
+	results := make(map[int64][]int64)
+	for i := range *grp {
+		results[(*grp)[i][0]] = (*aggr)[i]
+	}
+	assert.Equal(t, expected, results)
+
+	// the public results are verified with the new public key
+	publics := roster.Publics()
+	publics[1] = public
+	grp, aggr, err = client.SendSurveyPublicResultsQuery(*publicSurveyID, publics, libunlynx.MaxHomomorphicInt)
+	require.NoError(t, err)
+	results = make(map[int64][]int64)
+	for i := range *grp {
+		results[(*grp)[i][0]] = (*aggr)[i]
+	}
+	assert.Equal(t, expected, results)
+
+	// the survey on the other roster was not re-encrypted
+	grp, aggr, err = client.SendSurveyResultsQuery(*otherSurveyID)
+	require.NoError(t, err)
+	results = make(map[int64][]int64)
+	for i := range *grp {
+		results[(*grp)[i][0]] = (*aggr)[i]
+	}
+	assert.Equal(t, expected, results)
+}
+
+func TestServiceSchema(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+
+	schema := &libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "hospital", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityClear},
+		{Name: "weight", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Encoding: libunlynx.EncodingFixedPoint, Decimals: 1},
+		{Name: "visits", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 0, Max: 100}},
+	}}
+
+	// the query must use the attributes according to their role
+	_, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Sum: []string{"weight"}, GroupBy: []string{"visits"}, Schema: schema})
+	assert.Error(t, err)
+	_, err = client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1"}, GroupBy: []string{"hospital"}, Schema: schema})
+	assert.Error(t, err)
+
+	// the fixed-point scales are taken from the schema
+	surveyID, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{
+		Roster:  *el,
+		MapDPs:  nbrDPs,
+		Proofs:  proofsService,
+		Sum:     []string{"weight", "visits"},
+		GroupBy: []string{"hospital"},
+		Schema:  schema,
+	})
+	if err != nil {
+		t.Fatal("Service did not start.", err)
+	}
+
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+
+		// the responses must match the schema
+		wrong := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": 725, "s2": 2}}}
+		err = dataHolder.SendSurveyResponseQuery(*surveyID, wrong, el.Aggregate, 1, false)
+		assert.Error(t, err)
+
+		response, err := schema.NewDpClearResponse(map[string]int64{"hospital": 1, "weight": 725, "visits": int64(i)})
+		assert.NoError(t, err)
+		err = dataHolder.SendSurveyResponseQuery(*surveyID, []libunlynx.DpClearResponse{response}, el.Aggregate, 1, false)
+		assert.NoError(t, err)
+	}
+
+	grp, aggr, err := client.SendSurveyResultsQueryFixedPoint(*surveyID, 10000)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int64{{1}}, *grp)
+	assert.Equal(t, 1, len(*aggr))
+	assert.InDelta(t, 217.5, (*aggr)[0][0], 1e-9)
+	assert.InDelta(t, 3, (*aggr)[0][1], 1e-9)
+}
+
+func TestServiceRestart(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	servers, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	query := servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: proofsService, Sum: []string{"s1"}, GroupBy: []string{"g1"}}
+
+	// the servers lose the surveys they keep in memory and restore them from their database
+	services := local.GetServices(servers, onet.ServiceFactory.ServiceID(servicesunlynx.ServiceName))
+	restart := func() {
+		for _, service := range services {
+			unlynx := service.(*servicesunlynx.Service)
+			unlynx.Survey = concurrent.NewConcurrentMap()
+			assert.NoError(t, unlynx.LoadSurveys())
+		}
+	}
+	sendResponse := func(surveyID servicesunlynx.SurveyID, i int) {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": int64(i + 1)}}}
+		assert.NoError(t, dataHolder.SendSurveyResponseQuery(surveyID, responses, el.Aggregate, 1, false))
+	}
+
+	// a survey collecting responses is resumed
+	surveyID, err := client.SendSurveyCreation(query)
+	require.NoError(t, err)
+	sendResponse(*surveyID, 0)
+	restart()
+	sendResponse(*surveyID, 1)
+	sendResponse(*surveyID, 2)
+
+	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int64{{1}}, *grp)
+	assert.Equal(t, [][]int64{{6}}, *aggr)
+
+	// a survey interrupted during its processing has failed
+	surveyID, err = client.SendSurveyCreation(query)
+	require.NoError(t, err)
+	for i := range el.List {
+		sendResponse(*surveyID, i)
+	}
+	for _, service := range services {
+		unlynx := service.(*servicesunlynx.Service)
+		records, err := unlynx.SurveyStore.LoadAll()
+		assert.NoError(t, err)
+		records[*surveyID].Status = servicesunlynx.SurveyProcessing
+		assert.NoError(t, unlynx.SurveyStore.Save(*surveyID, records[*surveyID]))
+	}
+	restart()
+
+	_, _, err = client.SendSurveyResultsQuery(*surveyID)
+	assert.Error(t, err)
+
+	// a survey that cannot be restored has failed, without preventing the restoration of the other surveys
+	surveyID, err = client.SendSurveyCreation(query)
+	require.NoError(t, err)
+	sendResponse(*surveyID, 0)
+	unlynx := services[1].(*servicesunlynx.Service)
+	records, err := unlynx.SurveyStore.LoadAll()
+	require.NoError(t, err)
+	records[*surveyID].SurveySecretKey = []byte{1}
+	assert.NoError(t, unlynx.SurveyStore.Save(*surveyID, records[*surveyID]))
+	restart()
+
+	statuses, err := client.GetSurveyStatus(*surveyID)
+	require.NoError(t, err)
+	assert.Equal(t, servicesunlynx.SurveyCollecting, statuses[0].Status)
+	assert.Equal(t, int64(1), statuses[0].DpReceived)
+	assert.Equal(t, servicesunlynx.SurveyFailed, statuses[1].Status)
+	assert.NotEmpty(t, statuses[1].Error)
+}
+
+func TestServiceMemoryBudget(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	servers, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	spillDir, err := ioutil.TempDir("", "unlynx-spill-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(spillDir)
+	services := local.GetServices(servers, onet.ServiceFactory.ServiceID(servicesunlynx.ServiceName))
+	for _, service := range services {
+		service.(*servicesunlynx.Service).SpillDir = spillDir
+	}
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+
+	// a response has one group by attribute and one aggregating attribute: each server receives more than 3 times its
+	// budget
+	budget := 3 * 2 * int64(libunlynx.CipherTextByteSize())
+	query := servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: proofsService, Sum: []string{"s1"}, GroupBy: []string{"g1"}, MemoryBudget: budget}
+
+	surveyID, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1"}, MemoryBudget: -1})
+	assert.Error(t, err)
+	surveyID, err = client.SendSurveyCreation(query)
+	require.NoError(t, err)
+
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := make([]libunlynx.DpClearResponse, 10)
+		for j := range responses {
+			responses[j] = libunlynx.DpClearResponse{GroupByEnc: map[string]int64{"g1": int64(j % 2)}, AggregatingAttributesEnc: map[string]int64{"s1": int64(j)}}
+		}
+		assert.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+	}
+
+	for _, service := range services {
+		survey, err := service.(*servicesunlynx.Service).Survey.Get(string(*surveyID))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, survey.(servicesunlynx.Survey).SpilledChunks())
+	}
+
+	// the spilled responses are re-encrypted chunk by chunk when a server rotates its key
+	rotatingClient := servicesunlynx.NewUnLynxClient(el.List[1], strconv.Itoa(4))
+	_, _, err = rotatingClient.SendKeyRotationQuery(el)
+	require.NoError(t, err)
+
+	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+	assert.NoError(t, err)
+	results := make(map[int64]int64)
+	for i := range *grp {
+		results[(*grp)[i][0]] = (*aggr)[i][0]
+	}
+	assert.Equal(t, map[int64]int64{0: 3 * (0 + 2 + 4 + 6 + 8), 1: 3 * (1 + 3 + 5 + 7 + 9)}, results)
+
+	// the spilled chunks of the root are removed once it has processed the survey
+	survey, err := services[0].(*servicesunlynx.Service).Survey.Get(string(*surveyID))
+	assert.NoError(t, err)
+	_, err = os.Stat(survey.(servicesunlynx.Survey).SpillDir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServiceSurveyLifecycle(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	servers, all, _ := local.GenTree(4, true)
+	defer local.CloseAll()
+
+	// the last server is not in the roster of the survey
+	el := onet.NewRoster(all.List[:3])
+	services := local.GetServices(servers, onet.ServiceFactory.ServiceID(servicesunlynx.ServiceName))
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+	querier := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	surveyID, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: proofsService, Sum: []string{"s1"}, GroupBy: []string{"g1"}})
+	require.NoError(t, err)
+
+	// only the data provider of the first server sends its data
+	dataHolder := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(1))
+	responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": 1}}}
+	assert.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+
+	surveys, err := querier.ListSurveys()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(surveys))
+	assert.Equal(t, *surveyID, surveys[0].SurveyID)
+	assert.Equal(t, servicesunlynx.SurveyCollecting, surveys[0].Status)
+
+	statuses, err := querier.GetSurveyStatus(*surveyID)
+	assert.NoError(t, err)
+	require.Equal(t, len(el.List), len(statuses))
+	for i, status := range statuses {
+		assert.Equal(t, el.List[i].String(), status.Server)
+		assert.Equal(t, int64(1), status.DpExpected)
+		if i == 0 {
+			assert.Equal(t, int64(1), status.DpReceived)
+		} else {
+			assert.Equal(t, int64(0), status.DpReceived)
+		}
+	}
+	_, err = querier.GetSurveyStatus("unknown")
+	assert.Error(t, err)
+
+	// a server outside the roster can neither cancel nor delete the survey
+	services[1].(*servicesunlynx.Service).Process(&network.Envelope{
+		ServerIdentity: all.List[3],
+		MsgType:        network.MessageType(&servicesunlynx.CancelSurveyQuery{}),
+		Msg:            &servicesunlynx.CancelSurveyQuery{SurveyID: *surveyID, IntraMessage: true},
+	})
+	services[1].(*servicesunlynx.Service).Process(&network.Envelope{
+		ServerIdentity: all.List[3],
+		MsgType:        network.MessageType(&servicesunlynx.DeleteSurveyQuery{}),
+		Msg:            &servicesunlynx.DeleteSurveyQuery{SurveyID: *surveyID, IntraMessage: true},
+	})
+	statuses, err = querier.GetSurveyStatus(*surveyID)
+	assert.NoError(t, err)
+	require.Equal(t, len(el.List), len(statuses))
+	assert.Equal(t, servicesunlynx.SurveyCollecting, statuses[1].Status)
+
+	// the processing is stuck waiting for the other data providers until the survey is cancelled
+	results := make(chan error)
+	go func() {
+		_, _, err := client.SendSurveyResultsQuery(*surveyID)
+		results <- err
+	}()
+	assert.Eventually(t, func() bool {
+		statuses, err := querier.GetSurveyStatus(*surveyID)
+		return err == nil && statuses[0].Status == servicesunlynx.SurveyProcessing && statuses[0].Phase == servicesunlynx.PhaseAggregation
+	}, 10*time.Second, 100*time.Millisecond)
+
+	assert.NoError(t, querier.CancelSurvey(*surveyID))
+	select {
+	case err := <-results:
+		assert.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the results query was not released by the cancellation")
+	}
+	assert.Eventually(t, func() bool {
+		statuses, err := querier.GetSurveyStatus(*surveyID)
+		if err != nil {
+			return false
+		}
+		for _, status := range statuses {
+			if status.Status != servicesunlynx.SurveyCancelled {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 100*time.Millisecond)
+
+	assert.Error(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+	assert.Error(t, querier.CancelSurvey(*surveyID))
+
+	// the survey is deleted from all the servers
+	assert.NoError(t, querier.DeleteSurvey(*surveyID))
+	surveys, err = querier.ListSurveys()
+	assert.NoError(t, err)
+	assert.Empty(t, surveys)
+	assert.Eventually(t, func() bool {
+		for _, service := range services {
+			if survey, _ := service.(*servicesunlynx.Service).Survey.Get(string(*surveyID)); survey != nil {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestServiceAsyncResults(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	surveyID, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: proofsService, Sum: []string{"s1"}, GroupBy: []string{"g1"}})
+	require.NoError(t, err)
+
+	// the ticket is returned before the data providers send their data
+	ticket, err := client.SendAsyncResultsQuery(*surveyID)
+	require.NoError(t, err)
+	_, err = client.SendAsyncResultsQuery(*surveyID)
+	assert.Error(t, err)
+	_, err = client.PollResults("unknown")
+	assert.Error(t, err)
+
+	progress, err := client.PollResults(ticket)
+	assert.NoError(t, err)
+	assert.False(t, progress.Done)
+
+	type subscription struct {
+		result *servicesunlynx.ServiceResult
+		err    error
+	}
+	var phases []servicesunlynx.SurveyPhase
+	subscribed := make(chan subscription)
+	go func() {
+		result, err := client.SubscribeResults(ticket, func(event servicesunlynx.ProgressEvent) {
+			phases = append(phases, event.Phase)
+		})
+		subscribed <- subscription{result, err}
+	}()
+
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": int64(i + 1)}}}
+		assert.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+	}
+
+	var sub subscription
+	select {
+	case sub = <-subscribed:
+	case <-time.After(time.Minute):
+		t.Fatal("the subscription did not end")
+	}
+	require.NoError(t, sub.err)
+	assert.Equal(t, []servicesunlynx.SurveyPhase{servicesunlynx.PhaseShuffling, servicesunlynx.PhaseTagging,
+		servicesunlynx.PhaseAggregation, servicesunlynx.PhaseKeySwitching}, phases)
+	grp, aggr := client.DecryptResults(sub.result)
+	assert.Equal(t, [][]int64{{1}}, *grp)
+	assert.Equal(t, [][]int64{{6}}, *aggr)
+
+	// the results can also be polled
+	progress, err = client.PollResults(ticket)
+	assert.NoError(t, err)
+	assert.True(t, progress.Done)
+	assert.Empty(t, progress.Error)
+	assert.Equal(t, 4, len(progress.Events))
+	grp, aggr = client.DecryptResults(&progress.Result)
+	assert.Equal(t, [][]int64{{1}}, *grp)
+	assert.Equal(t, [][]int64{{6}}, *aggr)
+}
+
+func TestServiceDataset(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	servers, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	datasetID, err := client.SendDatasetCreation(servicesunlynx.DatasetCreationQuery{DatasetID: "patients", Roster: *el, Expiry: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	assert.Equal(t, servicesunlynx.DatasetID("patients"), *datasetID)
+	_, err = client.SendDatasetCreation(servicesunlynx.DatasetCreationQuery{DatasetID: "patients", Roster: *el})
+	assert.Error(t, err)
+
+	// the data providers upload their data once
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{
+			{GroupByEnc: map[string]int64{"g1": 0, "g2": int64(i)}, AggregatingAttributesEnc: map[string]int64{"s1": 1, "s2": 10}},
+			{GroupByEnc: map[string]int64{"g1": 1, "g2": int64(i)}, AggregatingAttributesEnc: map[string]int64{"s1": 2, "s2": 20}},
+		}
+		assert.NoError(t, dataHolder.SendDatasetResponseQuery(*datasetID, responses, el.Aggregate, 1, true))
+		// a second upload of the same data provider is refused
+		assert.Error(t, dataHolder.SendDatasetResponseQuery(*datasetID, responses, el.Aggregate, 1, true))
+	}
+
+	// several surveys are run on the same data, each with its own survey secret
+	sumByG1, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, Proofs: proofsService, Sum: []string{"s1", "count"}, Count: true, GroupBy: []string{"g1"}, DatasetID: *datasetID})
+	require.NoError(t, err)
+	sumByG2, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, Proofs: proofsService, Sum: []string{"s2"}, GroupBy: []string{"g2"}, DatasetID: *datasetID})
+	require.NoError(t, err)
+	assert.NotEqual(t, *sumByG1, *sumByG2)
+
+	grp, aggr, err := client.SendSurveyResultsQuery(*sumByG1)
+	assert.NoError(t, err)
+	results := make(map[int64][]int64)
+	for i := range *grp {
+		results[(*grp)[i][0]] = (*aggr)[i]
+	}
+	assert.Equal(t, map[int64][]int64{0: {3, 3}, 1: {6, 3}}, results)
+
+	grp, aggr, err = client.SendSurveyResultsQuery(*sumByG2)
+	assert.NoError(t, err)
+	results = make(map[int64][]int64)
+	for i := range *grp {
+		results[(*grp)[i][0]] = (*aggr)[i]
+	}
+	assert.Equal(t, map[int64][]int64{0: {30}, 1: {30}, 2: {30}}, results)
+
+	// the dataset is not drained by the surveys
+	services := local.GetServices(servers, onet.ServiceFactory.ServiceID(servicesunlynx.ServiceName))
+	for _, service := range services {
+		dataset, err := service.(*servicesunlynx.Service).Datasets.Get(string(*datasetID))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(dataset.(servicesunlynx.Dataset).Responses))
+		assert.Equal(t, int64(1), dataset.(servicesunlynx.Dataset).DpCount)
+	}
+
+	// a survey on a dataset does not wait for data providers
+	nbrDPs := map[string]int64{el.List[0].String(): 1}
+	_, err = client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1"}, DatasetID: *datasetID})
+	assert.Error(t, err)
+	_, err = client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, Sum: []string{"s1"}, DatasetID: "unknown"})
+	assert.Error(t, err)
+
+	// an expired dataset is deleted
+	expiring, err := client.SendDatasetCreation(servicesunlynx.DatasetCreationQuery{Roster: *el, Expiry: time.Now().Add(time.Second).Unix()})
+	require.NoError(t, err)
+	time.Sleep(2 * time.Second)
+	_, err = client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, Sum: []string{"s1"}, DatasetID: *expiring})
+	assert.Error(t, err)
+	dataset, err := services[0].(*servicesunlynx.Service).Datasets.Get(string(*expiring))
+	assert.NoError(t, err)
+	assert.Nil(t, dataset)
+
+	// a dataset refused by one of the servers is removed from the others
+	_, err = services[2].(*servicesunlynx.Service).Datasets.Put("taken", servicesunlynx.Dataset{})
+	require.NoError(t, err)
+	_, err = client.SendDatasetCreation(servicesunlynx.DatasetCreationQuery{DatasetID: "taken", Roster: *el})
+	assert.Error(t, err)
+	time.Sleep(time.Second)
+	for _, service := range services[:2] {
+		dataset, err := service.(*servicesunlynx.Service).Datasets.Get("taken")
+		assert.NoError(t, err)
+		assert.Nil(t, dataset)
+	}
+	dataset, err = services[2].(*servicesunlynx.Service).Datasets.Get("taken")
+	assert.NoError(t, err)
+	assert.NotNil(t, dataset)
+}
+
+func TestServiceQueryPolicy(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	servers, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	querierKeys := key.NewKeyPair(libunlynx.SuiTe)
+	public, err := libunlynx.SerializePoint(querierKeys.Public)
+	require.NoError(t, err)
+
+	policyFile, err := ioutil.TempFile("", "unlynx-policy-*.toml")
+	require.NoError(t, err)
+	defer os.Remove(policyFile.Name())
+	_, err = policyFile.WriteString(`Attributes = ["s1", "g1", "g2"]
+MaxGroupByDepth = 1
+ProofsRequired = true
+
+[[Queriers]]
+Name = "querier"
+Public = "` + public + `"
+`)
+	require.NoError(t, err)
+	require.NoError(t, policyFile.Close())
+	policy, err := servicesunlynx.LoadQueryPolicy(policyFile.Name())
+	require.NoError(t, err)
+
+	services := local.GetServices(servers, onet.ServiceFactory.ServiceID(servicesunlynx.ServiceName))
+	for _, service := range services {
+		service.(*servicesunlynx.Service).Policy = policy
+	}
+
+	querier := servicesunlynx.NewUnLynxClientWithKeys(el.List[0], strconv.Itoa(0), querierKeys)
+	stranger := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	query := servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: true, Sum: []string{"s1"}, GroupBy: []string{"g1"}}
+
+	// the queries not matching the policy are refused
+	_, err = stranger.SendSurveyCreation(query)
+	assert.Error(t, err)
+	for _, refused := range []servicesunlynx.SurveyCreationQuery{
+		{Roster: *el, MapDPs: nbrDPs, Proofs: true, Sum: []string{"s2"}, GroupBy: []string{"g1"}},
+		{Roster: *el, MapDPs: nbrDPs, Proofs: true, Sum: []string{"s1"}, GroupBy: []string{"g1", "g2"}},
+		{Roster: *el, MapDPs: nbrDPs, Proofs: false, Sum: []string{"s1"}, GroupBy: []string{"g1"}},
+	} {
+		_, err = querier.SendSurveyCreation(refused)
+		assert.Error(t, err)
+	}
+
+	// a query modified after its signature is refused
+	tampered := query
+	require.NoError(t, tampered.Sign(querierKeys.Private))
+	tampered.Sum = []string{"g2"}
+	assert.Error(t, tampered.VerifySignature())
+	tampered = query
+	require.NoError(t, tampered.Sign(querierKeys.Private))
+	tampered.FixedPoint = libunlynx.FixedPointScales{"s1": 2}
+	assert.Error(t, tampered.VerifySignature())
+
+	// the expired and replayed queries are refused
+	expired := query
+	expired.Expiry = time.Now().Add(-time.Minute).Unix()
+	_, err = querier.SendSurveyCreation(expired)
+	assert.Error(t, err)
+	expired.Expiry = time.Now().Add(2 * time.Hour).Unix()
+	_, err = querier.SendSurveyCreation(expired)
+	assert.Error(t, err)
+	replayed := query
+	replayed.Nonce = []byte("nonce")
+	replayedID, err := querier.SendSurveyCreation(replayed)
+	require.NoError(t, err)
+	_, err = querier.SendSurveyCreation(replayed)
+	assert.Error(t, err)
+
+	// only the querier of a survey manages it
+	_, err = stranger.GetSurveyStatus(*replayedID)
+	assert.Error(t, err)
+	assert.Error(t, stranger.CancelSurvey(*replayedID))
+	assert.Error(t, stranger.DeleteSurvey(*replayedID))
+	_, err = stranger.ListSurveys()
+	assert.Error(t, err)
+	list, err := querier.ListSurveys()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, *replayedID, list[0].SurveyID)
+	assert.NoError(t, querier.DeleteSurvey(*replayedID))
+
+	// so are the datasets and the keys of the servers
+	_, err = stranger.SendDatasetCreation(servicesunlynx.DatasetCreationQuery{Roster: *el})
+	assert.Error(t, err)
+	_, _, err = stranger.SendKeyRotationQuery(el)
+	assert.Error(t, err)
+	_, err = stranger.SendDKGQuery(el, 2)
+	assert.Error(t, err)
+	_, err = querier.SendDatasetCreation(servicesunlynx.DatasetCreationQuery{Roster: *el})
+	assert.NoError(t, err)
+
+	// a single server refusing the query is enough
+	services[1].(*servicesunlynx.Service).Policy = &servicesunlynx.QueryPolicy{}
+	_, err = querier.SendSurveyCreation(query)
+	assert.Error(t, err)
+	services[1].(*servicesunlynx.Service).Policy = policy
+
+	surveyID, err := querier.SendSurveyCreation(query)
+	require.NoError(t, err)
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": int64(i + 1)}}}
+		assert.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+	}
+
+	// only the querier of the survey gets its results
+	_, _, err = stranger.SendSurveyResultsQuery(*surveyID)
+	assert.Error(t, err)
+	grp, aggr, err := querier.SendSurveyResultsQuery(*surveyID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]int64{{1}}, *grp)
+	assert.Equal(t, [][]int64{{6}}, *aggr)
+
+	// a server whose policy changed refuses to process the survey, it is reported as the reason of the cancellation
+	surveyID, err = querier.SendSurveyCreation(query)
+	require.NoError(t, err)
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": int64(i + 1)}}}
+		assert.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+	}
+	services[2].(*servicesunlynx.Service).Policy = &servicesunlynx.QueryPolicy{}
+	_, _, err = querier.SendSurveyResultsQuery(*surveyID)
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		statuses, err := querier.GetSurveyStatus(*surveyID)
+		if err != nil {
+			return false
+		}
+		for _, status := range statuses {
+			if status.Status != servicesunlynx.SurveyCancelled || !strings.Contains(status.Error, "refused by "+el.List[2].String()) {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestServicePrivacyBudget(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	servers, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	querierKeys := key.NewKeyPair(libunlynx.SuiTe)
+	public, err := libunlynx.SerializePoint(querierKeys.Public)
+	require.NoError(t, err)
+	policy := &servicesunlynx.QueryPolicy{Queriers: []servicesunlynx.PolicyQuerier{{Name: "querier", Public: public}}, PrivacyBudget: 1}
+	require.NoError(t, policy.Validate())
+
+	services := local.GetServices(servers, onet.ServiceFactory.ServiceID(servicesunlynx.ServiceName))
+	for _, service := range services {
+		service.(*servicesunlynx.Service).Policy = policy
+	}
+	querier := servicesunlynx.NewUnLynxClientWithKeys(el.List[0], strconv.Itoa(0), querierKeys)
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	schema := &libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "s1", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 0, Max: 1}},
+	}}
+	_, err = querier.SendDatasetCreation(servicesunlynx.DatasetCreationQuery{DatasetID: "dataset", Roster: *el, Schema: schema})
+	require.NoError(t, err)
+	dp := &servicesunlynx.DiffPrivacyParameters{Sensitivity: map[string]float64{"s1": 1}, Limit: 10}
+	query := func(epsilon float64) servicesunlynx.SurveyCreationQuery {
+		return servicesunlynx.SurveyCreationQuery{Roster: *el, Sum: []string{"s1"}, DatasetID: "dataset", Epsilon: epsilon, DiffPrivacy: dp}
+	}
+
+	// the sensitivities must bound the values of the responses (the domain of the schema or the declared ranges)
+	tooSmall := query(0.5)
+	tooSmall.DiffPrivacy = &servicesunlynx.DiffPrivacyParameters{Sensitivity: map[string]float64{"s1": 1e-9}, Limit: 10}
+	_, err = querier.SendSurveyCreation(tooSmall)
+	assert.Error(t, err)
+	withoutRanges := servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1"}, Epsilon: 0.5, DiffPrivacy: dp}
+	_, err = querier.SendSurveyCreation(withoutRanges)
+	assert.Error(t, err)
+	withRanges := withoutRanges
+	withRanges.Ranges = map[string]*libunlynxrange.Bounds{"s1": {Min: 0, Max: 10}}
+	_, err = querier.SendSurveyCreation(withRanges)
+	assert.Error(t, err)
+
+	// the surveys must declare their epsilon and be differentially private
+	_, err = querier.SendSurveyCreation(query(0))
+	assert.Error(t, err)
+	_, err = querier.SendSurveyCreation(query(-0.5))
+	assert.Error(t, err)
+	withoutNoise := query(0.5)
+	withoutNoise.DiffPrivacy = nil
+	_, err = querier.SendSurveyCreation(withoutNoise)
+	assert.Error(t, err)
+
+	// the budget of a survey refused by another server or cancelled is refunded
+	services[1].(*servicesunlynx.Service).Policy = &servicesunlynx.QueryPolicy{}
+	_, err = querier.SendSurveyCreation(query(0.5))
+	assert.Error(t, err)
+	services[1].(*servicesunlynx.Service).Policy = policy
+	cancelled, err := querier.SendSurveyCreation(query(0.5))
+	require.NoError(t, err)
+	require.NoError(t, querier.CancelSurvey(*cancelled))
+	assert.Eventually(t, func() bool {
+		for _, service := range services {
+			if service.(*servicesunlynx.Service).Ledger.Spent(public, "dataset") != 0 {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 100*time.Millisecond)
+
+	_, err = querier.SendSurveyCreation(query(0.5))
+	require.NoError(t, err)
+	state, err := querier.GetPrivacyBudget("dataset")
+	require.NoError(t, err)
+	assert.Equal(t, servicesunlynx.PrivacyBudgetState{Spent: 0.5, Budget: 1, Remaining: 0.5}, *state)
+
+	// a survey exceeding the budget left is refused and does not consume it
+	_, err = querier.SendSurveyCreation(query(0.75))
+	assert.Error(t, err)
+	_, err = querier.SendSurveyCreation(query(0.5))
+	require.NoError(t, err)
+	_, err = querier.SendSurveyCreation(query(0.25))
+	assert.Error(t, err)
+
+	// each server keeps its own ledger
+	for i, server := range el.List {
+		client := servicesunlynx.NewUnLynxClientWithKeys(server, strconv.Itoa(i), querierKeys)
+		state, err := client.GetPrivacyBudget("dataset")
+		require.NoError(t, err)
+		assert.Equal(t, servicesunlynx.PrivacyBudgetState{Spent: 1, Budget: 1, Remaining: 0}, *state)
+	}
+
+	// the budget is consumed per querier and per dataset
+	state, err = servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0)).GetPrivacyBudget("dataset")
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, state.Spent)
+	state, err = querier.GetPrivacyBudget("other")
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, state.Spent)
+
+	// the surveys collecting their own responses are left out of the ledger: only their own epsilon is bounded
+	withRanges.Ranges = map[string]*libunlynxrange.Bounds{"s1": {Min: 0, Max: 1}}
+	_, err = querier.SendSurveyCreation(withRanges)
+	assert.NoError(t, err)
+	withRanges.Epsilon = 1.5
+	_, err = querier.SendSurveyCreation(withRanges)
+	assert.Error(t, err)
+	state, err = querier.GetPrivacyBudget("")
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, state.Spent)
+
+	// the ledger is kept when the server restarts
+	dbFile, err := ioutil.TempFile("", "unlynx-ledger-*.db")
+	require.NoError(t, err)
+	require.NoError(t, dbFile.Close())
+	defer os.Remove(dbFile.Name())
+	db, err := bbolt.Open(dbFile.Name(), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("ledger"))
+		return err
+	}))
+
+	ledger, err := servicesunlynx.NewPrivacyLedger(db, []byte("ledger"))
+	require.NoError(t, err)
+	require.NoError(t, ledger.Spend(public, "dataset", 0.5, 1))
+	assert.Error(t, ledger.Spend(public, "dataset", 0.75, 1))
+	ledger, err = servicesunlynx.NewPrivacyLedger(db, []byte("ledger"))
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, ledger.Spent(public, "dataset"))
+	assert.Equal(t, 0.0, ledger.Spent(public, "other"))
+}
+
+func TestServiceDiffPrivacy(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	// the quanta matches the size of the noise list, such that it follows the distribution
+	dp := &servicesunlynx.DiffPrivacyParameters{Sensitivity: map[string]float64{"s1": 1, "s2": 1}, NoiseListSize: 500, Quanta: 0.002}
+	query := servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1", "s2"}, GroupBy: []string{"g1"}, Epsilon: 1, DiffPrivacy: dp}
+
+	// the parameters must be complete
+	for _, invalid := range []servicesunlynx.SurveyCreationQuery{
+		{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1", "s2"}, DiffPrivacy: dp},
+		{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1", "s3"}, Epsilon: 1, DiffPrivacy: dp},
+		{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1"}, Epsilon: 0.5, DiffPrivacy: &servicesunlynx.DiffPrivacyParameters{Sensitivity: map[string]float64{"s1": 1}}},
+		{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1"}, Epsilon: 0.5, DiffPrivacy: &servicesunlynx.DiffPrivacyParameters{Mechanism: "gaussian", Sensitivity: map[string]float64{"s1": 1}, Quanta: 0.005}},
+	} {
+		_, err := client.SendSurveyCreation(invalid)
+		assert.Error(t, err)
+	}
+
+	surveyID, err := client.SendSurveyCreation(query)
+	require.NoError(t, err)
+
+	// each group gets its own noise
+	nbrGroups := 60
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := make([]libunlynx.DpClearResponse, nbrGroups)
+		for g := range responses {
+			responses[g] = libunlynx.DpClearResponse{GroupByClear: map[string]int64{"g1": int64(g)}, AggregatingAttributesEnc: map[string]int64{"s1": 1, "s2": 1}}
+		}
+		require.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+	}
+	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+	require.NoError(t, err)
+	require.Len(t, *grp, nbrGroups)
+
+	// the released noise is drawn from the noise list: epsilon is split between the 2 attributes, hence Laplace of
+	// scale sensitivity/(epsilon/2) = 2
+	list := make(map[int64]int)
+	listMean := 0.0
+	for _, v := range dp.NoiseValues(query.Epsilon/2, 1, 0) {
+		list[int64(v)]++
+		listMean += math.Abs(v)
+	}
+	listMean /= float64(dp.NoiseListSize)
+
+	releasedMean := 0.0
+	noisy := 0
+	for i := range *aggr {
+		for _, v := range (*aggr)[i] {
+			noise := v - 3
+			assert.Contains(t, list, noise)
+			releasedMean += math.Abs(float64(noise))
+			if noise != 0 {
+				noisy++
+			}
+		}
+	}
+	releasedMean /= float64(2 * nbrGroups)
+	assert.True(t, noisy > nbrGroups, "only %d noisy values", noisy)
+	assert.InDelta(t, listMean, releasedMean, 0.6)
+
+	// a survey without differential privacy releases the exact results
+	query.DiffPrivacy = nil
+	surveyID, err = client.SendSurveyCreation(query)
+	require.NoError(t, err)
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{{GroupByClear: map[string]int64{"g1": 1}, AggregatingAttributesEnc: map[string]int64{"s1": 1, "s2": 1}}}
+		require.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+	}
+	_, aggr, err = client.SendSurveyResultsQuery(*surveyID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]int64{{3, 3}}, *aggr)
+}
+
+func TestServicePredicate(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	where := []libunlynx.WhereQueryAttribute{{Name: "w1", Value: *libunlynx.EncryptInt(el.Aggregate, 1)}, {Name: "w2", Value: *libunlynx.EncryptInt(el.Aggregate, 2)}, {Name: "w2", Value: *libunlynx.EncryptInt(el.Aggregate, 3)}}
+	query := servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Sum: []string{"s1"}, Where: where, GroupBy: []string{"g1"}}
+
+	// the predicates are validated when the survey is created
+	for _, wrong := range []string{"w1 == $1", "w3 == $0", "w1 > $0", "(w1 == $0"} {
+		query.Predicate = wrong
+		_, err := client.SendSurveyCreation(query)
+		assert.Error(t, err, wrong)
+	}
+
+	query.Predicate = "w1 == $0 AND w2 IN ($1, $2)"
+	surveyID, err := client.SendSurveyCreation(query)
+	require.NoError(t, err)
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := []libunlynx.DpClearResponse{
+			{WhereEnc: map[string]int64{"w1": 1, "w2": 2}, GroupByClear: map[string]int64{"g1": 0}, AggregatingAttributesEnc: map[string]int64{"s1": 1}},
+			{WhereEnc: map[string]int64{"w1": 1, "w2": 3}, GroupByClear: map[string]int64{"g1": 0}, AggregatingAttributesEnc: map[string]int64{"s1": 10}},
+			{WhereEnc: map[string]int64{"w1": 1, "w2": 4}, GroupByClear: map[string]int64{"g1": 0}, AggregatingAttributesEnc: map[string]int64{"s1": 100}},
+			{WhereEnc: map[string]int64{"w1": 0, "w2": 2}, GroupByClear: map[string]int64{"g1": 0}, AggregatingAttributesEnc: map[string]int64{"s1": 1000}},
+		}
+		require.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, responses, el.Aggregate, 1, false))
+	}
+	grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+	require.NoError(t, err)
+	assert.Equal(t, [][]int64{{0}}, *grp)
+	assert.Equal(t, [][]int64{{33}}, *aggr)
+}
+
+func TestServiceRangePredicate(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+
+	domain := libunlynx.Domain{Min: 0, Max: 63}
+	schema := &libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "w0", Role: libunlynx.RoleWhere, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &domain, Ranges: true},
+		{Name: "g0", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityClear},
+		{Name: "s0", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted},
+	}}
+
+	testData := make(map[string][]libunlynx.DpClearResponse)
+	for i := range el.List {
+		for j := 0; j < 20; j++ {
+			response, err := schema.NewDpClearResponse(map[string]int64{"w0": rand.Int63n(64), "g0": int64(j % 3), "s0": rand.Int63n(10)})
+			require.NoError(t, err)
+			testData[strconv.Itoa(i)] = append(testData[strconv.Itoa(i)], response)
+		}
+	}
+
+	// [10, 40] and its complement
+	inRange := libunlynxpredicate.Query{}
+	p, err := inRange.Range("w0", domain, 10, 40)
+	require.NoError(t, err)
+	outOfRange := libunlynxpredicate.Query{}
+	atMost, err := outOfRange.AtMost("w0", domain, 9)
+	require.NoError(t, err)
+	atLeast, err := outOfRange.AtLeast("w0", domain, 41)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		query     libunlynxpredicate.Query
+		predicate *libunlynxpredicate.Predicate
+		keep      func(v int64) bool
+	}{
+		{inRange, p, func(v int64) bool { return v >= 10 && v <= 40 }},
+		{outOfRange, libunlynxpredicate.Or(atMost, atLeast), func(v int64) bool { return v < 10 || v > 40 }},
+	} {
+		query := servicesunlynx.SurveyCreationQuery{
+			Roster:    *el,
+			MapDPs:    nbrDPs,
+			Proofs:    proofsService,
+			Sum:       []string{"s0"},
+			Where:     test.query.Encrypt(el.Aggregate),
+			Predicate: test.predicate.String(),
+			GroupBy:   []string{"g0"},
+			Schema:    schema,
+		}
+
+		// a policy allowing an attribute allows its prefix tags
+		require.NoError(t, query.Sign(key.NewKeyPair(libunlynx.SuiTe).Private))
+		public, err := libunlynx.SerializePoint(query.Querier)
+		require.NoError(t, err)
+		policy := servicesunlynx.QueryPolicy{Queriers: []servicesunlynx.PolicyQuerier{{Public: public}}, Attributes: []string{"w0", "g0", "s0"}}
+		assert.NoError(t, policy.AuthorizeQuery(&query))
+		policy.Attributes = []string{"g0", "s0"}
+		assert.Error(t, policy.AuthorizeQuery(&query))
+		query.Querier, query.Signature = nil, nil
+
+		surveyID, err := client.SendSurveyCreation(query)
+		require.NoError(t, err)
+
+		for i := range el.List {
+			dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+			require.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, testData[strconv.Itoa(i)], el.Aggregate, 1, false))
+		}
+		grp, aggr, err := client.SendSurveyResultsQuery(*surveyID)
+		require.NoError(t, err)
+
+		results := make([]libunlynx.DpClearResponse, len(*grp))
+		for i := range *grp {
+			results[i] = libunlynx.DpClearResponse{GroupByClear: libunlynxtools.ConvertDataToMap((*grp)[i], "g", 0), AggregatingAttributesClear: libunlynxtools.ConvertDataToMap((*aggr)[i], "s", 0)}
+		}
+		keep := test.keep
+		expected := dataunlynx.ComputeExpectedResult(dataunlynx.FilterData(testData, func(dcr libunlynx.DpClearResponse) bool { return keep(dcr.WhereEnc["w0"]) }), 1, true)
+		assert.Equal(t, len(expected), len(results))
+		assert.True(t, dataunlynx.CompareClearResponses(expected, results), test.predicate.String())
+		assert.True(t, dataunlynx.CompareClearResponses(results, expected), test.predicate.String())
+	}
+}
+
+func TestServiceHistogram(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+
+	schema := &libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "hospital", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityClear},
+		{Name: "age", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Domain: &libunlynx.Domain{Min: 20, Max: 39}, Histogram: true},
+		{Name: "visits", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted},
+	}}
+	aggregates, err := libunlynxstatistics.ParseHistogramAggregates("{MIN(age), MAX(age), MEDIAN(age), PERCENTILE(age, 90)}")
+	require.NoError(t, err)
+	bins, err := libunlynxstatistics.HistogramSum(schema, aggregates)
+	require.NoError(t, err)
+	sum := append([]string{"visits"}, bins...)
+	ranges := libunlynxstatistics.HistogramRanges(sum)
+	query := servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: proofsService, Sum: sum, GroupBy: []string{"hospital"}, Schema: schema, Ranges: ranges}
+
+	// a policy allowing an attribute allows its histogram bins
+	require.NoError(t, query.Sign(key.NewKeyPair(libunlynx.SuiTe).Private))
+	public, err := libunlynx.SerializePoint(query.Querier)
+	require.NoError(t, err)
+	policy := servicesunlynx.QueryPolicy{Queriers: []servicesunlynx.PolicyQuerier{{Public: public}}, Attributes: []string{"hospital", "age", "visits"}}
+	assert.NoError(t, policy.AuthorizeQuery(&query))
+	policy.Attributes = []string{"hospital", "visits"}
+	assert.Error(t, policy.AuthorizeQuery(&query))
+	query.Querier, query.Signature = nil, nil
+
+	// the servers require the ranges of all the bins
+	partial := query
+	partial.Ranges = libunlynxstatistics.HistogramRanges(sum[:len(sum)-1])
+	_, err = client.SendSurveyCreation(partial)
+	assert.Error(t, err)
+
+	surveyID, err := client.SendSurveyCreation(query)
+	require.NoError(t, err)
+
+	// a data provider cannot count a response in two bins, although each bin is proven to be in [0, 1]
+	malicious := key.NewKeyPair(libunlynx.SuiTe)
+	encrypt := func(age int64) *servicesunlynx.SurveyResponseQuery {
+		response, err := schema.NewDpClearResponse(map[string]int64{"hospital": 0, "age": age, "visits": 1})
+		require.NoError(t, err)
+		resp, err := servicesunlynx.EncryptDataToSurveyWithRangeProofs("malicious", malicious, *surveyID, []libunlynx.DpClearResponse{response}, libunlynx.NewEncryptor(el.Aggregate), ranges, 1, false)
+		require.NoError(t, err)
+		return resp
+	}
+	twice, other := encrypt(20), encrypt(39)
+	bin := libunlynx.HistogramBinName("age", 39)
+	twice.Responses[0].AggregatingAttributesEnc[bin] = other.Responses[0].AggregatingAttributesEnc[bin]
+	twice.Responses[0].AggregatingAttributesEncProofs[bin] = other.Responses[0].AggregatingAttributesEncProofs[bin]
+	twice.Responses[0].AggregatingAttributesRangeProofs[bin] = other.Responses[0].AggregatingAttributesRangeProofs[bin]
+	require.NoError(t, twice.Sign(malicious.Private))
+	assert.Error(t, client.SendProtobuf(el.List[1], twice, &servicesunlynx.ServiceState{}))
+
+	// nor leave out the proof of the sum of the bins
+	delete(other.Responses[0].AggregatingAttributesRangeProofs, libunlynx.HistogramTotalName("age"))
+	require.NoError(t, other.Sign(malicious.Private))
+	assert.Error(t, client.SendProtobuf(el.List[1], other, &servicesunlynx.ServiceState{}))
+
+	ages := make(map[int64][]int64)
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		responses := make([]libunlynx.DpClearResponse, 0)
+		for j := 0; j < 15; j++ {
+			hospital, age := int64(j%2), 20+rand.Int63n(20)
+			response, err := schema.NewDpClearResponse(map[string]int64{"hospital": hospital, "age": age, "visits": 1})
+			require.NoError(t, err)
+			responses = append(responses, response)
+			ages[hospital] = append(ages[hospital], age)
+		}
+		// the data providers prove that each bin is 0 or 1 and that their sum is 1
+		require.NoError(t, dataHolder.SendSurveyResponseQueryWithRangeProofs(*surveyID, responses, el.Aggregate, ranges, 1, false))
+	}
+
+	grp, results, err := client.SendSurveyHistogramResultsQuery(*surveyID, aggregates)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(*grp))
+	for i, g := range *grp {
+		values := ages[g[0]]
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		n := len(values)
+		expected := []int64{values[0], values[n-1], values[(n+1)/2-1], values[int(math.Ceil(0.9*float64(n)))-1]}
+		for j, result := range (*results)[i] {
+			assert.False(t, result.Empty)
+			assert.Equal(t, expected[j], result.Value, result.Aggregate.String())
+		}
+	}
+}
+
+func TestServiceStatistics(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+
+	schema := &libunlynx.Schema{Attributes: []libunlynx.Attribute{
+		{Name: "hospital", Role: libunlynx.RoleGroupBy, Sensitivity: libunlynx.SensitivityClear},
+		{Name: "dose", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Encoding: libunlynx.EncodingFixedPoint, Decimals: 1, Moments: true},
+		{Name: "response", Role: libunlynx.RoleAggregate, Sensitivity: libunlynx.SensitivityEncrypted, Moments: true},
+	}}
+	statistics, err := libunlynxstatistics.ParseStatistics("{MEAN(dose), VARIANCE(dose), COVARIANCE(dose, response), REGRESSION(response, dose)}")
+	require.NoError(t, err)
+	sum, err := libunlynxstatistics.MomentSum(schema, statistics)
+	require.NoError(t, err)
+	surveyID, err := client.SendSurveyCreation(servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: proofsService, Sum: sum, Count: true, GroupBy: []string{"hospital"}, Schema: schema})
+	require.NoError(t, err)
+
+	doses := make(map[int64][]float64)
+	responses := make(map[int64][]float64)
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		data := make([]libunlynx.DpClearResponse, 0)
+		for j := 0; j < 10; j++ {
+			hospital, dose, response := int64(j%2), rand.Int63n(100), rand.Int63n(50)-10
+			dcr, err := schema.NewDpClearResponse(map[string]int64{"hospital": hospital, "dose": dose, "response": response})
+			require.NoError(t, err)
+			if i == 0 {
+				// a data provider without the schema only lists the attributes of the moments
+				dcr = libunlynx.DpClearResponse{GroupByClear: map[string]int64{"hospital": hospital}, AggregatingAttributesEnc: map[string]int64{"dose": dose, "response": response}, Moments: []string{"dose", "response"}}
+			}
+			data = append(data, dcr)
+			doses[hospital] = append(doses[hospital], float64(dose)/10)
+			responses[hospital] = append(responses[hospital], float64(response))
+		}
+		require.NoError(t, dataHolder.SendSurveyResponseQuery(*surveyID, data, el.Aggregate, 1, true))
+	}
+
+	grp, results, err := client.SendSurveyStatisticsResultsQuery(*surveyID, statistics, 1000000)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(*grp))
+	for i, g := range *grp {
+		x, y := doses[g[0]], responses[g[0]]
+		n := float64(len(x))
+		meanX, meanY := 0.0, 0.0
+		for j := range x {
+			meanX += x[j] / n
+			meanY += y[j] / n
+		}
+		varX, covXY := 0.0, 0.0
+		for j := range x {
+			varX += (x[j] - meanX) * (x[j] - meanX) / (n - 1)
+			covXY += (x[j] - meanX) * (y[j] - meanY) / (n - 1)
+		}
+
+		r := (*results)[i]
+		assert.Equal(t, int64(n), r[0].Count)
+		assert.InDelta(t, meanX, r[0].Value, 1e-9)
+		assert.InDelta(t, varX, r[1].Value, 1e-9)
+		assert.InDelta(t, covXY, r[2].Value, 1e-9)
+		assert.InDelta(t, meanY-covXY/varX*meanX, r[3].Coefficients[0], 1e-6)
+		assert.InDelta(t, covXY/varX, r[3].Coefficients[1], 1e-6)
+	}
+}
+
+func TestServiceMinCellSize(t *testing.T) {
+	log.Lvl1("***************************************************************************************************")
+	os.Remove("pre_compute_multiplications.gob")
+	local := onet.NewLocalTest(libunlynx.SuiTe)
+	_, el, _ := local.GenTree(3, true)
+	defer local.CloseAll()
+
+	client := servicesunlynx.NewUnLynxClient(el.List[0], strconv.Itoa(0))
+
+	nbrDPs := make(map[string]int64)
+	for _, server := range el.List {
+		nbrDPs[server.String()] = 1
+	}
+	query := servicesunlynx.SurveyCreationQuery{Roster: *el, MapDPs: nbrDPs, Proofs: proofsService, Sum: []string{"count", "s1"}, Count: true, GroupBy: []string{"g1"}, MinCellSize: 3}
+
+	// the minimum cell size is tested on the count attribute
+	noCount := query
+	noCount.Sum, noCount.Count = []string{"s1"}, false
+	_, err := client.SendSurveyCreation(noCount)
+	assert.Error(t, err)
+	noMinimum := query
+	noMinimum.MinCellSize, noMinimum.MergeSmallCells = 0, true
+	_, err = client.SendSurveyCreation(noMinimum)
+	assert.Error(t, err)
+
+	// a policy can impose a minimum cell size
+	signed := query
+	require.NoError(t, signed.Sign(key.NewKeyPair(libunlynx.SuiTe).Private))
+	public, err := libunlynx.SerializePoint(signed.Querier)
+	require.NoError(t, err)
+	policy := servicesunlynx.QueryPolicy{Queriers: []servicesunlynx.PolicyQuerier{{Public: public}}, MinCellSize: 3}
+	assert.NoError(t, policy.AuthorizeQuery(&signed))
+	policy.MinCellSize = 5
+	assert.Error(t, policy.AuthorizeQuery(&signed))
+
+	suppressed, err := client.SendSurveyCreation(query)
+	require.NoError(t, err)
+	query.MergeSmallCells = true
+	merged, err := client.SendSurveyCreation(query)
+	require.NoError(t, err)
+
+	// group 1 has 9 responses, group 2 has 2, group 3 has 1 and group 4 has 3
+	for i := range el.List {
+		dataHolder := servicesunlynx.NewUnLynxClient(el.List[i], strconv.Itoa(i+1))
+		groups := []int64{1, 1, 1, 4}
+		if i < 2 {
+			groups = append(groups, 2)
+		} else {
+			groups = append(groups, 3)
+		}
+		responses := make([]libunlynx.DpClearResponse, len(groups))
+		for j, g := range groups {
+			responses[j] = libunlynx.DpClearResponse{GroupByEnc: map[string]int64{"g1": g}, AggregatingAttributesEnc: map[string]int64{"s1": 10 * g}}
+		}
+		require.NoError(t, dataHolder.SendSurveyResponseQuery(*suppressed, responses, el.Aggregate, 1, true))
+		require.NoError(t, dataHolder.SendSurveyResponseQuery(*merged, responses, el.Aggregate, 1, true))
+	}
+
+	results := func(surveyID servicesunlynx.SurveyID) map[string][]int64 {
+		grp, aggr, err := client.SendSurveyResultsQuery(surveyID)
+		require.NoError(t, err)
+		groups := make(map[string][]int64)
+		for i, g := range *grp {
+			groups[fmt.Sprint(g)] = (*aggr)[i]
+		}
+		return groups
+	}
+	assert.Equal(t, map[string][]int64{"[1]": {9, 90}, "[4]": {3, 120}}, results(*suppressed))
+	// the "other" group has no group by values
+	assert.Equal(t, map[string][]int64{"[1]": {9, 90}, "[4]": {3, 120}, "[]": {3, 70}}, results(*merged))
+}
@@ -0,0 +1,432 @@
+package servicesunlynx
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ldsec/unlynx/lib/tools"
+	"github.com/satori/go.uuid"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// SurveyPhase is the phase of the UnLynx protocol a survey is in while it is processed
+type SurveyPhase string
+
+const (
+	// PhaseShuffling is the phase in which the responses are shuffled
+	PhaseShuffling SurveyPhase = "shuffling"
+	// PhaseTagging is the phase in which the responses are deterministically tagged, filtered and locally aggregated
+	PhaseTagging SurveyPhase = "tagging"
+	// PhaseAggregation is the phase in which the responses of all the servers are collectively aggregated
+	PhaseAggregation SurveyPhase = "aggregation"
+	// PhaseSuppression is the phase in which the groups below the minimum cell size are suppressed
+	PhaseSuppression SurveyPhase = "suppression"
+	// PhaseDRO is the phase in which the results are obfuscated (distributed results obfuscation)
+	PhaseDRO SurveyPhase = "DRO"
+	// PhaseDecryption is the phase in which the public results are collectively decrypted
+	PhaseDecryption SurveyPhase = "decryption"
+	// PhaseKeySwitching is the phase in which the results are key switched to the querier's key
+	PhaseKeySwitching SurveyPhase = "keySwitching"
+)
+
+// surveyStatusTimeout is how long a server waits for the other servers to send the status of a survey
+const surveyStatusTimeout = 10 * time.Second
+
+// Messages
+//______________________________________________________________________________________________________________________
+
+// ListSurveysQuery is used to list the surveys of the server receiving it. A server with a query policy only lists the
+// surveys of the querier signing the query.
+type ListSurveysQuery struct {
+	Auth QuerierSignature
+}
+
+// SurveyList contains the status of the surveys of a server.
+type SurveyList struct {
+	Surveys []SurveyServerStatus
+}
+
+// SurveyStatusQuery is used to get the status of a survey on all the servers of its roster.
+type SurveyStatusQuery struct {
+	SurveyID     SurveyID
+	IntraMessage bool
+	Source       *network.ServerIdentity
+	RequestID    string // identifies the query among the ones the source is waiting answers for
+	Auth         QuerierSignature
+}
+
+// SurveyStatusReply contains the status of a survey on a server, sent back to the server handling a SurveyStatusQuery.
+type SurveyStatusReply struct {
+	RequestID string
+	Status    SurveyServerStatus
+}
+
+// SurveyStatusReport contains the status of a survey on all the servers of its roster.
+type SurveyStatusReport struct {
+	Servers []SurveyServerStatus
+}
+
+// SurveyServerStatus is the status of a survey on a server
+type SurveyServerStatus struct {
+	SurveyID   SurveyID
+	Server     string
+	Status     SurveyStatus
+	Phase      SurveyPhase // current or last phase the survey was processed in
+	DpExpected int64       // number of data providers the server waits for (see SurveyCreationQuery.MapDPs)
+	DpReceived int64       // number of data providers who have already pushed their data to the server
+	Error      string
+}
+
+// CancelSurveyQuery is used to cancel a survey on all the servers of its roster.
+type CancelSurveyQuery struct {
+	SurveyID     SurveyID
+	IntraMessage bool
+	Reason       string // reported as the error of the survey (e.g. a server refusing it)
+	Auth         QuerierSignature
+}
+
+// DeleteSurveyQuery is used to cancel a survey and delete it (with its data) from all the servers of its roster.
+type DeleteSurveyQuery struct {
+	SurveyID     SurveyID
+	IntraMessage bool
+	Auth         QuerierSignature
+}
+
+// Handlers
+//______________________________________________________________________________________________________________________
+
+// HandleListSurveysQuery lists the surveys of the server, sorted by ID (only the ones of the querier if the server has a
+// query policy).
+func (s *Service) HandleListSurveysQuery(lsq *ListSurveysQuery) (network.Message, error) {
+	if err := s.authorize(lsq.Auth, listStatement, nil); err != nil {
+		return nil, err
+	}
+
+	entries := s.Survey.ToSlice()
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.Key().(string)
+	}
+	sort.Strings(ids)
+
+	list := &SurveyList{Surveys: make([]SurveyServerStatus, 0, len(ids))}
+	for _, id := range ids {
+		survey, err := s.getSurvey(SurveyID(id))
+		if err != nil {
+			continue
+		}
+		if s.Policy != nil && (survey.Query.Querier == nil || !survey.Query.Querier.Equal(lsq.Auth.Querier)) {
+			continue
+		}
+		list.Surveys = append(list.Surveys, s.surveyServerStatus(SurveyID(id), survey))
+	}
+	return list, nil
+}
+
+// HandleSurveyStatusQuery gets the status of a survey on the server. The server receiving the query from the client
+// asks the other servers of the roster for theirs and reports them all. A server with a query policy only answers the
+// querier of the survey.
+func (s *Service) HandleSurveyStatusQuery(ssq *SurveyStatusQuery) (network.Message, error) {
+	if err := s.authorizeSurveyQuery(ssq.Auth, "status", ssq.SurveyID); err != nil {
+		return nil, err
+	}
+	ssq.IntraMessage = false
+	return s.handleSurveyStatus(ssq)
+}
+
+// handleSurveyStatus gets the status of a survey on the server (see HandleSurveyStatusQuery), the query being
+// authorized or sent by another server
+func (s *Service) handleSurveyStatus(ssq *SurveyStatusQuery) (network.Message, error) {
+	survey, err := s.getSurvey(ssq.SurveyID)
+
+	if ssq.IntraMessage {
+		status := SurveyServerStatus{SurveyID: ssq.SurveyID, Server: s.ServerIdentity().String(), Error: fmt.Sprintf("unknown survey %s", ssq.SurveyID)}
+		if err == nil {
+			status = s.surveyServerStatus(ssq.SurveyID, survey)
+		}
+		return nil, s.SendRaw(ssq.Source, &SurveyStatusReply{RequestID: ssq.RequestID, Status: status})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	requestID := uuid.NewV4().String()
+	replies := make(chan SurveyServerStatus, len(survey.Query.Roster.List))
+	if _, err := s.statusRequests.Put(requestID, replies); err != nil {
+		return nil, err
+	}
+	defer s.statusRequests.Remove(requestID)
+
+	query := SurveyStatusQuery{SurveyID: ssq.SurveyID, IntraMessage: true, Source: s.ServerIdentity(), RequestID: requestID}
+	if err := libunlynxtools.SendISMOthers(s.ServiceProcessor, &survey.Query.Roster, &query); err != nil {
+		log.Error(s.ServerIdentity(), " could not ask for the status of survey ", ssq.SurveyID, ": ", err)
+	}
+
+	// the servers that do not answer on time are reported without status
+	statuses := make(map[string]SurveyServerStatus)
+	statuses[s.ServerIdentity().String()] = s.surveyServerStatus(ssq.SurveyID, survey)
+	timeout := time.After(surveyStatusTimeout)
+	for waiting := true; waiting && len(statuses) < len(survey.Query.Roster.List); {
+		select {
+		case status := <-replies:
+			statuses[status.Server] = status
+		case <-timeout:
+			waiting = false
+		}
+	}
+
+	report := &SurveyStatusReport{Servers: make([]SurveyServerStatus, len(survey.Query.Roster.List))}
+	for i, si := range survey.Query.Roster.List {
+		status, ok := statuses[si.String()]
+		if !ok {
+			status = SurveyServerStatus{SurveyID: ssq.SurveyID, Server: si.String(), Error: "no answer"}
+		}
+		report.Servers[i] = status
+	}
+	return report, nil
+}
+
+// processSurveyStatusQuery answers a SurveyStatusQuery forwarded by another server of the roster of the survey
+func (s *Service) processSurveyStatusQuery(ssq *SurveyStatusQuery, sender *network.ServerIdentity) (network.Message, error) {
+	// an unknown survey is reported as such to any server
+	if survey, err := s.getSurvey(ssq.SurveyID); err == nil {
+		if err := checkSender(sender, ssq.SurveyID, survey); err != nil {
+			return nil, err
+		}
+	}
+	ssq.IntraMessage, ssq.Source = true, sender
+	return s.handleSurveyStatus(ssq)
+}
+
+// HandleSurveyStatusReply handles the status of a survey sent by another server
+func (s *Service) HandleSurveyStatusReply(ssr *SurveyStatusReply) (network.Message, error) {
+	replies, err := s.statusRequests.Get(ssr.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	if replies == nil {
+		return nil, fmt.Errorf("no status query %s is waiting for answers", ssr.RequestID)
+	}
+	replies.(chan SurveyServerStatus) <- ssr.Status
+	return nil, nil
+}
+
+// HandleCancelSurveyQuery cancels a survey on the server: the goroutines waiting on its channels are released and it
+// is not processed any further. The server receiving the query from the client forwards it to the other servers of the
+// roster. A server with a query policy only accepts it from the querier of the survey.
+func (s *Service) HandleCancelSurveyQuery(csq *CancelSurveyQuery) (network.Message, error) {
+	if err := s.authorizeSurveyQuery(csq.Auth, "cancel", csq.SurveyID); err != nil {
+		return nil, err
+	}
+	return s.handleCancelSurvey(csq)
+}
+
+// handleCancelSurvey cancels a survey on the server (see HandleCancelSurveyQuery), the query being authorized or sent
+// by another server
+func (s *Service) handleCancelSurvey(csq *CancelSurveyQuery) (network.Message, error) {
+	survey, err := s.getSurvey(csq.SurveyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cancelSurvey(csq.SurveyID, csq.Reason); err != nil {
+		return nil, err
+	}
+	log.Lvl1(s.ServerIdentity(), " cancelled the survey ", csq.SurveyID)
+
+	if !csq.IntraMessage {
+		csq.IntraMessage = true
+		if err := libunlynxtools.SendISMOthers(s.ServiceProcessor, &survey.Query.Roster, csq); err != nil {
+			return nil, err
+		}
+	}
+	return &ServiceState{csq.SurveyID}, nil
+}
+
+// processCancelSurveyQuery handles a CancelSurveyQuery forwarded by another server of the roster of the survey. A server
+// with a query policy checks the signature of the querier of the survey, if any: a server of the roster can cancel the
+// survey without it (e.g. when another server refused the survey), as it could stop its processing by not taking part
+// in it anyway.
+func (s *Service) processCancelSurveyQuery(csq *CancelSurveyQuery, sender *network.ServerIdentity) (network.Message, error) {
+	survey, err := s.getSurvey(csq.SurveyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSender(sender, csq.SurveyID, survey); err != nil {
+		return nil, err
+	}
+	if csq.Auth.Querier != nil || len(csq.Auth.Signature) > 0 {
+		if err := s.authorizeSurveyQuery(csq.Auth, "cancel", csq.SurveyID); err != nil {
+			return nil, err
+		}
+	}
+	csq.IntraMessage = true
+	return s.handleCancelSurvey(csq)
+}
+
+// HandleDeleteSurveyQuery cancels a survey (if it is not finished) and deletes it from the server. The server receiving
+// the query from the client forwards it to the other servers of the roster. A server with a query policy only accepts it
+// from the querier of the survey.
+func (s *Service) HandleDeleteSurveyQuery(dsq *DeleteSurveyQuery) (network.Message, error) {
+	if err := s.authorizeSurveyQuery(dsq.Auth, "delete", dsq.SurveyID); err != nil {
+		return nil, err
+	}
+	return s.handleDeleteSurvey(dsq)
+}
+
+// handleDeleteSurvey deletes a survey from the server (see HandleDeleteSurveyQuery), the query being authorized or sent
+// by another server
+func (s *Service) handleDeleteSurvey(dsq *DeleteSurveyQuery) (network.Message, error) {
+	survey, err := s.getSurvey(dsq.SurveyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.deleteSurvey(dsq.SurveyID); err != nil {
+		return nil, err
+	}
+	log.Lvl1(s.ServerIdentity(), " deleted the survey ", dsq.SurveyID)
+
+	if !dsq.IntraMessage {
+		dsq.IntraMessage = true
+		if err := libunlynxtools.SendISMOthers(s.ServiceProcessor, &survey.Query.Roster, dsq); err != nil {
+			return nil, err
+		}
+	}
+	return &ServiceState{dsq.SurveyID}, nil
+}
+
+// processDeleteSurveyQuery handles a DeleteSurveyQuery forwarded by another server of the roster of the survey: a server
+// with a query policy checks the signature of the querier of the survey
+func (s *Service) processDeleteSurveyQuery(dsq *DeleteSurveyQuery, sender *network.ServerIdentity) (network.Message, error) {
+	survey, err := s.getSurvey(dsq.SurveyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSender(sender, dsq.SurveyID, survey); err != nil {
+		return nil, err
+	}
+	if err := s.authorizeSurveyQuery(dsq.Auth, "delete", dsq.SurveyID); err != nil {
+		return nil, err
+	}
+	dsq.IntraMessage = true
+	return s.handleDeleteSurvey(dsq)
+}
+
+// Functions
+//______________________________________________________________________________________________________________________
+
+// checkSender checks that a message about a survey was sent by a server of its roster
+func checkSender(sender *network.ServerIdentity, sid SurveyID, survey Survey) error {
+	if sender == nil {
+		return fmt.Errorf("message about survey %s from an unknown server", sid)
+	}
+	if _, si := survey.Query.Roster.Search(sender.ID); si == nil {
+		return fmt.Errorf("%v is not in the roster of survey %s", sender, sid)
+	}
+	return nil
+}
+
+// authorizeSurveyQuery checks, if the server has a query policy, that a query managing a survey is signed by the
+// querier of the survey (see Service.authorize)
+func (s *Service) authorizeSurveyQuery(qs QuerierSignature, action string, sid SurveyID) error {
+	if s.Policy == nil {
+		return nil
+	}
+	survey, err := s.getSurvey(sid)
+	if err != nil {
+		return err
+	}
+	return s.authorize(qs, surveyStatement(action, sid), survey.Query.Querier)
+}
+
+// surveyServerStatus returns the status of a survey on this server
+func (s *Service) surveyServerStatus(sid SurveyID, survey Survey) SurveyServerStatus {
+	return SurveyServerStatus{
+		SurveyID:   sid,
+		Server:     s.ServerIdentity().String(),
+		Status:     survey.Status,
+		Phase:      survey.Phase,
+		DpExpected: survey.Query.MapDPs[s.ServerIdentity().String()],
+		DpReceived: survey.DpCount,
+		Error:      survey.Error,
+	}
+}
+
+// cancelled checks if the survey was cancelled
+func (survey *Survey) cancelled() bool {
+	select {
+	case <-survey.Cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// cancelSurvey marks a survey as cancelled (for the given reason, if any) and releases the goroutines waiting on its
+// channels. A finished survey cannot be cancelled. The privacy budget of a cancelled survey is refunded.
+func (s *Service) cancelSurvey(sid SurveyID, reason string) error {
+	var query SurveyCreationQuery
+	err := s.updateSurvey(sid, func(survey *Survey) error {
+		if survey.Status.ended() {
+			return fmt.Errorf("survey %s is already %s", sid, survey.Status)
+		}
+
+		if reason == "" {
+			reason = "the survey was cancelled"
+		}
+		close(survey.Cancelled)
+		survey.Status, survey.Error = SurveyCancelled, reason
+		query = survey.Query
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.refundPrivacyBudget(query)
+	return nil
+}
+
+// deleteSurvey cancels a survey (if needed) and removes it with its data
+func (s *Service) deleteSurvey(sid SurveyID) error {
+	survey, err := s.getSurvey(sid)
+	if err != nil {
+		return err
+	}
+	if !survey.cancelled() && survey.Status != SurveyFinished && survey.Status != SurveyFailed {
+		if err := s.cancelSurvey(sid, ""); err != nil {
+			return err
+		}
+	}
+
+	s.Survey.Remove(string(sid))
+	s.surveyLocksMutex.Lock()
+	delete(s.surveyLocks, sid)
+	s.surveyLocksMutex.Unlock()
+	for _, ticket := range s.surveyTickets(sid) {
+		s.tickets.Remove(ticket)
+	}
+	if err := survey.Close(); err != nil {
+		log.Error(err)
+	}
+	if s.SurveyStore != nil {
+		return s.SurveyStore.Delete(sid)
+	}
+	return nil
+}
+
+// enterPhase records the phase a survey is entering (and notifies the tickets following it), unless it was cancelled
+func (s *Service) enterPhase(sid SurveyID, phase SurveyPhase) error {
+	err := s.updateSurvey(sid, func(survey *Survey) error {
+		if survey.cancelled() {
+			return fmt.Errorf("survey %s was cancelled", sid)
+		}
+		survey.Phase = phase
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyPhase(sid, phase)
+	return nil
+}
@@ -0,0 +1,376 @@
+package servicesunlynx
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/aggregation"
+	"github.com/ldsec/unlynx/lib/decryption"
+	"github.com/ldsec/unlynx/lib/shuffle"
+	"github.com/ldsec/unlynx/lib/store"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.etcd.io/bbolt"
+)
+
+// SurveyStatus is the processing state of a survey on a server
+type SurveyStatus string
+
+const (
+	// SurveyCollecting is the status of a survey waiting for the responses of its data providers
+	SurveyCollecting SurveyStatus = "collecting"
+	// SurveyProcessing is the status of a survey whose responses are being processed by the UnLynx protocols
+	SurveyProcessing SurveyStatus = "processing"
+	// SurveyFinished is the status of a survey whose processing ended
+	SurveyFinished SurveyStatus = "finished"
+	// SurveyFailed is the status of a survey whose processing failed (e.g. the server restarted during it)
+	SurveyFailed SurveyStatus = "failed"
+	// SurveyCancelled is the status of a survey cancelled by the querier
+	SurveyCancelled SurveyStatus = "cancelled"
+)
+
+// ended checks if a survey with this status ended (it is not processed any further)
+func (status SurveyStatus) ended() bool {
+	return status == SurveyFinished || status == SurveyFailed || status == SurveyCancelled
+}
+
+func init() {
+	network.RegisterMessage(&SurveyRecord{})
+	network.RegisterMessage(&ResponsesRecord{})
+}
+
+// responsesPrefix prefixes the names of the nested buckets in which the responses pushed to the surveys are appended
+const responsesPrefix = "responses/"
+
+// SurveyRecord is the persistent part of a survey: its definition, the received responses and the data produced by the
+// protocols. The protocol intermediate state is not kept, a survey cannot be resumed in the middle of its processing.
+type SurveyRecord struct {
+	Query            SurveyCreationQuery
+	SurveySecretKey  []byte
+	Status           SurveyStatus
+	Error            string
+	DpCount          int64
+	DataProviders    map[string]bool
+	Store            libunlynxstore.StoreBytes
+	DecryptionProofs libunlynxdecrypt.PublishedDecryptionListProofBytes
+
+	LinearCombinationProofs libunlynxaggr.PublishedLinearCombinationListProofBytes
+}
+
+// ResponsesRecord is a batch of responses pushed by a data provider to a survey. The batches are appended to the survey
+// store as they arrive rather than saving the whole survey each time, and inserted again in the survey when it is
+// restored (unless its record already contains them).
+type ResponsesRecord struct {
+	DataProvider string
+	Proofs       bool
+	Responses    []libunlynx.DpResponseToSend
+}
+
+// SurveyStore persists the surveys of a server so that they survive a restart.
+type SurveyStore interface {
+	// Save creates or replaces the record of a survey
+	Save(sid SurveyID, record *SurveyRecord) error
+	// Delete removes the record of a survey and its batches of responses
+	Delete(sid SurveyID) error
+	// LoadAll returns the records of all the surveys
+	LoadAll() (map[SurveyID]*SurveyRecord, error)
+	// AppendResponses stores a batch of responses pushed to a survey after the ones already stored
+	AppendResponses(sid SurveyID, record *ResponsesRecord) error
+	// LoadResponses returns the batches of responses pushed to a survey, in the order they were appended
+	LoadResponses(sid SurveyID) ([]*ResponsesRecord, error)
+}
+
+// boltSurveyStore is a SurveyStore keeping the surveys in a bucket of a bbolt database
+type boltSurveyStore struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+// NewBoltSurveyStore creates a SurveyStore keeping the surveys in an existing bucket of a bbolt database (e.g. one of the
+// onet database of the server, see onet.Context.GetAdditionalBucket).
+func NewBoltSurveyStore(db *bbolt.DB, bucket []byte) SurveyStore {
+	return &boltSurveyStore{db: db, bucket: bucket}
+}
+
+// Save creates or replaces the record of a survey
+func (bss *boltSurveyStore) Save(sid SurveyID, record *SurveyRecord) error {
+	return boltPut(bss.db, bss.bucket, string(sid), record)
+}
+
+// Delete removes the record of a survey and its batches of responses
+func (bss *boltSurveyStore) Delete(sid SurveyID) error {
+	return bss.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bss.bucket)
+		if err := b.DeleteBucket([]byte(responsesPrefix + string(sid))); err != nil && err != bbolt.ErrBucketNotFound {
+			return err
+		}
+		return b.Delete([]byte(sid))
+	})
+}
+
+// LoadAll returns the records of all the surveys
+func (bss *boltSurveyStore) LoadAll() (map[SurveyID]*SurveyRecord, error) {
+	records := make(map[SurveyID]*SurveyRecord)
+	err := boltForEach(bss.db, bss.bucket, func(key string, msg network.Message) error {
+		record, ok := msg.(*SurveyRecord)
+		if !ok {
+			return fmt.Errorf("survey %s: wrong record type", key)
+		}
+		records[SurveyID(key)] = record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// AppendResponses stores a batch of responses pushed to a survey after the ones already stored
+func (bss *boltSurveyStore) AppendResponses(sid SurveyID, record *ResponsesRecord) error {
+	data, err := network.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bss.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(bss.bucket).CreateBucketIfNotExists([]byte(responsesPrefix + string(sid)))
+		if err != nil {
+			return err
+		}
+		// big endian sequence numbers keep the batches in the order they were appended
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, data)
+	})
+}
+
+// LoadResponses returns the batches of responses pushed to a survey, in the order they were appended
+func (bss *boltSurveyStore) LoadResponses(sid SurveyID) ([]*ResponsesRecord, error) {
+	var records []*ResponsesRecord
+	err := bss.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bss.bucket).Bucket([]byte(responsesPrefix + string(sid)))
+		if b == nil {
+			return nil
+		}
+		return bucketForEach(b, func(key string, msg network.Message) error {
+			record, ok := msg.(*ResponsesRecord)
+			if !ok {
+				return fmt.Errorf("responses of survey %s: wrong record type", sid)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// boltPut marshals a record and stores it under a key of a bucket
+func boltPut(db *bbolt.DB, bucket []byte, key string, record network.Message) error {
+	data, err := network.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+// boltDelete removes the record stored under a key of a bucket
+func boltDelete(db *bbolt.DB, bucket []byte, key string) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+// boltForEach unmarshals each record of a bucket and calls f with it
+func boltForEach(db *bbolt.DB, bucket []byte, f func(key string, msg network.Message) error) error {
+	return db.View(func(tx *bbolt.Tx) error {
+		return bucketForEach(tx.Bucket(bucket), f)
+	})
+}
+
+// bucketForEach unmarshals each record of an open bucket and calls f with it (the nested buckets are skipped)
+func bucketForEach(b *bbolt.Bucket, f func(key string, msg network.Message) error) error {
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		// the value is only valid during the transaction and the byte slices of the record could point into it
+		_, msg, err := network.Unmarshal(append([]byte{}, v...), libunlynx.SuiTe)
+		if err != nil {
+			return fmt.Errorf("%s: %v", string(k), err)
+		}
+		return f(string(k), msg)
+	})
+}
+
+// Persistence
+//______________________________________________________________________________________________________________________
+
+// saveSurvey persists a survey in the survey store of the service (if any)
+func (s *Service) saveSurvey(sid SurveyID, survey Survey) error {
+	if s.SurveyStore == nil {
+		return nil
+	}
+
+	record := SurveyRecord{Query: survey.Query, Status: survey.Status, Error: survey.Error, DpCount: survey.DpCount, DataProviders: survey.DataProviders, DecryptionProofs: survey.DecryptionProofs, LinearCombinationProofs: survey.LinearCombinationProofs}
+	var err error
+	if record.SurveySecretKey, err = survey.SurveySecretKey.MarshalBinary(); err != nil {
+		return err
+	}
+	if record.Store, err = survey.Store.ToBytes(); err != nil {
+		return err
+	}
+	if err := s.SurveyStore.Save(sid, &record); err != nil {
+		return fmt.Errorf("could not save survey %s: %v", sid, err)
+	}
+	return nil
+}
+
+// appendResponses records a survey that received the responses of a data provider and appends them to the survey store
+// of the service (if any) without saving the whole survey again
+func (s *Service) appendResponses(sid SurveyID, survey Survey, record *ResponsesRecord) error {
+	if _, err := s.Survey.Put(string(sid), survey); err != nil {
+		return err
+	}
+	if s.SurveyStore == nil {
+		return nil
+	}
+	if err := s.SurveyStore.AppendResponses(sid, record); err != nil {
+		return fmt.Errorf("could not save the responses pushed to survey %s: %v", sid, err)
+	}
+	return nil
+}
+
+// surveyFromRecord rebuilds the definition and the state of a survey from its record, with an empty store
+func surveyFromRecord(record *SurveyRecord) Survey {
+	survey := Survey{
+		Store:            libunlynxstore.NewStore(),
+		Query:            record.Query,
+		SurveySecretKey:  libunlynx.SuiTe.Scalar(),
+		DecryptionProofs: record.DecryptionProofs,
+		Status:           record.Status,
+		Error:            record.Error,
+		DpCount:          record.DpCount,
+		DataProviders:    record.DataProviders,
+
+		LinearCombinationProofs: record.LinearCombinationProofs,
+
+		SurveyChannel: make(chan int, 100),
+		RefuseChannel: make(chan string, 100),
+		DpChannel:     make(chan int, 100),
+		DDTChannel:    make(chan int, 100),
+		Cancelled:     make(chan struct{}),
+	}
+	if survey.Status == SurveyCancelled {
+		close(survey.Cancelled)
+	}
+	return survey
+}
+
+// restoreSurvey rebuilds a survey from its record and the batches of responses pushed to it since it was last saved
+func (s *Service) restoreSurvey(sid SurveyID, record *SurveyRecord) (Survey, error) {
+	survey := surveyFromRecord(record)
+	if err := survey.SurveySecretKey.UnmarshalBinary(record.SurveySecretKey); err != nil {
+		return Survey{}, err
+	}
+	if err := survey.Store.FromBytes(record.Store); err != nil {
+		return Survey{}, err
+	}
+
+	batches, err := s.SurveyStore.LoadResponses(sid)
+	if err != nil {
+		return Survey{}, err
+	}
+	for _, batch := range batches {
+		// the record already contains the responses of the data providers it knows
+		if survey.DataProviders[batch.DataProvider] {
+			continue
+		}
+		for _, v := range batch.Responses {
+			dr := libunlynx.DpResponse{}
+			if err := dr.FromDpResponseToSend(v); err != nil {
+				return Survey{}, err
+			}
+			if err := survey.InsertDpResponse(dr, batch.Proofs, record.Query.GroupBy, record.Query.Sum, record.Query.Where); err != nil {
+				return Survey{}, err
+			}
+		}
+		survey.DpCount++
+		if survey.DataProviders == nil {
+			survey.DataProviders = make(map[string]bool)
+		}
+		survey.DataProviders[batch.DataProvider] = true
+	}
+
+	filter, err := compilePredicate(record.Query)
+	if err != nil {
+		return Survey{}, err
+	}
+	survey.Filter = filter
+
+	// the precomputation only depends on the survey secret and the collective key
+	collectiveKey, _, err := s.surveyKeys(record.Query)
+	if err != nil {
+		return Survey{}, err
+	}
+	survey.ShufflePrecompute, err = libunlynxshuffle.PrecomputationWritingForShuffling(false, gobFile, s.ServerIdentity().String(), survey.SurveySecretKey, collectiveKey, shuffleLineSize(record.Query))
+	if err != nil {
+		return Survey{}, err
+	}
+	return survey, nil
+}
+
+// LoadSurveys restores the surveys of the survey store (it is called when the service starts). The surveys still
+// collecting responses are resumed, the ones that were being processed or that cannot be restored are marked as failed.
+func (s *Service) LoadSurveys() error {
+	if s.SurveyStore == nil {
+		return nil
+	}
+
+	records, err := s.SurveyStore.LoadAll()
+	if err != nil {
+		return err
+	}
+	for sid, record := range records {
+		survey, err := s.restoreSurvey(sid, record)
+		if err != nil {
+			// the other surveys are still restored, this one is kept (without its data) to report its failure
+			log.Error(s.ServerIdentity(), " could not restore survey ", sid, ": ", err)
+			if !record.Status.ended() {
+				s.refundPrivacyBudget(record.Query)
+			}
+			record.Status, record.Error = SurveyFailed, fmt.Sprintf("the survey could not be restored: %v", err)
+			if err := s.SurveyStore.Save(sid, record); err != nil {
+				log.Error(s.ServerIdentity(), " could not save survey ", sid, ": ", err)
+			}
+			if _, err := s.Survey.Put(string(sid), surveyFromRecord(record)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch survey.Status {
+		case SurveyCollecting:
+			// the data providers that already sent their responses are not waited for
+			if survey.DpCount > 0 {
+				survey.DpChannel <- int(survey.DpCount)
+			}
+		case SurveyProcessing:
+			survey.Status, survey.Error = SurveyFailed, "the server restarted while the survey was being processed"
+			s.refundPrivacyBudget(survey.Query)
+		}
+		if err := s.putSurvey(sid, survey); err != nil {
+			return err
+		}
+		log.Lvl1(s.ServerIdentity(), " restored survey ", sid, " (", survey.Status, ")")
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/ldsec/unlynx/data"
 	"github.com/ldsec/unlynx/lib"
+	"github.com/ldsec/unlynx/lib/predicate"
 	"github.com/ldsec/unlynx/lib/tools"
 	"github.com/ldsec/unlynx/services"
 	"strconv"
@@ -103,16 +104,13 @@ func (sim *SimulationUnLynx) Run(config *onet.SimulationConfig) error {
 		whereQueryValues := make([]libunlynx.WhereQueryAttribute, NbrWhere)
 
 		predicate := ""
-		counter := 0
-
 		if int(NbrWhere) > 0 {
+			equalities := make([]*libunlynxpredicate.Predicate, NbrWhere)
 			for i := 0; i < int(NbrWhere); i++ {
 				whereQueryValues[i] = libunlynx.WhereQueryAttribute{Name: "w" + strconv.Itoa(i), Value: *libunlynx.EncryptInt(el.Aggregate, 1)}
-				predicate = predicate + " v" + strconv.Itoa(counter) + " == v" + strconv.Itoa(counter+1) + " &&"
-				counter = counter + 2
+				equalities[i] = libunlynxpredicate.Equal("w"+strconv.Itoa(i), i)
 			}
-			// remove the last &&
-			predicate = predicate[:len(predicate)-2]
+			predicate = libunlynxpredicate.And(equalities...).String()
 		}
 
 		// Group by attributes